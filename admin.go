@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mmxmb/quiet_hn/hn"
+	"github.com/mmxmb/quiet_hn/jobs"
+	"github.com/mmxmb/quiet_hn/router"
+)
+
+// The operational endpoints (/admin, /metrics and /debug/pprof) are only
+// served on the admin listener, which listens on localhost by default, so
+// that public traffic can't reach them whatever the configuration of the
+// public listeners. The admin listener has no authentication, so it only
+// answers requests for localhost, the loopback addresses and the hosts set
+// by the operator: a page of another site whose domain resolves to the
+// admin address, by DNS rebinding, would otherwise be of the same origin as
+// the dashboard.
+
+// adminTemplateData is the data of the admin dashboard. Clicks is nil if the
+// /out redirector is disabled.
+type adminTemplateData struct {
+	Build    buildInfo
+	Ready    bool
+	Cache    CacheStats
+	Upstream hn.MetricsStats
+	Clicks   []storyClicks
+	Jobs     jobs.Stats
+	Dead     []jobs.Failure
+	Schedule []scheduleEntry
+	Rules    []ruleStatus
+	// RulesEnabled is whether the alert rules are, so that they can be
+	// added while there are none
+	RulesEnabled bool
+	// Pinned are the stories pinned by the operator, PinsEnabled whether
+	// they can be
+	Pinned      []int
+	PinsEnabled bool
+	// Watched are the threads whose new comments are notified, WatchEnabled
+	// whether threads can be watched
+	Watched      []watchedThread
+	WatchEnabled bool
+	Lang         string
+	Time         time.Duration
+}
+
+// adminRouter returns the router of the admin listener, reached by hosts
+// besides localhost and the loopback addresses. clicks, sched, rules, pins
+// and watches may be nil.
+func adminRouter(hosts []string, cache *Cache, metrics *hn.Metrics, clicks *clickCounter, queue *jobs.Queue, sched *scheduler, rules *ruleEngine, pins *pinBoard, watches *watchList, ready *readiness, tpls *templateSet) *router.Router {
+	mux := router.New()
+	mux.Use(localHostsOnly(hosts))
+	mux.HandleFunc("/admin", adminHandler(cache, metrics, clicks, queue, sched, rules, pins, watches, ready, tpls))
+	if pins != nil {
+		mux.Handle("/admin/pinned", methods(rejectPage(tpls), http.MethodPost)(pinnedAdminHandler(pins)))
+	}
+	if watches != nil {
+		mux.Handle("/admin/watch", methods(rejectPage(tpls), http.MethodPost)(watchAdminHandler(watches)))
+	}
+	if rules != nil {
+		mux.Handle("/admin/rules", methods(rejectPage(tpls), http.MethodPost)(rulesAdminHandler(rules)))
+	}
+	mux.HandleFunc("/metrics", metricsHandler(cache, metrics, clicks, queue))
+	// pprof.Index serves the profiles named after /debug/pprof/, except for
+	// the ones with their own handler
+	mux.HandleFunc("/debug/pprof", pprof.Index)
+	mux.HandleFunc("/debug/pprof/{profile}", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	return mux
+}
+
+// localHostsOnly rejects the requests whose Host isn't localhost, a loopback
+// IP address or one of hosts
+func localHostsOnly(hosts []string) router.Middleware {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			host := r.Host
+			if name, _, err := net.SplitHostPort(host); err == nil {
+				host = name
+			}
+			host = strings.TrimSuffix(strings.ToLower(host), ".")
+			ok := host == "localhost"
+			if ip := net.ParseIP(strings.Trim(host, "[]")); ip != nil {
+				ok = ip.IsLoopback()
+			}
+			for _, allowed := range hosts {
+				ok = ok || strings.EqualFold(host, allowed)
+			}
+			if !ok {
+				http.Error(w, "Unknown host, see -admin_hosts", http.StatusForbidden)
+				return
+			}
+			h.ServeHTTP(w, r)
+		})
+	}
+}
+
+// adminHandler serves /admin, a dashboard of the state of the server
+func adminHandler(cache *Cache, metrics *hn.Metrics, clicks *clickCounter, queue *jobs.Queue, sched *scheduler, rules *ruleEngine, pins *pinBoard, watches *watchList, ready *readiness, tpls *templateSet) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		data := adminTemplateData{
+			Build:    currentBuild(),
+			Ready:    ready.isReady(),
+			Cache:    cache.Stats(),
+			Upstream: metrics.Stats(),
+			Jobs:     queue.Stats(),
+			Dead:     queue.Dead(),
+			Lang:     languagePref(w, r, tpls.messages),
+		}
+		if clicks != nil {
+			data.Clicks = clicks.Top(30)
+		}
+		if sched != nil {
+			data.Schedule = sched.Entries()
+		}
+		if rules != nil {
+			data.Rules, data.RulesEnabled = rules.Rules(), true
+		}
+		if pins != nil {
+			data.Pinned, data.PinsEnabled = pins.IDs(), true
+		}
+		if watches != nil {
+			data.Watched, data.WatchEnabled = watches.Threads(), true
+		}
+		data.Time = time.Now().Sub(start)
+		if err := tpls.render(w, "admin", data); err != nil {
+			http.Error(w, "Failed to process the template", http.StatusInternalServerError)
+		}
+	})
+}
+
+// metricsHandler serves /metrics, the counters of the server in the
+// Prometheus text format. clicks may be nil.
+func metricsHandler(cache *Cache, metrics *hn.Metrics, clicks *clickCounter, queue *jobs.Queue) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var buf bytes.Buffer
+		metric := func(name, kind, help string, value float64) {
+			fmt.Fprintf(&buf, "# HELP %s %s\n# TYPE %s %s\n%s %s\n", name, help, name, kind, name, strconv.FormatFloat(value, 'g', -1, 64))
+		}
+		build := currentBuild()
+		fmt.Fprintf(&buf, "# HELP quiet_hn_build_info The build of the server.\n# TYPE quiet_hn_build_info gauge\nquiet_hn_build_info{version=%q,commit=%q,go_version=%q} 1\n", build.Version, build.Commit, build.GoVersion)
+
+		cs := cache.Stats()
+		metric("quiet_hn_cache_hits_total", "counter", "Front page fetches served from the cache.", float64(cs.Hits))
+		metric("quiet_hn_cache_misses_total", "counter", "Front page fetches not served from the cache.", float64(cs.Misses))
+		metric("quiet_hn_cache_refreshes_total", "counter", "Successful fetches of the front page from HN.", float64(cs.Refreshes))
+		metric("quiet_hn_cache_errors_total", "counter", "Failed fetches of the front page from HN.", float64(cs.Errors))
+		metric("quiet_hn_cache_evictions_total", "counter", "Story lists evicted from the cache.", float64(cs.Evictions))
+		metric("quiet_hn_cache_bytes", "gauge", "Estimated size of the cached story lists.", float64(cs.Bytes))
+		metric("quiet_hn_cache_entries", "gauge", "Story lists in the cache.", float64(len(cs.Entries)))
+
+		us := metrics.Stats()
+		metric("quiet_hn_upstream_requests_total", "counter", "Requests to the HN API.", float64(us.Requests))
+		metric("quiet_hn_upstream_reused_conns_total", "counter", "Requests to the HN API made on a reused connection.", float64(us.ReusedConns))
+		metric("quiet_hn_upstream_ttfb_mean_seconds", "gauge", "Mean time to the first byte of the responses of the HN API.", us.TimeToFirstByte.MeanMS/1000)
+
+		js := queue.Stats()
+		metric("quiet_hn_jobs_queued", "gauge", "Background jobs waiting for a worker.", float64(js.Queued))
+		metric("quiet_hn_jobs_running", "gauge", "Background jobs running.", float64(js.Running))
+		metric("quiet_hn_jobs_done_total", "counter", "Background jobs which succeeded.", float64(js.Done))
+		metric("quiet_hn_jobs_retried_total", "counter", "Failed runs of background jobs which were retried.", float64(js.Retried))
+		metric("quiet_hn_jobs_failed_total", "counter", "Background jobs which failed past their retries.", float64(js.Failed))
+		metric("quiet_hn_jobs_dropped_total", "counter", "Background jobs dropped as the queue was full.", float64(js.Dropped))
+
+		if clicks != nil {
+			metric("quiet_hn_clicks_total", "counter", "Clicks on story links through /out.", float64(clicks.Total()))
+		}
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+		w.Write(buf.Bytes())
+	})
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/mmxmb/quiet_hn/router"
+)
+
+// apiStory is a front page story as returned by /api/stories
+type apiStory struct {
+	ID         int    `json:"id"`
+	Rank       int    `json:"rank"`
+	Type       string `json:"type"`
+	Title      string `json:"title"`
+	URL        string `json:"url,omitempty"`
+	Host       string `json:"host,omitempty"`
+	By         string `json:"by"`
+	Time       int    `json:"time"`
+	Score      int    `json:"score"`
+	Comments   int    `json:"comments"`
+	Paywalled  bool   `json:"paywalled"`
+	ArchiveURL string `json:"archive_url,omitempty"`
+}
+
+// storiesAPIHandler serves /api/stories, the front page as JSON. Unlike the
+// page, preferences are only read from the query string and never saved.
+// The stories can be sorted and paged through, n at a time.
+func storiesAPIHandler(client StoryProvider, cache *Cache, cfg config) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := newQueryParams(r)
+		prefs := cfg.Defaults
+		prefs.HideJobs = q.Bool("hide_jobs", prefs.HideJobs)
+		prefs.HidePaywalled = q.Bool("hide_paywalled", prefs.HidePaywalled)
+		n := q.Int("n", cfg.NumStories, 1, cfg.NumStories)
+		page := q.Int("page", 1, 1, cfg.NumStories)
+		by := q.Enum("sort", sortByRank, storyOrders...)
+		if err := q.Err(); err != nil {
+			writeQueryError(w, err)
+			return
+		}
+		stories, err := cachedTopStories(r.Context(), client, cache, cfg, newFilter(cfg, prefs))
+		if err != nil {
+			writeAPIError(w, http.StatusBadGateway, "failed to load the top stories")
+			return
+		}
+		sortStories(stories, by)
+		stories = pageOf(stories, page, n)
+		cfg.Archive.decorate(stories)
+		markPaywalled(stories, cfg.PaywallDomains)
+
+		ret := make([]apiStory, len(stories))
+		for i, story := range stories {
+			ret[i] = apiStory{
+				ID:         story.ID,
+				Rank:       story.Rank,
+				Type:       story.Type,
+				Title:      story.Title,
+				URL:        story.URL,
+				Host:       story.Host,
+				By:         story.By,
+				Time:       story.Time,
+				Score:      story.Score,
+				Comments:   story.Descendants,
+				Paywalled:  story.Paywalled,
+				ArchiveURL: story.ArchiveURL,
+			}
+		}
+		writeJSON(w, struct {
+			Stories []apiStory `json:"stories"`
+		}{ret})
+	})
+}
+
+// itemAPIHandler serves /api/item/{id}, any item as returned by the HN API
+func itemAPIHandler(client StoryProvider) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(router.Param(r, "id"))
+		if err != nil || id <= 0 {
+			writeAPIError(w, http.StatusBadRequest, "invalid item id")
+			return
+		}
+		hnItem, err := client.GetItem(id)
+		if err != nil {
+			writeAPIError(w, http.StatusBadGateway, "failed to load the item")
+			return
+		}
+		if !hnItem.Alive() {
+			writeAPIError(w, http.StatusNotFound, "item not found")
+			return
+		}
+		writeJSON(w, hnItem)
+	})
+}
+
+// cacheStatsHandler serves /api/cache/stats, the statistics of the front
+// page cache
+func cacheStatsHandler(cache *Cache) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, cache.Stats())
+	})
+}
+
+// writeJSON responds with v encoded as JSON. v is encoded before anything is
+// written, so that the response has a Content-Length, which the responses
+// to HEAD requests need.
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	writeJSONStatus(w, http.StatusOK, v)
+}
+
+// writeJSONStatus is writeJSON with a status other than 200
+func writeJSONStatus(w http.ResponseWriter, status int, v interface{}) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "failed to encode the response")
+		return
+	}
+	body = append(body, '\n')
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+// writeAPIError responds with status and a JSON body such as
+// {"error": "item not found"}
+func writeAPIError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(struct {
+		Error string `json:"error"`
+	}{message})
+}
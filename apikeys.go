@@ -0,0 +1,238 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// apiKey is an entry of the -api_keys file:
+//
+//	[{"name": "my-app", "key": "8f4e...", "requests_per_minute": 60}]
+type apiKey struct {
+	Name              string `json:"name"`
+	Key               string `json:"key"`
+	RequestsPerMinute int    `json:"requests_per_minute"`
+}
+
+// apiUsage are the usage counters of a key
+type apiUsage struct {
+	Requests int64 `json:"requests"`
+	// Limited is the number of requests rejected for going over the rate
+	// limit
+	Limited  int64     `json:"limited"`
+	LastUsed time.Time `json:"last_used"`
+}
+
+// apiKeys authenticates requests to the JSON API and enforces the rate
+// limit of each key with a token bucket. It is safe for concurrent use.
+type apiKeys struct {
+	keys []apiKey
+
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+	// usage is keyed by key name, so counters survive key rotations
+	usage map[string]*apiUsage
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// loadAPIKeys reads the keys in the JSON file at path
+func loadAPIKeys(path string) (*apiKeys, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var keys []apiKey
+	if err := json.Unmarshal(b, &keys); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	for _, k := range keys {
+		if k.Name == "" || k.Key == "" || k.RequestsPerMinute <= 0 {
+			return nil, fmt.Errorf("%s: every key needs a name, a key and a positive requests_per_minute", path)
+		}
+	}
+	return newAPIKeys(keys), nil
+}
+
+func newAPIKeys(keys []apiKey) *apiKeys {
+	return &apiKeys{
+		keys:    keys,
+		buckets: make(map[string]*tokenBucket),
+		usage:   make(map[string]*apiUsage),
+	}
+}
+
+// lookup returns the key matching the secret s
+func (a *apiKeys) lookup(s string) (apiKey, bool) {
+	for _, k := range a.keys {
+		if subtle.ConstantTimeCompare([]byte(k.Key), []byte(s)) == 1 {
+			return k, true
+		}
+	}
+	return apiKey{}, false
+}
+
+// allow records a request made with k at now and reports whether it is
+// within the rate limit of k. If it isn't, it also returns how long until the
+// next request would be allowed.
+func (a *apiKeys) allow(k apiKey, now time.Time) (bool, time.Duration) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	usage := a.usage[k.Name]
+	if usage == nil {
+		usage = &apiUsage{}
+		a.usage[k.Name] = usage
+	}
+	usage.Requests++
+	usage.LastUsed = now
+
+	rate := float64(k.RequestsPerMinute) / float64(time.Minute)
+	bucket := a.buckets[k.Name]
+	if bucket == nil {
+		bucket = &tokenBucket{tokens: float64(k.RequestsPerMinute), last: now}
+		a.buckets[k.Name] = bucket
+	}
+	bucket.tokens = math.Min(float64(k.RequestsPerMinute), bucket.tokens+float64(now.Sub(bucket.last))*rate)
+	bucket.last = now
+	if bucket.tokens < 1 {
+		usage.Limited++
+		return false, time.Duration((1 - bucket.tokens) / rate)
+	}
+	bucket.tokens--
+	return true, 0
+}
+
+// Usage returns the usage counters of the key named name
+func (a *apiKeys) Usage(name string) apiUsage {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if u := a.usage[name]; u != nil {
+		return *u
+	}
+	return apiUsage{}
+}
+
+// usageBackend is where the usage counters are kept, by key name
+type usageBackend interface {
+	Load() (map[string]*apiUsage, error)
+	Save(usage map[string]*apiUsage) error
+}
+
+// fileUsage keeps the usage counters in a JSON file
+type fileUsage struct {
+	path string
+}
+
+// Load reads the counters of the file, none if it doesn't exist
+func (f fileUsage) Load() (map[string]*apiUsage, error) {
+	b, err := ioutil.ReadFile(f.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var usage map[string]*apiUsage
+	if err := json.Unmarshal(b, &usage); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", f.path, err)
+	}
+	return usage, nil
+}
+
+// Save writes usage to the file, replacing it atomically
+func (f fileUsage) Save(usage map[string]*apiUsage) error {
+	b, err := json.MarshalIndent(usage, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := f.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, f.path)
+}
+
+// save saves the usage counters to backend
+func (a *apiKeys) save(backend usageBackend) error {
+	a.mu.Lock()
+	usage := make(map[string]*apiUsage, len(a.usage))
+	for name, u := range a.usage {
+		copied := *u
+		usage[name] = &copied
+	}
+	a.mu.Unlock()
+	return backend.Save(usage)
+}
+
+// load reads the usage counters saved to backend
+func (a *apiKeys) load(backend usageBackend) error {
+	usage, err := backend.Load()
+	if err != nil {
+		return err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for name, u := range usage {
+		a.usage[name] = u
+	}
+	return nil
+}
+
+// requestKey returns the API key of r, passed as a bearer token, in the
+// X-API-Key header or in the api_key query parameter
+func requestKey(r *http.Request) string {
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimPrefix(auth, "Bearer ")
+	}
+	if k := r.Header.Get("X-API-Key"); k != "" {
+		return k
+	}
+	return r.URL.Query().Get("api_key")
+}
+
+// withAPIKeys returns h, only serving requests with a valid API key that are
+// within its rate limit. All requests are allowed if keys is nil.
+func withAPIKeys(keys *apiKeys, h http.Handler) http.Handler {
+	if keys == nil {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		k, ok := keys.lookup(requestKey(r))
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="quiet_hn"`)
+			writeAPIError(w, http.StatusUnauthorized, "a valid API key is required")
+			return
+		}
+		if ok, wait := keys.allow(k, time.Now()); !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			writeAPIError(w, http.StatusTooManyRequests, "rate limit exceeded")
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// apiUsageHandler serves /api/usage, the usage counters of the key the
+// request is made with
+func apiUsageHandler(keys *apiKeys) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		k, _ := keys.lookup(requestKey(r))
+		writeJSON(w, struct {
+			Name              string `json:"name"`
+			RequestsPerMinute int    `json:"requests_per_minute"`
+			apiUsage
+		}{k.Name, k.RequestsPerMinute, keys.Usage(k.Name)})
+	})
+}
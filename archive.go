@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// archiveServices maps the supported archive services to functions building
+// the URL of the archived copy of a page.
+var archiveServices = map[string]func(pageURL string) string{
+	"wayback": func(pageURL string) string {
+		return "https://web.archive.org/web/" + pageURL
+	},
+	"archive.today": func(pageURL string) string {
+		return "https://archive.ph/newest/" + pageURL
+	},
+}
+
+// archiveConfig configures the alternate "archive" links rendered next to
+// stories. With an empty Service no archive links are rendered.
+type archiveConfig struct {
+	Service string
+	// Stories on these domains (or their subdomains) link straight to the
+	// archived copy, eg because the site is paywalled.
+	AutoDomains []string
+}
+
+func (c archiveConfig) validate() error {
+	if _, ok := archiveServices[c.Service]; c.Service != "" && !ok {
+		return fmt.Errorf("unknown archive service %q", c.Service)
+	}
+	return nil
+}
+
+// decorate sets the ArchiveURL and AutoArchive fields of stories
+func (c archiveConfig) decorate(stories []item) {
+	archiveURL, ok := archiveServices[c.Service]
+	if !ok {
+		return
+	}
+	for i := range stories {
+		if stories[i].URL == "" || stories[i].HNItemID != 0 {
+			continue
+		}
+		stories[i].ArchiveURL = archiveURL(stories[i].URL)
+		stories[i].AutoArchive = matchesAnyDomain(stories[i].Host, c.AutoDomains)
+	}
+}
+
+// matchesAnyDomain reports whether host is one of domains or a subdomain of
+// one of them
+func matchesAnyDomain(host string, domains []string) bool {
+	for _, domain := range domains {
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
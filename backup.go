@@ -0,0 +1,340 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Backups are gzipped tarballs of the state of the server: a manifest, the
+// files it is kept in, each under its name, and, with -database, a dump of
+// the database in the custom format of pg_dump.
+const (
+	backupManifest = "manifest.json"
+	backupDump     = "database.dump"
+)
+
+// backupManifestData describes a backup
+type backupManifestData struct {
+	Created time.Time `json:"created"`
+	Version string    `json:"version"`
+	// Files are the paths the files were backed up from, by name
+	Files    map[string]string `json:"files"`
+	Database bool              `json:"database,omitempty"`
+}
+
+// stateFileFlag is a state file in the backups, backed up from and restored
+// to the path of its flag
+type stateFileFlag struct {
+	name, flag, usage string
+	appended          bool
+}
+
+// stateFiles are the flags of the files the state is kept in, by their name
+// in the backups. history and dead_letters are appended to as JSON lines.
+var stateFiles = []stateFileFlag{
+	{"history", "history_file", "the front page history file", true},
+	{"clicks", "clicks_file", "the click counts file", false},
+	{"api_usage", "api_usage_file", "the API usage file", false},
+	{"posted", "posted_file", "the file of the stories posted", false},
+	{"rules", "rules_file", "the alert rules file", false},
+	{"pinned", "pinned_file", "the file of the stories pinned", false},
+	{"watched", "watch_file", "the file of the threads watched", false},
+	{"dead_letters", "dead_letter_file", "the dead-letter log", true},
+}
+
+// backupCommand writes a backup of the state of the server, eg
+//
+//	quiet_hn backup -history_file history.jsonl -clicks_file clicks.json -o s3://backups/quiet_hn.tar.gz
+//
+// The files appended to are backed up up to their last complete line, so
+// that the server may keep running.
+func backupCommand(args []string) error {
+	fs := flag.NewFlagSet("backup", flag.ExitOnError)
+	paths := make(map[string]*string)
+	for _, f := range stateFiles {
+		paths[f.name] = fs.String(f.flag, "", f.usage+" to back up")
+	}
+	databaseURL := fs.String("database", os.Getenv("DATABASE_URL"), "the Postgres URL of the database to back up with pg_dump (defaults to $DATABASE_URL)")
+	out := fs.String("o", "", "the file or s3://bucket/key URL to write the backup to (defaults to stdout)")
+	fs.Parse(args)
+
+	files := make(map[string]string)
+	for name, path := range paths {
+		if *path != "" {
+			files[name] = *path
+		}
+	}
+	if len(files) == 0 && *databaseURL == "" {
+		return errors.New("backup: nothing to back up, set -database or some of the files")
+	}
+	w, err := createOutput(*out, "application/gzip")
+	if err != nil {
+		return err
+	}
+	if err := writeBackup(context.Background(), w, files, *databaseURL, time.Now()); err != nil {
+		w.Abort()
+		return fmt.Errorf("backup: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("backup: %w", err)
+	}
+	if *databaseURL != "" {
+		log.Printf("backed up %d files and the database", len(files))
+	} else {
+		log.Printf("backed up %d files", len(files))
+	}
+	return nil
+}
+
+func writeBackup(ctx context.Context, w io.Writer, files map[string]string, databaseURL string, now time.Time) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	add := func(name string, data []byte) error {
+		hdr := &tar.Header{Name: name, Mode: 0644, Size: int64(len(data)), ModTime: now}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		_, err := tw.Write(data)
+		return err
+	}
+
+	m := backupManifestData{Created: now.UTC(), Version: currentBuild().Version, Files: files, Database: databaseURL != ""}
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := add(backupManifest, b); err != nil {
+		return err
+	}
+	for _, f := range stateFiles {
+		path, ok := files[f.name]
+		if !ok {
+			continue
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if f.appended {
+			// leave out the line being appended, if any
+			data = data[:bytes.LastIndexByte(data, '\n')+1]
+		}
+		if err := add(f.name, data); err != nil {
+			return err
+		}
+	}
+	if databaseURL != "" {
+		var dump, stderr bytes.Buffer
+		cmd := pgCommand(ctx, databaseURL, "pg_dump", "--format=custom", "--no-owner")
+		cmd.Stdout, cmd.Stderr = &dump, &stderr
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("pg_dump: %w: %s", err, bytes.TrimSpace(stderr.Bytes()))
+		}
+		if err := add(backupDump, dump.Bytes()); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// restoreCommand restores a backup, eg
+//
+//	quiet_hn restore -i backup.tar.gz -history_file history.jsonl
+//
+// The files are restored to the paths of their flags or, with
+// -manifest_paths, to the paths they were backed up from according to the
+// manifest of the backup, and the database is restored with pg_restore. The
+// server mustn't be running.
+func restoreCommand(args []string) error {
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	paths := make(map[string]*string)
+	for _, f := range stateFiles {
+		paths[f.name] = fs.String(f.flag, "", "where to restore "+f.usage+" (defaults to where it was backed up from)")
+	}
+	databaseURL := fs.String("database", os.Getenv("DATABASE_URL"), "the Postgres URL of the database to restore the dump to with pg_restore (defaults to $DATABASE_URL)")
+	in := fs.String("i", "", "the file or s3://bucket/key URL of the backup to restore (defaults to stdin)")
+	force := fs.Bool("force", false, "overwrite the files and the tables which exist")
+	manifestPaths := fs.Bool("manifest_paths", false, "restore the files without a flag to the paths listed in the manifest of the backup, which should be trusted")
+	fs.Parse(args)
+
+	r, err := openInput(*in)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	overrides := make(map[string]string)
+	for name, path := range paths {
+		if *path != "" {
+			overrides[name] = *path
+		}
+	}
+	n, err := readBackup(context.Background(), r, overrides, *manifestPaths, *databaseURL, *force)
+	if err != nil {
+		return fmt.Errorf("restore: %w", err)
+	}
+	log.Printf("restored %d files", n)
+	return nil
+}
+
+// readBackup restores the backup read from r and returns how many files it
+// restored. The files are restored to the paths of overrides, by name, or if
+// manifestPaths is set to the ones of the manifest. Backups may come from
+// places others can write to, so only the state files are restored, and to
+// the paths of the manifest only if asked to.
+func readBackup(ctx context.Context, r io.Reader, overrides map[string]string, manifestPaths bool, databaseURL string, force bool) (int, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return 0, err
+	}
+	tr := tar.NewReader(gz)
+	hdr, err := tr.Next()
+	if err != nil || hdr.Name != backupManifest {
+		return 0, errors.New("not a backup, it doesn't start with a manifest")
+	}
+	var m backupManifestData
+	if err := json.NewDecoder(tr).Decode(&m); err != nil {
+		return 0, fmt.Errorf("reading the manifest: %w", err)
+	}
+	if m.Database && databaseURL == "" {
+		return 0, errors.New("the backup has a database dump, set -database")
+	}
+
+	n := 0
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return n, nil
+		}
+		if err != nil {
+			return n, err
+		}
+		if hdr.Name == backupDump {
+			if err := restoreDump(ctx, tr, databaseURL, force); err != nil {
+				return n, err
+			}
+			continue
+		}
+		f, ok := stateFile(hdr.Name)
+		if !ok {
+			return n, fmt.Errorf("the backup has an unknown file %q", hdr.Name)
+		}
+		path := overrides[f.name]
+		if path == "" && manifestPaths {
+			path = m.Files[f.name]
+		}
+		if path == "" {
+			if p := m.Files[f.name]; p != "" {
+				return n, fmt.Errorf("the backup has %s, backed up from %s: set -%s, or -manifest_paths to restore it there", f.usage, p, f.flag)
+			}
+			return n, fmt.Errorf("the backup has %s, set -%s", f.usage, f.flag)
+		}
+		if err := restoreFile(tr, path, force); err != nil {
+			return n, err
+		}
+		n++
+	}
+}
+
+// stateFile returns the state file with the given name in the backups
+func stateFile(name string) (stateFileFlag, bool) {
+	for _, f := range stateFiles {
+		if f.name == name {
+			return f, true
+		}
+	}
+	return stateFileFlag{}, false
+}
+
+// restoreFile writes r to path, replacing it atomically
+func restoreFile(r io.Reader, path string, force bool) error {
+	if _, err := os.Stat(path); err == nil && !force {
+		return fmt.Errorf("%s exists, set -force to overwrite it", path)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// restoreDump restores a dump of pg_dump to the database
+func restoreDump(ctx context.Context, r io.Reader, databaseURL string, force bool) error {
+	args := []string{"--no-owner", "--exit-on-error", "--single-transaction"}
+	if force {
+		args = append(args, "--clean", "--if-exists")
+	}
+	var stderr bytes.Buffer
+	cmd := pgCommand(ctx, databaseURL, "pg_restore", args...)
+	cmd.Stdin, cmd.Stderr = r, &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("pg_restore: %w: %s", err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	return nil
+}
+
+// pgCommand returns the command running the Postgres client name with args
+// on the database of databaseURL. The password of the URL is passed in
+// $PGPASSWORD rather than in the arguments, which other users can see, eg
+// in ps.
+func pgCommand(ctx context.Context, databaseURL, name string, args ...string) *exec.Cmd {
+	dbname, password := splitPassword(databaseURL)
+	cmd := exec.CommandContext(ctx, name, append(args, "--dbname="+dbname)...)
+	if password != "" {
+		cmd.Env = append(os.Environ(), "PGPASSWORD="+password)
+	}
+	return cmd
+}
+
+// splitPassword returns the connection string databaseURL, a postgres:// URL
+// or key=value pairs, without its password, and the password
+func splitPassword(databaseURL string) (string, string) {
+	if u, err := url.Parse(databaseURL); err == nil && (u.Scheme == "postgres" || u.Scheme == "postgresql") {
+		password, _ := u.User.Password()
+		if password == "" {
+			return databaseURL, ""
+		}
+		u.User = url.User(u.User.Username())
+		return u.String(), password
+	}
+	var kept []string
+	password := ""
+	for _, field := range strings.Fields(databaseURL) {
+		if v := strings.TrimPrefix(field, "password="); v != field {
+			password = strings.Trim(v, "'")
+			continue
+		}
+		kept = append(kept, field)
+	}
+	return strings.Join(kept, " "), password
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// The benchmarks of the request path, with -benchmem to track allocations:
+//
+//	go test -run '^$' -bench . -benchmem
+//
+// Most of the allocations left serving a cached front page are made by
+// html/template, which evaluates every field and function call through
+// reflection.
+
+func BenchmarkHandler_cached(b *testing.B) {
+	p := newFakeProvider(100)
+	cfg := config{Messages: testMessages(b), NumStories: 30, Concurrency: 10, PaywallDomains: defaultPaywallDomains}
+	cache := &Cache{ExpirationDuration: time.Hour, RefreshAfter: time.Hour}
+	h := handler(p, cache, cfg, nil, nil, nil, nil, nil, testTemplates(b))
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		h.ServeHTTP(discardResponse{header: make(http.Header)}, req)
+	}
+}
+
+func BenchmarkGetTopStories(b *testing.B) {
+	p := newFakeProvider(100)
+	f := filter{HideJobs: true, paywallDomains: defaultPaywallDomains}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := getTopStories(context.Background(), p, 30, 10, f); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkCache_Fetch(b *testing.B) {
+	cache := &Cache{ExpirationDuration: time.Hour, RefreshAfter: time.Hour}
+	stories, _ := getTopStories(context.Background(), newFakeProvider(30), 30, 10, filter{})
+	cache.Set(filter{}.key(), stories)
+	fetch := func(ctx context.Context) ([]item, error) { return stories, nil }
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		items, _ := cache.Fetch(context.Background(), filter{}.key(), fetch)
+		releaseItems(items)
+	}
+}
+
+// discardResponse is a ResponseWriter throwing the body away, so the
+// benchmarks only measure the handlers
+type discardResponse struct {
+	header http.Header
+}
+
+func (d discardResponse) Header() http.Header         { return d.header }
+func (d discardResponse) Write(b []byte) (int, error) { return len(b), nil }
+func (d discardResponse) WriteHeader(int)             {}
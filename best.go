@@ -0,0 +1,76 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/mmxmb/quiet_hn/history"
+	"github.com/mmxmb/quiet_hn/hn"
+	"github.com/mmxmb/quiet_hn/router"
+)
+
+// bestWindows are the periods of the /best/ pages
+var bestWindows = map[string]time.Duration{
+	"day":  24 * time.Hour,
+	"week": 7 * 24 * time.Hour,
+}
+
+// bestHandler serves /best/{period}, /best/day (also /best) and /best/week,
+// the stories with the highest scores seen on the front page over the last
+// day or week, according to the front page history.
+func bestHandler(cfg config, hist *history.Store, tpls *templateSet) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		period := router.Param(r, "period")
+		if period == "" {
+			period = "day"
+		}
+		window, ok := bestWindows[period]
+		if !ok {
+			tpls.renderError(w, r, http.StatusNotFound, "error.not_found")
+			return
+		}
+
+		peaks := hist.Peaks(start.Add(-window), start)
+		if len(peaks) > cfg.NumStories {
+			peaks = peaks[:cfg.NumStories]
+		}
+		stories := make([]item, len(peaks))
+		for i, p := range peaks {
+			stories[i] = parseHNItem(hn.Item{
+				ID:          p.ID,
+				Title:       p.Title,
+				URL:         p.URL,
+				Score:       p.PeakScore,
+				Descendants: p.Comments,
+			})
+			stories[i].Rank = i + 1
+		}
+		cfg.Archive.decorate(stories)
+		markPaywalled(stories, cfg.PaywallDomains)
+		if cfg.Redirect {
+			redirectLinks(stories)
+		}
+
+		data := bestTemplateData{
+			Period:  period,
+			Stories: stories,
+			Lang:    languagePref(w, r, cfg.Messages),
+			Time:    time.Now().Sub(start),
+		}
+		err := tpls.render(w, "best", data)
+		if err != nil {
+			http.Error(w, "Failed to process the template", http.StatusInternalServerError)
+			return
+		}
+	})
+}
+
+type bestTemplateData struct {
+	// Period is "day" or "week"
+	Period  string
+	Stories []item
+	Lang    string
+	Time    time.Duration
+}
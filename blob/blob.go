@@ -0,0 +1,267 @@
+// Package blob is a minimal client of S3-compatible object stores, such as
+// AWS S3 or MinIO: just enough to put and get the backups, exports and
+// static sites of the server without the AWS SDK.
+//
+// Requests are signed with AWS Signature Version 4, with the credentials
+// and the region of the standard AWS_* environment variables. Objects are
+// named by URLs like s3://bucket/key.
+package blob
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ErrNotFound is returned by Get for objects which don't exist
+var ErrNotFound = errors.New("blob: no such object")
+
+// Error is an error reported by the store
+type Error struct {
+	Status  int
+	Code    string
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("blob: %s: %s (status %d)", e.Code, e.Message, e.Status)
+}
+
+// Store is an S3-compatible object store
+type Store struct {
+	// Endpoint is the base URL of the store, eg
+	// https://s3.eu-west-1.amazonaws.com or http://localhost:9000
+	Endpoint        string
+	Region          string
+	AccessKeyID     string
+	SecretAccessKey string
+	// SessionToken is the token of temporary credentials, if any
+	SessionToken string
+	// PathStyle addresses the buckets as Endpoint/bucket/key rather than
+	// bucket.host/key, as MinIO and most other stores require
+	PathStyle bool
+	// Client defaults to http.DefaultClient
+	Client *http.Client
+
+	now func() time.Time
+}
+
+// FromEnv returns the store configured by the environment variables of the
+// AWS CLI: AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN,
+// AWS_REGION or AWS_DEFAULT_REGION (defaults to us-east-1) and
+// AWS_ENDPOINT_URL_S3 or AWS_ENDPOINT_URL for stores other than AWS S3,
+// which are addressed path-style.
+func FromEnv() (*Store, error) {
+	s := &Store{
+		Region:          firstEnv("AWS_REGION", "AWS_DEFAULT_REGION"),
+		AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+		Endpoint:        firstEnv("AWS_ENDPOINT_URL_S3", "AWS_ENDPOINT_URL"),
+	}
+	if s.AccessKeyID == "" || s.SecretAccessKey == "" {
+		return nil, errors.New("blob: AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY must be set")
+	}
+	if s.Region == "" {
+		s.Region = "us-east-1"
+	}
+	if s.Endpoint == "" {
+		s.Endpoint = "https://s3." + s.Region + ".amazonaws.com"
+	} else {
+		s.PathStyle = true
+	}
+	if _, err := url.Parse(s.Endpoint); err != nil {
+		return nil, fmt.Errorf("blob: bad endpoint: %w", err)
+	}
+	return s, nil
+}
+
+func firstEnv(names ...string) string {
+	for _, name := range names {
+		if v := os.Getenv(name); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// ParseURL returns the bucket and the key of an s3://bucket/key URL, and
+// false if u isn't one
+func ParseURL(u string) (bucket, key string, ok bool) {
+	rest := strings.TrimPrefix(u, "s3://")
+	if rest == u {
+		return "", "", false
+	}
+	bucket = rest
+	if i := strings.IndexByte(rest, '/'); i >= 0 {
+		bucket, key = rest[:i], rest[i+1:]
+	}
+	return bucket, key, bucket != ""
+}
+
+// Put stores body as the object key of bucket
+func (s *Store) Put(ctx context.Context, bucket, key string, body []byte, contentType string) error {
+	req, err := s.newRequest(ctx, http.MethodPut, bucket, key, body)
+	if err != nil {
+		return err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	resp, err := s.do(req, body)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// Get returns the content of the object key of bucket, which the caller
+// must close
+func (s *Store) Get(ctx context.Context, bucket, key string) (io.ReadCloser, error) {
+	req, err := s.newRequest(ctx, http.MethodGet, bucket, key, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.do(req, nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (s *Store) newRequest(ctx context.Context, method, bucket, key string, body []byte) (*http.Request, error) {
+	u, err := url.Parse(s.Endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("blob: bad endpoint: %w", err)
+	}
+	path := "/" + key
+	if s.PathStyle {
+		path = "/" + bucket + path
+	} else {
+		u.Host = bucket + "." + u.Host
+	}
+	u.Path, u.RawPath = path, uriEncode(path, false)
+	return http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+}
+
+func (s *Store) do(req *http.Request, body []byte) (*http.Response, error) {
+	s.sign(req, body)
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 == 2 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound && req.Method == http.MethodGet {
+		return nil, ErrNotFound
+	}
+	e := &Error{Status: resp.StatusCode, Code: resp.Status}
+	b, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<16))
+	xml.Unmarshal(b, &struct {
+		Code    *string
+		Message *string
+	}{&e.Code, &e.Message})
+	return nil, e
+}
+
+// sign signs req with AWS Signature Version 4, signing all of its headers
+func (s *Store) sign(req *http.Request, body []byte) {
+	now := time.Now
+	if s.now != nil {
+		now = s.now
+	}
+	t := now().UTC()
+	amzDate, date := t.Format("20060102T150405Z"), t.Format("20060102")
+	payload := sha256.Sum256(body)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(payload[:]))
+	if s.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.SessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonical strings.Builder
+	for _, name := range names {
+		canonical.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signed := strings.Join(names, ";")
+
+	request := strings.Join([]string{
+		req.Method,
+		uriEncode(req.URL.Path, false),
+		canonicalQuery(req.URL.Query()),
+		canonical.String(),
+		signed,
+		hex.EncodeToString(payload[:]),
+	}, "\n")
+	scope := date + "/" + s.Region + "/s3/aws4_request"
+	hashed := sha256.Sum256([]byte(request))
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hashed[:])
+
+	key := hmacSHA256([]byte("AWS4"+s.SecretAccessKey), date)
+	for _, part := range []string{s.Region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, toSign))
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+s.AccessKeyID+"/"+scope+",SignedHeaders="+signed+",Signature="+signature)
+}
+
+func canonicalQuery(q url.Values) string {
+	var params []string
+	for name, values := range q {
+		for _, v := range values {
+			params = append(params, uriEncode(name, true)+"="+uriEncode(v, true))
+		}
+	}
+	sort.Strings(params)
+	return strings.Join(params, "&")
+}
+
+// uriEncode percent-encodes s as Signature Version 4 does, everything but
+// the unreserved characters of RFC 3986 and, unless slash, the slashes
+func uriEncode(s string, slash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '-' || c == '.' || c == '_' || c == '~' || c == '/' && !slash {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func hmacSHA256(key []byte, msg string) []byte {
+	m := hmac.New(sha256.New, key)
+	m.Write([]byte(msg))
+	return m.Sum(nil)
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mmxmb/quiet_hn/blob"
+)
+
+// The targets of the subcommands are files, - or empty for stdin and
+// stdout, or objects of the S3-compatible store configured by the AWS_*
+// environment variables, as s3://bucket/key URLs.
+
+// output is the output of a subcommand, which is only complete once closed
+type output struct {
+	io.Writer
+	close func() error
+	abort func()
+}
+
+// Close completes the output, uploading it to its object if any
+func (o *output) Close() error { return o.close() }
+
+// Abort drops the output, removing its file if any
+func (o *output) Abort() { o.abort() }
+
+// createOutput creates the output target, of type contentType if it is an
+// object
+func createOutput(target, contentType string) (*output, error) {
+	if target == "" || target == "-" {
+		return &output{Writer: os.Stdout, close: func() error { return nil }, abort: func() {}}, nil
+	}
+	if bucket, key, ok := blob.ParseURL(target); ok {
+		store, err := blob.FromEnv()
+		if err != nil {
+			return nil, err
+		}
+		var buf bytes.Buffer
+		return &output{
+			Writer: &buf,
+			close: func() error {
+				if err := store.Put(context.Background(), bucket, key, buf.Bytes(), contentType); err != nil {
+					return fmt.Errorf("uploading %s: %w", target, err)
+				}
+				return nil
+			},
+			abort: func() {},
+		}, nil
+	}
+	f, err := os.Create(target)
+	if err != nil {
+		return nil, err
+	}
+	return &output{
+		Writer: f,
+		close:  f.Close,
+		abort: func() {
+			f.Close()
+			os.Remove(target)
+		},
+	}, nil
+}
+
+// openInput opens the input target
+func openInput(target string) (io.ReadCloser, error) {
+	if target == "" || target == "-" {
+		return io.NopCloser(os.Stdin), nil
+	}
+	if bucket, key, ok := blob.ParseURL(target); ok {
+		store, err := blob.FromEnv()
+		if err != nil {
+			return nil, err
+		}
+		body, err := store.Get(context.Background(), bucket, key)
+		if err != nil {
+			return nil, fmt.Errorf("downloading %s: %w", target, err)
+		}
+		return body, nil
+	}
+	return os.Open(target)
+}
+
+// sitePages returns a function writing the pages of a static site to the
+// directory out, or under the prefix of out if it is an s3://bucket/prefix
+// URL
+func sitePages(out string) (func(path string, page []byte) error, error) {
+	bucket, prefix, ok := blob.ParseURL(out)
+	if !ok {
+		return func(path string, page []byte) error {
+			file := filepath.Join(out, filepath.FromSlash(path))
+			if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+				return err
+			}
+			return os.WriteFile(file, page, 0644)
+		}, nil
+	}
+	store, err := blob.FromEnv()
+	if err != nil {
+		return nil, err
+	}
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	return func(path string, page []byte) error {
+		return store.Put(context.Background(), bucket, prefix+path, page, "text/html; charset=utf-8")
+	}, nil
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/mmxmb/quiet_hn/hn"
+)
+
+// errCircuitOpen is returned instead of calling the API while the breaker is
+// open
+var errCircuitOpen = errors.New("the HN API is unavailable, not calling it for a while")
+
+// circuitBreaker is a StoryProvider that stops calling the wrapped one after
+// Threshold consecutive failures, failing fast with errCircuitOpen instead so
+// that an outage doesn't turn every request into a storm of doomed calls.
+// Once Cooldown has passed, a single call is let through to probe the API:
+// the breaker closes if it succeeds, and stays open for another Cooldown
+// otherwise.
+type circuitBreaker struct {
+	StoryProvider
+	Threshold int
+	Cooldown  time.Duration
+
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+	probing   bool
+}
+
+func newCircuitBreaker(client StoryProvider, threshold int, cooldown time.Duration) *circuitBreaker {
+	return &circuitBreaker{StoryProvider: client, Threshold: threshold, Cooldown: cooldown}
+}
+
+// Open reports whether calls are currently failing fast
+func (b *circuitBreaker) Open() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.failures >= b.Threshold
+}
+
+// allow reports whether a call may go through, the probe if the breaker is
+// half-open
+func (b *circuitBreaker) allow(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.failures < b.Threshold {
+		return true
+	}
+	if now.Before(b.openUntil) || b.probing {
+		return false
+	}
+	b.probing = true
+	return true
+}
+
+// record counts the outcome of a call let through by allow. Canceled calls
+// say nothing of the API and are ignored.
+func (b *circuitBreaker) record(err error, now time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	wasOpen := b.failures >= b.Threshold
+	b.probing = false
+	if errors.Is(err, context.Canceled) {
+		return
+	}
+	if err == nil {
+		if wasOpen {
+			log.Printf("the HN API is back, closing the circuit breaker")
+		}
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.failures >= b.Threshold {
+		if !wasOpen {
+			log.Printf("%d consecutive failures calling the HN API, opening the circuit breaker for %s", b.failures, b.Cooldown)
+		}
+		b.openUntil = now.Add(b.Cooldown)
+	}
+}
+
+func (b *circuitBreaker) TopItems() ([]int, error) {
+	if !b.allow(time.Now()) {
+		return nil, errCircuitOpen
+	}
+	ids, err := b.StoryProvider.TopItems()
+	b.record(err, time.Now())
+	return ids, err
+}
+
+func (b *circuitBreaker) GetItem(id int) (hn.Item, error) {
+	if !b.allow(time.Now()) {
+		return hn.Item{}, hn.ItemError{ID: id, Err: errCircuitOpen}
+	}
+	itm, err := b.StoryProvider.GetItem(id)
+	b.record(err, time.Now())
+	return itm, err
+}
+
+// GetItems counts as a failure only if none of the items could be fetched:
+// single items failing are more likely a problem of theirs than an outage.
+func (b *circuitBreaker) GetItems(ctx context.Context, ids []int, concurrency int) ([]hn.Item, error) {
+	if !b.allow(time.Now()) {
+		return make([]hn.Item, len(ids)), errCircuitOpen
+	}
+	items, err := b.StoryProvider.GetItems(ctx, ids, concurrency)
+	outcome := err
+	var itemErrs hn.ItemErrors
+	switch {
+	case ctx.Err() != nil:
+		// the items that failed were abandoned by the caller
+		outcome = context.Canceled
+	case errors.As(err, &itemErrs) && len(itemErrs) < len(ids):
+		outcome = nil
+	}
+	b.record(outcome, time.Now())
+	return items, err
+}
+
+func (b *circuitBreaker) GetUser(username string) (hn.User, error) {
+	if !b.allow(time.Now()) {
+		return hn.User{}, errCircuitOpen
+	}
+	user, err := b.StoryProvider.GetUser(username)
+	b.record(err, time.Now())
+	return user, err
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mmxmb/quiet_hn/hn"
+)
+
+// failingProvider fails every call while down is set
+type failingProvider struct {
+	*fakeProvider
+	down  bool
+	calls int
+}
+
+var errDown = errors.New("upstream is down")
+
+func (p *failingProvider) TopItems() ([]int, error) {
+	p.calls++
+	if p.down {
+		return nil, errDown
+	}
+	return p.fakeProvider.TopItems()
+}
+
+func (p *failingProvider) GetItems(ctx context.Context, ids []int, concurrency int) ([]hn.Item, error) {
+	p.calls++
+	if p.down {
+		return make([]hn.Item, len(ids)), errDown
+	}
+	return p.fakeProvider.GetItems(ctx, ids, concurrency)
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	p := &failingProvider{fakeProvider: newFakeProvider(3), down: true}
+	b := newCircuitBreaker(p, 2, time.Minute)
+
+	for i := 0; i < 2; i++ {
+		if _, err := b.TopItems(); !errors.Is(err, errDown) {
+			t.Fatalf("TopItems() #%d: want errDown, got %v", i+1, err)
+		}
+	}
+	if !b.Open() {
+		t.Fatalf("want the breaker open after 2 failures")
+	}
+	if _, err := b.GetItems(context.Background(), []int{1}, 1); !errors.Is(err, errCircuitOpen) {
+		t.Errorf("GetItems() while open: want errCircuitOpen, got %v", err)
+	}
+	if p.calls != 2 {
+		t.Errorf("calls: want %d, got %d", 2, p.calls)
+	}
+
+	// past the cooldown, a failed probe keeps it open
+	b.openUntil = time.Now()
+	if _, err := b.TopItems(); !errors.Is(err, errDown) {
+		t.Errorf("probe: want errDown, got %v", err)
+	}
+	if _, err := b.TopItems(); !errors.Is(err, errCircuitOpen) {
+		t.Errorf("TopItems() after a failed probe: want errCircuitOpen, got %v", err)
+	}
+
+	// and a successful one closes it
+	p.down = false
+	b.openUntil = time.Now()
+	if _, err := b.TopItems(); err != nil {
+		t.Errorf("probe: received an error: %s", err)
+	}
+	if b.Open() {
+		t.Errorf("want the breaker closed after a successful probe")
+	}
+}
+
+func TestCircuitBreaker_partialFailures(t *testing.T) {
+	p := newFakeProvider(3)
+	b := newCircuitBreaker(p, 1, time.Minute)
+	// item 4 doesn't exist, but the others were fetched
+	b.GetItems(context.Background(), []int{1, 2, 4}, 2)
+	if b.Open() {
+		t.Errorf("want the breaker closed after a partial failure")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	b.GetItems(ctx, []int{4}, 2)
+	if b.Open() {
+		t.Errorf("want the breaker closed after a canceled call")
+	}
+}
+
+func TestHandler_stale(t *testing.T) {
+	p := &failingProvider{fakeProvider: newFakeProvider(3)}
+	cfg := config{Messages: testMessages(t), NumStories: 3, Concurrency: 2}
+	cache := &Cache{ExpirationDuration: time.Millisecond}
+	h := handler(p, cache, cfg, nil, nil, nil, nil, nil, testTemplates(t))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	time.Sleep(2 * time.Millisecond)
+	p.down = true
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status code: want %d, got %d", http.StatusOK, rec.Code)
+	}
+	body := rec.Body.String()
+	if !strings.Contains(body, "Story 1") || !strings.Contains(body, "can&#39;t be reached") {
+		t.Errorf("body does not contain the stale stories and the banner:\n%s", body)
+	}
+}
@@ -0,0 +1,407 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/mmxmb/quiet_hn/jobs"
+)
+
+// Cache stores lists of items under a key, eg one list per filter, each of
+// which expires ExpirationDuration after it was set.
+//
+// Every key has two slots, A and B, that are set in turns: setting a list
+// keeps the previous one until it expires. Fetch uses this to refresh lists
+// in the background RefreshAfter after they were set, while they are still
+// being served, so that no request waits on a refresh as long as there is
+// traffic at least every ExpirationDuration-RefreshAfter.
+//
+// To keep the lists of different keys from expiring and being refreshed in
+// the same second, both durations are randomly shortened by up to Jitter
+// (a fraction, eg 0.1 for up to 10%) for every list, and background
+// refreshes are started at least RefreshSpacing apart.
+//
+// MaxEntries and MaxBytes, if set, bound the number of keys and the
+// (estimated) size of their lists, evicting the least recently used keys.
+//
+// The background refreshes are run on Jobs, if set, or in goroutines of
+// their own otherwise.
+type Cache struct {
+	ExpirationDuration time.Duration
+	// RefreshAfter is how long after a list is set Fetch refreshes it. It
+	// defaults to half of ExpirationDuration.
+	RefreshAfter   time.Duration
+	Jitter         float64
+	RefreshSpacing time.Duration
+	MaxEntries     int
+	MaxBytes       int64
+	Jobs           *jobs.Queue
+
+	mu      sync.RWMutex
+	entries map[string]*cacheSlots
+	// lru has the keys, most recently used first
+	lru   *list.List
+	bytes int64
+	// nextRefresh is the earliest time the next background refresh may start
+	nextRefresh time.Time
+	stats       cacheCounters
+}
+
+// cacheCounters are the counters of the CacheStats, guarded by Cache.mu
+type cacheCounters struct {
+	hits, misses, errors int64
+	refreshes, evictions int64
+	refreshTime          time.Duration
+	lastRefreshTime      time.Duration
+}
+
+// CacheStats are statistics about the use of a Cache.
+type CacheStats struct {
+	// Hits and Misses count the Fetch calls served from the cache or not
+	Hits   int64 `json:"hits"`
+	Misses int64 `json:"misses"`
+	// Refreshes counts the successful fetches, in the background or not, and
+	// Errors the failed ones
+	Refreshes int64 `json:"refreshes"`
+	Errors    int64 `json:"errors"`
+	// Evictions counts the keys evicted to stay within the bounds
+	Evictions int64 `json:"evictions"`
+	// Bytes is the estimated size of the cached lists
+	Bytes int64 `json:"bytes"`
+	// AvgRefreshMillis and LastRefreshMillis are the average and last
+	// durations of the successful fetches
+	AvgRefreshMillis  float64           `json:"avg_refresh_ms"`
+	LastRefreshMillis float64           `json:"last_refresh_ms"`
+	Entries           []CacheEntryStats `json:"entries"`
+}
+
+// CacheEntryStats describe the most recent list of a key.
+type CacheEntryStats struct {
+	Key   string `json:"key"`
+	Items int    `json:"items"`
+	// AgeSeconds is how long ago the list was set
+	AgeSeconds float64 `json:"age_seconds"`
+	Expired    bool    `json:"expired"`
+	Refreshing bool    `json:"refreshing"`
+}
+
+type cacheEntry struct {
+	items      []item
+	set        time.Time
+	refreshAt  time.Time
+	expiration time.Time
+}
+
+// cacheSlots are the two lists of a key. current is the index of the most
+// recently set one.
+type cacheSlots struct {
+	slots      [2]cacheEntry
+	current    int
+	refreshing bool
+	elem       *list.Element
+}
+
+// bytes returns the estimated size of the lists of s
+func (s *cacheSlots) bytes() int64 {
+	return itemsSize(s.slots[0].items) + itemsSize(s.slots[1].items)
+}
+
+// itemsSize estimates the memory used by items: the fixed size of the
+// struct plus its strings and slices
+func itemsSize(items []item) int64 {
+	const itemOverhead = 400
+	var n int64
+	for _, itm := range items {
+		n += itemOverhead + int64(len(itm.By)+len(itm.Title)+len(itm.Text)+len(itm.URL)+len(itm.Host)+
+			len(itm.Label)+len(itm.ArchiveURL)+len(itm.Summary)+len(itm.Thumbnail)+len(itm.Favicon)) +
+			8*int64(len(itm.Kids)+len(itm.Parts))
+	}
+	return n
+}
+
+// valid returns the most recent list of s that hasn't expired at now
+func (s *cacheSlots) valid(now time.Time) (cacheEntry, bool) {
+	for _, i := range []int{s.current, 1 - s.current} {
+		if now.Before(s.slots[i].expiration) {
+			return s.slots[i], true
+		}
+	}
+	return cacheEntry{}, false
+}
+
+func (c *Cache) IsExpired(key string) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	s := c.entries[key]
+	if s == nil {
+		return true
+	}
+	_, ok := s.valid(time.Now())
+	return !ok
+}
+
+func (c *Cache) IsEmpty(key string) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	s := c.entries[key]
+	return s == nil || len(s.slots[s.current].items) == 0
+}
+
+// Set sets the list of key to items, in the slot that doesn't hold the most
+// recent list.
+func (c *Cache) Set(key string, items []item) {
+	c.mu.Lock()
+	if c.entries == nil {
+		c.entries = make(map[string]*cacheSlots)
+		c.lru = list.New()
+	}
+	s := c.entries[key]
+	if s == nil {
+		s = &cacheSlots{current: 1, elem: c.lru.PushFront(key)}
+		c.entries[key] = s
+	}
+	c.lru.MoveToFront(s.elem)
+	c.bytes -= s.bytes()
+	now := time.Now()
+	s.current = 1 - s.current
+	s.slots[s.current] = cacheEntry{
+		items:      items,
+		set:        now,
+		refreshAt:  now.Add(c.jitter(c.refreshAfter())),
+		expiration: now.Add(c.jitter(c.ExpirationDuration)),
+	}
+	c.bytes += s.bytes()
+	c.evict()
+	c.mu.Unlock()
+}
+
+// evict removes the least recently used keys, but the most recent one, until
+// c is within its bounds. c.mu must be held.
+func (c *Cache) evict() {
+	for c.lru.Len() > 1 && (c.MaxEntries > 0 && c.lru.Len() > c.MaxEntries || c.MaxBytes > 0 && c.bytes > c.MaxBytes) {
+		key := c.lru.Remove(c.lru.Back()).(string)
+		c.bytes -= c.entries[key].bytes()
+		delete(c.entries, key)
+		c.stats.evictions++
+	}
+}
+
+// jitter returns d shortened by a random fraction of up to Jitter
+func (c *Cache) jitter(d time.Duration) time.Duration {
+	if c.Jitter <= 0 {
+		return d
+	}
+	return d - time.Duration(rand.Float64()*c.Jitter*float64(d))
+}
+
+// Get returns a copy of the most recent list of key that hasn't expired, or
+// of the most recent one if both have.
+func (c *Cache) Get(key string) []item {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := c.entries[key]
+	if s == nil {
+		return []item{}
+	}
+	c.lru.MoveToFront(s.elem)
+	entry, ok := s.valid(time.Now())
+	if !ok {
+		entry = s.slots[s.current]
+	}
+	return copyItems(entry.items)
+}
+
+// Stale returns a copy of the most recent list of key and when it was set,
+// even if it has expired, for when a fresh one can't be fetched. ok is false
+// if there is none.
+func (c *Cache) Stale(key string) (items []item, set time.Time, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := c.entries[key]
+	if s == nil || len(s.slots[s.current].items) == 0 {
+		return nil, time.Time{}, false
+	}
+	entry := s.slots[s.current]
+	return copyItems(entry.items), entry.set, true
+}
+
+// Purge removes the lists of key, or of every key if key is empty, so they
+// are fetched again by the next request, and returns how many were removed
+func (c *Cache) Purge(key string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := 0
+	for k, s := range c.entries {
+		if key == "" || k == key {
+			c.lru.Remove(s.elem)
+			c.bytes -= s.bytes()
+			delete(c.entries, k)
+			n++
+		}
+	}
+	return n
+}
+
+// Fetch returns the list of key, calling fetch to get it if it isn't cached
+// or has expired. Lists set more than RefreshAfter ago are returned as is and
+// refreshed in the background, with a context that isn't tied to ctx since the
+// refresh outlives the request. Failed background refreshes are retried by
+// the next Fetch.
+func (c *Cache) Fetch(ctx context.Context, key string, fetch func(ctx context.Context) ([]item, error)) ([]item, error) {
+	now := time.Now()
+	c.mu.Lock()
+	s := c.entries[key]
+	var entry cacheEntry
+	ok := false
+	if s != nil {
+		entry, ok = s.valid(now)
+	}
+	if ok && len(entry.items) > 0 {
+		c.stats.hits++
+		c.lru.MoveToFront(s.elem)
+		if !now.Before(entry.refreshAt) && !s.refreshing {
+			s.refreshing = true
+			start := now
+			if start.Before(c.nextRefresh) {
+				start = c.nextRefresh
+			}
+			c.nextRefresh = start.Add(c.RefreshSpacing)
+			c.startRefresh(key, start.Sub(now), fetch)
+		}
+		c.mu.Unlock()
+		return copyItems(entry.items), nil
+	}
+	c.stats.misses++
+	c.mu.Unlock()
+
+	items, err := c.timedFetch(ctx, fetch)
+	if err != nil {
+		return nil, err
+	}
+	c.Set(key, items)
+	return copyItems(items), nil
+}
+
+// timedFetch calls fetch, counting it in the stats
+func (c *Cache) timedFetch(ctx context.Context, fetch func(ctx context.Context) ([]item, error)) ([]item, error) {
+	start := time.Now()
+	items, err := fetch(ctx)
+	elapsed := time.Now().Sub(start)
+	c.mu.Lock()
+	if err != nil {
+		c.stats.errors++
+	} else {
+		c.stats.refreshes++
+		c.stats.refreshTime += elapsed
+		c.stats.lastRefreshTime = elapsed
+	}
+	c.mu.Unlock()
+	return items, err
+}
+
+// Stats returns the statistics of c, with the entries sorted by key
+func (c *Cache) Stats() CacheStats {
+	now := time.Now()
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	stats := CacheStats{
+		Hits:              c.stats.hits,
+		Misses:            c.stats.misses,
+		Refreshes:         c.stats.refreshes,
+		Errors:            c.stats.errors,
+		Evictions:         c.stats.evictions,
+		Bytes:             c.bytes,
+		LastRefreshMillis: c.stats.lastRefreshTime.Seconds() * 1000,
+		Entries:           []CacheEntryStats{},
+	}
+	if c.stats.refreshes > 0 {
+		stats.AvgRefreshMillis = c.stats.refreshTime.Seconds() * 1000 / float64(c.stats.refreshes)
+	}
+	for key, s := range c.entries {
+		entry := s.slots[s.current]
+		_, valid := s.valid(now)
+		stats.Entries = append(stats.Entries, CacheEntryStats{
+			Key:        key,
+			Items:      len(entry.items),
+			AgeSeconds: now.Sub(entry.set).Seconds(),
+			Expired:    !valid,
+			Refreshing: s.refreshing,
+		})
+	}
+	sort.Slice(stats.Entries, func(i, j int) bool {
+		return stats.Entries[i].Key < stats.Entries[j].Key
+	})
+	return stats
+}
+
+// startRefresh refreshes the list of key in the background after delay.
+// It is called with c.mu held.
+func (c *Cache) startRefresh(key string, delay time.Duration, fetch func(ctx context.Context) ([]item, error)) {
+	if c.Jobs == nil {
+		go func() {
+			time.Sleep(delay)
+			c.refresh(context.Background(), key, fetch)
+		}()
+		return
+	}
+	ok := c.Jobs.Submit(jobs.Job{
+		Name:  "refresh " + key,
+		Delay: delay,
+		Run: func(ctx context.Context) error {
+			// a failed refresh is retried by the next Fetch
+			return c.refresh(ctx, key, fetch)
+		},
+	})
+	if !ok {
+		c.entries[key].refreshing = false
+	}
+}
+
+func (c *Cache) refresh(ctx context.Context, key string, fetch func(ctx context.Context) ([]item, error)) error {
+	ctx, cancel := context.WithTimeout(ctx, c.ExpirationDuration)
+	defer cancel()
+	items, err := c.timedFetch(ctx, fetch)
+	if err == nil {
+		c.Set(key, items)
+	}
+	c.mu.Lock()
+	// the key may have been evicted in the meantime
+	if s := c.entries[key]; s != nil {
+		s.refreshing = false
+	}
+	c.mu.Unlock()
+	return err
+}
+
+func (c *Cache) refreshAfter() time.Duration {
+	if c.RefreshAfter > 0 {
+		return c.RefreshAfter
+	}
+	return c.ExpirationDuration / 2
+}
+
+// itemSlices recycles the copies of the cached lists handed out for every
+// front page request, which callers done with them return with releaseItems
+var itemSlices = sync.Pool{New: func() interface{} { return new([]item) }}
+
+// copyItems returns a copy of items the caller may modify, reusing a
+// released slice if there is one
+func copyItems(items []item) []item {
+	ret := *itemSlices.Get().(*[]item)
+	return append(ret[:0], items...)
+}
+
+// releaseItems makes items, a list returned by the Cache, available for
+// reuse. items must not be used afterwards.
+func releaseItems(items []item) {
+	// the strings of the stories shouldn't outlive them
+	for i := range items {
+		items[i] = item{}
+	}
+	items = items[:0]
+	itemSlices.Put(&items)
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mmxmb/quiet_hn/hn"
+	"github.com/mmxmb/quiet_hn/jobs"
+)
+
+func TestCache_Fetch(t *testing.T) {
+	cache := &Cache{ExpirationDuration: time.Hour, RefreshAfter: time.Hour}
+	var calls int32
+	fetch := func(ctx context.Context) ([]item, error) {
+		n := atomic.AddInt32(&calls, 1)
+		return []item{{Item: hn.Item{ID: int(n)}}}, nil
+	}
+
+	for i := 0; i < 3; i++ {
+		items, err := cache.Fetch(context.Background(), "key", fetch)
+		if err != nil {
+			t.Fatalf("Fetch() received an error: %s", err.Error())
+		}
+		if len(items) != 1 || items[0].ID != 1 {
+			t.Errorf("Fetch() #%d: want the first list, got %v", i+1, items)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("fetch calls: want %d, got %d", 1, calls)
+	}
+
+	if _, err := cache.Fetch(context.Background(), "other", func(ctx context.Context) ([]item, error) {
+		return nil, errors.New("upstream error")
+	}); err == nil {
+		t.Errorf("Fetch() with a failing fetch: want an error, got nil")
+	}
+}
+
+func TestCache_Fetch_backgroundRefresh(t *testing.T) {
+	t.Run("goroutine", func(t *testing.T) {
+		testBackgroundRefresh(t, &Cache{ExpirationDuration: time.Hour, RefreshAfter: time.Nanosecond})
+	})
+	t.Run("jobs", func(t *testing.T) {
+		q := jobs.New(jobs.Config{Size: 1})
+		testBackgroundRefresh(t, &Cache{ExpirationDuration: time.Hour, RefreshAfter: time.Nanosecond, Jobs: q})
+		q.Close(time.Second)
+		if s := q.Stats(); s.Done != 1 {
+			t.Errorf("jobs done: want 1 refresh, got %+v", s)
+		}
+	})
+}
+
+func testBackgroundRefresh(t *testing.T, cache *Cache) {
+	t.Helper()
+	refreshed := make(chan struct{})
+	var calls int32
+	fetch := func(ctx context.Context) ([]item, error) {
+		n := atomic.AddInt32(&calls, 1)
+		if n == 2 {
+			defer close(refreshed)
+		}
+		return []item{{Item: hn.Item{ID: int(n)}}}, nil
+	}
+
+	cache.Fetch(context.Background(), "key", fetch)
+	// the list is due for a refresh: it is still served while the other
+	// slot is filled in the background
+	items, _ := cache.Fetch(context.Background(), "key", fetch)
+	if items[0].ID != 1 {
+		t.Errorf("Fetch() during the refresh: want the first list, got %v", items)
+	}
+	select {
+	case <-refreshed:
+	case <-time.After(time.Second):
+		t.Fatal("the list wasn't refreshed in the background")
+	}
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		if cache.Get("key")[0].ID == 2 {
+			return
+		}
+	}
+	t.Errorf("Get() after the refresh: want the second list, got %v", cache.Get("key"))
+}
+
+func TestCache_rotation(t *testing.T) {
+	cache := &Cache{ExpirationDuration: 50 * time.Millisecond}
+	cache.Set("key", []item{{Item: hn.Item{ID: 1}}})
+	time.Sleep(30 * time.Millisecond)
+	cache.Set("key", []item{{Item: hn.Item{ID: 2}}})
+	if got := cache.Get("key"); got[0].ID != 2 {
+		t.Errorf("Get(): want the most recent list, got %v", got)
+	}
+	time.Sleep(30 * time.Millisecond)
+	// the first list has expired, the second hasn't
+	if cache.IsExpired("key") {
+		t.Errorf("IsExpired(): want false while the most recent list is valid")
+	}
+	time.Sleep(30 * time.Millisecond)
+	if !cache.IsExpired("key") {
+		t.Errorf("IsExpired(): want true once both lists have expired")
+	}
+}
+
+func TestCache_jitter(t *testing.T) {
+	cache := &Cache{ExpirationDuration: time.Hour, Jitter: 0.2}
+	seen := make(map[time.Duration]bool)
+	for i := 0; i < 20; i++ {
+		d := cache.jitter(time.Hour)
+		if d > time.Hour || d < 48*time.Minute {
+			t.Fatalf("jitter(1h): want between 48m and 1h, got %v", d)
+		}
+		seen[d] = true
+	}
+	if len(seen) < 2 {
+		t.Errorf("jitter(1h) always returned the same duration")
+	}
+}
+
+func TestCache_refreshSpacing(t *testing.T) {
+	cache := &Cache{ExpirationDuration: time.Hour, RefreshAfter: time.Nanosecond, RefreshSpacing: 50 * time.Millisecond}
+	started := make(chan time.Time, 3)
+	fetch := func(ctx context.Context) ([]item, error) {
+		started <- time.Now()
+		return []item{{}}, nil
+	}
+	for _, key := range []string{"a", "b", "c"} {
+		cache.Set(key, []item{{}})
+	}
+	time.Sleep(time.Millisecond)
+	for _, key := range []string{"a", "b", "c"} {
+		cache.Fetch(context.Background(), key, fetch)
+	}
+
+	var times []time.Time
+	for i := 0; i < 3; i++ {
+		select {
+		case st := <-started:
+			times = append(times, st)
+		case <-time.After(time.Second):
+			t.Fatalf("only %d of 3 refreshes started", i)
+		}
+	}
+	if d := times[2].Sub(times[0]); d < 90*time.Millisecond {
+		t.Errorf("3 refreshes spaced 50ms apart started within %v", d)
+	}
+}
+
+func TestCache_Stats(t *testing.T) {
+	cache := &Cache{ExpirationDuration: time.Hour, RefreshAfter: time.Hour}
+	fetch := func(ctx context.Context) ([]item, error) {
+		return []item{{}, {}}, nil
+	}
+	cache.Fetch(context.Background(), "b", fetch)
+	cache.Fetch(context.Background(), "b", fetch)
+	cache.Fetch(context.Background(), "a", fetch)
+	cache.Fetch(context.Background(), "c", func(ctx context.Context) ([]item, error) {
+		return nil, errors.New("upstream error")
+	})
+
+	stats := cache.Stats()
+	if stats.Hits != 1 || stats.Misses != 3 || stats.Refreshes != 2 || stats.Errors != 1 {
+		t.Errorf("Stats(): want 1 hit, 3 misses, 2 refreshes and 1 error, got %+v", stats)
+	}
+	if len(stats.Entries) != 2 || stats.Entries[0].Key != "a" || stats.Entries[1].Items != 2 || stats.Entries[1].Expired {
+		t.Errorf("Stats().Entries: want a and b with 2 items each, got %+v", stats.Entries)
+	}
+}
+
+func TestCache_eviction(t *testing.T) {
+	cache := &Cache{ExpirationDuration: time.Hour, MaxEntries: 2}
+	cache.Set("a", []item{{Item: hn.Item{ID: 1}}})
+	cache.Set("b", []item{{Item: hn.Item{ID: 2}}})
+	cache.Get("a")
+	cache.Set("c", []item{{Item: hn.Item{ID: 3}}})
+	if cache.IsEmpty("a") || !cache.IsEmpty("b") || cache.IsEmpty("c") {
+		t.Errorf("want the least recently used key b evicted")
+	}
+
+	size := itemsSize([]item{{Item: hn.Item{Title: "title"}}})
+	cache = &Cache{ExpirationDuration: time.Hour, MaxBytes: 2 * size}
+	cache.Set("a", []item{{Item: hn.Item{Title: "title"}}})
+	cache.Set("b", []item{{Item: hn.Item{Title: "title"}}})
+	if cache.IsEmpty("a") || cache.IsEmpty("b") {
+		t.Fatalf("want both keys within the bounds")
+	}
+	cache.Set("a", []item{{Item: hn.Item{Title: "title"}}})
+	// a now holds two lists in its slots
+	if cache.IsEmpty("a") || !cache.IsEmpty("b") {
+		t.Errorf("want b evicted when a grows")
+	}
+	stats := cache.Stats()
+	if stats.Evictions != 1 || stats.Bytes != 2*size {
+		t.Errorf("Stats(): want 1 eviction and %d bytes, got %+v", 2*size, stats)
+	}
+}
@@ -0,0 +1,169 @@
+// Package card renders the social cards of stories: the preview images chat
+// apps and social networks show when a link to a page is shared, as set by its
+// og:image meta tag.
+//
+// Cards are PNG images drawing the title, host and footer of a story with a
+// built-in pixel font, so no font files or image libraries are needed. The
+// font only has the printable ASCII characters; accented letters lose their
+// accents and the other characters are drawn as ?.
+package card
+
+import (
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"strings"
+)
+
+// Width and Height are the size of the cards, the size recommended for Open
+// Graph images
+const (
+	Width  = 1200
+	Height = 630
+)
+
+const (
+	margin = 80
+	// the scales of the glyphs of the title and of the other lines, in
+	// pixels per font pixel
+	titleScale = 6
+	smallScale = 4
+	// maxTitleLines is the number of lines the title is wrapped to before
+	// it is cut
+	maxTitleLines = 5
+)
+
+var palette = color.Palette{
+	color.RGBA{0xff, 0xff, 0xff, 0xff}, // background
+	color.RGBA{0x33, 0x33, 0x33, 0xff}, // title and border
+	color.RGBA{0x88, 0x88, 0x88, 0xff}, // host and footer
+}
+
+// Card is the text of a card
+type Card struct {
+	Title string
+	// Host is the host of the story, shown under the title
+	Host string
+	// Footer is shown at the bottom, eg the score and number of comments
+	Footer string
+}
+
+// Render writes c to w as a PNG image
+func Render(w io.Writer, c Card) error {
+	img := image.NewPaletted(image.Rect(0, 0, Width, Height), palette)
+	fill(img, image.Rect(0, 0, Width, 16), 1)
+
+	y := margin
+	for _, line := range wrap(fold(c.Title), (Width-2*margin)/(6*titleScale), maxTitleLines) {
+		drawText(img, margin, y, line, titleScale, 1)
+		y += 10 * titleScale
+	}
+	if c.Host != "" {
+		drawText(img, margin, y+2*smallScale, fold(c.Host), smallScale, 2)
+	}
+	if c.Footer != "" {
+		drawText(img, margin, Height-margin-7*smallScale, fold(c.Footer), smallScale, 2)
+	}
+	enc := png.Encoder{CompressionLevel: png.BestCompression}
+	return enc.Encode(w, img)
+}
+
+func fill(img *image.Paletted, r image.Rectangle, c uint8) {
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			img.SetColorIndex(x, y, c)
+		}
+	}
+}
+
+// drawText draws the ASCII text s with its top left corner at x, y, cutting
+// it at the right margin
+func drawText(img *image.Paletted, x, y int, s string, scale int, c uint8) {
+	for i := 0; i < len(s); i++ {
+		if x+5*scale > Width-margin {
+			return
+		}
+		g := font[s[i]-' ']
+		for col := 0; col < 5; col++ {
+			for row := 0; row < 7; row++ {
+				if g[col]>>uint(row)&1 == 1 {
+					px, py := x+col*scale, y+row*scale
+					fill(img, image.Rect(px, py, px+scale, py+scale), c)
+				}
+			}
+		}
+		x += 6 * scale
+	}
+}
+
+// wrap breaks s into at most maxLines lines of up to width characters,
+// between words when possible, ending the last line with ... if s doesn't fit
+func wrap(s string, width, maxLines int) []string {
+	var lines []string
+	line := ""
+	for _, word := range strings.Fields(s) {
+		for len(word) > width {
+			// words longer than a line are broken anywhere
+			if line != "" {
+				lines = append(lines, line)
+				line = ""
+			}
+			lines = append(lines, word[:width])
+			word = word[width:]
+		}
+		switch {
+		case line == "":
+			line = word
+		case len(line)+1+len(word) <= width:
+			line += " " + word
+		default:
+			lines = append(lines, line)
+			line = word
+		}
+	}
+	if line != "" {
+		lines = append(lines, line)
+	}
+	if len(lines) > maxLines {
+		lines = lines[:maxLines]
+		last := lines[maxLines-1]
+		if len(last) > width-3 {
+			last = last[:width-3]
+		}
+		lines[maxLines-1] = last + "..."
+	}
+	return lines
+}
+
+// folded are the replacements of the common characters the font doesn't
+// have
+var folded = map[rune]string{
+	'à': "a", 'á': "a", 'â': "a", 'ä': "a", 'ã': "a", 'å': "a",
+	'ç': "c", 'è': "e", 'é': "e", 'ê': "e", 'ë': "e",
+	'ì': "i", 'í': "i", 'î': "i", 'ï': "i", 'ñ': "n",
+	'ò': "o", 'ó': "o", 'ô': "o", 'ö': "o", 'õ': "o", 'ø': "o",
+	'ù': "u", 'ú': "u", 'û': "u", 'ü': "u", 'ý': "y", 'ÿ': "y",
+	'À': "A", 'Á': "A", 'Â': "A", 'Ä': "A", 'Ç': "C", 'É': "E", 'È': "E",
+	'Ö': "O", 'Ø': "O", 'Ü': "U", 'Ñ': "N", 'ß': "ss",
+	'‘': "'", '’': "'", '“': "\"", '”': "\"", '–': "-", '—': "-",
+	'…': "...", '·': "-", '•': "-", '\u00a0': " ",
+}
+
+// fold returns s with only printable ASCII characters
+func fold(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r >= ' ' && r <= '~':
+			b.WriteRune(r)
+		case r == '\t' || r == '\n':
+			b.WriteByte(' ')
+		case folded[r] != "":
+			b.WriteString(folded[r])
+		default:
+			b.WriteByte('?')
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,48 @@
+package card
+
+import (
+	"bytes"
+	"image/png"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestWrap(t *testing.T) {
+	tests := []struct {
+		s     string
+		width int
+		want  []string
+	}{
+		{"Show HN: A quiet Hacker News", 12, []string{"Show HN: A", "quiet Hacker", "News"}},
+		{"Supercalifragilistic", 8, []string{"Supercal", "ifragili", "stic"}},
+		{"one two three four five six", 5, []string{"one", "two", "th..."}},
+		{"", 10, nil},
+	}
+	for _, tc := range tests {
+		if got := wrap(tc.s, tc.width, 3); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("wrap(%q, %d): want %q, got %q", tc.s, tc.width, tc.want, got)
+		}
+	}
+}
+
+func TestFold(t *testing.T) {
+	if got, want := fold("Über “naïve” café — 日本"), "Uber \"naive\" cafe - ??"; got != want {
+		t.Errorf("fold(): want %q, got %q", want, got)
+	}
+}
+
+func TestRender(t *testing.T) {
+	var buf bytes.Buffer
+	c := Card{Title: strings.Repeat("A very long title ", 20), Host: "example.com", Footer: "512 points - 143 comments"}
+	if err := Render(&buf, c); err != nil {
+		t.Fatalf("Render() received an error: %s", err.Error())
+	}
+	img, err := png.Decode(&buf)
+	if err != nil {
+		t.Fatalf("Render() did not write a PNG: %s", err.Error())
+	}
+	if b := img.Bounds(); b.Dx() != Width || b.Dy() != Height {
+		t.Errorf("size: want %dx%d, got %dx%d", Width, Height, b.Dx(), b.Dy())
+	}
+}
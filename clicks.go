@@ -0,0 +1,250 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/mmxmb/quiet_hn/router"
+)
+
+// maxClickStories bounds the stories whose clicks are counted. /out counts
+// the clicks on any story, not only the ones listed, so past it the least
+// clicked stories are forgotten to make room for the new ones.
+const maxClickStories = 10000
+
+// clickCounter counts the clicks on the links of stories, as redirected by
+// /out. The counts stay on the server, there are no third-party analytics.
+type clickCounter struct {
+	// max is the number of stories counted, maxClickStories but in tests
+	max int
+
+	mu     sync.Mutex
+	counts map[int]int
+}
+
+func newClickCounter() *clickCounter {
+	return &clickCounter{max: maxClickStories, counts: make(map[int]int)}
+}
+
+func (c *clickCounter) add(id int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.counts[id]; !ok {
+		c.evict(c.max - 1)
+	}
+	c.counts[id]++
+}
+
+// evict forgets the least clicked stories, the oldest first, until at most n
+// are left. c.mu must be held.
+func (c *clickCounter) evict(n int) {
+	for len(c.counts) > n {
+		least, leastClicks := 0, 0
+		for id, clicks := range c.counts {
+			if least == 0 || clicks < leastClicks || clicks == leastClicks && id < least {
+				least, leastClicks = id, clicks
+			}
+		}
+		delete(c.counts, least)
+	}
+}
+
+// Count returns the number of clicks on the link of the story id
+func (c *clickCounter) Count(id int) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.counts[id]
+}
+
+// Total returns the number of clicks on the links of all the stories
+func (c *clickCounter) Total() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	total := 0
+	for _, n := range c.counts {
+		total += n
+	}
+	return total
+}
+
+// storyClicks is the number of clicks on the link of a story
+type storyClicks struct {
+	ID     int `json:"id"`
+	Clicks int `json:"clicks"`
+}
+
+// Top returns the n stories with the most clicks, most clicked first
+func (c *clickCounter) Top(n int) []storyClicks {
+	c.mu.Lock()
+	top := make([]storyClicks, 0, len(c.counts))
+	for id, clicks := range c.counts {
+		top = append(top, storyClicks{ID: id, Clicks: clicks})
+	}
+	c.mu.Unlock()
+	sort.Slice(top, func(i, j int) bool {
+		if top[i].Clicks != top[j].Clicks {
+			return top[i].Clicks > top[j].Clicks
+		}
+		return top[i].ID < top[j].ID
+	})
+	if len(top) > n {
+		top = top[:n]
+	}
+	return top
+}
+
+// clickBackend is where the click counts are kept, by story ID
+type clickBackend interface {
+	Load() (map[int]int, error)
+	Save(counts map[int]int) error
+}
+
+// fileClicks keeps the click counts in a JSON file
+type fileClicks struct {
+	path string
+}
+
+// Load reads the counts of the file, none if it doesn't exist
+func (f fileClicks) Load() (map[int]int, error) {
+	b, err := ioutil.ReadFile(f.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var counts map[int]int
+	if err := json.Unmarshal(b, &counts); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", f.path, err)
+	}
+	return counts, nil
+}
+
+// Save writes counts to the file, replacing it atomically
+func (f fileClicks) Save(counts map[int]int) error {
+	b, err := json.MarshalIndent(counts, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := f.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, f.path)
+}
+
+// save saves the counts to backend
+func (c *clickCounter) save(backend clickBackend) error {
+	c.mu.Lock()
+	counts := make(map[int]int, len(c.counts))
+	for id, n := range c.counts {
+		counts[id] = n
+	}
+	c.mu.Unlock()
+	return backend.Save(counts)
+}
+
+// load reads the counts saved to backend, adding them to the ones counted
+func (c *clickCounter) load(backend clickBackend) error {
+	counts, err := backend.Load()
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for id, n := range counts {
+		c.counts[id] = n
+	}
+	c.evict(c.max)
+	return nil
+}
+
+// outLink returns the /out URL redirecting to target, the link of the story
+// id
+func outLink(id int, target string) string {
+	return "/out?" + url.Values{"id": {strconv.Itoa(id)}, "url": {target}}.Encode()
+}
+
+// redirectLinks sets the Out field of the stories linking to other sites
+func redirectLinks(stories []item) {
+	for i := range stories {
+		if stories[i].HNItemID == 0 && stories[i].URL != "" {
+			stories[i].Out = outLink(stories[i].ID, stories[i].Link())
+		}
+	}
+}
+
+// outHandler redirects to the link of a story, counting the click. The
+// redirect drops the referrer, so the linked site can't tell where its
+// visitors come from.
+//
+// The only URLs redirected to are the link of the story and its archived
+// copy, so /out can't be used to disguise links to other sites.
+func outHandler(client StoryProvider, cfg config, clicks *clickCounter, tpls *templateSet) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := newQueryParams(r)
+		id := q.Int("id", 0, 1, math.MaxInt32)
+		if !q.Has("id") {
+			q.fail("id", "is required")
+		}
+		target := r.URL.Query().Get("url")
+		if err := q.Err(); err != nil {
+			tpls.renderQueryError(w, r, err)
+			return
+		}
+		hnItem, err := client.GetItem(id)
+		if err != nil {
+			tpls.renderError(w, r, http.StatusInternalServerError, "error.load_item")
+			return
+		}
+		story := []item{parseHNItem(hnItem)}
+		cfg.Archive.decorate(story)
+		if target == "" {
+			target = story[0].Link()
+		}
+		if story[0].URL == "" || story[0].HNItemID != 0 || target != story[0].URL && target != story[0].ArchiveURL {
+			tpls.renderError(w, r, http.StatusNotFound, "error.not_found")
+			return
+		}
+		if r.Method == http.MethodGet {
+			clicks.add(id)
+		}
+		w.Header().Set("Referrer-Policy", "no-referrer")
+		w.Header().Set("Cache-Control", "no-store")
+		http.Redirect(w, r, target, http.StatusFound)
+	})
+}
+
+// storyClicksHandler serves /api/stories/{id}/clicks, the number of clicks on
+// the link of a story
+func storyClicksHandler(clicks *clickCounter) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(router.Param(r, "id"))
+		if err != nil || id <= 0 {
+			writeAPIError(w, http.StatusBadRequest, "invalid story id")
+			return
+		}
+		writeJSON(w, storyClicks{ID: id, Clicks: clicks.Count(id)})
+	})
+}
+
+// topClicksHandler serves /api/clicks, the most clicked stories
+func topClicksHandler(clicks *clickCounter) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := newQueryParams(r)
+		n := q.Int("n", 30, 1, 1000)
+		if err := q.Err(); err != nil {
+			writeQueryError(w, err)
+			return
+		}
+		writeJSON(w, map[string]interface{}{"stories": clicks.Top(n)})
+	})
+}
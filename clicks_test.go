@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOutHandler(t *testing.T) {
+	p := newFakeProvider(2)
+	cfg := config{Messages: testMessages(t), Archive: archiveConfig{Service: "wayback"}}
+	clicks := newClickCounter()
+	h := outHandler(p, cfg, clicks, testTemplates(t))
+
+	tests := []struct {
+		target   string
+		status   int
+		location string
+	}{
+		{"/out?id=1&url=https%3A%2F%2Fexample.com%2F1", http.StatusFound, "https://example.com/1"},
+		{"/out?id=1", http.StatusFound, "https://example.com/1"},
+		{"/out?id=1&url=https%3A%2F%2Fweb.archive.org%2Fweb%2Fhttps%3A%2F%2Fexample.com%2F1", http.StatusFound, "https://web.archive.org/web/https://example.com/1"},
+		{"/out?id=1&url=https%3A%2F%2Fevil.example", http.StatusNotFound, ""},
+		{"/out?url=https%3A%2F%2Fexample.com%2F1", http.StatusBadRequest, ""},
+		{"/out?id=x", http.StatusBadRequest, ""},
+	}
+	for _, tc := range tests {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tc.target, nil))
+		if rec.Code != tc.status {
+			t.Errorf("%s: status = %d, want %d", tc.target, rec.Code, tc.status)
+			continue
+		}
+		if got := rec.Header().Get("Location"); got != tc.location {
+			t.Errorf("%s: Location = %q, want %q", tc.target, got, tc.location)
+		}
+		if tc.status == http.StatusFound && rec.Header().Get("Referrer-Policy") != "no-referrer" {
+			t.Errorf("%s: the referrer isn't dropped", tc.target)
+		}
+	}
+	if got := clicks.Count(1); got != 3 {
+		t.Errorf("Count(1) = %d, want 3", got)
+	}
+	if got := clicks.Count(2); got != 0 {
+		t.Errorf("Count(2) = %d, want 0", got)
+	}
+}
+
+func TestHandler_redirect(t *testing.T) {
+	p := newFakeProvider(1)
+	cfg := config{NumStories: 1, Concurrency: 1, Messages: testMessages(t), Redirect: true}
+	rec := httptest.NewRecorder()
+	handler(p, &Cache{ExpirationDuration: time.Minute}, cfg, nil, nil, nil, nil, nil, testTemplates(t)).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	want := `href="/out?id=1&amp;url=https%3A%2F%2Fexample.com%2F1" rel="noopener noreferrer"`
+	if !strings.Contains(rec.Body.String(), want) {
+		t.Errorf("body does not link through /out:\n%s", rec.Body)
+	}
+}
+
+func TestClickCounter_Top(t *testing.T) {
+	c := newClickCounter()
+	for _, id := range []int{3, 1, 3, 2, 3, 1} {
+		c.add(id)
+	}
+	got := fmt.Sprint(c.Top(2))
+	if want := "[{3 3} {1 2}]"; got != want {
+		t.Errorf("Top(2) = %s, want %s", got, want)
+	}
+}
+
+func TestClickCounter_max(t *testing.T) {
+	c := newClickCounter()
+	c.max = 2
+	for _, id := range []int{1, 1, 2, 3} {
+		c.add(id)
+	}
+	// story 2, the least clicked, made room for story 3
+	if c.Count(1) != 2 || c.Count(2) != 0 || c.Count(3) != 1 {
+		t.Errorf("counts: want stories 1 and 3 counted, got %v", c.counts)
+	}
+}
+
+func TestClickCounter_save(t *testing.T) {
+	backend := fileClicks{path: filepath.Join(t.TempDir(), "clicks.json")}
+	c := newClickCounter()
+	c.add(1)
+	c.add(1)
+	if err := c.save(backend); err != nil {
+		t.Fatal(err)
+	}
+	loaded := newClickCounter()
+	if err := loaded.load(backend); err != nil {
+		t.Fatal(err)
+	}
+	if got := loaded.Count(1); got != 2 {
+		t.Errorf("Count(1) after loading = %d, want 2", got)
+	}
+}
+
+func TestStoryClicksHandler(t *testing.T) {
+	c := newClickCounter()
+	c.add(8863)
+	h := routed("/api/stories/{id}/clicks", storyClicksHandler(c))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/stories/8863/clicks", nil))
+	if got, want := strings.TrimSpace(rec.Body.String()), `{"id":8863,"clicks":1}`; got != want {
+		t.Errorf("body = %s, want %s", got, want)
+	}
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/stories/x/clicks", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("invalid id: status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+}
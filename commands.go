@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mmxmb/quiet_hn/history"
+)
+
+// commands are the subcommands of the server, run as quiet_hn <command>
+// [flags] instead of serving the site
+var commands = map[string]func(args []string) error{
+	"backup":   backupCommand,
+	"export":   exportCommand,
+	"generate": generateCommand,
+	"import":   importCommand,
+	"restore":  restoreCommand,
+}
+
+// exportCommand writes the front page history to a file or stdout, eg
+//
+//	quiet_hn export -history_file history.jsonl -since 7d -format jsonl > week.jsonl
+func exportCommand(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	historyFile := fs.String("history_file", "", "the front page history file to export (required)")
+	since := fs.String("since", "", "only export the snapshots taken since then: an age such as 7d or 12h, or a date such as 2018-04-01 (defaults to all of them)")
+	format := fs.String("format", "jsonl", "the format of the export: jsonl, one snapshot per line like the history file, or json, an array of snapshots")
+	out := fs.String("o", "", "the file or s3://bucket/key URL to write the export to (defaults to stdout)")
+	fs.Parse(args)
+	if *historyFile == "" {
+		return errors.New("export: -history_file is required")
+	}
+	if *format != "jsonl" && *format != "json" {
+		return fmt.Errorf("export: unknown format %q", *format)
+	}
+	from, err := parseSince(*since, time.Now())
+	if err != nil {
+		return fmt.Errorf("export: %w", err)
+	}
+	hist, err := history.Open(*historyFile)
+	if err != nil {
+		return err
+	}
+	defer hist.Close()
+
+	contentType := "application/x-ndjson"
+	if *format == "json" {
+		contentType = "application/json"
+	}
+	w, err := createOutput(*out, contentType)
+	if err != nil {
+		return err
+	}
+	n := 0
+	if *format == "json" {
+		snaps := hist.Snapshots(from, time.Unix(1<<62, 0))
+		if snaps == nil {
+			snaps = []history.Snapshot{}
+		}
+		n = len(snaps)
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(snaps)
+	} else {
+		n, err = hist.Export(w, from)
+	}
+	if err == nil {
+		err = w.Close()
+	} else {
+		w.Abort()
+	}
+	if err != nil {
+		return err
+	}
+	log.Printf("exported %d snapshots", n)
+	return nil
+}
+
+// importCommand adds the snapshots of an export to a front page history
+// file, eg to move the history to another instance, which mustn't be
+// running:
+//
+//	quiet_hn import -history_file history.jsonl < week.jsonl
+func importCommand(args []string) error {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	historyFile := fs.String("history_file", "", "the front page history file to import into, created if it doesn't exist (required)")
+	in := fs.String("i", "", "the file or s3://bucket/key URL of the jsonl export to import (defaults to stdin)")
+	fs.Parse(args)
+	if *historyFile == "" {
+		return errors.New("import: -history_file is required")
+	}
+	r, err := openInput(*in)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	hist, err := history.Open(*historyFile)
+	if err != nil {
+		return err
+	}
+	n, err := hist.Import(r)
+	if cerr := hist.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	log.Printf("imported %d snapshots", n)
+	return nil
+}
+
+// parseSince parses the start of a period: an age before now, in days (7d)
+// or as a time.Duration (12h), or a date, optionally with a time in RFC 3339.
+// An empty s is the zero time, the start of any history.
+func parseSince(s string, now time.Time) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if days := strings.TrimSuffix(s, "d"); days != s {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 0 {
+			return time.Time{}, fmt.Errorf("invalid number of days %q", s)
+		}
+		return now.AddDate(0, 0, -n), nil
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		return now.Add(-d), nil
+	}
+	for _, layout := range []string{"2006-01-02", time.RFC3339} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q, want an age such as 7d or a date such as 2018-04-01", s)
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mmxmb/quiet_hn/history"
+)
+
+func TestParseSince(t *testing.T) {
+	now := time.Date(2018, 4, 8, 12, 0, 0, 0, time.UTC)
+	tests := map[string]time.Time{
+		"":           {},
+		"7d":         time.Date(2018, 4, 1, 12, 0, 0, 0, time.UTC),
+		"12h":        time.Date(2018, 4, 8, 0, 0, 0, 0, time.UTC),
+		"2018-04-01": time.Date(2018, 4, 1, 0, 0, 0, 0, time.UTC),
+	}
+	for s, want := range tests {
+		got, err := parseSince(s, now)
+		if err != nil {
+			t.Errorf("parseSince(%q) received an error: %s", s, err.Error())
+			continue
+		}
+		if !got.Equal(want) {
+			t.Errorf("parseSince(%q): want %v, got %v", s, want, got)
+		}
+	}
+	for _, s := range []string{"xd", "-1d", "last week"} {
+		if _, err := parseSince(s, now); err == nil {
+			t.Errorf("parseSince(%q): want an error", s)
+		}
+	}
+}
+
+func TestExportImportCommands(t *testing.T) {
+	dir := t.TempDir()
+	src, dst, export := filepath.Join(dir, "src.jsonl"), filepath.Join(dir, "dst.jsonl"), filepath.Join(dir, "export.jsonl")
+	hist, err := history.Open(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hist.Record(history.Snapshot{Time: time.Now().Add(-48 * time.Hour), Stories: []history.Story{{ID: 1, Rank: 1}}})
+	hist.Record(history.Snapshot{Time: time.Now().Add(-time.Hour), Stories: []history.Story{{ID: 2, Rank: 1}}})
+	hist.Close()
+
+	if err := exportCommand([]string{"-history_file", src, "-since", "1d", "-o", export}); err != nil {
+		t.Fatalf("exportCommand() received an error: %s", err.Error())
+	}
+	if err := importCommand([]string{"-history_file", dst, "-i", export}); err != nil {
+		t.Fatalf("importCommand() received an error: %s", err.Error())
+	}
+	imported, err := history.Open(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer imported.Close()
+	if _, ok := imported.FirstSeen(1); ok {
+		t.Errorf("story 1 was imported, but it is older than the -since of the export")
+	}
+	if _, ok := imported.FirstSeen(2); !ok {
+		t.Errorf("story 2 wasn't imported")
+	}
+}
@@ -0,0 +1,501 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mmxmb/quiet_hn/hn"
+)
+
+// commentsConfig configures the comment trees of item pages
+type commentsConfig struct {
+	// CacheDuration is how long a fetched tree is reused
+	CacheDuration time.Duration
+	// MaxComments bounds the number of comments fetched per item
+	MaxComments int
+	// FetchTimeout, if set, bounds the walk of a tree, which is shared by the
+	// requests for it and only canceled once none of them is waiting
+	FetchTimeout time.Duration
+	// MaxWalks, if set, bounds the number of trees walked at once, the walks
+	// over it waiting for their turn
+	MaxWalks int
+	// PageSize, if set, is the number of top level comments of a page of
+	// comments, the others only fetched for the next pages
+	PageSize int
+	// NotableUsers are the users whose comments are marked as notable
+	NotableUsers []string
+}
+
+// comment is a comment of an item page with its replies. OP is set if it
+// was written by the submitter of the story, and Notable by one of the
+// NotableUsers.
+type comment struct {
+	hn.Item
+	Replies []*comment
+	OP      bool
+	Notable bool
+}
+
+// newComment returns the comment c of a thread submitted by op
+func newComment(c hn.Item, op string, notable []string) *comment {
+	return &comment{
+		Item:    c,
+		OP:      c.By != "" && c.By == op,
+		Notable: c.By != "" && containsUser(notable, c.By),
+	}
+}
+
+// commentTrees fetches the comment trees of items. Concurrent requests for
+// the tree of an item share a single walk of it, and trees are cached for
+// CacheDuration so that hot threads aren't walked for every viewer. It is
+// safe for concurrent use.
+type commentTrees struct {
+	client      StoryProvider
+	cfg         commentsConfig
+	concurrency int
+
+	// walks holds a token per walk in progress if MaxWalks is set
+	walks chan struct{}
+
+	mu      sync.Mutex
+	calls   map[treeKey]*treeCall
+	entries map[treeKey]treeEntry
+}
+
+// treeKey identifies the tree of a page of the comments of an item, page 0
+// being all of them
+type treeKey struct {
+	id   int
+	page int
+}
+
+// treeCall is a walk of a tree in progress; done is closed once it is over.
+// waiters is the number of requests waiting for it, guarded by the mu of
+// commentTrees, and the walk is canceled once it drops to zero.
+type treeCall struct {
+	done     chan struct{}
+	cancel   context.CancelFunc
+	waiters  int
+	comments []*comment
+	err      error
+}
+
+type treeEntry struct {
+	comments   []*comment
+	expiration time.Time
+}
+
+func newCommentTrees(client StoryProvider, cfg config) *commentTrees {
+	t := &commentTrees{
+		client:      client,
+		cfg:         cfg.Comments,
+		concurrency: cfg.Concurrency,
+		calls:       make(map[treeKey]*treeCall),
+		entries:     make(map[treeKey]treeEntry),
+	}
+	if cfg.Comments.MaxWalks > 0 {
+		t.walks = make(chan struct{}, cfg.Comments.MaxWalks)
+	}
+	return t
+}
+
+// Get returns the comments of itm with their replies, in the order they are
+// displayed on HN. The trees returned are shared and must not be modified.
+func (t *commentTrees) Get(ctx context.Context, itm hn.Item) ([]*comment, error) {
+	return t.get(ctx, itm, treeKey{id: itm.ID})
+}
+
+// Page returns the page-th (from 1) page of the comments of itm, PageSize
+// top level comments with their replies, and whether there are more pages.
+// Only the comments of the page are fetched. Without PageSize, the first
+// page has all the comments.
+func (t *commentTrees) Page(ctx context.Context, itm hn.Item, page int) ([]*comment, bool, error) {
+	if t.cfg.PageSize <= 0 {
+		if page > 1 {
+			return nil, false, nil
+		}
+		comments, err := t.Get(ctx, itm)
+		return comments, false, err
+	}
+	start := (page - 1) * t.cfg.PageSize
+	if start >= len(itm.Kids) {
+		return nil, false, nil
+	}
+	end := start + t.cfg.PageSize
+	more := end < len(itm.Kids)
+	if !more {
+		end = len(itm.Kids)
+	}
+	// the page of itm is the tree of itm with only the kids of the page
+	paged := itm
+	paged.Kids = itm.Kids[start:end]
+	comments, err := t.get(ctx, paged, treeKey{id: itm.ID, page: page})
+	return comments, more, err
+}
+
+// get returns the tree of itm, cached under key
+func (t *commentTrees) get(ctx context.Context, itm hn.Item, key treeKey) ([]*comment, error) {
+	if len(itm.Kids) == 0 {
+		return nil, nil
+	}
+	now := time.Now()
+	t.mu.Lock()
+	if entry, ok := t.entries[key]; ok && now.Before(entry.expiration) {
+		t.mu.Unlock()
+		return entry.comments, nil
+	}
+	call, ok := t.calls[key]
+	if !ok {
+		// the walk outlives the request that started it, as long as others
+		// wait for it
+		var walkCtx context.Context
+		var cancel context.CancelFunc
+		if t.cfg.FetchTimeout > 0 {
+			walkCtx, cancel = context.WithTimeout(context.Background(), t.cfg.FetchTimeout)
+		} else {
+			walkCtx, cancel = context.WithCancel(context.Background())
+		}
+		call = &treeCall{done: make(chan struct{}), cancel: cancel}
+		t.calls[key] = call
+		go t.walk(walkCtx, itm, key, call)
+	}
+	call.waiters++
+	t.mu.Unlock()
+
+	select {
+	case <-call.done:
+		return call.comments, call.err
+	case <-ctx.Done():
+		t.mu.Lock()
+		call.waiters--
+		if call.waiters == 0 {
+			call.cancel()
+			// the next request starts a walk of its own instead of
+			// waiting for the canceled one
+			if t.calls[key] == call {
+				delete(t.calls, key)
+			}
+		}
+		t.mu.Unlock()
+		return nil, ctx.Err()
+	}
+}
+
+// walk fetches the tree of itm for call and caches it under key, once one of
+// the MaxWalks is free
+func (t *commentTrees) walk(ctx context.Context, itm hn.Item, key treeKey, call *treeCall) {
+	defer call.cancel()
+	if t.walks == nil {
+		call.comments, call.err = t.fetch(ctx, itm)
+	} else {
+		select {
+		case t.walks <- struct{}{}:
+			call.comments, call.err = t.fetch(ctx, itm)
+			<-t.walks
+		case <-ctx.Done():
+			call.err = ctx.Err()
+		}
+	}
+
+	now := time.Now()
+	t.mu.Lock()
+	if t.calls[key] == call {
+		delete(t.calls, key)
+	}
+	if call.err == nil {
+		for key, entry := range t.entries {
+			if !now.Before(entry.expiration) {
+				delete(t.entries, key)
+			}
+		}
+		t.entries[key] = treeEntry{comments: call.comments, expiration: now.Add(t.cfg.CacheDuration)}
+	}
+	t.mu.Unlock()
+	close(call.done)
+}
+
+// fetch walks the tree of itm one level at a time, stopping at MaxComments.
+// Comments that failed to load are skipped along with their replies, like
+// dead and deleted ones. The comments by itm.By are marked as OP's, so the
+// tree of a comment should be fetched with the By of its story.
+func (t *commentTrees) fetch(ctx context.Context, itm hn.Item) ([]*comment, error) {
+	root := &comment{Item: itm}
+	// parents[i] is the comment the i-th id of the level replies to
+	var ids []int
+	var parents []*comment
+	for _, id := range itm.Kids {
+		ids = append(ids, id)
+		parents = append(parents, root)
+	}
+	count := 0
+	for len(ids) > 0 && (t.cfg.MaxComments <= 0 || count < t.cfg.MaxComments) {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if t.cfg.MaxComments > 0 && count+len(ids) > t.cfg.MaxComments {
+			ids, parents = ids[:t.cfg.MaxComments-count], parents[:t.cfg.MaxComments-count]
+		}
+		items, err := t.client.GetItems(ctx, ids, t.concurrency)
+		var itemErrs hn.ItemErrors
+		if err != nil && !errors.As(err, &itemErrs) {
+			return nil, err
+		}
+		var next []int
+		var nextParents []*comment
+		for i, c := range items {
+			if !c.Alive() {
+				continue
+			}
+			reply := newComment(c, itm.By, t.cfg.NotableUsers)
+			parents[i].Replies = append(parents[i].Replies, reply)
+			count++
+			for _, id := range c.Kids {
+				next = append(next, id)
+				nextParents = append(nextParents, reply)
+			}
+		}
+		ids, parents = next, nextParents
+	}
+	return root.Replies, nil
+}
+
+// maxThreadDepth bounds the walk from a comment up to the story it is a
+// comment of
+const maxThreadDepth = 100
+
+// threadOf returns the story (or poll) the comment c is part of, walking up
+// its parents, and the ID of the comment c replies to, 0 if it replies to
+// the story itself
+func threadOf(client StoryProvider, c hn.Item) (hn.Item, int, error) {
+	parent := 0
+	itm := c
+	for depth := 0; itm.Type == "comment"; depth++ {
+		if depth >= maxThreadDepth {
+			return hn.Item{}, 0, fmt.Errorf("comment %d is deeper than %d replies", c.ID, maxThreadDepth)
+		}
+		next, err := client.GetItem(itm.Parent)
+		if err != nil {
+			return hn.Item{}, 0, err
+		}
+		if next.ID == 0 {
+			return hn.Item{}, 0, fmt.Errorf("item %d, the parent of item %d, doesn't exist", itm.Parent, itm.ID)
+		}
+		if depth == 0 && next.Type == "comment" {
+			parent = next.ID
+		}
+		itm = next
+	}
+	return itm, parent, nil
+}
+
+// The orders of the top level comments of item pages
+const (
+	commentOrderDefault = "default"
+	commentOrderNewest  = "newest"
+	commentOrderLargest = "largest"
+)
+
+var commentOrders = []string{commentOrderDefault, commentOrderNewest, commentOrderLargest}
+
+func validCommentOrder(order string) bool {
+	for _, o := range commentOrders {
+		if o == order {
+			return true
+		}
+	}
+	return false
+}
+
+// sortComments returns comments, the top level comments of an item, in one
+// of commentOrders: the order of HN, most recent first, or the ones with
+// the most replies first. comments is shared by the trees, so it is sorted
+// as a copy. Ties keep the order of HN.
+func sortComments(comments []*comment, by string) []*comment {
+	var less func(a, b *comment) bool
+	switch by {
+	case commentOrderNewest:
+		less = func(a, b *comment) bool { return a.Time > b.Time }
+	case commentOrderLargest:
+		less = func(a, b *comment) bool { return a.Count() > b.Count() }
+	default:
+		return comments
+	}
+	sorted := append([]*comment(nil), comments...)
+	sort.SliceStable(sorted, func(i, j int) bool { return less(sorted[i], sorted[j]) })
+	return sorted
+}
+
+// Count returns the number of replies to c, counting the replies to the
+// replies
+func (c *comment) Count() int {
+	n := len(c.Replies)
+	for _, reply := range c.Replies {
+		n += reply.Count()
+	}
+	return n
+}
+
+// maxCollapsed bounds the comments collapsed on an item page
+const maxCollapsed = 500
+
+// maxCommentQuery bounds the length of the search of the comments of an item
+// page
+const maxCommentQuery = 100
+
+// threadView is how the comments of an item page are viewed, kept in its
+// query parameters so that threads can be folded and searched without
+// JavaScript: collapse, a comma separated list of the IDs of the comments
+// collapsed, and q, the keywords the comments are filtered by
+type threadView struct {
+	page      url.URL
+	collapsed []int
+	// Query is the search of the comments, if any
+	Query string
+}
+
+// newThreadView returns the view of the comments of the page of r. Invalid
+// parameters are recorded in q.
+func newThreadView(r *http.Request, q *queryParams) threadView {
+	v := threadView{page: *r.URL}
+	v.page.Fragment = ""
+	v.Query = strings.TrimSpace(r.URL.Query().Get("q"))
+	if len([]rune(v.Query)) > maxCommentQuery {
+		q.fail("q", fmt.Sprintf("must be at most %d characters", maxCommentQuery))
+		v.Query = ""
+	}
+	ids, err := parseIDs(r.URL.Query().Get("collapse"))
+	if err == nil && len(ids) > maxCollapsed {
+		err = fmt.Errorf("at most %d comments can be collapsed", maxCollapsed)
+	}
+	if err != nil {
+		q.fail("collapse", err.Error())
+		return v
+	}
+	v.collapsed = ids
+	return v
+}
+
+// Collapsed reports whether the comment id is collapsed
+func (v threadView) Collapsed(id int) bool {
+	return containsID(v.collapsed, id)
+}
+
+// Toggle returns the URL of the page with the comment id collapsed, or
+// expanded if it was, scrolled to the comment
+func (v threadView) Toggle(id int) string {
+	var ids []int
+	for _, collapsed := range v.collapsed {
+		if collapsed != id {
+			ids = append(ids, collapsed)
+		}
+	}
+	if len(ids) == len(v.collapsed) {
+		ids = append(ids, id)
+	}
+	u := v.page
+	q := u.Query()
+	if len(ids) > 0 {
+		q.Set("collapse", formatIDs(ids))
+	} else {
+		q.Del("collapse")
+	}
+	u.RawQuery = q.Encode()
+	u.Fragment = strconv.Itoa(id)
+	return u.RequestURI() + "#" + u.Fragment
+}
+
+// filterComments returns the comments matching query, with their replies
+// that do, and the comments with replies that do, so that the matches are
+// shown in their threads. The trees are shared, so the comments kept are
+// copies.
+func filterComments(comments []*comment, query string) []*comment {
+	var kept []*comment
+	for _, c := range comments {
+		replies := filterComments(c.Replies, query)
+		if len(replies) == 0 && !matchText(c.Text, query) {
+			continue
+		}
+		filtered := *c
+		filtered.Replies = replies
+		kept = append(kept, &filtered)
+	}
+	return kept
+}
+
+// matchText reports whether the text of the HTML s contains query, ignoring
+// case
+func matchText(s, query string) bool {
+	return strings.Contains(strings.ToLower(plainText(s)), strings.ToLower(query))
+}
+
+// tagRE matches the tags of HN texts
+var tagRE = regexp.MustCompile(`<[^>]*>`)
+
+// plainText returns the text of the HTML s, without its tags and entities
+func plainText(s string) string {
+	return html.UnescapeString(tagRE.ReplaceAllString(s, " "))
+}
+
+// highlightMatches wraps the occurrences of query, ignoring case, in the
+// text of the sanitized HTML s in <mark>, leaving its tags alone
+func highlightMatches(s, query string) string {
+	if query == "" {
+		return s
+	}
+	var b strings.Builder
+	for len(s) > 0 {
+		i := strings.IndexByte(s, '<')
+		if i < 0 {
+			i = len(s)
+		}
+		b.WriteString(markText(html.UnescapeString(s[:i]), query))
+		s = s[i:]
+		if j := strings.IndexByte(s, '>'); j >= 0 {
+			b.WriteString(s[:j+1])
+			s = s[j+1:]
+		}
+	}
+	return b.String()
+}
+
+// markText escapes text, wrapping the occurrences of query in <mark>
+func markText(text, query string) string {
+	var b strings.Builder
+	lower, lowerQuery := strings.ToLower(text), strings.ToLower(query)
+	// lowercasing can change the length of some runes, in which case the
+	// offsets of lower don't apply to text and it is left unmarked
+	if len(lower) != len(text) {
+		return html.EscapeString(text)
+	}
+	for {
+		i := strings.Index(lower, lowerQuery)
+		if i < 0 {
+			break
+		}
+		b.WriteString(html.EscapeString(text[:i]))
+		n := i + len(lowerQuery)
+		b.WriteString("<mark>" + html.EscapeString(text[i:n]) + "</mark>")
+		text, lower = text[n:], lower[n:]
+	}
+	b.WriteString(html.EscapeString(text))
+	return b.String()
+}
+
+// commentTemplateData is the data of the "comment" template, which renders
+// a comment and, recursively, its replies
+type commentTemplateData struct {
+	Comment *comment
+	Lang    string
+	TZ      string
+	View    threadView
+}
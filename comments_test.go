@@ -0,0 +1,340 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mmxmb/quiet_hn/hn"
+)
+
+// newThreadProvider returns a provider with story 1, its comments 2 and 3 (dead)
+// and the reply 4 to comment 2
+func newThreadProvider() *fakeProvider {
+	p := newFakeProvider(1)
+	p.items[1] = hn.Item{ID: 1, Type: "story", Title: "Story 1", Kids: []int{2, 3}}
+	p.items[2] = hn.Item{ID: 2, Type: "comment", By: "alice", Text: "First", Kids: []int{4}, Parent: 1}
+	p.items[3] = hn.Item{ID: 3, Type: "comment", Dead: true, Parent: 1}
+	p.items[4] = hn.Item{ID: 4, Type: "comment", By: "bob", Text: "Reply", Parent: 2}
+	return p
+}
+
+// blockingProvider blocks GetItems until release is closed
+type blockingProvider struct {
+	*fakeProvider
+	release chan struct{}
+}
+
+func (p blockingProvider) GetItems(ctx context.Context, ids []int, concurrency int) ([]hn.Item, error) {
+	<-p.release
+	return p.fakeProvider.GetItems(ctx, ids, concurrency)
+}
+
+func TestCommentTrees_Get(t *testing.T) {
+	p := newThreadProvider()
+	blocking := blockingProvider{fakeProvider: p, release: make(chan struct{})}
+	trees := newCommentTrees(blocking, config{Comments: commentsConfig{CacheDuration: time.Minute, FetchTimeout: time.Second}})
+
+	var wg sync.WaitGroup
+	results := make([][]*comment, 5)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], _ = trees.Get(context.Background(), p.items[1])
+		}(i)
+	}
+	close(blocking.release)
+	wg.Wait()
+
+	// one walk of two levels, for every caller
+	if p.fetched != 3 {
+		t.Errorf("fetched items: want %d, got %d", 3, p.fetched)
+	}
+	for i, comments := range results {
+		if len(comments) != 1 || comments[0].ID != 2 || len(comments[0].Replies) != 1 || comments[0].Replies[0].ID != 4 {
+			t.Fatalf("Get() #%d: want comment 2 with the reply 4, got %+v", i+1, comments)
+		}
+	}
+
+	trees.Get(context.Background(), p.items[1])
+	if p.fetched != 3 {
+		t.Errorf("fetched items after a cached Get(): want %d, got %d", 3, p.fetched)
+	}
+}
+
+// waitingProvider reports the first id of the GetItems calls on started and
+// blocks them until their ctx is done
+type waitingProvider struct {
+	*fakeProvider
+	started chan int
+}
+
+func (p waitingProvider) GetItems(ctx context.Context, ids []int, concurrency int) ([]hn.Item, error) {
+	p.started <- ids[0]
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestCommentTrees_canceled(t *testing.T) {
+	p := newThreadProvider()
+	p.items[5] = hn.Item{ID: 5, Type: "story", Title: "Story 5", Kids: []int{6}}
+	waiting := waitingProvider{fakeProvider: p, started: make(chan int)}
+	trees := newCommentTrees(waiting, config{Comments: commentsConfig{FetchTimeout: time.Minute, MaxWalks: 1}})
+
+	get := func(ctx context.Context, itm hn.Item) chan error {
+		errs := make(chan error, 1)
+		go func() {
+			_, err := trees.Get(ctx, itm)
+			errs <- err
+		}()
+		return errs
+	}
+	ctx1, cancel1 := context.WithCancel(context.Background())
+	errs1 := get(ctx1, p.items[1])
+	if id := <-waiting.started; id != 2 {
+		t.Fatalf("first walk: want the comments of story 1, got item %d", id)
+	}
+	ctx2, cancel2 := context.WithCancel(context.Background())
+	defer cancel2()
+	errs2 := get(ctx2, p.items[5])
+	select {
+	case id := <-waiting.started:
+		t.Fatalf("second walk: started with item %d while the first one holds the only walk", id)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	// the first walk has no waiter left, which frees the walk for the second
+	cancel1()
+	if err := <-errs1; err != context.Canceled {
+		t.Errorf("Get() canceled: want %v, got %v", context.Canceled, err)
+	}
+	if id := <-waiting.started; id != 6 {
+		t.Errorf("second walk: want the comments of story 5, got item %d", id)
+	}
+	cancel2()
+	<-errs2
+	trees.mu.Lock()
+	defer trees.mu.Unlock()
+	if len(trees.calls) != 0 {
+		t.Errorf("walks without waiters: want none, got %d", len(trees.calls))
+	}
+}
+
+func TestCommentTrees_maxComments(t *testing.T) {
+	p := newThreadProvider()
+	trees := newCommentTrees(p, config{Comments: commentsConfig{MaxComments: 1, FetchTimeout: time.Second}})
+	comments, err := trees.Get(context.Background(), p.items[1])
+	if err != nil {
+		t.Fatalf("Get() received an error: %s", err)
+	}
+	if len(comments) != 1 || len(comments[0].Replies) != 0 {
+		t.Errorf("Get(): want only comment 2, got %+v", comments)
+	}
+}
+
+func TestItemHandler_comments(t *testing.T) {
+	p := newThreadProvider()
+	cfg := config{Messages: testMessages(t), Comments: commentsConfig{FetchTimeout: time.Second}}
+	rec := httptest.NewRecorder()
+	routed("/item/{id}", itemHandler(p, cfg, newCommentTrees(p, cfg), nil, testTemplates(t))).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/item/1", nil))
+	body := rec.Body.String()
+	if !strings.Contains(body, "First") || !strings.Contains(body, `class="replies"`) || !strings.Contains(body, "Reply") {
+		t.Errorf("body does not contain the comment and its reply:\n%s", body)
+	}
+}
+
+func TestCommentTrees_authors(t *testing.T) {
+	p := newThreadProvider()
+	story := p.items[1]
+	story.By = "bob"
+	trees := newCommentTrees(p, config{Comments: commentsConfig{FetchTimeout: time.Second, NotableUsers: []string{"Alice"}}})
+	comments, err := trees.Get(context.Background(), story)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(comments) != 1 || !comments[0].Notable || comments[0].OP || len(comments[0].Replies) != 1 {
+		t.Fatalf("Get(): want comment 2 by the notable alice, got %+v", comments)
+	}
+	if reply := comments[0].Replies[0]; !reply.OP || reply.Notable {
+		t.Errorf("Get(): want reply 4 by bob, the submitter, marked as OP's, got %+v", reply)
+	}
+}
+
+func TestItemHandler_commentHTML(t *testing.T) {
+	p := newThreadProvider()
+	p.items[2] = hn.Item{ID: 2, Type: "comment", By: "alice", Text: `See <a href="https:&#x2F;&#x2F;news.ycombinator.com&#x2F;item?id=8863">this</a><p><i>really</i><script>alert(1)</script>`}
+	cfg := config{Messages: testMessages(t), Comments: commentsConfig{FetchTimeout: time.Second}}
+	rec := httptest.NewRecorder()
+	routed("/item/{id}", itemHandler(p, cfg, newCommentTrees(p, cfg), nil, testTemplates(t))).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/item/1", nil))
+	body := rec.Body.String()
+	if !strings.Contains(body, `<a href="/item/8863" rel="nofollow noopener noreferrer">this</a><p><i>really</i></p>`) {
+		t.Errorf("body does not contain the sanitized comment:\n%s", body)
+	}
+	if strings.Contains(body, "alert") {
+		t.Errorf("body contains the script of the comment:\n%s", body)
+	}
+}
+
+func TestItemHandler_commentsDisabled(t *testing.T) {
+	p := newThreadProvider()
+	cfg := config{Messages: testMessages(t)}
+	rec := httptest.NewRecorder()
+	routed("/item/{id}", itemHandler(p, cfg, nil, nil, testTemplates(t))).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/item/1", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status code: want %d, got %d", http.StatusOK, rec.Code)
+	}
+	if strings.Contains(rec.Body.String(), "First") {
+		t.Errorf("body contains comments while they are disabled")
+	}
+}
+
+func TestCommentHandler(t *testing.T) {
+	p := newThreadProvider()
+	cfg := config{Messages: testMessages(t), Comments: commentsConfig{FetchTimeout: time.Second}}
+	trees := newCommentTrees(p, cfg)
+	comments := routed("/comment/{id}", commentHandler(p, cfg, trees, testTemplates(t)))
+	items := routed("/item/{id}", itemHandler(p, cfg, trees, nil, testTemplates(t)))
+
+	rec := httptest.NewRecorder()
+	comments.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/comment/4", nil))
+	body := rec.Body.String()
+	if rec.Code != http.StatusOK || !strings.Contains(body, "Story 1") || !strings.Contains(body, "Reply") || strings.Contains(body, "First") {
+		t.Errorf("/comment/4: want reply 4 alone below story 1, got status %d and %s", rec.Code, body)
+	}
+	if !strings.Contains(body, `href="/item/1#4"`) || !strings.Contains(body, `href="/comment/2"`) {
+		t.Errorf("/comment/4: want links to the thread and comment 2, got %s", body)
+	}
+
+	rec = httptest.NewRecorder()
+	items.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/item/1?comment=2", nil))
+	body = rec.Body.String()
+	if !strings.Contains(body, "First") || !strings.Contains(body, "Reply") || strings.Contains(body, `href="/comment/1"`) {
+		t.Errorf("/item/1?comment=2: want comment 2 with its reply, got %s", body)
+	}
+
+	rec = httptest.NewRecorder()
+	comments.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/comment/1", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("/comment/1 of a story: want status %d, got %d", http.StatusNotFound, rec.Code)
+	}
+}
+
+func TestItemHandler_collapse(t *testing.T) {
+	p := newThreadProvider()
+	cfg := config{Messages: testMessages(t), Comments: commentsConfig{FetchTimeout: time.Second}}
+	h := routed("/item/{id}", itemHandler(p, cfg, newCommentTrees(p, cfg), nil, testTemplates(t)))
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/item/1", nil))
+	if body := rec.Body.String(); !strings.Contains(body, `href="/item/1?collapse=2#2"`) || !strings.Contains(body, `href="/item/1?collapse=4#4"`) {
+		t.Errorf("body does not link to collapsing the comments:\n%s", body)
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/item/1?collapse=2", nil))
+	body := rec.Body.String()
+	if strings.Contains(body, "First") || strings.Contains(body, "Reply") || !strings.Contains(body, "1 reply hidden") {
+		t.Errorf("body does not collapse comment 2 and its reply:\n%s", body)
+	}
+	if !strings.Contains(body, `href="/item/1#2"`) {
+		t.Errorf("body does not link to expanding comment 2:\n%s", body)
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/item/1?collapse=two", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("status code of an invalid collapse: want %d, got %d", http.StatusBadRequest, rec.Code)
+	}
+}
+
+func TestItemHandler_search(t *testing.T) {
+	p := newThreadProvider()
+	p.items[1] = hn.Item{ID: 1, Type: "story", Title: "Story 1", Kids: []int{2, 3, 5}}
+	p.items[4] = hn.Item{ID: 4, Type: "comment", By: "bob", Text: "Author here: <i>see</i> the benchmarks &amp; more", Parent: 2}
+	p.items[5] = hn.Item{ID: 5, Type: "comment", By: "carol", Text: "Unrelated", Parent: 1}
+	cfg := config{Messages: testMessages(t), Comments: commentsConfig{FetchTimeout: time.Second, PageSize: 1}}
+	h := routed("/item/{id}", itemHandler(p, cfg, newCommentTrees(p, cfg), nil, testTemplates(t)))
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/item/1?q=author+HERE", nil))
+	body := rec.Body.String()
+	// comment 2 is kept as the parent of the match
+	if !strings.Contains(body, "First") || strings.Contains(body, "Unrelated") {
+		t.Errorf("body does not filter the comments by the search:\n%s", body)
+	}
+	if !strings.Contains(body, "<mark>Author here</mark>: <i>see</i> the benchmarks &amp; more") {
+		t.Errorf("body does not highlight the match:\n%s", body)
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/item/1?q=nowhere", nil))
+	if body := rec.Body.String(); strings.Contains(body, "First") || !strings.Contains(body, "No comments match") {
+		t.Errorf("body of a search without matches:\n%s", body)
+	}
+}
+
+func TestItemHandler_commentOrder(t *testing.T) {
+	p := newThreadProvider()
+	p.items[1] = hn.Item{ID: 1, Type: "story", Title: "Story 1", Kids: []int{5, 2, 3}}
+	p.items[2] = hn.Item{ID: 2, Type: "comment", By: "alice", Text: "First", Kids: []int{4}, Parent: 1, Time: 100}
+	p.items[5] = hn.Item{ID: 5, Type: "comment", By: "carol", Text: "Latest", Parent: 1, Time: 200}
+	cfg := config{Messages: testMessages(t), Comments: commentsConfig{FetchTimeout: time.Second}, Defaults: preferences{CommentOrder: commentOrderDefault}}
+	trees := newCommentTrees(p, cfg)
+	h := routed("/item/{id}", itemHandler(p, cfg, trees, nil, testTemplates(t)))
+
+	for _, tt := range []struct {
+		order       string
+		firstLatest bool
+	}{
+		{"", true},
+		{commentOrderLargest, false},
+		{commentOrderNewest, true},
+	} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/item/1?comment_order="+tt.order, nil))
+		body := rec.Body.String()
+		if got := strings.Index(body, "Latest") < strings.Index(body, "First"); got != tt.firstLatest {
+			t.Errorf("comment_order=%s: want comment 5 first %v, got %v", tt.order, tt.firstLatest, got)
+		}
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/item/1?comment_order=newest", nil))
+	if cookies := rec.Result().Cookies(); len(cookies) != 1 || cookies[0].Name != "comment_order" || cookies[0].Value != "newest" {
+		t.Errorf("cookies: want comment_order=newest, got %v", cookies)
+	}
+	// the trees are shared, and keep the order of HN
+	if comments, _ := trees.Get(context.Background(), p.items[1]); len(comments) != 2 || comments[0].ID != 5 {
+		t.Errorf("Get(): want comment 5 first, got %+v", comments)
+	}
+}
+
+func TestItemHandler_commentPages(t *testing.T) {
+	p := newThreadProvider()
+	p.items[1] = hn.Item{ID: 1, Type: "story", Title: "Story 1", Kids: []int{2, 5}}
+	p.items[5] = hn.Item{ID: 5, Type: "comment", By: "carol", Text: "Second", Parent: 1}
+	cfg := config{Messages: testMessages(t), Comments: commentsConfig{FetchTimeout: time.Second, PageSize: 1}}
+	h := routed("/item/{id}", itemHandler(p, cfg, newCommentTrees(p, cfg), nil, testTemplates(t)))
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/item/1", nil))
+	body := rec.Body.String()
+	if !strings.Contains(body, "First") || !strings.Contains(body, "Reply") || strings.Contains(body, "Second") || !strings.Contains(body, `href="/item/1?page=2"`) {
+		t.Errorf("page 1: want comment 2 and its reply with a link to page 2, got %s", body)
+	}
+	// comments 2 and 4 only
+	if p.fetched != 2 {
+		t.Errorf("fetched items: want %d, got %d", 2, p.fetched)
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/item/1?page=2", nil))
+	body = rec.Body.String()
+	if strings.Contains(body, "First") || !strings.Contains(body, "Second") || !strings.Contains(body, `href="/item/1"`) || strings.Contains(body, "page=3") {
+		t.Errorf("page 2: want comment 5 with a link back to page 1, got %s", body)
+	}
+}
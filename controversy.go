@@ -0,0 +1,63 @@
+package main
+
+import (
+	"time"
+
+	"github.com/mmxmb/quiet_hn/history"
+)
+
+// The heuristics flagging controversial stories: discussions outgrowing the
+// votes of their stories, and stories falling down the front page faster
+// than their age explains, as HN does to flamewars
+const (
+	// controversyRatio is the number of comments per point past which a
+	// story with at least controversyMinComments comments is controversial
+	controversyRatio       = 1.0
+	controversyMinComments = 40
+	// controversyRankDrop is the number of positions a story must have lost
+	// over controversyWindow, according to the history, to be controversial
+	controversyRankDrop = 20
+	controversyWindow   = time.Hour
+)
+
+// markControversial sets Controversial on the stories with more comments
+// than points or, if hist isn't nil, ranked controversyRankDrop positions
+// below the best rank they had in the last controversyWindow
+func markControversial(stories []item, hist *history.Store, now time.Time) {
+	var best map[int]int
+	if hist != nil {
+		best = make(map[int]int)
+		for _, snap := range hist.Snapshots(now.Add(-controversyWindow), now) {
+			for _, story := range snap.Stories {
+				if rank, ok := best[story.ID]; !ok || story.Rank < rank {
+					best[story.ID] = story.Rank
+				}
+			}
+		}
+	}
+	for i := range stories {
+		itm := &stories[i]
+		if itm.Descendants >= controversyMinComments && float64(itm.Descendants) > controversyRatio*float64(itm.Score) {
+			itm.Controversial = true
+		} else if rank, ok := best[itm.ID]; ok && itm.Rank > 0 && itm.Rank-rank >= controversyRankDrop {
+			itm.Controversial = true
+		}
+	}
+}
+
+// dropControversial removes the controversial stories from stories, except
+// for the pinned ones, and returns the stories left
+func dropControversial(stories []item) []item {
+	n := 0
+	for _, itm := range stories {
+		if !itm.Controversial || itm.Pinned {
+			stories[n] = itm
+			n++
+		}
+	}
+	// the strings of the stories dropped shouldn't outlive them
+	for i := n; i < len(stories); i++ {
+		stories[i] = item{}
+	}
+	return stories[:n]
+}
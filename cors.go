@@ -0,0 +1,80 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// corsConfig configures the cross-origin requests allowed to the JSON API
+type corsConfig struct {
+	// Origins are the allowed origins, eg https://app.example.com, or "*"
+	// for any. No cross-origin requests are allowed if it is empty.
+	Origins []string
+	Methods []string
+	// MaxAge is how long browsers may cache the result of preflight requests
+	MaxAge time.Duration
+}
+
+// allowedOrigin returns the value of Access-Control-Allow-Origin for origin,
+// or "" if it isn't allowed
+func (c corsConfig) allowedOrigin(origin string) string {
+	for _, o := range c.Origins {
+		if o == "*" {
+			return "*"
+		}
+		if strings.EqualFold(o, origin) {
+			return origin
+		}
+	}
+	return ""
+}
+
+// withCORS returns h, answering CORS preflight requests itself and adding
+// the CORS headers to the responses to allowed origins.
+func withCORS(cfg corsConfig, h http.Handler) http.Handler {
+	methods := strings.Join(cfg.Methods, ", ")
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			h.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Add("Vary", "Origin")
+		allowed := cfg.allowedOrigin(origin)
+		preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+		if !preflight {
+			if allowed != "" {
+				w.Header().Set("Access-Control-Allow-Origin", allowed)
+			}
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Add("Vary", "Access-Control-Request-Method")
+		w.Header().Add("Vary", "Access-Control-Request-Headers")
+		if allowed == "" || !containsFold(cfg.Methods, r.Header.Get("Access-Control-Request-Method")) {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		w.Header().Set("Access-Control-Allow-Origin", allowed)
+		w.Header().Set("Access-Control-Allow-Methods", methods)
+		// the API reads JSON bodies and API keys, which are better sent in
+		// headers than in query strings that end up in logs
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-API-Key")
+		if cfg.MaxAge > 0 {
+			w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(cfg.MaxAge/time.Second)))
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+func containsFold(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
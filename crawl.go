@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+// defaultRobots is served as robots.txt unless -robots is set. Reader mode
+// pages and favicons are copies of third party content, so crawlers are kept
+// away from them.
+const defaultRobots = `User-agent: *
+Disallow: /read/
+Disallow: /favicon
+`
+
+// loadRobots returns the contents of the robots.txt file at path, or
+// defaultRobots if path is empty
+func loadRobots(path string) (string, error) {
+	if path == "" {
+		return defaultRobots, nil
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// robotsHandler serves robots, followed by the location of the sitemap
+func robotsHandler(robots string) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		fmt.Fprintf(w, "%s\nSitemap: %s/sitemap.xml\n", robots, baseURL(r))
+	})
+}
+
+// sitemap is a sitemaps.org urlset.
+// See https://www.sitemaps.org/protocol.html.
+type sitemap struct {
+	XMLName xml.Name     `xml:"http://www.sitemaps.org/schemas/sitemap/0.9 urlset"`
+	URLs    []sitemapURL `xml:"url"`
+}
+
+type sitemapURL struct {
+	Loc        string `xml:"loc"`
+	LastMod    string `xml:"lastmod,omitempty"`
+	ChangeFreq string `xml:"changefreq,omitempty"`
+}
+
+// sitemapHandler serves a sitemap with the front page and the /item pages of
+// the stories currently on it, as seen by users with the default preferences.
+func sitemapHandler(cache *Cache, cfg config) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		base := baseURL(r)
+		sm := sitemap{URLs: []sitemapURL{{Loc: base + "/", ChangeFreq: "always"}}}
+		f := newFilter(cfg, cfg.Defaults)
+		for _, story := range f.unmuted(cache.Get(f.key()), cfg.NumStories) {
+			u := sitemapURL{
+				Loc:        fmt.Sprintf("%s/item/%d", base, story.ID),
+				ChangeFreq: "hourly",
+			}
+			if story.Time != 0 {
+				u.LastMod = time.Unix(int64(story.Time), 0).UTC().Format("2006-01-02")
+			}
+			sm.URLs = append(sm.URLs, u)
+		}
+		w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+		w.Write([]byte(xml.Header))
+		enc := xml.NewEncoder(w)
+		enc.Indent("", "  ")
+		enc.Encode(sm)
+	})
+}
+
+// baseURL returns the scheme and host r was made to, for the places where
+// absolute URLs are required
+func baseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
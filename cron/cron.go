@@ -0,0 +1,197 @@
+// Package cron parses cron expressions and computes when they next fire.
+//
+// Expressions have the five fields of crontab(5): minute, hour, day of the
+// month, month and day of the week (0 or 7 for Sunday), each either *, a
+// number, a range like 1-5, a step like */15 or 0-30/10, or a comma
+// separated list of those. Months and days of the week may also be given by
+// their three letter English names. As in cron, when both the day of the
+// month and the day of the week are restricted, a day matching either of
+// them fires.
+//
+// The descriptors @yearly (or @annually), @monthly, @weekly, @daily (or
+// @midnight) and @hourly stand for the usual expressions, and "@every 90s"
+// fires at a fixed interval.
+package cron
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule is when a job runs
+type Schedule interface {
+	// Next returns the first time the schedule fires after t, or the zero
+	// time if it never does
+	Next(t time.Time) time.Time
+}
+
+// maxYears bounds the search of Next, for expressions such as 0 0 30 2 *
+// which never fire
+const maxYears = 5
+
+var descriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+var (
+	monthNames = map[string]int{"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6, "jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12}
+	dayNames   = map[string]int{"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6}
+)
+
+// field is the bounds and names of a field of the expressions
+type field struct {
+	name     string
+	min, max int
+	names    map[string]int
+}
+
+var fields = [5]field{
+	{"minute", 0, 59, nil},
+	{"hour", 0, 23, nil},
+	{"day of month", 1, 31, nil},
+	{"month", 1, 12, monthNames},
+	{"day of week", 0, 7, dayNames},
+}
+
+// Parse parses a cron expression or descriptor
+func Parse(spec string) (Schedule, error) {
+	spec = strings.TrimSpace(spec)
+	if strings.HasPrefix(spec, "@every ") {
+		d, err := time.ParseDuration(strings.TrimSpace(strings.TrimPrefix(spec, "@every ")))
+		if err != nil {
+			return nil, fmt.Errorf("cron: %q: %w", spec, err)
+		}
+		if d < time.Second {
+			return nil, fmt.Errorf("cron: %q: the interval must be at least a second", spec)
+		}
+		return every(d), nil
+	}
+	if expr, ok := descriptors[strings.ToLower(spec)]; ok {
+		spec = expr
+	}
+	parts := strings.Fields(spec)
+	if len(parts) != len(fields) {
+		return nil, fmt.Errorf("cron: %q: want 5 fields, got %d", spec, len(parts))
+	}
+	var e expr
+	for i, part := range parts {
+		bits, err := parseField(part, fields[i])
+		if err != nil {
+			return nil, fmt.Errorf("cron: %q: %w", spec, err)
+		}
+		e.fields[i] = bits
+	}
+	// 7 is Sunday too
+	if e.fields[4]&(1<<7) != 0 {
+		e.fields[4] |= 1
+	}
+	e.anyDay = strings.HasPrefix(parts[2], "*") || strings.HasPrefix(parts[4], "*")
+	return e, nil
+}
+
+// parseField returns the values of a field as a bit set
+func parseField(s string, f field) (uint64, error) {
+	var bits uint64
+	for _, item := range strings.Split(s, ",") {
+		rng, step := item, 1
+		if i := strings.IndexByte(item, '/'); i >= 0 {
+			n, err := strconv.Atoi(item[i+1:])
+			if err != nil || n < 1 {
+				return 0, fmt.Errorf("bad step in the %s field %q", f.name, item)
+			}
+			rng, step = item[:i], n
+		}
+		lo, hi := f.min, f.max
+		if rng != "*" {
+			var err error
+			bounds := strings.SplitN(rng, "-", 2)
+			if lo, err = f.value(bounds[0]); err != nil {
+				return 0, err
+			}
+			hi = lo
+			if len(bounds) == 2 {
+				if hi, err = f.value(bounds[1]); err != nil {
+					return 0, err
+				}
+			} else if step > 1 {
+				// 5/10 is 5-max/10
+				hi = f.max
+			}
+			if hi < lo {
+				return 0, fmt.Errorf("bad range in the %s field %q", f.name, item)
+			}
+		}
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+func (f field) value(s string) (int, error) {
+	if v, ok := f.names[strings.ToLower(s)]; ok {
+		return v, nil
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil || v < f.min || v > f.max {
+		return 0, fmt.Errorf("bad %s %q, want %d to %d", f.name, s, f.min, f.max)
+	}
+	return v, nil
+}
+
+// expr is a parsed expression: the bit sets of the values of its fields
+type expr struct {
+	fields [5]uint64
+	// anyDay is whether the day of the month or of the week is *, in which
+	// case a day must match both
+	anyDay bool
+}
+
+func (e expr) has(i, v int) bool {
+	return e.fields[i]&(1<<uint(v)) != 0
+}
+
+func (e expr) dayMatches(t time.Time) bool {
+	dom, dow := e.has(2, t.Day()), e.has(4, int(t.Weekday()))
+	if e.anyDay {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// Next returns the first minute after t matching e, in the location of t
+func (e expr) Next(t time.Time) time.Time {
+	loc := t.Location()
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	end := t.AddDate(maxYears, 0, 0)
+	for t.Before(end) {
+		switch {
+		case !e.has(3, int(t.Month())):
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+		case !e.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+		case !e.has(1, t.Hour()):
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc)
+		case !e.has(0, t.Minute()):
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// every fires at a fixed interval
+type every time.Duration
+
+func (d every) Next(t time.Time) time.Time {
+	return t.Truncate(time.Second).Add(time.Duration(d))
+}
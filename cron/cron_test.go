@@ -0,0 +1,48 @@
+package cron
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParse_Next(t *testing.T) {
+	// a Wednesday
+	from := time.Date(2024, time.January, 10, 12, 34, 56, 0, time.UTC)
+	tests := []struct {
+		spec string
+		want time.Time
+	}{
+		{"* * * * *", time.Date(2024, time.January, 10, 12, 35, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2024, time.January, 10, 12, 45, 0, 0, time.UTC)},
+		{"0 3 * * *", time.Date(2024, time.January, 11, 3, 0, 0, 0, time.UTC)},
+		{"30 9 * * mon-fri", time.Date(2024, time.January, 11, 9, 30, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2024, time.January, 14, 0, 0, 0, 0, time.UTC)},
+		{"0 0 1 feb *", time.Date(2024, time.February, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 12 29 2 *", time.Date(2024, time.February, 29, 12, 0, 0, 0, time.UTC)},
+		// either the day of the month or of the week
+		{"0 0 15 * fri", time.Date(2024, time.January, 12, 0, 0, 0, 0, time.UTC)},
+		{"5,10-20/5 13 * * *", time.Date(2024, time.January, 10, 13, 5, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2024, time.January, 10, 13, 0, 0, 0, time.UTC)},
+		{"@monthly", time.Date(2024, time.February, 1, 0, 0, 0, 0, time.UTC)},
+		{"@every 90s", time.Date(2024, time.January, 10, 12, 36, 26, 0, time.UTC)},
+		{"0 0 30 2 *", time.Time{}},
+	}
+	for _, tc := range tests {
+		s, err := Parse(tc.spec)
+		if err != nil {
+			t.Errorf("Parse(%q) received an error: %s", tc.spec, err)
+			continue
+		}
+		if got := s.Next(from); !got.Equal(tc.want) {
+			t.Errorf("Parse(%q).Next(): want %s, got %s", tc.spec, tc.want, got)
+		}
+	}
+}
+
+func TestParse_errors(t *testing.T) {
+	for _, spec := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "5-1 * * * *", "*/0 * * * *", "* * * foo *", "@every 1ms", "@every soon"} {
+		if _, err := Parse(spec); err == nil {
+			t.Errorf("Parse(%q): want an error", spec)
+		}
+	}
+}
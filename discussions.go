@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mmxmb/quiet_hn/history"
+)
+
+// maxDiscussions bounds the earlier discussions listed on an item page
+const maxDiscussions = 10
+
+// discussionsTimeout bounds the lookup of the earlier discussions of a story,
+// which the item page is rendered without past it
+const discussionsTimeout = 3 * time.Second
+
+// discussion is an earlier submission of the story of an item page. Time
+// is the Unix time it was submitted at or, for the local archive, first
+// seen on the front page.
+type discussion struct {
+	ID       int
+	Title    string
+	Score    int
+	Comments int
+	Time     int
+}
+
+// discussionFinder looks up the earlier discussions of a story: the other
+// submissions of its URL or, for stories without one, of its title
+type discussionFinder interface {
+	Discussions(ctx context.Context, itm item) ([]discussion, error)
+}
+
+// sameStory reports whether the submission with the given URL and title is
+// one of the story itm
+func sameStory(itm item, rawURL, title string) bool {
+	if itm.URL != "" {
+		return rawURL != "" && normalizeURL(rawURL) == normalizeURL(itm.URL)
+	}
+	return strings.EqualFold(strings.TrimSpace(title), strings.TrimSpace(itm.Title))
+}
+
+// normalizeURL returns rawURL without its scheme, fragment, www. prefix and
+// trailing slash, so that the submissions of a page compare equal
+func normalizeURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	s := strings.TrimPrefix(strings.ToLower(u.Host), "www.") + strings.TrimSuffix(u.Path, "/")
+	if u.RawQuery != "" {
+		s += "?" + u.RawQuery
+	}
+	return s
+}
+
+// sortDiscussions sorts ds by most recent first and bounds them to
+// maxDiscussions
+func sortDiscussions(ds []discussion) []discussion {
+	sort.SliceStable(ds, func(i, j int) bool { return ds[i].Time > ds[j].Time })
+	if len(ds) > maxDiscussions {
+		ds = ds[:maxDiscussions]
+	}
+	return ds
+}
+
+// algoliaFinder looks up the earlier discussions with the HN Search API
+// of Algolia at BaseURL, eg https://hn.algolia.com/api/v1
+type algoliaFinder struct {
+	BaseURL string
+	Client  *http.Client
+}
+
+func (f algoliaFinder) Discussions(ctx context.Context, itm item) ([]discussion, error) {
+	query, attr := itm.URL, "url"
+	if query == "" {
+		query, attr = itm.Title, "title"
+	}
+	params := url.Values{
+		"query":                        {query},
+		"restrictSearchableAttributes": {attr},
+		"tags":                         {"story"},
+		"hitsPerPage":                  {"50"},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(f.BaseURL, "/")+"/search?"+params.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	client := f.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("searching algolia: status %d", resp.StatusCode)
+	}
+	var body struct {
+		Hits []struct {
+			ObjectID    string `json:"objectID"`
+			Title       string `json:"title"`
+			URL         string `json:"url"`
+			Points      int    `json:"points"`
+			NumComments int    `json:"num_comments"`
+			CreatedAt   int    `json:"created_at_i"`
+		} `json:"hits"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("decoding the algolia response: %w", err)
+	}
+	var ds []discussion
+	for _, hit := range body.Hits {
+		id, err := strconv.Atoi(hit.ObjectID)
+		if err != nil || id == itm.ID || !sameStory(itm, hit.URL, hit.Title) {
+			continue
+		}
+		ds = append(ds, discussion{ID: id, Title: hit.Title, Score: hit.Points, Comments: hit.NumComments, Time: hit.CreatedAt})
+	}
+	return sortDiscussions(ds), nil
+}
+
+// localFinder looks up the earlier discussions among the stories of the
+// local archive, the ones seen on the front page according to the history
+type localFinder struct {
+	hist *history.Store
+}
+
+func (f localFinder) Discussions(ctx context.Context, itm item) ([]discussion, error) {
+	var ds []discussion
+	for _, p := range f.hist.Peaks(time.Time{}, time.Unix(1<<62, 0)) {
+		if p.ID == itm.ID || !sameStory(itm, p.URL, p.Title) {
+			continue
+		}
+		seen, _ := f.hist.FirstSeen(p.ID)
+		ds = append(ds, discussion{ID: p.ID, Title: p.Title, Score: p.PeakScore, Comments: p.Comments, Time: int(seen.Unix())})
+	}
+	return sortDiscussions(ds), nil
+}
+
+// maxCachedDiscussions bounds the items whose discussions are cached
+const maxCachedDiscussions = 1024
+
+// cachedFinder caches the discussions found by a discussionFinder for ttl,
+// by item
+type cachedFinder struct {
+	finder discussionFinder
+	ttl    time.Duration
+
+	mu      sync.Mutex
+	entries map[int]cachedDiscussions
+}
+
+type cachedDiscussions struct {
+	discussions []discussion
+	expires     time.Time
+}
+
+func newCachedFinder(finder discussionFinder, ttl time.Duration) *cachedFinder {
+	return &cachedFinder{finder: finder, ttl: ttl, entries: make(map[int]cachedDiscussions)}
+}
+
+func (f *cachedFinder) Discussions(ctx context.Context, itm item) ([]discussion, error) {
+	now := time.Now()
+	f.mu.Lock()
+	e, ok := f.entries[itm.ID]
+	f.mu.Unlock()
+	if ok && now.Before(e.expires) {
+		return e.discussions, nil
+	}
+	ds, err := f.finder.Discussions(ctx, itm)
+	if err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.entries) >= maxCachedDiscussions {
+		for id, e := range f.entries {
+			if !now.Before(e.expires) {
+				delete(f.entries, id)
+			}
+		}
+		if len(f.entries) >= maxCachedDiscussions {
+			f.entries = make(map[int]cachedDiscussions)
+		}
+	}
+	f.entries[itm.ID] = cachedDiscussions{discussions: ds, expires: now.Add(f.ttl)}
+	return ds, nil
+}
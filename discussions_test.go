@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mmxmb/quiet_hn/history"
+	"github.com/mmxmb/quiet_hn/hn"
+)
+
+func TestAlgoliaFinder(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if q := r.URL.Query(); r.URL.Path != "/search" || q.Get("query") != "https://example.com/1" || q.Get("restrictSearchableAttributes") != "url" {
+			t.Errorf("unexpected request %s", r.URL)
+		}
+		fmt.Fprint(w, `{"hits": [
+			{"objectID": "1", "title": "Story 1", "url": "https://example.com/1", "points": 10, "num_comments": 2, "created_at_i": 1700000000},
+			{"objectID": "7", "title": "An earlier story", "url": "http://www.example.com/1/", "points": 250, "num_comments": 90, "created_at_i": 1500000000},
+			{"objectID": "8", "title": "A later story", "url": "https://example.com/1", "points": null, "num_comments": 0, "created_at_i": 1600000000},
+			{"objectID": "9", "title": "Another page", "url": "https://example.com/1/more", "points": 5, "num_comments": 1, "created_at_i": 1650000000}
+		]}`)
+	}))
+	defer srv.Close()
+
+	f := newCachedFinder(algoliaFinder{BaseURL: srv.URL, Client: srv.Client()}, time.Hour)
+	itm := parseHNItem(hn.Item{ID: 1, Type: "story", Title: "Story 1", URL: "https://example.com/1"})
+	ds, err := f.Discussions(context.Background(), itm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ds) != 2 || ds[0].ID != 8 || ds[1].ID != 7 || ds[1].Score != 250 || ds[1].Comments != 90 {
+		t.Errorf("Discussions(): want stories 8 and 7, got %+v", ds)
+	}
+
+	srv.Close()
+	if cached, err := f.Discussions(context.Background(), itm); err != nil || len(cached) != 2 {
+		t.Errorf("Discussions() again: want the cached discussions, got %+v, %v", cached, err)
+	}
+}
+
+func TestItemHandler_discussions(t *testing.T) {
+	hist := history.NewStore()
+	t0 := time.Date(2018, 4, 1, 10, 0, 0, 0, time.UTC)
+	hist.Record(history.Snapshot{Time: t0, Stories: []history.Story{
+		{ID: 7, Title: "The first time", URL: "https://example.com/1", Score: 120, Comments: 30},
+		{ID: 8, Title: "Something else", URL: "https://example.com/8", Score: 50},
+	}})
+	p := newFakeProvider(1)
+	cfg := config{Messages: testMessages(t), Defaults: preferences{Timezone: "UTC"}}
+	rec := httptest.NewRecorder()
+	routed("/item/{id}", itemHandler(p, cfg, nil, localFinder{hist: hist}, testTemplates(t))).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/item/1", nil))
+	body := rec.Body.String()
+	if !strings.Contains(body, `<a href="/item/7">The first time</a>`) || !strings.Contains(body, "120 points") || strings.Contains(body, "Something else") {
+		t.Errorf("body: want story 7 listed as an earlier discussion, got %s", body)
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/mmxmb/quiet_hn/history"
+	"github.com/mmxmb/quiet_hn/hn"
+)
+
+// domainPeriods are the periods /domains aggregates the history over. "all"
+// is the whole history.
+var domainPeriods = map[string]time.Duration{
+	"week":  7 * 24 * time.Hour,
+	"month": 30 * 24 * time.Hour,
+	"all":   0,
+}
+
+// domainRecent is the number of recent stories listed per domain
+const domainRecent = 3
+
+// domainStat is how a domain did on the front page over a period
+type domainStat struct {
+	Host     string
+	Stories  int
+	AvgScore int
+	// Recent are the stories of the domain that were most recently first
+	// seen on the front page, newest first
+	Recent []item
+}
+
+// domainStats groups peaks by the host of their stories, most stories
+// first, and ties by average score. Stories linking to HN itself, such as
+// Ask HN, aren't counted. firstSeen returns when a story was first seen.
+func domainStats(peaks []history.Peak, firstSeen func(id int) time.Time) []domainStat {
+	byHost := make(map[string]*domainStat)
+	scores := make(map[string]int)
+	var hosts []string
+	for _, p := range peaks {
+		itm := parseHNItem(hn.Item{ID: p.ID, Title: p.Title, URL: p.URL, Score: p.PeakScore, Descendants: p.Comments})
+		if itm.Host == "" || itm.HNItemID != 0 {
+			continue
+		}
+		stat, ok := byHost[itm.Host]
+		if !ok {
+			stat = &domainStat{Host: itm.Host}
+			byHost[itm.Host] = stat
+			hosts = append(hosts, itm.Host)
+		}
+		stat.Stories++
+		scores[itm.Host] += p.PeakScore
+		stat.Recent = append(stat.Recent, itm)
+	}
+
+	stats := make([]domainStat, len(hosts))
+	for i, host := range hosts {
+		stat := byHost[host]
+		stat.AvgScore = scores[host] / stat.Stories
+		sort.SliceStable(stat.Recent, func(i, j int) bool {
+			return firstSeen(stat.Recent[i].ID).After(firstSeen(stat.Recent[j].ID))
+		})
+		if len(stat.Recent) > domainRecent {
+			stat.Recent = stat.Recent[:domainRecent]
+		}
+		stats[i] = *stat
+	}
+	sort.SliceStable(stats, func(i, j int) bool {
+		if stats[i].Stories != stats[j].Stories {
+			return stats[i].Stories > stats[j].Stories
+		}
+		return stats[i].AvgScore > stats[j].AvgScore
+	})
+	return stats
+}
+
+// domainsHandler serves /domains?period=week|month|all, the domains with the
+// most stories on the front page over the period according to the history,
+// with their average score and most recent stories.
+func domainsHandler(cfg config, hist *history.Store, tpls *templateSet) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		q := newQueryParams(r)
+		period := q.Enum("period", "month", "week", "month", "all")
+		if err := q.Err(); err != nil {
+			tpls.renderQueryError(w, r, err)
+			return
+		}
+
+		var from time.Time
+		if window := domainPeriods[period]; window > 0 {
+			from = start.Add(-window)
+		}
+		stats := domainStats(hist.Peaks(from, start), func(id int) time.Time {
+			t, _ := hist.FirstSeen(id)
+			return t
+		})
+		if len(stats) > cfg.NumStories {
+			stats = stats[:cfg.NumStories]
+		}
+		for _, stat := range stats {
+			cfg.Archive.decorate(stat.Recent)
+			if cfg.Redirect {
+				redirectLinks(stat.Recent)
+			}
+		}
+
+		data := domainsTemplateData{
+			Period:  period,
+			Periods: []string{"week", "month", "all"},
+			Domains: stats,
+			Lang:    languagePref(w, r, cfg.Messages),
+			Time:    time.Now().Sub(start),
+		}
+		err := tpls.render(w, "domains", data)
+		if err != nil {
+			http.Error(w, "Failed to process the template", http.StatusInternalServerError)
+			return
+		}
+	})
+}
+
+type domainsTemplateData struct {
+	Period  string
+	Periods []string
+	Domains []domainStat
+	Lang    string
+	Time    time.Duration
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/mmxmb/quiet_hn/jobs"
+	"github.com/mmxmb/quiet_hn/opengraph"
+)
+
+// maxSummaryLen is the maximum length in runes of the summaries shown on the
+// front page
+const maxSummaryLen = 200
+
+// enrichConfig configures the background fetching of the Open Graph
+// metadata of stories, shown as one line summaries and thumbnails
+type enrichConfig struct {
+	Enabled    bool
+	Thumbnails bool
+	// Timeout bounds the time spent fetching the metadata of a single page
+	Timeout time.Duration
+	// MaxBytes is the maximum number of bytes read from a page
+	MaxBytes int64
+	// CacheDuration is how long metadata (or the failure to get it) is kept
+	CacheDuration time.Duration
+	Workers       int
+}
+
+// enricher fetches the metadata of story pages in the background, so that
+// rendering the front page never waits on third party sites. Stories are
+// rendered without metadata until it has been fetched. The pages are fetched
+// on a queue of their own, so that slow sites can't hold up the other
+// background jobs.
+type enricher struct {
+	cfg    enrichConfig
+	client *http.Client
+	jobs   *jobs.Queue
+
+	mu      sync.Mutex
+	entries map[string]enrichEntry
+	pending map[string]bool
+}
+
+type enrichEntry struct {
+	meta       opengraph.Metadata
+	expiration time.Time
+}
+
+func newEnricher(cfg enrichConfig, userAgent string) *enricher {
+	e := &enricher{
+		cfg:     cfg,
+		client:  newPageClient(cfg.Timeout, userAgent),
+		jobs:    jobs.New(jobs.Config{Workers: cfg.Workers, Size: 100, Timeout: cfg.Timeout}),
+		entries: make(map[string]enrichEntry),
+		pending: make(map[string]bool),
+	}
+	return e
+}
+
+// decorate sets the Summary and Thumbnail of stories whose metadata has been
+// fetched already and schedules fetching it for the others
+func (e *enricher) decorate(stories []item) {
+	now := time.Now()
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for i := range stories {
+		pageURL := stories[i].URL
+		if pageURL == "" || stories[i].HNItemID != 0 {
+			continue
+		}
+		entry, ok := e.entries[pageURL]
+		if ok && now.Before(entry.expiration) {
+			stories[i].Summary = truncate(maxSummaryLen, entry.meta.Description)
+			if e.cfg.Thumbnails {
+				stories[i].Thumbnail = entry.meta.Image
+			}
+			continue
+		}
+		if e.pending[pageURL] {
+			continue
+		}
+		job := jobs.Job{Name: "enrich " + pageURL, Run: func(ctx context.Context) error {
+			e.enrich(ctx, pageURL)
+			return nil
+		}}
+		// if the workers are busy, try again on the next request
+		if e.jobs.Submit(job) {
+			e.pending[pageURL] = true
+		}
+	}
+}
+
+// enrich fetches the metadata of pageURL into e.entries
+func (e *enricher) enrich(ctx context.Context, pageURL string) {
+	meta, err := e.fetch(ctx, pageURL)
+	if err != nil {
+		// remember the failure too, so we don't keep hitting the page
+		meta = opengraph.Metadata{}
+	}
+	e.mu.Lock()
+	now := time.Now()
+	for u, entry := range e.entries {
+		if now.After(entry.expiration) {
+			delete(e.entries, u)
+		}
+	}
+	e.entries[pageURL] = enrichEntry{meta: meta, expiration: now.Add(e.cfg.CacheDuration)}
+	delete(e.pending, pageURL)
+	e.mu.Unlock()
+}
+
+func (e *enricher) fetch(ctx context.Context, pageURL string) (opengraph.Metadata, error) {
+	var meta opengraph.Metadata
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, pageURL, nil)
+	if err != nil {
+		return meta, err
+	}
+	if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+		return meta, fmt.Errorf("unsupported URL scheme %q", req.URL.Scheme)
+	}
+	req.Header.Set("Accept", "text/html")
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return meta, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return meta, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	meta, err = opengraph.Parse(io.LimitReader(resp.Body, e.cfg.MaxBytes))
+	if err != nil {
+		return meta, err
+	}
+	// only keep absolute http(s) image URLs, resolving relative ones
+	if meta.Image != "" {
+		img, err := req.URL.Parse(meta.Image)
+		if err != nil || (img.Scheme != "http" && img.Scheme != "https") {
+			meta.Image = ""
+		} else {
+			meta.Image = img.String()
+		}
+	}
+	return meta, nil
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"html"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// itemEPUBHandler serves /item/{id}.epub, the item and its comment tree as
+// an EPUB 3 book for offline reading on e-readers: the story with its
+// metadata, then the comments, the replies nested in the comments they
+// reply to. trees is nil if comments are disabled, in which case the book
+// only has the story.
+func itemEPUBHandler(client StoryProvider, cfg config, trees *commentTrees) http.HandlerFunc {
+	return exportHandler(client, cfg, trees, func(w http.ResponseWriter, lang, tz string, itm item, comments []*comment) error {
+		var buf bytes.Buffer
+		if err := writeEPUB(&buf, cfg, lang, tz, itm, comments, time.Now()); err != nil {
+			http.Error(w, "Failed to render the book", http.StatusInternalServerError)
+			return err
+		}
+		w.Header().Set("Content-Type", "application/epub+zip")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="item-%d.epub"`, itm.ID))
+		w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+		_, err := w.Write(buf.Bytes())
+		return err
+	})
+}
+
+// epubContainer points the readers to the package document of the books
+const epubContainer = `<?xml version="1.0" encoding="UTF-8"?>
+<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
+  <rootfiles>
+    <rootfile full-path="OEBPS/content.opf" media-type="application/oebps-package+xml"/>
+  </rootfiles>
+</container>
+`
+
+// epubStyle is the stylesheet of the books
+const epubStyle = `body { font-family: serif; }
+.meta { color: #666; font-size: 0.9em; }
+.comment { margin: 1em 0 0 0; }
+.replies { margin-left: 1em; padding-left: 0.5em; border-left: 1px solid #ccc; }
+pre { white-space: pre-wrap; font-size: 0.85em; }
+`
+
+// writeEPUB writes the book of the thread of itm to w, modified at now
+func writeEPUB(w *bytes.Buffer, cfg config, lang, tz string, itm item, comments []*comment, now time.Time) error {
+	z := zip.NewWriter(w)
+	// the mimetype must come first, uncompressed, for readers to sniff it
+	mimetype, err := z.CreateHeader(&zip.FileHeader{Name: "mimetype", Method: zip.Store})
+	if err != nil {
+		return err
+	}
+	if _, err := mimetype.Write([]byte("application/epub+zip")); err != nil {
+		return err
+	}
+	files := []struct{ name, content string }{
+		{"META-INF/container.xml", epubContainer},
+		{"OEBPS/content.opf", epubPackage(lang, itm, now)},
+		{"OEBPS/nav.xhtml", epubNav(cfg, lang, itm, len(comments) > 0)},
+		{"OEBPS/style.css", epubStyle},
+		{"OEBPS/thread.xhtml", epubThread(cfg, lang, tz, itm, comments)},
+	}
+	for _, f := range files {
+		fw, err := z.Create(f.name)
+		if err != nil {
+			return err
+		}
+		if _, err := fw.Write([]byte(f.content)); err != nil {
+			return err
+		}
+	}
+	return z.Close()
+}
+
+// epubPackage returns the package document of the book of itm, listing its
+// metadata and files
+func epubPackage(lang string, itm item, now time.Time) string {
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0" unique-identifier="id" xml:lang="` + lang + `">
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/">
+`)
+	fmt.Fprintf(&b, "    <dc:identifier id=\"id\">https://news.ycombinator.com/item?id=%d</dc:identifier>\n", itm.ID)
+	b.WriteString("    <dc:title>" + html.EscapeString(itm.Title) + "</dc:title>\n")
+	b.WriteString("    <dc:language>" + lang + "</dc:language>\n")
+	if itm.By != "" {
+		b.WriteString("    <dc:creator>" + html.EscapeString(itm.By) + "</dc:creator>\n")
+	}
+	b.WriteString("    <dc:date>" + isoTime(itm.Time) + "</dc:date>\n")
+	if itm.URL != "" {
+		b.WriteString("    <dc:source>" + html.EscapeString(itm.URL) + "</dc:source>\n")
+	}
+	b.WriteString(`    <meta property="dcterms:modified">` + now.UTC().Format("2006-01-02T15:04:05Z") + `</meta>
+  </metadata>
+  <manifest>
+    <item id="nav" href="nav.xhtml" media-type="application/xhtml+xml" properties="nav"/>
+    <item id="style" href="style.css" media-type="text/css"/>
+    <item id="thread" href="thread.xhtml" media-type="application/xhtml+xml"/>
+  </manifest>
+  <spine>
+    <itemref idref="thread"/>
+  </spine>
+</package>
+`)
+	return b.String()
+}
+
+// epubNav returns the navigation document of the book of itm: the story and,
+// if it has any, its comments
+func epubNav(cfg config, lang string, itm item, comments bool) string {
+	var b strings.Builder
+	b.WriteString(xhtmlHeader(lang, itm.Title, `xmlns:epub="http://www.idpf.org/2007/ops"`))
+	b.WriteString(`    <nav epub:type="toc">
+      <ol>
+        <li><a href="thread.xhtml#story">` + html.EscapeString(itm.Title) + "</a></li>\n")
+	if comments {
+		b.WriteString(`        <li><a href="thread.xhtml#comments">` + html.EscapeString(cfg.Messages.Plural(lang, "comments", itm.Descendants)) + "</a></li>\n")
+	}
+	b.WriteString("      </ol>\n    </nav>\n  </body>\n</html>\n")
+	return b.String()
+}
+
+// epubThread returns the document of the thread of itm
+func epubThread(cfg config, lang, tz string, itm item, comments []*comment) string {
+	var b strings.Builder
+	b.WriteString(xhtmlHeader(lang, itm.Title, ""))
+	b.WriteString(`    <h1 id="story">` + html.EscapeString(itm.Title) + "</h1>\n")
+	meta := cfg.Messages.Plural(lang, "points", itm.Score) + " · " + cfg.Messages.Plural(lang, "comments", itm.Descendants) + " · " + cfg.Messages.Translate(lang, "by", itm.By) + " · " + localTime(itm.Time, tz)
+	b.WriteString(`    <p class="meta">` + html.EscapeString(meta) + "</p>\n")
+	if itm.URL != "" {
+		b.WriteString(`    <p class="meta"><a href="` + html.EscapeString(itm.URL) + `">` + html.EscapeString(itm.URL) + "</a></p>\n")
+	}
+	if itm.Text != "" {
+		writeXHTMLParagraphs(&b, plainParagraphs(itm.Text))
+	}
+	if len(comments) > 0 {
+		b.WriteString(`    <h2 id="comments">` + html.EscapeString(cfg.Messages.Plural(lang, "comments", itm.Descendants)) + "</h2>\n")
+		for _, c := range comments {
+			writeXHTMLComment(&b, c, tz)
+		}
+	}
+	b.WriteString("  </body>\n</html>\n")
+	return b.String()
+}
+
+// xhtmlHeader returns the start of an XHTML document of the book, up to its
+// body
+func xhtmlHeader(lang, title, attrs string) string {
+	if attrs != "" {
+		attrs = " " + attrs
+	}
+	return `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE html>
+<html xmlns="http://www.w3.org/1999/xhtml"` + attrs + ` xml:lang="` + lang + `" lang="` + lang + `">
+  <head>
+    <meta charset="utf-8"/>
+    <title>` + html.EscapeString(title) + `</title>
+    <link rel="stylesheet" type="text/css" href="style.css"/>
+  </head>
+  <body>
+`
+}
+
+// writeXHTMLComment writes c and, nested in it, its replies to b
+func writeXHTMLComment(b *strings.Builder, c *comment, tz string) {
+	header := c.By
+	if c.OP {
+		header += " [OP]"
+	}
+	fmt.Fprintf(b, "    <div class=\"comment\" id=\"c%d\">\n", c.ID)
+	b.WriteString(`    <p class="meta">` + html.EscapeString(header+" · "+localTime(c.Time, tz)) + "</p>\n")
+	writeXHTMLParagraphs(b, plainParagraphs(c.Text))
+	if len(c.Replies) > 0 {
+		b.WriteString("    <div class=\"replies\">\n")
+		for _, reply := range c.Replies {
+			writeXHTMLComment(b, reply, tz)
+		}
+		b.WriteString("    </div>\n")
+	}
+	b.WriteString("    </div>\n")
+}
+
+// writeXHTMLParagraphs writes the paragraphs ps to b
+func writeXHTMLParagraphs(b *strings.Builder, ps []paragraph) {
+	for _, p := range ps {
+		if p.pre {
+			b.WriteString("    <pre>" + html.EscapeString(p.text) + "</pre>\n")
+		} else {
+			b.WriteString("    <p>" + html.EscapeString(p.text) + "</p>\n")
+		}
+	}
+}
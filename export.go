@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bufio"
+	"html"
+	"io"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/mmxmb/quiet_hn/router"
+)
+
+// textWidth is the width the texts of plain text threads are wrapped at
+const textWidth = 72
+
+// itemTextHandler serves /item/{id}.txt, the item and its comment tree as
+// plain text, with the replies indented under the comments they reply to,
+// to be read in pagers or e-readers or fed to other programs. trees is nil
+// if comments are disabled, in which case only the item is rendered.
+func itemTextHandler(client StoryProvider, cfg config, trees *commentTrees) http.HandlerFunc {
+	return exportHandler(client, cfg, trees, func(w http.ResponseWriter, lang, tz string, itm item, comments []*comment) error {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		bw := bufio.NewWriter(w)
+		writeThreadText(bw, cfg, lang, tz, itm, comments)
+		return bw.Flush()
+	})
+}
+
+// threadExport writes the thread of itm, with its comments, to w
+type threadExport func(w http.ResponseWriter, lang, tz string, itm item, comments []*comment) error
+
+// exportHandler serves the thread of the item of the id path parameter,
+// written by export. trees is nil if comments are disabled, in which case
+// export is given none.
+func exportHandler(client StoryProvider, cfg config, trees *commentTrees, export threadExport) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(router.Param(r, "id"))
+		if err != nil || id <= 0 {
+			http.Error(w, "Invalid item ID", http.StatusBadRequest)
+			return
+		}
+		prefs, err := loadPreferences(w, r, cfg.Defaults)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		hnItem, err := client.GetItem(id)
+		if err != nil {
+			http.Error(w, "Failed to load the item", http.StatusInternalServerError)
+			return
+		}
+		if !hnItem.Alive() {
+			http.NotFound(w, r)
+			return
+		}
+		var comments []*comment
+		if trees != nil {
+			if comments, err = trees.Get(r.Context(), hnItem); err != nil {
+				http.Error(w, "Failed to load the comments", http.StatusInternalServerError)
+				return
+			}
+		}
+		if err := export(w, languagePref(w, r, cfg.Messages), prefs.Timezone, parseHNItem(hnItem), comments); err != nil {
+			log.Printf("exporting item %d: %s", id, err)
+		}
+	})
+}
+
+// writeThreadText writes the plain text of the thread of itm to w
+func writeThreadText(w io.Writer, cfg config, lang, tz string, itm item, comments []*comment) {
+	io.WriteString(w, itm.Title+"\n")
+	if itm.URL != "" {
+		io.WriteString(w, itm.URL+"\n")
+	}
+	io.WriteString(w, cfg.Messages.Plural(lang, "points", itm.Score)+" · "+cfg.Messages.Plural(lang, "comments", itm.Descendants)+" · "+cfg.Messages.Translate(lang, "by", itm.By)+" · "+localTime(itm.Time, tz)+"\n")
+	if itm.Text != "" {
+		io.WriteString(w, "\n"+wrapText(plainParagraphs(itm.Text), "", textWidth))
+	}
+	for _, c := range comments {
+		writeCommentText(w, c, tz, 0)
+	}
+}
+
+// writeCommentText writes the plain text of c and its replies to w, the
+// comment indented by depth levels
+func writeCommentText(w io.Writer, c *comment, tz string, depth int) {
+	indent := strings.Repeat("    ", depth)
+	header := c.By
+	if c.OP {
+		header += " [OP]"
+	}
+	io.WriteString(w, "\n"+indent+header+" · "+localTime(c.Time, tz)+"\n")
+	io.WriteString(w, wrapText(plainParagraphs(c.Text), indent, textWidth))
+	for _, reply := range c.Replies {
+		writeCommentText(w, reply, tz, depth+1)
+	}
+}
+
+// paragraph is a paragraph of a text, pre if it is preformatted
+type paragraph struct {
+	text string
+	pre  bool
+}
+
+var (
+	// paragraphRE matches the markup separating the paragraphs of HN texts:
+	// <p>, which HN doesn't close, and the code blocks
+	paragraphRE = regexp.MustCompile(`(?is)<pre>.*?</pre>|<p>`)
+	// linkRE matches the links of HN texts, whose text is their URL, cut
+	// short if it is long
+	linkRE = regexp.MustCompile(`(?is)<a\s[^>]*href="([^"]*)"[^>]*>.*?</a>`)
+)
+
+// plainParagraphs returns the paragraphs of the HN text s, without markup.
+// The links are replaced with their full URLs.
+func plainParagraphs(s string) []paragraph {
+	s = linkRE.ReplaceAllString(s, "$1")
+	var ps []paragraph
+	text := func(s string) {
+		if s = strings.TrimSpace(strings.Join(strings.Fields(plainText(s)), " ")); s != "" {
+			ps = append(ps, paragraph{text: s})
+		}
+	}
+	for len(s) > 0 {
+		loc := paragraphRE.FindStringIndex(s)
+		if loc == nil {
+			text(s)
+			break
+		}
+		text(s[:loc[0]])
+		if m := s[loc[0]:loc[1]]; !strings.EqualFold(m, "<p>") {
+			code := html.UnescapeString(tagRE.ReplaceAllString(m, ""))
+			ps = append(ps, paragraph{text: strings.Trim(code, "\n"), pre: true})
+		}
+		s = s[loc[1]:]
+	}
+	return ps
+}
+
+// wrapText returns the paragraphs ps separated by blank lines, each line
+// starting with indent, the ones that aren't preformatted wrapped at width
+// columns (longer words, eg URLs, aren't broken)
+func wrapText(ps []paragraph, indent string, width int) string {
+	var b strings.Builder
+	for i, p := range ps {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		if p.pre {
+			for _, line := range strings.Split(p.text, "\n") {
+				b.WriteString(indent + "  " + line + "\n")
+			}
+			continue
+		}
+		line := 0
+		for _, word := range strings.Fields(p.text) {
+			n := len([]rune(word))
+			switch {
+			case line == 0:
+				b.WriteString(indent)
+			case line+1+n > width:
+				b.WriteString("\n" + indent)
+				line = 0
+			default:
+				b.WriteString(" ")
+				line++
+			}
+			b.WriteString(word)
+			line += n
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mmxmb/quiet_hn/hn"
+)
+
+func TestItemTextHandler(t *testing.T) {
+	p := newThreadProvider()
+	p.items[2] = hn.Item{ID: 2, Type: "comment", By: "alice", Text: `See <a href="https:&#x2F;&#x2F;example.com&#x2F;a" rel="nofollow">https:&#x2F;&#x2F;example.com&#x2F;a</a> &amp; more<p>Second<pre><code>  x := 1
+</code></pre>`, Kids: []int{4}, Parent: 1}
+	cfg := config{Messages: testMessages(t), Defaults: preferences{Timezone: "UTC"}, Comments: commentsConfig{FetchTimeout: time.Second}}
+	rec := httptest.NewRecorder()
+	routed("/item/{id}.txt", itemTextHandler(p, cfg, newCommentTrees(p, cfg))).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/item/1.txt", nil))
+	if ct := rec.Header().Get("Content-Type"); ct != "text/plain; charset=utf-8" {
+		t.Errorf("Content-Type: want plain text, got %q", ct)
+	}
+	body := rec.Body.String()
+	want := "\nalice · 1970-01-01 00:00 UTC\nSee https://example.com/a & more\n\nSecond\n\n    x := 1\n\n    bob · 1970-01-01 00:00 UTC\n    Reply\n"
+	if !strings.HasPrefix(body, "Story 1\n") || !strings.HasSuffix(body, want) {
+		t.Errorf("body: want story 1 with the comments indented, got:\n%s", body)
+	}
+}
+
+func TestWrapText(t *testing.T) {
+	got := wrapText([]paragraph{{text: "aaa bbb ccc ddd"}}, "> ", 7)
+	if want := "> aaa bbb\n> ccc ddd\n"; got != want {
+		t.Errorf("wrapText(): want %q, got %q", want, got)
+	}
+}
+
+func TestItemEPUBHandler(t *testing.T) {
+	p := newThreadProvider()
+	cfg := config{Messages: testMessages(t), Defaults: preferences{Timezone: "UTC"}, Comments: commentsConfig{FetchTimeout: time.Second}}
+	rec := httptest.NewRecorder()
+	routed("/item/{id}.epub", itemEPUBHandler(p, cfg, newCommentTrees(p, cfg))).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/item/1.epub", nil))
+	if ct := rec.Header().Get("Content-Type"); ct != "application/epub+zip" {
+		t.Fatalf("Content-Type: want an EPUB, got %q", ct)
+	}
+	z, err := zip.NewReader(bytes.NewReader(rec.Body.Bytes()), int64(rec.Body.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f := z.File[0]; f.Name != "mimetype" || f.Method != zip.Store {
+		t.Errorf("first file: want the stored mimetype, got %s", f.Name)
+	}
+	for _, f := range z.File {
+		r, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.HasSuffix(f.Name, ".xml") || strings.HasSuffix(f.Name, ".opf") || strings.HasSuffix(f.Name, ".xhtml") {
+			// the documents of books must be well-formed XML
+			d := xml.NewDecoder(bytes.NewReader(data))
+			for {
+				if _, err := d.Token(); err == io.EOF {
+					break
+				} else if err != nil {
+					t.Errorf("%s: %s", f.Name, err)
+					break
+				}
+			}
+		}
+		if f.Name == "OEBPS/thread.xhtml" && (!strings.Contains(string(data), "<p>First</p>") || !strings.Contains(string(data), `<div class="replies">`)) {
+			t.Errorf("thread.xhtml: want comment 2 with its reply nested, got %s", data)
+		}
+	}
+}
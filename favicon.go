@@ -0,0 +1,250 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mmxmb/quiet_hn/opengraph"
+)
+
+// fallbackFavicon is served for hosts without a usable favicon
+const fallbackFavicon = `<svg xmlns="http://www.w3.org/2000/svg" width="16" height="16" viewBox="0 0 16 16"><circle cx="8" cy="8" r="6" fill="none" stroke="#bbb" stroke-width="1.5"/></svg>`
+
+// faviconConfig configures the favicon proxy served at /favicon
+type faviconConfig struct {
+	Enabled bool
+	Timeout time.Duration
+	// MaxBytes is the maximum size of the favicons served
+	MaxBytes      int64
+	CacheDuration time.Duration
+}
+
+// faviconFetcher resolves, fetches and caches the favicons of story hosts.
+// Only the favicons of hosts that appeared in a story in the last
+// CacheDuration are served, so the proxy can't be used to fetch arbitrary
+// sites.
+type faviconFetcher struct {
+	cfg    faviconConfig
+	client *http.Client
+
+	mu sync.Mutex
+	// allowed are the hosts whose favicons are served, until when
+	allowed map[string]time.Time
+	icons   map[string]favicon
+	// calls are the fetches in progress, shared by the requests for the
+	// favicon of the same host
+	calls map[string]*faviconCall
+}
+
+// faviconCall is a fetch of a favicon in progress; done is closed once it is
+// over
+type faviconCall struct {
+	done chan struct{}
+	icon favicon
+}
+
+// maxFaviconHosts bounds the hosts whose favicons are served, in case
+// CacheDuration covers more stories than it takes to fill the memory
+const maxFaviconHosts = 10000
+
+// faviconRetry is how long the fallback icon of a host whose favicon failed
+// to load is cached, rather than CacheDuration, since the failure may not
+// last
+const faviconRetry = 5 * time.Minute
+
+// favicon is an icon served; failed is set for the fallback icon of a host
+// whose favicon failed to load
+type favicon struct {
+	contentType string
+	data        []byte
+	failed      bool
+	expiration  time.Time
+}
+
+// ttl returns how long icon is cached
+func (f *faviconFetcher) ttl(icon favicon) time.Duration {
+	if icon.failed && faviconRetry < f.cfg.CacheDuration {
+		return faviconRetry
+	}
+	return f.cfg.CacheDuration
+}
+
+func newFaviconFetcher(cfg faviconConfig, userAgent string) *faviconFetcher {
+	return &faviconFetcher{
+		cfg:     cfg,
+		client:  newPageClient(cfg.Timeout, userAgent),
+		allowed: make(map[string]time.Time),
+		icons:   make(map[string]favicon),
+		calls:   make(map[string]*faviconCall),
+	}
+}
+
+// decorate sets the Favicon of stories and allows serving it for
+// CacheDuration, as long as the cached icon
+func (f *faviconFetcher) decorate(stories []item) {
+	now := time.Now()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for host, until := range f.allowed {
+		if now.After(until) {
+			delete(f.allowed, host)
+		}
+	}
+	for i := range stories {
+		host := stories[i].Host
+		if host == "" || stories[i].HNItemID != 0 {
+			continue
+		}
+		if _, ok := f.allowed[host]; !ok && len(f.allowed) >= maxFaviconHosts {
+			continue
+		}
+		f.allowed[host] = now.Add(f.cfg.CacheDuration)
+		stories[i].Favicon = "/favicon?host=" + url.QueryEscape(host)
+	}
+}
+
+// Allowed reports whether the favicon of host is served
+func (f *faviconFetcher) Allowed(host string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	until, ok := f.allowed[host]
+	return ok && !time.Now().After(until)
+}
+
+// Get returns the favicon of host, fetching it if it isn't cached.
+// Concurrent requests for the favicon of a host share a single fetch, which
+// isn't canceled with ctx but bounded by Timeout. Hosts without a favicon,
+// and requests done before it is fetched, get the fallback icon.
+func (f *faviconFetcher) Get(ctx context.Context, host string) favicon {
+	f.mu.Lock()
+	if icon, ok := f.icons[host]; ok && time.Now().Before(icon.expiration) {
+		f.mu.Unlock()
+		return icon
+	}
+	call, ok := f.calls[host]
+	if !ok {
+		call = &faviconCall{done: make(chan struct{})}
+		f.calls[host] = call
+		go f.load(host, call)
+	}
+	f.mu.Unlock()
+
+	select {
+	case <-call.done:
+		return call.icon
+	case <-ctx.Done():
+		return fallbackIcon()
+	}
+}
+
+func fallbackIcon() favicon {
+	return favicon{contentType: "image/svg+xml", data: []byte(fallbackFavicon), failed: true}
+}
+
+// load fetches the favicon of host for call and caches it, the fallback icon
+// for faviconRetry only if the fetch failed
+func (f *faviconFetcher) load(host string, call *faviconCall) {
+	ctx := context.Background()
+	if f.cfg.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, f.cfg.Timeout)
+		defer cancel()
+	}
+	icon, err := f.fetch(ctx, host)
+	if err != nil {
+		icon = fallbackIcon()
+	}
+	now := time.Now()
+	icon.expiration = now.Add(f.ttl(icon))
+	call.icon = icon
+
+	f.mu.Lock()
+	for h, cached := range f.icons {
+		if now.After(cached.expiration) {
+			delete(f.icons, h)
+		}
+	}
+	f.icons[host] = icon
+	delete(f.calls, host)
+	f.mu.Unlock()
+	close(call.done)
+}
+
+// fetch looks for the icon linked from the home page of host, falling back to
+// the conventional /favicon.ico
+func (f *faviconFetcher) fetch(ctx context.Context, host string) (favicon, error) {
+	home := &url.URL{Scheme: "https", Host: host, Path: "/"}
+	iconURL := home.ResolveReference(&url.URL{Path: "/favicon.ico"})
+	body, _, err := f.get(ctx, home.String(), 256<<10)
+	if err == nil {
+		meta, err := opengraph.Parse(strings.NewReader(string(body)))
+		if err == nil && meta.Icon != "" {
+			if u, err := home.Parse(meta.Icon); err == nil && (u.Scheme == "http" || u.Scheme == "https") {
+				iconURL = u
+			}
+		}
+	}
+
+	data, contentType, err := f.get(ctx, iconURL.String(), f.cfg.MaxBytes)
+	if err != nil {
+		return favicon{}, err
+	}
+	if !strings.HasPrefix(contentType, "image/") {
+		return favicon{}, fmt.Errorf("favicon of %s is a %s", host, contentType)
+	}
+	return favicon{contentType: contentType, data: data}, nil
+}
+
+// get returns the body and content type of the resource at rawURL, failing
+// if it is larger than maxBytes
+func (f *faviconFetcher) get(ctx context.Context, rawURL string, maxBytes int64) ([]byte, string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxBytes+1))
+	if err != nil {
+		return nil, "", err
+	}
+	if int64(len(data)) > maxBytes {
+		return nil, "", fmt.Errorf("%s is larger than %d bytes", rawURL, maxBytes)
+	}
+	contentType := resp.Header.Get("Content-Type")
+	if contentType == "" {
+		contentType = http.DetectContentType(data)
+	}
+	return data, contentType, nil
+}
+
+func faviconHandler(fetcher *faviconFetcher) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.URL.Query().Get("host")
+		if !fetcher.Allowed(host) {
+			http.NotFound(w, r)
+			return
+		}
+
+		icon := fetcher.Get(r.Context(), host)
+		w.Header().Set("Content-Type", icon.contentType)
+		w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(fetcher.ttl(icon)/time.Second)))
+		// favicons are third party content; don't let them be anything but images
+		w.Header().Set("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'; sandbox")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.Write(icon.data)
+	})
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// The experimental subsystems gated by feature flags
+const (
+	featureComments = "comments"
+)
+
+// features are the known feature flags and whether they are enabled by
+// default
+var features = map[string]bool{
+	featureComments: true,
+}
+
+// featureFlags is a flag.Value holding the enabled features, set from a
+// comma separated list of features to enable, each prefixed with - to
+// disable it instead, eg "-comments". Features that aren't listed keep their
+// default.
+//
+// Experimental subsystems are all gated the same way: their routes are only
+// registered, and the handlers only given what they need to use them, if
+// Enabled reports their feature is.
+type featureFlags map[string]bool
+
+func defaultFeatures() featureFlags {
+	f := make(featureFlags, len(features))
+	for name, enabled := range features {
+		f[name] = enabled
+	}
+	return f
+}
+
+// Enabled reports whether the feature name is enabled
+func (f featureFlags) Enabled(name string) bool {
+	return f[name]
+}
+
+func (f featureFlags) String() string {
+	var enabled []string
+	for name, on := range f {
+		if on {
+			enabled = append(enabled, name)
+		}
+	}
+	sort.Strings(enabled)
+	return strings.Join(enabled, ",")
+}
+
+func (f featureFlags) Set(value string) error {
+	for _, name := range strings.Split(value, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		enable := !strings.HasPrefix(name, "-")
+		name = strings.TrimPrefix(name, "-")
+		if _, ok := features[name]; !ok {
+			return fmt.Errorf("unknown feature %q", name)
+		}
+		f[name] = enable
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+)
+
+// filter decides which items make it to the front page. Items that aren't
+// alive or aren't links to stories, polls or (optionally) job postings are
+// always filtered out.
+type filter struct {
+	HideJobs      bool
+	HidePaywalled bool
+
+	// Muted are the users whose stories are filtered out for everyone,
+	// muted by the operator, lowercased and sorted
+	Muted []string
+	// userMuted are the users muted by the user. Their stories are dropped
+	// from the cached lists, by unmuted, rather than being part of their
+	// key, so that every user shares the lists.
+	userMuted []string
+
+	// numStories is the number of stories of the lists filtered, part of
+	// their key since the hosts showing fewer or more stories share the
+	// cache
+	numStories     int
+	paywallDomains []string
+}
+
+// newFilter returns the filter applying prefs with the settings in cfg
+func newFilter(cfg config, prefs preferences) filter {
+	return filter{
+		HideJobs:       prefs.HideJobs,
+		HidePaywalled:  prefs.HidePaywalled,
+		Muted:          mutedUsers(cfg.MutedUsers),
+		userMuted:      mutedUsers(prefs.Muted),
+		numStories:     cfg.NumStories,
+		paywallDomains: cfg.PaywallDomains,
+	}
+}
+
+func (f filter) keep(item item) bool {
+	if !item.Alive() {
+		return false
+	}
+	if f.HidePaywalled && matchesAnyDomain(item.Host, f.paywallDomains) {
+		return false
+	}
+	if item.By != "" && (containsUser(f.Muted, item.By) || containsUser(f.userMuted, item.By)) {
+		return false
+	}
+	switch {
+	case isStoryLink(item), isPoll(item):
+		return true
+	case isJob(item):
+		return !f.HideJobs
+	}
+	return false
+}
+
+// shared returns f without the users muted by the user, the filter of the
+// cached lists
+func (f filter) shared() filter {
+	f.userMuted = nil
+	return f
+}
+
+// unmuted returns the first n of stories, a list filtered with shared, that
+// aren't by a user muted by the user
+func (f filter) unmuted(stories []item, n int) []item {
+	kept := stories[:0]
+	for _, story := range stories {
+		if len(kept) == n {
+			break
+		}
+		if story.By == "" || !containsUser(f.userMuted, story.By) {
+			kept = append(kept, story)
+		}
+	}
+	return kept
+}
+
+// key returns a string identifying the shared filter. Lists of stories
+// filtered with the same settings have the same key, so it can be used as a
+// cache key.
+func (f filter) key() string {
+	key := "hide_jobs=" + strconv.FormatBool(f.HideJobs) + "&hide_paywalled=" + strconv.FormatBool(f.HidePaywalled)
+	if f.numStories > 0 {
+		key += "&n=" + strconv.Itoa(f.numStories)
+	}
+	if len(f.Muted) > 0 {
+		key += "&muted=" + strings.Join(f.Muted, ",")
+	}
+	return key
+}
+
+func isStoryLink(item item) bool {
+	return item.Alive() && item.Type == "story" && item.URL != ""
+}
+
+// isPoll reports whether item is a poll. Polls don't link anywhere, so they
+// are rendered on the /item page instead.
+func isPoll(item item) bool {
+	return item.Alive() && item.Type == "poll"
+}
+
+// isJob reports whether item is a job posting, eg a YC company hiring
+func isJob(item item) bool {
+	return item.Alive() && item.Type == "job"
+}
+
+// defaultPaywallDomains are well known sites that require a subscription to
+// read most of their articles
+var defaultPaywallDomains = []string{
+	"bloomberg.com",
+	"economist.com",
+	"ft.com",
+	"hbr.org",
+	"newyorker.com",
+	"nytimes.com",
+	"theatlantic.com",
+	"washingtonpost.com",
+	"wired.com",
+	"wsj.com",
+}
+
+// markPaywalled sets the Paywalled field of stories hosted on one of domains
+func markPaywalled(stories []item, domains []string) {
+	for i := range stories {
+		stories[i].Paywalled = matchesAnyDomain(stories[i].Host, domains)
+	}
+}
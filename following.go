@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// followingHandler serves /following, the latest stories submitted by the
+// users of -following, most recent first
+func followingHandler(client StoryProvider, cache *Cache, cfg config, tpls *templateSet) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		prefs, err := loadPreferences(w, r, cfg.Defaults)
+		if err != nil {
+			tpls.renderQueryError(w, r, err)
+			return
+		}
+		stories, err := cachedFollowedStories(r.Context(), client, cache, cfg, newFilter(cfg, prefs))
+		if err != nil {
+			tpls.renderError(w, r, http.StatusInternalServerError, "error.load_stories")
+			return
+		}
+		cfg.Archive.decorate(stories)
+		markPaywalled(stories, cfg.PaywallDomains)
+		if cfg.Redirect {
+			redirectLinks(stories)
+		}
+
+		data := followingTemplateData{
+			Users:   cfg.Following,
+			Stories: stories,
+			Lang:    languagePref(w, r, cfg.Messages),
+			TZ:      prefs.Timezone,
+			Time:    time.Now().Sub(start),
+		}
+		err = tpls.render(w, "following", data)
+		releaseItems(stories)
+		if err != nil {
+			http.Error(w, "Failed to process the template", http.StatusInternalServerError)
+			return
+		}
+	})
+}
+
+type followingTemplateData struct {
+	Users   []string
+	Stories []item
+	Lang    string
+	TZ      string
+	Time    time.Duration
+}
+
+// cachedFollowedStories returns the stories kept by f among the latest
+// submissions of the users of cfg.Following, cached like the front page
+func cachedFollowedStories(ctx context.Context, client StoryProvider, cache *Cache, cfg config, f filter) ([]item, error) {
+	stories, err := cache.Fetch(ctx, "following&"+f.key(), func(ctx context.Context) ([]item, error) {
+		return getFollowedStories(ctx, client, cfg, f.shared())
+	})
+	if err != nil {
+		return nil, err
+	}
+	return f.unmuted(stories, cfg.NumStories), nil
+}
+
+// getFollowedStories returns the cfg.NumStories most recent stories kept by
+// f among the last numUserSubmissions submissions of each of the users of
+// cfg.Following. Users that can't be loaded are skipped, unless none can.
+func getFollowedStories(ctx context.Context, client StoryProvider, cfg config, f filter) ([]item, error) {
+	submitted := make([][]int, len(cfg.Following))
+	errs := make([]error, len(cfg.Following))
+	concurrency := cfg.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i, name := range cfg.Following {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, name string) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			user, err := client.GetUser(name)
+			if err != nil {
+				log.Printf("loading followed user %s: %s", name, err)
+				errs[i] = err
+				return
+			}
+			ids := user.Submitted
+			if len(ids) > numUserSubmissions {
+				ids = ids[:numUserSubmissions]
+			}
+			submitted[i] = ids
+		}(i, name)
+	}
+	wg.Wait()
+
+	var ids []int
+	var failed int
+	for i := range cfg.Following {
+		if errs[i] != nil {
+			failed++
+		}
+		ids = append(ids, submitted[i]...)
+	}
+	if failed > 0 && failed == len(cfg.Following) {
+		return nil, errs[0]
+	}
+
+	stories := getStories(ctx, ids, 0, client, cfg.Concurrency, f)
+	sort.SliceStable(stories, func(i, j int) bool { return stories[i].Time > stories[j].Time })
+	if len(stories) > cfg.NumStories {
+		stories = stories[:cfg.NumStories]
+	}
+	for i := range stories {
+		stories[i].Rank = i + 1
+	}
+	return stories, nil
+}
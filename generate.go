@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/mmxmb/quiet_hn/history"
+	"github.com/mmxmb/quiet_hn/hn"
+	"github.com/mmxmb/quiet_hn/i18n"
+)
+
+// staticLinks match the links of the rendered pages to the item and user
+// pages, which a static site doesn't have
+var staticLinks = regexp.MustCompile(`href="/(item|user)/([^"]+)"`)
+
+// generateCommand renders the front page, and the recent days of the front
+// page history, to static HTML files that can be hosted anywhere, eg
+//
+//	quiet_hn generate -out ./site -history_file history.jsonl -days 7
+//
+// Run daily, it records the front page of the day to the history file and
+// builds a digest of the past days.
+func generateCommand(args []string) error {
+	fs := flag.NewFlagSet("generate", flag.ExitOnError)
+	out := fs.String("out", "./site", "the directory the site is written to, or an s3://bucket/prefix URL to upload it to")
+	historyFile := fs.String("history_file", "", "the front page history file the front page is recorded to and the recent days are rendered from (defaults to only rendering the front page)")
+	days := fs.Int("days", 7, "the number of past days of the history rendered, at /history/{date}/")
+	cfg := config{Features: defaultFeatures()}
+	fs.IntVar(&cfg.NumStories, "num_stories", 30, "the number of top stories to display")
+	fs.IntVar(&cfg.Concurrency, "concurrency", 10, "the maximum number of concurrent requests to the HN API")
+	fs.BoolVar(&cfg.Defaults.HideJobs, "hide_jobs", true, "hide job postings")
+	fs.StringVar(&cfg.Archive.Service, "archive", "", "render archive links to the given service: wayback or archive.today")
+	fs.StringVar(&cfg.Defaults.Timezone, "default_timezone", "UTC", "the timezone times are displayed in")
+	templatesDir := fs.String("templates", "", "a directory with templates replacing the built-in ones with the same file name")
+	localesDir := fs.String("locales", "./locales", "the directory with the translations of the UI")
+	lang := fs.String("lang", "en", "the language of the pages")
+	minify := fs.Bool("minify", false, "strip the comments and indentation of the pages")
+	apiBase := fs.String("hn_api_base", "", "the base URL of the HN API (defaults to $"+hn.APIBaseEnv+" or the official API)")
+	fs.Parse(args)
+	cfg.PaywallDomains = defaultPaywallDomains
+	if err := cfg.Archive.validate(); err != nil {
+		return fmt.Errorf("generate: %w", err)
+	}
+	if !validTimezone(cfg.Defaults.Timezone) {
+		return fmt.Errorf("generate: unknown timezone %q", cfg.Defaults.Timezone)
+	}
+
+	messages, err := i18n.LoadDir(*localesDir, *lang)
+	if err != nil {
+		return err
+	}
+	cfg.Messages = messages
+	tpls, err := loadTemplates(messages, *templatesDir)
+	if err != nil {
+		return err
+	}
+	tpls.Minify = *minify
+	opts := []hn.Option{hn.WithUserAgent(defaultUserAgent(defaultContactURL))}
+	if *apiBase != "" {
+		opts = append(opts, hn.WithBaseURL(*apiBase))
+	}
+	s := &site{
+		client:      hn.NewClient(opts...),
+		cache:       &Cache{ExpirationDuration: time.Hour},
+		cfg:         cfg,
+		tpls:        tpls,
+		compression: func(h http.Handler) http.Handler { return h },
+	}
+	if *historyFile != "" {
+		if s.hist, err = history.Open(*historyFile); err != nil {
+			return err
+		}
+		defer s.hist.Close()
+	}
+
+	n, err := generateSite(s, *out, *lang, *days, time.Now())
+	if err != nil {
+		return fmt.Errorf("generate: %w", err)
+	}
+	log.Printf("generated %d pages in %s", n, *out)
+	return nil
+}
+
+// generateSite renders the pages of s to files in the directory out, or to
+// objects under out if it is an s3:// URL, in lang, and returns how many were
+// written. The front page is fetched and recorded to the history of s, if
+// any, and the last days of the history are rendered before now.
+func generateSite(s *site, out, lang string, days int, now time.Time) (int, error) {
+	f := newFilter(s.cfg, s.cfg.Defaults)
+	stories, err := fetchTopStories(context.Background(), s.client, s.cfg, f)
+	if err != nil {
+		return 0, err
+	}
+	s.cache.Set(f.key(), stories)
+	stories = f.unmuted(stories, s.cfg.NumStories)
+
+	paths := []string{"/"}
+	if s.hist != nil {
+		if err := recordSnapshot(s.hist, stories, now); err != nil {
+			return 0, err
+		}
+		paths = append(paths, "/best/day", "/best/week")
+		loc, _ := loadLocation(s.cfg.Defaults.Timezone)
+		for i := 1; i <= days; i++ {
+			day := now.In(loc).AddDate(0, 0, -i).Format("2006-01-02")
+			paths = append(paths, "/history/"+day)
+		}
+	}
+
+	write, err := sitePages(out)
+	if err != nil {
+		return 0, err
+	}
+	h := s.routes()
+	n := 0
+	for _, path := range paths {
+		req, err := http.NewRequest(http.MethodGet, path, nil)
+		if err != nil {
+			return n, err
+		}
+		req.Header.Set("Accept-Language", lang)
+		rec := newPageWriter()
+		h.ServeHTTP(rec, req)
+		if rec.status == http.StatusNotFound && strings.HasPrefix(path, "/history/") {
+			// the history doesn't go back that far
+			continue
+		}
+		if rec.status != http.StatusOK {
+			return n, fmt.Errorf("rendering %s: status %d", path, rec.status)
+		}
+		if err := write(strings.TrimLeft(path+"/index.html", "/"), staticPage(rec.body.Bytes())); err != nil {
+			return n, err
+		}
+		n++
+	}
+	if n == 0 {
+		return 0, errors.New("no pages rendered")
+	}
+	if s.cfg.KeyboardJS {
+		// the pages link to the keyboard navigation
+		if err := write("keys.js", keysJS); err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// staticPage rewrites the links of page to the item and user pages to their
+// HN counterparts
+func staticPage(page []byte) []byte {
+	return staticLinks.ReplaceAll(page, []byte(`href="https://`+hnHost+`/$1?id=$2"`))
+}
+
+// pageWriter is an http.ResponseWriter keeping a page in memory
+type pageWriter struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func newPageWriter() *pageWriter {
+	return &pageWriter{header: make(http.Header), status: http.StatusOK}
+}
+
+func (w *pageWriter) Header() http.Header { return w.header }
+
+func (w *pageWriter) Write(b []byte) (int, error) { return w.body.Write(b) }
+
+func (w *pageWriter) WriteHeader(status int) { w.status = status }
@@ -1,3 +1,3 @@
 module github.com/mmxmb/quiet_hn
 
-go 1.15
+go 1.16
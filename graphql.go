@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/mmxmb/quiet_hn/graphql"
+	"github.com/mmxmb/quiet_hn/hn"
+)
+
+const (
+	// maxGraphQLPage is the maximum number of elements of a list field
+	// returned by /graphql
+	maxGraphQLPage = 100
+	// maxGraphQLItems is the maximum number of items and users a query to
+	// /graphql may fetch, since nested lists would otherwise multiply
+	maxGraphQLItems = 1000
+)
+
+// graphQLBudgetKey is the context key of the *int number of items and users
+// the query may still fetch
+type graphQLBudgetKey struct{}
+
+// spend takes n items or users off the budget of the query of ctx, failing
+// if that's more than it has left
+func spend(ctx context.Context, n int) error {
+	left, ok := ctx.Value(graphQLBudgetKey{}).(*int)
+	if !ok {
+		return nil
+	}
+	if n > *left {
+		return fmt.Errorf("the query fetches more than %d items and users", maxGraphQLItems)
+	}
+	*left -= n
+	return nil
+}
+
+// graphQLSchema returns the schema of /graphql:
+//
+//	type Query {
+//	  stories(first: Int = 30, offset: Int = 0): [Item]  # the front page
+//	  item(id: Int!): Item
+//	  user(id: String!): User
+//	}
+//	type Item {
+//	  id: Int, type: String, rank: Int, title: String, url: String,
+//	  host: String, text: String, score: Int, time: Int, descendants: Int,
+//	  by: User, comments(first: Int = 30, offset: Int = 0): [Item]
+//	}
+//	type User {
+//	  id: String, karma: Int, about: String, created: Int,
+//	  submitted(first: Int = 30, offset: Int = 0): [Item]
+//	}
+//
+// Front page stories are filtered with the default preferences and come from
+// cache. Dead and deleted items are left out of lists and null otherwise. A
+// query may fetch up to maxGraphQLItems items and users, after which the
+// fields fetching more are null, with an error.
+func graphQLSchema(client StoryProvider, cache *Cache, cfg config) *graphql.Object {
+	user := &graphql.Object{Name: "User"}
+	itemType := &graphql.Object{Name: "Item"}
+	getItems := func(ctx context.Context, ids []int, args graphql.Args) (interface{}, error) {
+		ids, err := page(ids, args)
+		if err != nil {
+			return nil, err
+		}
+		if err := spend(ctx, len(ids)); err != nil {
+			return nil, err
+		}
+		hnItems, err := client.GetItems(ctx, ids, cfg.Concurrency)
+		if err != nil {
+			return nil, err
+		}
+		items := make([]item, 0, len(hnItems))
+		for _, hnItem := range hnItems {
+			if hnItem.Alive() {
+				items = append(items, parseHNItem(hnItem))
+			}
+		}
+		return items, nil
+	}
+
+	itemType.Fields = map[string]*graphql.Field{
+		"id": {}, "type": {}, "rank": {}, "title": {}, "url": {}, "host": {},
+		"text": {}, "score": {}, "time": {}, "descendants": {},
+		"by": {Type: user, Resolve: func(ctx context.Context, source interface{}, args graphql.Args) (interface{}, error) {
+			return getUser(ctx, client, source.(item).By)
+		}},
+		"comments": {Type: itemType, Resolve: func(ctx context.Context, source interface{}, args graphql.Args) (interface{}, error) {
+			return getItems(ctx, source.(item).Kids, args)
+		}},
+	}
+	user.Fields = map[string]*graphql.Field{
+		"id": {}, "karma": {}, "about": {}, "created": {},
+		"submitted": {Type: itemType, Resolve: func(ctx context.Context, source interface{}, args graphql.Args) (interface{}, error) {
+			return getItems(ctx, source.(*hn.User).Submitted, args)
+		}},
+	}
+	return &graphql.Object{Name: "Query", Fields: map[string]*graphql.Field{
+		"stories": {Type: itemType, Resolve: func(ctx context.Context, source interface{}, args graphql.Args) (interface{}, error) {
+			first, offset, err := pageArgs(args)
+			if err != nil {
+				return nil, err
+			}
+			stories, err := cachedTopStories(ctx, client, cache, cfg, newFilter(cfg, cfg.Defaults))
+			if err != nil {
+				return nil, err
+			}
+			if offset > len(stories) {
+				offset = len(stories)
+			}
+			if offset+first < len(stories) {
+				stories = stories[:offset+first]
+			}
+			return stories[offset:], nil
+		}},
+		"item": {Type: itemType, Resolve: func(ctx context.Context, source interface{}, args graphql.Args) (interface{}, error) {
+			id, err := args.Int("id", 0)
+			if err != nil {
+				return nil, err
+			}
+			if err := spend(ctx, 1); err != nil {
+				return nil, err
+			}
+			hnItem, err := client.GetItem(id)
+			if err != nil || !hnItem.Alive() {
+				return nil, err
+			}
+			return parseHNItem(hnItem), nil
+		}},
+		"user": {Type: user, Resolve: func(ctx context.Context, source interface{}, args graphql.Args) (interface{}, error) {
+			id, err := args.String("id", "")
+			if err != nil {
+				return nil, err
+			}
+			return getUser(ctx, client, id)
+		}},
+	}}
+}
+
+// getUser returns the user with the given name, or a nil *hn.User if there
+// is none
+func getUser(ctx context.Context, client StoryProvider, username string) (*hn.User, error) {
+	if username == "" {
+		return nil, nil
+	}
+	if err := spend(ctx, 1); err != nil {
+		return nil, err
+	}
+	user, err := client.GetUser(username)
+	if err != nil || user.ID == "" {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// pageArgs returns the first and offset arguments of a list field
+func pageArgs(args graphql.Args) (first, offset int, err error) {
+	if first, err = args.Int("first", 30); err != nil {
+		return 0, 0, err
+	}
+	if offset, err = args.Int("offset", 0); err != nil {
+		return 0, 0, err
+	}
+	if first < 0 || first > maxGraphQLPage || offset < 0 {
+		return 0, 0, fmt.Errorf("first must be between 0 and %d and offset positive", maxGraphQLPage)
+	}
+	return first, offset, nil
+}
+
+// page returns the page of ids selected by the first and offset arguments
+func page(ids []int, args graphql.Args) ([]int, error) {
+	first, offset, err := pageArgs(args)
+	if err != nil {
+		return nil, err
+	}
+	if offset > len(ids) {
+		offset = len(ids)
+	}
+	if offset+first < len(ids) {
+		ids = ids[:offset+first]
+	}
+	return ids[offset:], nil
+}
+
+// graphQLRequest is the body of POST requests to /graphql
+type graphQLRequest struct {
+	Query     string                 `json:"query"`
+	Variables map[string]interface{} `json:"variables"`
+}
+
+// graphQLHandler serves /graphql, taking queries as JSON in the body of POST
+// requests, or in the query and variables parameters of GET requests.
+func graphQLHandler(schema *graphql.Object) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req graphQLRequest
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+			req.Query = r.URL.Query().Get("query")
+			if v := r.URL.Query().Get("variables"); v != "" {
+				if err := json.Unmarshal([]byte(v), &req.Variables); err != nil {
+					http.Error(w, "Invalid variables", http.StatusBadRequest)
+					return
+				}
+			}
+		case http.MethodPost:
+			if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&req); err != nil {
+				http.Error(w, "Invalid request body", http.StatusBadRequest)
+				return
+			}
+		default:
+			w.Header().Set("Allow", "GET, HEAD, POST")
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if req.Query == "" {
+			http.Error(w, "Missing query", http.StatusBadRequest)
+			return
+		}
+
+		budget := maxGraphQLItems
+		ctx := context.WithValue(r.Context(), graphQLBudgetKey{}, &budget)
+		writeJSON(w, graphql.Execute(ctx, schema, req.Query, req.Variables))
+	})
+}
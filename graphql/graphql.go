@@ -0,0 +1,240 @@
+// Package graphql implements a small subset of GraphQL, enough to serve
+// read-only APIs with field selection in a single round trip.
+//
+// Schemas are made of Objects whose Fields are resolved by functions.
+// Queries support aliases, arguments, variables and nested selections, and
+// the __typename meta field. Mutations, subscriptions, fragments, directives
+// and introspection are not supported.
+//
+//	query Front($n: Int = 10) {
+//	  stories(first: $n) {
+//	    id
+//	    title
+//	    by { id karma }
+//	  }
+//	}
+package graphql
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+)
+
+// Object is an object type of a schema.
+type Object struct {
+	Name   string
+	Fields map[string]*Field
+}
+
+// Field is a field of an Object.
+type Field struct {
+	// Type is the type of the field value, or its elements for lists. It is
+	// nil for scalars, which are returned as is.
+	Type *Object
+	// Resolve returns the value of the field of source, the value of the
+	// parent object. If nil, the value is source itself, or the struct field
+	// with the same name if source is a struct. Lists are returned as slices.
+	Resolve func(ctx context.Context, source interface{}, args Args) (interface{}, error)
+}
+
+// Args are the arguments of a field, with variables substituted.
+type Args map[string]interface{}
+
+// Int returns the int argument name, or def if it isn't set.
+func (a Args) Int(name string, def int) (int, error) {
+	v, ok := a[name]
+	if !ok || v == nil {
+		return def, nil
+	}
+	switch n := v.(type) {
+	case int:
+		return n, nil
+	case float64:
+		// JSON variables are decoded as float64
+		if n == float64(int(n)) {
+			return int(n), nil
+		}
+	}
+	return 0, fmt.Errorf("argument %q must be an Int", name)
+}
+
+// String returns the string argument name, or def if it isn't set.
+func (a Args) String(name string, def string) (string, error) {
+	v, ok := a[name]
+	if !ok || v == nil {
+		return def, nil
+	}
+	s, ok := v.(string)
+	if !ok {
+		return "", fmt.Errorf("argument %q must be a String", name)
+	}
+	return s, nil
+}
+
+// Error is an error of a query.
+type Error struct {
+	Message string        `json:"message"`
+	Path    []interface{} `json:"path,omitempty"`
+}
+
+// Response is the result of a query, encoded as JSON as specified by
+// GraphQL.
+type Response struct {
+	Data   interface{} `json:"data"`
+	Errors []Error     `json:"errors,omitempty"`
+}
+
+// Execute runs query against the schema whose root query type is query.
+// Fields that fail to resolve are null in the response and reported as
+// errors, without failing the whole query.
+func Execute(ctx context.Context, root *Object, query string, variables map[string]interface{}) Response {
+	selection, defaults, err := parse(query)
+	if err != nil {
+		return Response{Errors: []Error{{Message: "syntax error: " + err.Error()}}}
+	}
+	e := &executor{variables: make(map[string]interface{})}
+	for name, v := range defaults {
+		e.variables[name] = v.literal
+	}
+	for name, v := range variables {
+		e.variables[name] = v
+	}
+	data := e.object(ctx, root, nil, selection, nil)
+	return Response{Data: data, Errors: e.errors}
+}
+
+type executor struct {
+	variables map[string]interface{}
+	errors    []Error
+}
+
+func (e *executor) fail(path []interface{}, err error) {
+	e.errors = append(e.errors, Error{Message: err.Error(), Path: append([]interface{}(nil), path...)})
+}
+
+func (e *executor) object(ctx context.Context, obj *Object, source interface{}, selection []field, path []interface{}) *orderedMap {
+	ret := &orderedMap{}
+	for _, f := range selection {
+		fieldPath := append(path, f.key())
+		if f.name == "__typename" {
+			ret.set(f.key(), obj.Name)
+			continue
+		}
+		def, ok := obj.Fields[f.name]
+		if !ok {
+			e.fail(fieldPath, fmt.Errorf("unknown field %q on %s", f.name, obj.Name))
+			continue
+		}
+		if def.Type != nil && f.selection == nil {
+			e.fail(fieldPath, fmt.Errorf("field %q of type %s must have a selection of subfields", f.name, def.Type.Name))
+			continue
+		}
+		if def.Type == nil && f.selection != nil {
+			e.fail(fieldPath, fmt.Errorf("field %q is a scalar and can't have subfields", f.name))
+			continue
+		}
+		args := make(Args, len(f.args))
+		for name, v := range f.args {
+			if v.variable != "" {
+				args[name] = e.variables[v.variable]
+			} else {
+				args[name] = v.literal
+			}
+		}
+		v, err := resolve(ctx, def, f.name, source, args)
+		if err != nil {
+			e.fail(fieldPath, err)
+			ret.set(f.key(), nil)
+			continue
+		}
+		ret.set(f.key(), e.complete(ctx, def.Type, v, f.selection, fieldPath))
+	}
+	return ret
+}
+
+// complete returns the result for the value v of a field
+func (e *executor) complete(ctx context.Context, typ *Object, v interface{}, selection []field, path []interface{}) interface{} {
+	rv := reflect.ValueOf(v)
+	if v == nil || (rv.Kind() == reflect.Ptr || rv.Kind() == reflect.Slice) && rv.IsNil() {
+		return nil
+	}
+	if typ == nil {
+		return v
+	}
+	if rv.Kind() == reflect.Slice {
+		ret := make([]interface{}, rv.Len())
+		for i := range ret {
+			ret[i] = e.complete(ctx, typ, rv.Index(i).Interface(), selection, append(path, i))
+		}
+		return ret
+	}
+	return e.object(ctx, typ, v, selection, path)
+}
+
+func resolve(ctx context.Context, def *Field, name string, source interface{}, args Args) (interface{}, error) {
+	if def.Resolve != nil {
+		return def.Resolve(ctx, source, args)
+	}
+	rv := reflect.Indirect(reflect.ValueOf(source))
+	if rv.Kind() == reflect.Struct {
+		if fv := rv.FieldByNameFunc(func(field string) bool { return equalFold(field, name) }); fv.IsValid() {
+			return fv.Interface(), nil
+		}
+	}
+	return nil, fmt.Errorf("no resolver for field %q", name)
+}
+
+// equalFold reports whether the ASCII strings a and b are equal regardless
+// of case, so that field "id" resolves to the struct field ID
+func equalFold(a, b string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := 0; i < len(a); i++ {
+		x, y := a[i]|0x20, b[i]|0x20
+		if x != y {
+			return false
+		}
+	}
+	return true
+}
+
+// orderedMap is an object of the response, whose fields are encoded in the
+// order they were selected in
+type orderedMap struct {
+	keys   []string
+	values map[string]interface{}
+}
+
+func (m *orderedMap) set(key string, v interface{}) {
+	if m.values == nil {
+		m.values = make(map[string]interface{})
+	}
+	if _, ok := m.values[key]; !ok {
+		m.keys = append(m.keys, key)
+	}
+	m.values[key] = v
+}
+
+func (m *orderedMap) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, key := range m.keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		k, _ := json.Marshal(key)
+		buf.Write(k)
+		buf.WriteByte(':')
+		v, err := json.Marshal(m.values[key])
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(v)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,130 @@
+package graphql
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+type testUser struct {
+	ID    string
+	Karma int
+}
+
+type testStory struct {
+	ID    int
+	Title string
+	By    string
+}
+
+func testSchema() *Object {
+	users := map[string]testUser{"pg": {ID: "pg", Karma: 155111}}
+	stories := []testStory{{1, "Y Combinator", "pg"}, {2, "A Student's Guide to Startups", "pg"}, {3, "Woz Interview", "jl"}}
+
+	user := &Object{Name: "User", Fields: map[string]*Field{
+		"id":    {},
+		"karma": {},
+	}}
+	story := &Object{Name: "Story", Fields: map[string]*Field{
+		"id":    {},
+		"title": {},
+		"by": {Type: user, Resolve: func(ctx context.Context, source interface{}, args Args) (interface{}, error) {
+			u, ok := users[source.(testStory).By]
+			if !ok {
+				return nil, fmt.Errorf("user %q not found", source.(testStory).By)
+			}
+			return u, nil
+		}},
+	}}
+	return &Object{Name: "Query", Fields: map[string]*Field{
+		"stories": {Type: story, Resolve: func(ctx context.Context, source interface{}, args Args) (interface{}, error) {
+			first, err := args.Int("first", len(stories))
+			if err != nil {
+				return nil, err
+			}
+			if first > len(stories) {
+				first = len(stories)
+			}
+			return stories[:first], nil
+		}},
+		"user": {Type: user, Resolve: func(ctx context.Context, source interface{}, args Args) (interface{}, error) {
+			id, err := args.String("id", "")
+			if err != nil {
+				return nil, err
+			}
+			if u, ok := users[id]; ok {
+				return &u, nil
+			}
+			return (*testUser)(nil), nil
+		}},
+	}}
+}
+
+func TestExecute(t *testing.T) {
+	tests := []struct {
+		name      string
+		query     string
+		variables map[string]interface{}
+		want      string
+	}{
+		{
+			"selection",
+			`{ stories(first: 2) { id title } }`,
+			nil,
+			`{"data":{"stories":[{"id":1,"title":"Y Combinator"},{"id":2,"title":"A Student's Guide to Startups"}]}}`,
+		},
+		{
+			"nested objects and aliases",
+			`query { pg: user(id: "pg") { karma __typename } nobody: user(id: "nobody") { karma } }`,
+			nil,
+			`{"data":{"pg":{"karma":155111,"__typename":"User"},"nobody":null}}`,
+		},
+		{
+			"variables and defaults",
+			`query Front($n: Int = 3, $unused: [String!]) { stories(first: $n) { id } }`,
+			map[string]interface{}{"n": float64(1)},
+			`{"data":{"stories":[{"id":1}]}}`,
+		},
+		{
+			"resolver errors",
+			`{ stories { id by { id } } }`,
+			nil,
+			`{"data":{"stories":[{"id":1,"by":{"id":"pg"}},{"id":2,"by":{"id":"pg"}},{"id":3,"by":null}]},"errors":[{"message":"user \"jl\" not found","path":["stories",2,"by"]}]}`,
+		},
+		{
+			"unknown fields",
+			`{ stories(first: 1) { id score } }`,
+			nil,
+			`{"data":{"stories":[{"id":1}]},"errors":[{"message":"unknown field \"score\" on Story","path":["stories",0,"score"]}]}`,
+		},
+		{
+			"missing selection",
+			`{ user(id: "pg") }`,
+			nil,
+			`{"data":{},"errors":[{"message":"field \"user\" of type User must have a selection of subfields","path":["user"]}]}`,
+		},
+		{
+			"syntax errors",
+			`{ stories { id }`,
+			nil,
+			`{"data":null,"errors":[{"message":"syntax error: unexpected end of query, want a field name"}]}`,
+		},
+		{
+			"mutations",
+			`mutation { vote(id: 1) }`,
+			nil,
+			`{"data":null,"errors":[{"message":"syntax error: only queries are supported, got \"mutation\""}]}`,
+		},
+	}
+	for _, tc := range tests {
+		resp := Execute(context.Background(), testSchema(), tc.query, tc.variables)
+		got, err := json.Marshal(resp)
+		if err != nil {
+			t.Fatalf("%s: json.Marshal() received an error: %s", tc.name, err.Error())
+		}
+		if string(got) != tc.want {
+			t.Errorf("%s:\nwant %s\ngot  %s", tc.name, tc.want, got)
+		}
+	}
+}
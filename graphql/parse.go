@@ -0,0 +1,336 @@
+package graphql
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// field is a field of a selection set in a query
+type field struct {
+	alias     string
+	name      string
+	args      map[string]value
+	selection []field
+}
+
+// key returns the name of the field in the result
+func (f field) key() string {
+	if f.alias != "" {
+		return f.alias
+	}
+	return f.name
+}
+
+// value is an argument value: a literal (int, float64, string, bool, nil or
+// an enum name as a string) or a variable reference
+type value struct {
+	literal  interface{}
+	variable string
+}
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokPunct
+	tokName
+	tokInt
+	tokFloat
+	tokString
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+// lex splits a query into tokens. Commas are insignificant in GraphQL and
+// dropped like whitespace and comments.
+func lex(src string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == ',':
+			i++
+		case c == '#':
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+		case strings.IndexByte("{}()[]:$!=@", c) >= 0:
+			tokens = append(tokens, token{tokPunct, string(c), i})
+			i++
+		case c == '.' && strings.HasPrefix(src[i:], "..."):
+			return nil, fmt.Errorf("fragments are not supported (at %d)", i)
+		case c == '_' || isLetter(c):
+			start := i
+			for i < len(src) && (src[i] == '_' || isLetter(src[i]) || isDigit(src[i])) {
+				i++
+			}
+			tokens = append(tokens, token{tokName, src[start:i], start})
+		case c == '-' || isDigit(c):
+			start := i
+			i++
+			kind := tokInt
+			for i < len(src) && (isDigit(src[i]) || strings.IndexByte(".eE+-", src[i]) >= 0) {
+				if !isDigit(src[i]) {
+					kind = tokFloat
+				}
+				i++
+			}
+			tokens = append(tokens, token{kind, src[start:i], start})
+		case c == '"':
+			start := i
+			i++
+			for i < len(src) && src[i] != '"' {
+				if src[i] == '\\' {
+					i++
+				}
+				i++
+			}
+			if i >= len(src) {
+				return nil, fmt.Errorf("unterminated string at %d", start)
+			}
+			i++
+			s, err := strconv.Unquote(src[start:i])
+			if err != nil {
+				return nil, fmt.Errorf("invalid string at %d", start)
+			}
+			tokens = append(tokens, token{tokString, s, start})
+		default:
+			return nil, fmt.Errorf("unexpected character %q at %d", c, i)
+		}
+	}
+	return append(tokens, token{kind: tokEOF, pos: len(src)}), nil
+}
+
+func isLetter(c byte) bool { return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' }
+func isDigit(c byte) bool  { return c >= '0' && c <= '9' }
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() token { return p.tokens[p.pos] }
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) expect(punct string) error {
+	if t := p.next(); t.kind != tokPunct || t.text != punct {
+		return p.unexpected(t, fmt.Sprintf("%q", punct))
+	}
+	return nil
+}
+
+func (p *parser) unexpected(t token, want string) error {
+	if t.kind == tokEOF {
+		return fmt.Errorf("unexpected end of query, want %s", want)
+	}
+	return fmt.Errorf("unexpected %q at %d, want %s", t.text, t.pos, want)
+}
+
+func (p *parser) isPunct(punct string) bool {
+	t := p.peek()
+	return t.kind == tokPunct && t.text == punct
+}
+
+// parse parses a document made of a single query operation, either the
+// shorthand "{ ... }" or "query Name($var: Type = default) { ... }". It
+// returns the selection set of the query and the default values of its
+// variables.
+func parse(src string) ([]field, map[string]value, error) {
+	tokens, err := lex(src)
+	if err != nil {
+		return nil, nil, err
+	}
+	p := &parser{tokens: tokens}
+	defaults := make(map[string]value)
+	if t := p.peek(); t.kind == tokName {
+		if t.text != "query" {
+			return nil, nil, fmt.Errorf("only queries are supported, got %q", t.text)
+		}
+		p.next()
+		if p.peek().kind == tokName {
+			p.next()
+		}
+		if p.isPunct("(") {
+			if err := p.variableDefinitions(defaults); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+	selection, err := p.selectionSet()
+	if err != nil {
+		return nil, nil, err
+	}
+	if t := p.next(); t.kind != tokEOF {
+		return nil, nil, fmt.Errorf("unexpected %q at %d, only one operation is supported", t.text, t.pos)
+	}
+	return selection, defaults, nil
+}
+
+// variableDefinitions parses "($name: Type = default, ...)". Types are only
+// checked by the resolvers, when the values are used.
+func (p *parser) variableDefinitions(defaults map[string]value) error {
+	p.next()
+	for !p.isPunct(")") {
+		if err := p.expect("$"); err != nil {
+			return err
+		}
+		name := p.next()
+		if name.kind != tokName {
+			return p.unexpected(name, "a variable name")
+		}
+		if err := p.expect(":"); err != nil {
+			return err
+		}
+		if err := p.typeRef(); err != nil {
+			return err
+		}
+		if p.isPunct("=") {
+			p.next()
+			v, err := p.value()
+			if err != nil {
+				return err
+			}
+			defaults[name.text] = v
+		}
+	}
+	p.next()
+	return nil
+}
+
+// typeRef parses a type: Type, [Type] or either followed by !
+func (p *parser) typeRef() error {
+	t := p.next()
+	switch {
+	case t.kind == tokPunct && t.text == "[":
+		if err := p.typeRef(); err != nil {
+			return err
+		}
+		if err := p.expect("]"); err != nil {
+			return err
+		}
+	case t.kind == tokName:
+	default:
+		return p.unexpected(t, "a type")
+	}
+	if p.isPunct("!") {
+		p.next()
+	}
+	return nil
+}
+
+func (p *parser) selectionSet() ([]field, error) {
+	if err := p.expect("{"); err != nil {
+		return nil, err
+	}
+	var fields []field
+	for !p.isPunct("}") {
+		f, err := p.field()
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, f)
+	}
+	p.next()
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("empty selection set")
+	}
+	return fields, nil
+}
+
+func (p *parser) field() (field, error) {
+	var f field
+	name := p.next()
+	if name.kind != tokName {
+		return f, p.unexpected(name, "a field name")
+	}
+	f.name = name.text
+	if p.isPunct(":") {
+		p.next()
+		name = p.next()
+		if name.kind != tokName {
+			return f, p.unexpected(name, "a field name")
+		}
+		f.alias, f.name = f.name, name.text
+	}
+	if p.isPunct("(") {
+		p.next()
+		f.args = make(map[string]value)
+		for !p.isPunct(")") {
+			arg := p.next()
+			if arg.kind != tokName {
+				return f, p.unexpected(arg, "an argument name")
+			}
+			if err := p.expect(":"); err != nil {
+				return f, err
+			}
+			v, err := p.value()
+			if err != nil {
+				return f, err
+			}
+			f.args[arg.text] = v
+		}
+		p.next()
+	}
+	if p.isPunct("@") {
+		return f, fmt.Errorf("directives are not supported (at %d)", p.peek().pos)
+	}
+	if p.isPunct("{") {
+		selection, err := p.selectionSet()
+		if err != nil {
+			return f, err
+		}
+		f.selection = selection
+	}
+	return f, nil
+}
+
+func (p *parser) value() (value, error) {
+	t := p.next()
+	switch t.kind {
+	case tokPunct:
+		if t.text == "$" {
+			name := p.next()
+			if name.kind != tokName {
+				return value{}, p.unexpected(name, "a variable name")
+			}
+			return value{variable: name.text}, nil
+		}
+	case tokInt:
+		n, err := strconv.Atoi(t.text)
+		if err != nil {
+			return value{}, fmt.Errorf("invalid int %q at %d", t.text, t.pos)
+		}
+		return value{literal: n}, nil
+	case tokFloat:
+		f, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return value{}, fmt.Errorf("invalid float %q at %d", t.text, t.pos)
+		}
+		return value{literal: f}, nil
+	case tokString:
+		return value{literal: t.text}, nil
+	case tokName:
+		switch t.text {
+		case "true":
+			return value{literal: true}, nil
+		case "false":
+			return value{literal: false}, nil
+		case "null":
+			return value{}, nil
+		}
+		return value{literal: t.text}, nil
+	}
+	return value{}, p.unexpected(t, "a value")
+}
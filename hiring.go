@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mmxmb/quiet_hn/hiring"
+	"github.com/mmxmb/quiet_hn/hn"
+)
+
+// hiringUser is the account posting the monthly "Who is hiring?" threads
+const hiringUser = "whoishiring"
+
+// hiringConfig configures the /hiring page
+type hiringConfig struct {
+	Enabled bool
+	// CacheDuration is how long the parsed thread is kept before looking for a
+	// newer one and refetching its comments
+	CacheDuration time.Duration
+}
+
+// jobPosting is a parsed top-level comment of a "Who is hiring?" thread
+type jobPosting struct {
+	hiring.Job
+	ID   int
+	By   string
+	Time int
+}
+
+// hiringBoard finds the latest "Who is hiring?" thread and parses its job
+// postings. It is safe for concurrent use.
+type hiringBoard struct {
+	client      StoryProvider
+	cfg         hiringConfig
+	concurrency int
+
+	mu         sync.Mutex
+	thread     hn.Item
+	jobs       []jobPosting
+	expiration time.Time
+}
+
+var errNoHiringThread = errors.New("no \"Who is hiring?\" thread found")
+
+func newHiringBoard(client StoryProvider, cfg config) *hiringBoard {
+	return &hiringBoard{client: client, cfg: cfg.Hiring, concurrency: cfg.Concurrency}
+}
+
+// Get returns the latest thread and its job postings, in the order they are
+// displayed on HN.
+func (b *hiringBoard) Get(ctx context.Context) (hn.Item, []jobPosting, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if time.Now().Before(b.expiration) {
+		return b.thread, b.jobs, nil
+	}
+
+	thread, err := b.findThread(ctx)
+	if err != nil {
+		return hn.Item{}, nil, err
+	}
+	comments, err := b.client.GetItems(ctx, thread.Kids, b.concurrency)
+	if err != nil && ctx.Err() != nil {
+		return hn.Item{}, nil, err
+	}
+	// comments that failed to load are skipped, like dead ones
+	jobs := make([]jobPosting, 0, len(comments))
+	for _, c := range comments {
+		if !c.Alive() || c.Text == "" {
+			continue
+		}
+		jobs = append(jobs, jobPosting{Job: hiring.Parse(c.Text), ID: c.ID, By: c.By, Time: c.Time})
+	}
+	b.thread, b.jobs = thread, jobs
+	b.expiration = time.Now().Add(b.cfg.CacheDuration)
+	return thread, jobs, nil
+}
+
+// findThread returns the latest "Who is hiring?" thread among the recent
+// submissions of hiringUser, which also posts the "Who wants to be hired?"
+// and freelancer threads.
+func (b *hiringBoard) findThread(ctx context.Context) (hn.Item, error) {
+	user, err := b.client.GetUser(hiringUser)
+	if err != nil {
+		return hn.Item{}, err
+	}
+	ids := user.Submitted
+	if len(ids) > 10 {
+		ids = ids[:10]
+	}
+	items, err := b.client.GetItems(ctx, ids, b.concurrency)
+	if err != nil && ctx.Err() != nil {
+		return hn.Item{}, err
+	}
+	for _, itm := range items {
+		if itm.Alive() && hiring.IsThread(itm.Title) {
+			return itm, nil
+		}
+	}
+	return hn.Item{}, errNoHiringThread
+}
+
+// jobFilter narrows down the job postings on the /hiring page
+type jobFilter struct {
+	// Query is matched against the whole text of postings
+	Query    string
+	Location string
+	Remote   bool
+}
+
+// matches reports whether job matches f. Text matches are case insensitive.
+func (f jobFilter) matches(job jobPosting) bool {
+	if f.Remote && !job.Remote {
+		return false
+	}
+	if f.Location != "" && !strings.Contains(strings.ToLower(job.Location), strings.ToLower(f.Location)) {
+		return false
+	}
+	if f.Query != "" && !strings.Contains(strings.ToLower(job.Text), strings.ToLower(f.Query)) {
+		return false
+	}
+	return true
+}
+
+func hiringHandler(cfg config, board *hiringBoard, tpls *templateSet) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		thread, jobs, err := board.Get(r.Context())
+		if err != nil {
+			tpls.renderError(w, r, http.StatusBadGateway, "error.load_hiring")
+			return
+		}
+		prefs, err := loadPreferences(w, r, cfg.Defaults)
+		if err != nil {
+			tpls.renderQueryError(w, r, err)
+			return
+		}
+		q := r.URL.Query()
+		f := jobFilter{
+			Query:    strings.TrimSpace(q.Get("q")),
+			Location: strings.TrimSpace(q.Get("location")),
+			Remote:   q.Get("remote") != "",
+		}
+		var matching []jobPosting
+		for _, job := range jobs {
+			if f.matches(job) {
+				matching = append(matching, job)
+			}
+		}
+
+		data := hiringTemplateData{
+			Thread: thread,
+			Jobs:   matching,
+			Total:  len(jobs),
+			Filter: f,
+			Lang:   languagePref(w, r, cfg.Messages),
+			TZ:     prefs.Timezone,
+			Time:   time.Now().Sub(start),
+		}
+		err = tpls.render(w, "hiring", data)
+		if err != nil {
+			http.Error(w, "Failed to process the template", http.StatusInternalServerError)
+			return
+		}
+	})
+}
+
+type hiringTemplateData struct {
+	Thread hn.Item
+	Jobs   []jobPosting
+	// Total is the number of postings before filtering
+	Total  int
+	Filter jobFilter
+	Lang   string
+	TZ     string
+	Time   time.Duration
+}
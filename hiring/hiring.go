@@ -0,0 +1,78 @@
+// Package hiring parses the job postings of the monthly "Ask HN: Who is
+// hiring?" threads.
+//
+// By convention each top-level comment of a thread is a job posting whose
+// first line lists the company, location and other details separated by
+// pipes:
+//
+//	Acme Corp | Berlin, Germany | REMOTE (EU) | Full-time | https://acme.example
+//
+// The convention is loosely followed, so parsing is best effort: the company
+// is the first field, the location the first field that doesn't look like
+// something else (a URL, a role, a salary, ...) and a posting is remote if
+// any field mentions it.
+package hiring
+
+import (
+	"html"
+	"regexp"
+	"strings"
+)
+
+// Job is a job posting.
+type Job struct {
+	Company  string
+	Location string
+	Remote   bool
+	// Text is the plain text of the whole posting
+	Text string
+}
+
+var (
+	tags      = regexp.MustCompile(`<[^>]*>`)
+	spaces    = regexp.MustCompile(`\s+`)
+	paragraph = regexp.MustCompile(`(?i)<p\s*/?>`)
+
+	remote    = regexp.MustCompile(`(?i)\bremote\b`)
+	notRemote = regexp.MustCompile(`(?i)\b(no|not)\s+remote\b`)
+	// fields matching this aren't locations
+	notLocation = regexp.MustCompile(`(?i)^(https?://|www\.)|[$€£]|\d+k\b|\b(engineers?|developers?|designers?|scientists?|managers?|full[- ]?time|part[- ]?time|contract(or)?|interns?(hip)?|visa|equity|salary|senior|junior|staff|lead|frontend|backend|full[- ]?stack|devops|sre)\b`)
+)
+
+// IsThread reports whether title is the title of a "Who is hiring?" thread,
+// eg "Ask HN: Who is hiring? (April 2018)".
+func IsThread(title string) bool {
+	return strings.HasPrefix(strings.ToLower(title), "ask hn: who is hiring?")
+}
+
+// Parse parses the HTML text of a top-level comment of a thread.
+func Parse(text string) Job {
+	paragraphs := paragraph.Split(text, -1)
+	job := Job{Text: plain(strings.Join(paragraphs, " "))}
+
+	fields := strings.Split(plain(paragraphs[0]), "|")
+	for i := range fields {
+		fields[i] = strings.TrimSpace(fields[i])
+	}
+	job.Company = fields[0]
+	for _, field := range fields[1:] {
+		if remote.MatchString(field) && !notRemote.MatchString(field) {
+			job.Remote = true
+			// eg "Remote (US)", which is the only location of the job
+			if strings.TrimSpace(remote.ReplaceAllString(field, "")) == "" {
+				continue
+			}
+		}
+		if job.Location == "" && field != "" && !notLocation.MatchString(field) {
+			job.Location = field
+		}
+	}
+	return job
+}
+
+// plain returns the text of the HTML fragment s
+func plain(s string) string {
+	s = tags.ReplaceAllString(s, " ")
+	s = html.UnescapeString(s)
+	return strings.TrimSpace(spaces.ReplaceAllString(s, " "))
+}
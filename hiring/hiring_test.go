@@ -0,0 +1,47 @@
+package hiring
+
+import "testing"
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		text string
+		want Job
+	}{
+		{
+			"Acme Corp | Berlin, Germany | REMOTE (EU) | Full-time | <a href=\"https:&#x2F;&#x2F;acme.example\">https:&#x2F;&#x2F;acme.example</a><p>We make anvils.",
+			Job{Company: "Acme Corp", Location: "Berlin, Germany", Remote: true, Text: "Acme Corp | Berlin, Germany | REMOTE (EU) | Full-time | https://acme.example We make anvils."},
+		},
+		{
+			"Initech | Senior Backend Engineer | Austin, TX | Onsite | $150k<p>TPS reports &amp; more.",
+			Job{Company: "Initech", Location: "Austin, TX", Text: "Initech | Senior Backend Engineer | Austin, TX | Onsite | $150k TPS reports & more."},
+		},
+		{
+			"Globex | Remote | Full-time",
+			Job{Company: "Globex", Remote: true, Text: "Globex | Remote | Full-time"},
+		},
+		{
+			"Hooli | Palo Alto | No remote",
+			Job{Company: "Hooli", Location: "Palo Alto", Text: "Hooli | Palo Alto | No remote"},
+		},
+		{
+			"We are hiring at Umbrella, email me.",
+			Job{Company: "We are hiring at Umbrella, email me.", Text: "We are hiring at Umbrella, email me."},
+		},
+	}
+	for _, tc := range tests {
+		if got := Parse(tc.text); got != tc.want {
+			t.Errorf("Parse(%q):\nwant %+v\ngot  %+v", tc.text, tc.want, got)
+		}
+	}
+}
+
+func TestIsThread(t *testing.T) {
+	if !IsThread("Ask HN: Who is hiring? (April 2018)") {
+		t.Errorf("IsThread() of a hiring thread: want true, got false")
+	}
+	for _, title := range []string{"Ask HN: Who wants to be hired? (April 2018)", "Ask HN: Freelancer? Seeking freelancer? (April 2018)"} {
+		if IsThread(title) {
+			t.Errorf("IsThread(%q): want false, got true", title)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package history
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// Backend persists the snapshots of a Store. The Store serializes its
+// calls.
+type Backend interface {
+	// Load calls fn with the snapshots taken after the given time, oldest
+	// first
+	Load(after time.Time, fn func(Snapshot) error) error
+	// Append persists snap, the most recent snapshot
+	Append(snap Snapshot) error
+	// Compact removes the snapshots other than keep, the most recent ones
+	// left after a Prune
+	Compact(keep []Snapshot) error
+	Close() error
+}
+
+// fileBackend keeps the snapshots in a file, one JSON encoded snapshot per
+// line
+type fileBackend struct {
+	path string
+	file *os.File
+}
+
+// OpenFile returns the Backend of the file at path, creating it if it
+// doesn't exist
+func OpenFile(path string) (Backend, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &fileBackend{path: path, file: f}, nil
+}
+
+func (b *fileBackend) Load(after time.Time, fn func(Snapshot) error) error {
+	// the file may have been replaced by another process compacting it
+	f, err := os.Open(b.path)
+	if err != nil {
+		return fmt.Errorf("history: %w", err)
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 16<<20)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var snap Snapshot
+		if err := json.Unmarshal(scanner.Bytes(), &snap); err != nil {
+			return fmt.Errorf("history: %s:%d: %w", b.path, line, err)
+		}
+		if !snap.Time.After(after) {
+			continue
+		}
+		if err := fn(snap); err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("history: reading %s: %w", b.path, err)
+	}
+	return nil
+}
+
+func (b *fileBackend) Append(snap Snapshot) error {
+	if b.file == nil {
+		return fmt.Errorf("history: %s is closed", b.path)
+	}
+	data, err := json.Marshal(snap)
+	if err != nil {
+		return err
+	}
+	if _, err := b.file.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("history: %w", err)
+	}
+	return nil
+}
+
+// Compact rewrites the file with only the snapshots kept
+func (b *fileBackend) Compact(keep []Snapshot) error {
+	if b.file == nil {
+		return fmt.Errorf("history: %s is closed", b.path)
+	}
+	tmp := b.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return fmt.Errorf("history: %w", err)
+	}
+	bw := bufio.NewWriter(f)
+	enc := json.NewEncoder(bw)
+	for _, snap := range keep {
+		if err = enc.Encode(snap); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = bw.Flush()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if err == nil {
+		err = os.Rename(tmp, b.path)
+	}
+	if err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return fmt.Errorf("history: compacting %s: %w", b.path, err)
+	}
+	// the renamed file is kept open for the snapshots recorded from now
+	b.file.Close()
+	if _, err := f.Seek(0, io.SeekEnd); err != nil {
+		f.Close()
+		b.file = nil
+		return fmt.Errorf("history: %w", err)
+	}
+	b.file = f
+	return nil
+}
+
+func (b *fileBackend) Close() error {
+	if b.file == nil {
+		return nil
+	}
+	err := b.file.Close()
+	b.file = nil
+	return err
+}
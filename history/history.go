@@ -0,0 +1,355 @@
+// Package history records snapshots of the front page over time.
+//
+// A Store keeps the snapshots in memory and, optionally, persists them to a
+// Backend so the history survives restarts, such as a file with one JSON
+// encoded snapshot per line:
+//
+//	{"time":"2018-04-01T16:11:23Z","stories":[{"id":16731721,"rank":1,"title":"...","score":512,"comments":143}]}
+//
+// The history gives answers the HN API can't, such as when a story first made
+// it to the front page or how its score evolved.
+package history
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Story is a story as seen on the front page at the time of a snapshot
+type Story struct {
+	ID       int    `json:"id"`
+	Rank     int    `json:"rank"`
+	Title    string `json:"title"`
+	URL      string `json:"url,omitempty"`
+	Score    int    `json:"score"`
+	Comments int    `json:"comments"`
+}
+
+// Snapshot is the front page at a point in time
+type Snapshot struct {
+	Time    time.Time `json:"time"`
+	Stories []Story   `json:"stories"`
+}
+
+// Store is a history of snapshots. It is safe for concurrent use.
+type Store struct {
+	mu        sync.RWMutex
+	backend   Backend
+	pruned    int
+	snapshots []Snapshot
+	firstSeen map[int]time.Time
+}
+
+// NewStore returns an empty Store that is only kept in memory.
+func NewStore() *Store {
+	return &Store{firstSeen: make(map[int]time.Time)}
+}
+
+// Open returns a Store with the snapshots in the file at path, creating it if
+// it doesn't exist. Snapshots recorded to the Store are appended to the file.
+func Open(path string) (*Store, error) {
+	b, err := OpenFile(path)
+	if err != nil {
+		return nil, err
+	}
+	s, err := OpenBackend(b)
+	if err != nil {
+		b.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// OpenBackend returns a Store with the snapshots of b, which the snapshots
+// recorded to the Store are persisted to.
+func OpenBackend(b Backend) (*Store, error) {
+	s := NewStore()
+	if err := b.Load(time.Time{}, func(snap Snapshot) error {
+		s.add(snap)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	s.backend = b
+	return s, nil
+}
+
+// Sync adds the snapshots persisted to the backend of the Store by others,
+// eg by the replica recording the front page to a shared database, and
+// returns how many there were.
+func (s *Store) Sync() (int, error) {
+	s.mu.RLock()
+	b := s.backend
+	var last time.Time
+	if len(s.snapshots) > 0 {
+		last = s.snapshots[len(s.snapshots)-1].Time
+	}
+	s.mu.RUnlock()
+	if b == nil {
+		return 0, nil
+	}
+	var snaps []Snapshot
+	if err := b.Load(last, func(snap Snapshot) error {
+		snaps = append(snaps, snap)
+		return nil
+	}); err != nil {
+		return 0, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for _, snap := range snaps {
+		// the Store may have recorded some in the meantime
+		if len(s.snapshots) > 0 && !snap.Time.After(s.snapshots[len(s.snapshots)-1].Time) {
+			continue
+		}
+		s.add(snap)
+		n++
+	}
+	return n, nil
+}
+
+// Close closes the backend of the Store, if any.
+func (s *Store) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.backend == nil {
+		return nil
+	}
+	err := s.backend.Close()
+	s.backend = nil
+	return err
+}
+
+// Record adds snap to the history. Snapshots must be recorded in
+// chronological order.
+func (s *Store) Record(snap Snapshot) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.backend != nil {
+		if err := s.backend.Append(snap); err != nil {
+			return err
+		}
+	}
+	s.add(snap)
+	return nil
+}
+
+func (s *Store) add(snap Snapshot) {
+	s.snapshots = append(s.snapshots, snap)
+	for _, story := range snap.Stories {
+		if _, ok := s.firstSeen[story.ID]; !ok {
+			s.firstSeen[story.ID] = snap.Time
+		}
+	}
+}
+
+// FirstSeen returns the time of the first snapshot the story with the given
+// id was in, and false if it was never seen.
+func (s *Store) FirstSeen(id int) (time.Time, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	t, ok := s.firstSeen[id]
+	return t, ok
+}
+
+// Start returns the time of the oldest snapshot, and false if the history is
+// empty.
+func (s *Store) Start() (time.Time, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if len(s.snapshots) == 0 {
+		return time.Time{}, false
+	}
+	return s.snapshots[0].Time, true
+}
+
+// At returns the last snapshot taken at or before t, and false if there is
+// none.
+func (s *Store) At(t time.Time) (Snapshot, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	i := sort.Search(len(s.snapshots), func(i int) bool {
+		return s.snapshots[i].Time.After(t)
+	})
+	if i == 0 {
+		return Snapshot{}, false
+	}
+	return s.snapshots[i-1], true
+}
+
+// StoryAt returns the story with the given id as it was in the last snapshot
+// taken at or before t it was in, and false if it wasn't in any.
+func (s *Store) StoryAt(id int, t time.Time) (Story, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	i := sort.Search(len(s.snapshots), func(i int) bool {
+		return s.snapshots[i].Time.After(t)
+	})
+	for i--; i >= 0; i-- {
+		for _, story := range s.snapshots[i].Stories {
+			if story.ID == id {
+				return story, true
+			}
+		}
+		if s.snapshots[i].Time.Before(s.firstSeen[id]) {
+			break
+		}
+	}
+	return Story{}, false
+}
+
+// Snapshots returns the snapshots taken in [from, to), oldest first.
+func (s *Store) Snapshots(from, to time.Time) []Snapshot {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	start := sort.Search(len(s.snapshots), func(i int) bool {
+		return !s.snapshots[i].Time.Before(from)
+	})
+	end := sort.Search(len(s.snapshots), func(i int) bool {
+		return !s.snapshots[i].Time.Before(to)
+	})
+	if start >= end {
+		return nil
+	}
+	ret := make([]Snapshot, end-start)
+	copy(ret, s.snapshots[start:end])
+	return ret
+}
+
+// Peak is the best a story did over a period of time: the story as last seen
+// in the period, with its highest score and rank.
+type Peak struct {
+	Story
+	PeakScore int
+	BestRank  int
+}
+
+// Peaks returns the stories seen in the snapshots taken in [from, to), by
+// descending peak score.
+func (s *Store) Peaks(from, to time.Time) []Peak {
+	byID := make(map[int]*Peak)
+	var peaks []*Peak
+	for _, snap := range s.Snapshots(from, to) {
+		for _, story := range snap.Stories {
+			p, ok := byID[story.ID]
+			if !ok {
+				p = &Peak{PeakScore: story.Score, BestRank: story.Rank}
+				byID[story.ID] = p
+				peaks = append(peaks, p)
+			}
+			p.Story = story
+			if story.Score > p.PeakScore {
+				p.PeakScore = story.Score
+			}
+			if story.Rank < p.BestRank {
+				p.BestRank = story.Rank
+			}
+		}
+	}
+	// stable, so ties keep the order the stories were first seen in
+	sort.SliceStable(peaks, func(i, j int) bool {
+		return peaks[i].PeakScore > peaks[j].PeakScore
+	})
+	ret := make([]Peak, len(peaks))
+	for i, p := range peaks {
+		ret[i] = *p
+	}
+	return ret
+}
+
+// Export writes the snapshots taken at or after from to w, oldest first, as
+// JSON lines like the file of a Store, and returns how many were written.
+func (s *Store) Export(w io.Writer, from time.Time) (int, error) {
+	snaps := s.Snapshots(from, time.Unix(1<<62, 0))
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	for i, snap := range snaps {
+		if err := enc.Encode(snap); err != nil {
+			return i, err
+		}
+	}
+	return len(snaps), bw.Flush()
+}
+
+// Import records the snapshots read from r, JSON lines as written by Export,
+// and returns how many were recorded. Snapshots taken at or before the last
+// one of the Store are skipped, as the history must stay in chronological
+// order, so importing the same export twice doesn't duplicate it.
+func (s *Store) Import(r io.Reader) (int, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 16<<20)
+	n := 0
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var snap Snapshot
+		if err := json.Unmarshal(scanner.Bytes(), &snap); err != nil {
+			return n, fmt.Errorf("history: line %d: %w", line, err)
+		}
+		s.mu.RLock()
+		skip := len(s.snapshots) > 0 && !snap.Time.After(s.snapshots[len(s.snapshots)-1].Time)
+		s.mu.RUnlock()
+		if skip {
+			continue
+		}
+		if err := s.Record(snap); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, scanner.Err()
+}
+
+// Prune drops the snapshots taken before the given time and, if max > 0, the
+// oldest ones beyond the max most recent, and returns how many were dropped.
+// Stories only seen in the dropped snapshots are forgotten. The snapshots
+// stay in the backend of the Store until it is compacted.
+func (s *Store) Prune(before time.Time, max int) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := sort.Search(len(s.snapshots), func(i int) bool {
+		return !s.snapshots[i].Time.Before(before)
+	})
+	if max > 0 && len(s.snapshots)-n > max {
+		n = len(s.snapshots) - max
+	}
+	if n == 0 {
+		return 0
+	}
+	s.snapshots = append([]Snapshot(nil), s.snapshots[n:]...)
+	s.firstSeen = make(map[int]time.Time)
+	for _, snap := range s.snapshots {
+		for _, story := range snap.Stories {
+			if _, ok := s.firstSeen[story.ID]; !ok {
+				s.firstSeen[story.ID] = snap.Time
+			}
+		}
+	}
+	s.pruned += n
+	return n
+}
+
+// Compact removes the pruned snapshots from the backend of the Store,
+// eg rewriting its file to reclaim their space, and returns how many were
+// removed. It does nothing for a Store only kept in memory or that wasn't
+// pruned since it was last compacted.
+func (s *Store) Compact() (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.backend == nil || s.pruned == 0 {
+		return 0, nil
+	}
+	if err := s.backend.Compact(s.snapshots); err != nil {
+		return 0, err
+	}
+	n := s.pruned
+	s.pruned = 0
+	return n, nil
+}
@@ -0,0 +1,230 @@
+package history
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var t0 = time.Date(2018, 4, 1, 16, 0, 0, 0, time.UTC)
+
+func testSnapshots() []Snapshot {
+	return []Snapshot{
+		{Time: t0, Stories: []Story{{ID: 1, Rank: 1, Score: 10}, {ID: 2, Rank: 2, Score: 5}}},
+		{Time: t0.Add(time.Hour), Stories: []Story{{ID: 2, Rank: 1, Score: 50}, {ID: 3, Rank: 2, Score: 20}}},
+		{Time: t0.Add(2 * time.Hour), Stories: []Story{{ID: 3, Rank: 1, Score: 80}, {ID: 1, Rank: 2, Score: 12}}},
+	}
+}
+
+func TestStore(t *testing.T) {
+	s := NewStore()
+	for _, snap := range testSnapshots() {
+		if err := s.Record(snap); err != nil {
+			t.Fatalf("Record() received an error: %s", err.Error())
+		}
+	}
+
+	tests := []struct {
+		id   int
+		want time.Time
+		ok   bool
+	}{
+		{1, t0, true},
+		{3, t0.Add(time.Hour), true},
+		{4, time.Time{}, false},
+	}
+	for _, tc := range tests {
+		got, ok := s.FirstSeen(tc.id)
+		if ok != tc.ok || !got.Equal(tc.want) {
+			t.Errorf("FirstSeen(%d): want %v, %t, got %v, %t", tc.id, tc.want, tc.ok, got, ok)
+		}
+	}
+
+	if start, ok := s.Start(); !ok || !start.Equal(t0) {
+		t.Errorf("Start(): want %v, true, got %v, %t", t0, start, ok)
+	}
+
+	if snap, ok := s.At(t0.Add(90 * time.Minute)); !ok || !snap.Time.Equal(t0.Add(time.Hour)) {
+		t.Errorf("At(): want the snapshot at %v, got %v, %t", t0.Add(time.Hour), snap.Time, ok)
+	}
+	if _, ok := s.At(t0.Add(-time.Minute)); ok {
+		t.Errorf("At() before the first snapshot: want false, got true")
+	}
+
+	if story, ok := s.StoryAt(1, t0.Add(90*time.Minute)); !ok || story.Score != 10 {
+		t.Errorf("StoryAt(1): want the story with 10 points, got %+v, %t", story, ok)
+	}
+	if _, ok := s.StoryAt(3, t0.Add(30*time.Minute)); ok {
+		t.Errorf("StoryAt(3) before it was seen: want false, got true")
+	}
+
+	snaps := s.Snapshots(t0.Add(time.Minute), t0.Add(2*time.Hour))
+	if len(snaps) != 1 || !snaps[0].Time.Equal(t0.Add(time.Hour)) {
+		t.Errorf("Snapshots(): want the snapshot at %v, got %v", t0.Add(time.Hour), snaps)
+	}
+	if snaps := s.Snapshots(t0.Add(3*time.Hour), t0.Add(4*time.Hour)); len(snaps) != 0 {
+		t.Errorf("Snapshots() after the last snapshot: want none, got %v", snaps)
+	}
+}
+
+func TestOpen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+	s, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open() received an error: %s", err.Error())
+	}
+	for _, snap := range testSnapshots() {
+		if err := s.Record(snap); err != nil {
+			t.Fatalf("Record() received an error: %s", err.Error())
+		}
+	}
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close() received an error: %s", err.Error())
+	}
+
+	s, err = Open(path)
+	if err != nil {
+		t.Fatalf("Open() of an existing file received an error: %s", err.Error())
+	}
+	defer s.Close()
+	if n := len(s.Snapshots(t0, t0.Add(24*time.Hour))); n != 3 {
+		t.Errorf("number of snapshots: want %d, got %d", 3, n)
+	}
+	if got, _ := s.FirstSeen(2); !got.Equal(t0) {
+		t.Errorf("FirstSeen(2): want %v, got %v", t0, got)
+	}
+}
+
+func TestStore_Peaks(t *testing.T) {
+	s := NewStore()
+	for _, snap := range testSnapshots() {
+		s.Record(snap)
+	}
+
+	peaks := s.Peaks(t0, t0.Add(3*time.Hour))
+	want := []Peak{
+		{Story: Story{ID: 3, Rank: 1, Score: 80}, PeakScore: 80, BestRank: 1},
+		{Story: Story{ID: 2, Rank: 1, Score: 50}, PeakScore: 50, BestRank: 1},
+		{Story: Story{ID: 1, Rank: 2, Score: 12}, PeakScore: 12, BestRank: 1},
+	}
+	if len(peaks) != len(want) {
+		t.Fatalf("Peaks(): want %v, got %v", want, peaks)
+	}
+	for i := range want {
+		if peaks[i] != want[i] {
+			t.Errorf("Peaks()[%d]: want %+v, got %+v", i, want[i], peaks[i])
+		}
+	}
+
+	peaks = s.Peaks(t0, t0.Add(time.Hour))
+	if len(peaks) != 2 || peaks[0].ID != 1 {
+		t.Errorf("Peaks() of the first snapshot: want stories 1 and 2, got %v", peaks)
+	}
+}
+
+func TestStore_Export(t *testing.T) {
+	s := NewStore()
+	for _, snap := range testSnapshots() {
+		s.Record(snap)
+	}
+	var buf bytes.Buffer
+	n, err := s.Export(&buf, t0.Add(time.Nanosecond))
+	if err != nil {
+		t.Fatalf("Export() received an error: %s", err.Error())
+	}
+	if n != 2 {
+		t.Errorf("exported snapshots: want %d, got %d", 2, n)
+	}
+
+	imported := NewStore()
+	imported.Record(testSnapshots()[1])
+	n, err = imported.Import(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("Import() received an error: %s", err.Error())
+	}
+	// the first exported snapshot is already there
+	if n != 1 {
+		t.Errorf("imported snapshots: want %d, got %d", 1, n)
+	}
+	if got := len(imported.Snapshots(t0, t0.Add(24*time.Hour))); got != 2 {
+		t.Errorf("number of snapshots after the import: want %d, got %d", 2, got)
+	}
+	if _, err := imported.Import(strings.NewReader("{not json}\n")); err == nil {
+		t.Errorf("Import(): want an error for invalid JSON")
+	}
+}
+
+func TestStore_PruneCompact(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+	s, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open() received an error: %s", err.Error())
+	}
+	for _, snap := range testSnapshots() {
+		s.Record(snap)
+	}
+
+	if n := s.Prune(t0.Add(time.Minute), 0); n != 1 {
+		t.Errorf("Prune() before the second snapshot: want %d pruned, got %d", 1, n)
+	}
+	if got, _ := s.FirstSeen(2); !got.Equal(t0.Add(time.Hour)) {
+		t.Errorf("FirstSeen(2) after pruning: want %v, got %v", t0.Add(time.Hour), got)
+	}
+	if n := s.Prune(time.Time{}, 1); n != 1 {
+		t.Errorf("Prune() to one snapshot: want %d pruned, got %d", 1, n)
+	}
+	if n, err := s.Compact(); err != nil || n != 2 {
+		t.Errorf("Compact(): want %d, nil, got %d, %v", 2, n, err)
+	}
+	if n, _ := s.Compact(); n != 0 {
+		t.Errorf("Compact() again: want %d, got %d", 0, n)
+	}
+	if err := s.Record(Snapshot{Time: t0.Add(3 * time.Hour)}); err != nil {
+		t.Fatalf("Record() after compacting received an error: %s", err.Error())
+	}
+	s.Close()
+
+	s, err = Open(path)
+	if err != nil {
+		t.Fatalf("Open() of a compacted file received an error: %s", err.Error())
+	}
+	defer s.Close()
+	snaps := s.Snapshots(t0, t0.Add(24*time.Hour))
+	if len(snaps) != 2 || !snaps[0].Time.Equal(t0.Add(2*time.Hour)) {
+		t.Errorf("snapshots after compacting: want the last two, got %v", snaps)
+	}
+}
+
+func TestStore_Sync(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+	leader, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer leader.Close()
+	snaps := testSnapshots()
+	leader.Record(snaps[0])
+	follower, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer follower.Close()
+
+	leader.Record(snaps[1])
+	leader.Record(snaps[2])
+	n, err := follower.Sync()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("Sync(): want 2 new snapshots, got %d", n)
+	}
+	if first, _ := follower.FirstSeen(3); !first.Equal(snaps[1].Time) {
+		t.Errorf("FirstSeen(3) after Sync(): want %s, got %s", snaps[1].Time, first)
+	}
+	if n, _ := follower.Sync(); n != 0 {
+		t.Errorf("Sync() again: want no new snapshots, got %d", n)
+	}
+}
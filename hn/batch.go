@@ -0,0 +1,124 @@
+package hn
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// ItemError records the failure to fetch a single item.
+type ItemError struct {
+	ID  int
+	Err error
+}
+
+func (e ItemError) Error() string {
+	return fmt.Sprintf("item %d: %s", e.ID, e.Err)
+}
+
+func (e ItemError) Unwrap() error {
+	return e.Err
+}
+
+// ItemErrors is returned by GetItems when some of the items could not be
+// fetched. It lists an ItemError for every failed id, in input order.
+type ItemErrors []ItemError
+
+func (e ItemErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return fmt.Sprintf("failed to get %d item(s): %s", len(e), strings.Join(msgs, "; "))
+}
+
+// ItemResult is a single result yielded by StreamItems. Index is the position
+// of the item's id in the ids passed to StreamItems.
+type ItemResult struct {
+	Index int
+	Item  Item
+	Err   error
+}
+
+// StreamItems fetches the items with the provided ids, at most concurrency at
+// a time, and sends each result on the returned channel as soon as it
+// arrives, so results are generally out of order. A concurrency of zero or
+// less fetches all of them at once.
+//
+// The channel is closed once every item has been sent or ctx is canceled.
+// Callers that stop reading early must cancel ctx so that the fetching
+// goroutines can exit.
+func (c *Client) StreamItems(ctx context.Context, ids []int, concurrency int) <-chan ItemResult {
+	c.defaultify()
+	if concurrency <= 0 || concurrency > len(ids) {
+		concurrency = len(ids)
+	}
+
+	results := make(chan ItemResult)
+	// indexes of the ids still to fetch are handed out to the workers
+	idxChan := make(chan int)
+	var wg sync.WaitGroup
+	wg.Add(concurrency)
+	for w := 0; w < concurrency; w++ {
+		go func() {
+			defer wg.Done()
+			for idx := range idxChan {
+				item, err := c.GetItemContext(ctx, ids[idx])
+				select {
+				case results <- ItemResult{Index: idx, Item: item, Err: err}:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+	go func() {
+		defer close(idxChan)
+		for idx := range ids {
+			select {
+			case idxChan <- idx:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+	return results
+}
+
+// GetItems fetches the items with the provided ids, at most concurrency at a
+// time, and returns them in the same order as ids. A concurrency of zero or
+// less fetches all of them at once.
+//
+// The returned slice always has len(ids) entries. If any of the fetches
+// fail, the corresponding entries are zero Items and the returned error is
+// an ItemErrors describing each failure. Canceling ctx aborts the fetches
+// that haven't completed yet.
+func (c *Client) GetItems(ctx context.Context, ids []int, concurrency int) ([]Item, error) {
+	items := make([]Item, len(ids))
+	errs := make([]error, len(ids))
+	done := make([]bool, len(ids))
+	for res := range c.StreamItems(ctx, ids, concurrency) {
+		items[res.Index], errs[res.Index] = res.Item, res.Err
+		done[res.Index] = true
+	}
+
+	var itemErrs ItemErrors
+	for idx, err := range errs {
+		if !done[idx] {
+			err = ctx.Err()
+		}
+		if err != nil {
+			items[idx] = Item{}
+			itemErrs = append(itemErrs, ItemError{ID: ids[idx], Err: err})
+		}
+	}
+	if itemErrs != nil {
+		return items, itemErrs
+	}
+	return items, nil
+}
@@ -0,0 +1,131 @@
+package hn
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func setupBatch() (string, func()) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/item/", func(w http.ResponseWriter, r *http.Request) {
+		id := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/item/"), ".json")
+		if id == "13" {
+			fmt.Fprint(w, "not json")
+			return
+		}
+		fmt.Fprintf(w, "{\"id\":%s,\"title\":\"Story %s\",\"type\":\"story\"}", id, id)
+	})
+	server := httptest.NewServer(mux)
+	return server.URL, func() {
+		server.Close()
+	}
+}
+
+func TestClient_GetItems(t *testing.T) {
+	baseURL, teardown := setupBatch()
+	defer teardown()
+
+	c := Client{
+		apiBase: baseURL,
+	}
+	ids := []int{5, 3, 8, 1, 9, 2, 7}
+	for _, concurrency := range []int{0, 1, 3, 100} {
+		items, err := c.GetItems(context.Background(), ids, concurrency)
+		if err != nil {
+			t.Errorf("client.GetItems() received an error: %s", err.Error())
+		}
+		if len(items) != len(ids) {
+			t.Fatalf("len(items): want %d, got %d", len(ids), len(items))
+		}
+		for i, id := range ids {
+			if items[i].ID != id {
+				t.Errorf("concurrency %d: items[%d].ID: want %d, got %d", concurrency, i, id, items[i].ID)
+			}
+		}
+	}
+}
+
+func TestClient_GetItems_errors(t *testing.T) {
+	baseURL, teardown := setupBatch()
+	defer teardown()
+
+	c := Client{
+		apiBase: baseURL,
+	}
+	items, err := c.GetItems(context.Background(), []int{1, 13, 2}, 2)
+	var itemErrs ItemErrors
+	if !errors.As(err, &itemErrs) {
+		t.Fatalf("err: want ItemErrors, got %v", err)
+	}
+	if len(itemErrs) != 1 || itemErrs[0].ID != 13 {
+		t.Errorf("itemErrs: want a single error for id 13, got %v", itemErrs)
+	}
+	if items[0].ID != 1 || items[1].ID != 0 || items[2].ID != 2 {
+		t.Errorf("items: want ids [1 0 2], got [%d %d %d]", items[0].ID, items[1].ID, items[2].ID)
+	}
+}
+
+func TestClient_GetItems_canceled(t *testing.T) {
+	baseURL, teardown := setupBatch()
+	defer teardown()
+
+	c := Client{
+		apiBase: baseURL,
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := c.GetItems(ctx, []int{1, 2}, 1)
+	var itemErrs ItemErrors
+	if !errors.As(err, &itemErrs) {
+		t.Fatalf("err: want ItemErrors, got %v", err)
+	}
+	for _, itemErr := range itemErrs {
+		if !errors.Is(itemErr, context.Canceled) {
+			t.Errorf("itemErr: want context.Canceled, got %v", itemErr)
+		}
+	}
+}
+
+func TestClient_StreamItems(t *testing.T) {
+	baseURL, teardown := setupBatch()
+	defer teardown()
+
+	c := Client{
+		apiBase: baseURL,
+	}
+	ids := []int{5, 3, 8, 1, 9}
+	seen := make(map[int]bool)
+	for res := range c.StreamItems(context.Background(), ids, 2) {
+		if res.Err != nil {
+			t.Errorf("result.Err: %s", res.Err.Error())
+		}
+		if res.Item.ID != ids[res.Index] {
+			t.Errorf("result.Item.ID: want %d, got %d", ids[res.Index], res.Item.ID)
+		}
+		seen[res.Index] = true
+	}
+	if len(seen) != len(ids) {
+		t.Errorf("number of results: want %d, got %d", len(ids), len(seen))
+	}
+}
+
+func TestClient_StreamItems_earlyExit(t *testing.T) {
+	baseURL, teardown := setupBatch()
+	defer teardown()
+
+	c := Client{
+		apiBase: baseURL,
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	results := c.StreamItems(ctx, []int{1, 2, 3, 4, 5, 6, 7, 8}, 2)
+	<-results
+	cancel()
+	// the channel must get closed even though we stopped reading
+	for range results {
+	}
+}
@@ -2,27 +2,104 @@
 package hn
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
 )
 
 const (
 	apiBase = "https://hacker-news.firebaseio.com/v0"
+
+	// APIBaseEnv is the environment variable that overrides the API base URL
+	// of clients that weren't given one explicitly.
+	APIBaseEnv = "HN_API_BASE"
 )
 
 // Client is an API client used to interact with the Hacker News API
 type Client struct {
 	// unexported fields...
-	apiBase string
+	apiBase     string
+	itemTimeout time.Duration
+	httpClient  *http.Client
+	metrics     *Metrics
+	userAgent   string
+}
+
+// Option configures a Client created with NewClient.
+type Option func(*Client)
+
+// WithBaseURL makes the client talk to the API at baseURL instead of the
+// official Firebase endpoint, eg a local fake server, a caching proxy or a
+// mirror. baseURL should include the version path, eg
+// "http://localhost:8080/v0".
+func WithBaseURL(baseURL string) Option {
+	return func(c *Client) {
+		c.apiBase = strings.TrimSuffix(baseURL, "/")
+	}
+}
+
+// WithItemTimeout bounds every item fetch to d, so that a single slow item
+// can't hold up GetItems: it fails with context.DeadlineExceeded instead.
+func WithItemTimeout(d time.Duration) Option {
+	return func(c *Client) {
+		c.itemTimeout = d
+	}
+}
+
+// WithHTTPClient makes the client send its requests with hc instead of
+// http.DefaultClient, eg to tune its transport.
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = hc
+	}
+}
+
+// WithMetrics records the timings of the requests of the client in m.
+func WithMetrics(m *Metrics) Option {
+	return func(c *Client) {
+		c.metrics = m
+	}
+}
+
+// WithUserAgent sends ua as the User-Agent of the requests of the client
+// instead of the default of net/http, so that the operators of the API can
+// tell who is calling it.
+func WithUserAgent(ua string) Option {
+	return func(c *Client) {
+		c.userAgent = ua
+	}
+}
+
+// NewClient returns a Client configured with opts. The zero value Client is
+// still perfectly usable; NewClient is only needed to change the defaults.
+func NewClient(opts ...Option) *Client {
+	var c Client
+	for _, opt := range opts {
+		opt(&c)
+	}
+	c.defaultify()
+	return &c
 }
 
 // Making the Client zero value useful without forcing users to do something
 // like `NewClient()`
 func (c *Client) defaultify() {
+	if c.apiBase == "" {
+		c.apiBase = strings.TrimSuffix(os.Getenv(APIBaseEnv), "/")
+	}
 	if c.apiBase == "" {
 		c.apiBase = apiBase
 	}
+	if c.httpClient == nil {
+		c.httpClient = http.DefaultClient
+	}
 }
 
 // TopItems returns the ids of roughly 450 top items in decreasing order. These
@@ -32,15 +109,8 @@ func (c *Client) defaultify() {
 // TopItmes does not filter out job listings or anything else, as the type of
 // each item is unknown without further API calls.
 func (c *Client) TopItems() ([]int, error) {
-	c.defaultify()
-	resp, err := http.Get(fmt.Sprintf("%s/topstories.json", c.apiBase))
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
 	var ids []int
-	dec := json.NewDecoder(resp.Body)
-	err = dec.Decode(&ids)
+	err := c.getJSON(context.Background(), "/topstories.json", &ids)
 	if err != nil {
 		return nil, err
 	}
@@ -49,32 +119,105 @@ func (c *Client) TopItems() ([]int, error) {
 
 // GetItem will return the Item defined by the provided ID.
 func (c *Client) GetItem(id int) (Item, error) {
-	c.defaultify()
+	return c.GetItemContext(context.Background(), id)
+}
+
+// GetItemContext is like GetItem, but the request is bound to ctx.
+func (c *Client) GetItemContext(ctx context.Context, id int) (Item, error) {
+	if c.itemTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.itemTimeout)
+		defer cancel()
+	}
 	var item Item
-	resp, err := http.Get(fmt.Sprintf("%s/item/%d.json", c.apiBase, id))
+	err := c.getJSON(ctx, fmt.Sprintf("/item/%d.json", id), &item)
 	if err != nil {
 		return item, err
 	}
-	defer resp.Body.Close()
-	dec := json.NewDecoder(resp.Body)
-	err = dec.Decode(&item)
+	return item, nil
+}
+
+// GetUser will return the User with the provided username. Usernames are
+// case-sensitive. The returned User has an empty ID if there is no such
+// user.
+func (c *Client) GetUser(username string) (User, error) {
+	return c.GetUserContext(context.Background(), username)
+}
+
+// GetUserContext is like GetUser, but the request is bound to ctx.
+func (c *Client) GetUserContext(ctx context.Context, username string) (User, error) {
+	var user User
+	err := c.getJSON(ctx, fmt.Sprintf("/user/%s.json", url.PathEscape(username)), &user)
 	if err != nil {
-		return item, err
+		return user, err
 	}
-	return item, nil
+	return user, nil
+}
+
+// StatusError is the error of a response of the API other than a success,
+// eg a 503 during an outage or a 429 when rate limited
+type StatusError struct {
+	Path       string
+	StatusCode int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("hn: GET %s: %d %s", e.Path, e.StatusCode, http.StatusText(e.StatusCode))
+}
+
+// getJSON decodes the JSON response to a GET request for path into v. Any
+// response but a 2xx is a *StatusError, whatever its body: Firebase answers
+// errors with JSON too, eg {"error": "..."}, which would decode into a zero
+// Item.
+func (c *Client) getJSON(ctx context.Context, path string, v interface{}) error {
+	c.defaultify()
+	if c.metrics != nil {
+		ctx = c.metrics.trace(ctx)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.apiBase+path, nil)
+	if err != nil {
+		return err
+	}
+	if c.userAgent != "" {
+		req.Header.Set("User-Agent", c.userAgent)
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		// drained, the connection can be reused
+		io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 64<<10))
+		return &StatusError{Path: path, StatusCode: resp.StatusCode}
+	}
+	dec := json.NewDecoder(resp.Body)
+	return dec.Decode(v)
 }
 
 // Item represents a single item returned by the HN API. This can have a type
-// of "story", "comment", or "job" (and probably more values), and one of the
-// URL or Text fields will be set, but not both.
+// of "story", "comment", "job", "poll" or "pollopt", and one of the URL or
+// Text fields will be set, but not both.
+//
+// A poll lists the IDs of its options in Parts, and each option points back
+// at its poll with Poll. The votes an option received are stored in Score.
+// A comment points at the story or comment it replies to with Parent.
 //
 // For the purpose of this exercise, we only care about items where the
 // type is "story", and the URL is set.
+//
+// Deleted and dead items are still returned by the API, but most of their
+// fields are stripped. Use Alive to tell them apart from regular items.
 type Item struct {
 	By          string `json:"by"`
+	Dead        bool   `json:"dead"`
+	Deleted     bool   `json:"deleted"`
 	Descendants int    `json:"descendants"`
 	ID          int    `json:"id"`
 	Kids        []int  `json:"kids"`
+	Parent      int    `json:"parent"`
+	Parts       []int  `json:"parts"`
+	Poll        int    `json:"poll"`
 	Score       int    `json:"score"`
 	Time        int    `json:"time"`
 	Title       string `json:"title"`
@@ -84,3 +227,20 @@ type Item struct {
 	Text string `json:"text"`
 	URL  string `json:"url"`
 }
+
+// Alive reports whether the item exists and has been neither deleted nor
+// killed by moderators. The API responds with null for IDs that don't exist,
+// which decodes into an Item without an ID.
+func (item Item) Alive() bool {
+	return item.ID != 0 && !item.Deleted && !item.Dead
+}
+
+// User represents a single HN user. Submitted lists the ids of the stories,
+// polls and comments of the user, most recent first.
+type User struct {
+	About     string `json:"about"`
+	Created   int    `json:"created"`
+	ID        string `json:"id"`
+	Karma     int    `json:"karma"`
+	Submitted []int  `json:"submitted"`
+}
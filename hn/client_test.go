@@ -1,10 +1,14 @@
 package hn
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"os"
 	"testing"
+	"time"
 )
 
 func setup() (string, func()) {
@@ -15,6 +19,19 @@ func setup() (string, func()) {
 	mux.HandleFunc("/item/", func(w http.ResponseWriter, r *http.Request) {
 		fmt.Fprint(w, "{\"by\":\"test_user\",\"descendants\":10,\"id\":1,\"kids\":[16732999,16729637,16729517,16729595],\"score\":34,\"time\":1522599083,\"title\":\"Test Story Title\",\"type\":\"story\",\"url\":\"https://www.test-story.com\"}")
 	})
+	mux.HandleFunc("/item/2.json", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "{\"deleted\":true,\"id\":2,\"time\":1522599083,\"type\":\"story\"}")
+	})
+	mux.HandleFunc("/item/3.json", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "null")
+	})
+	mux.HandleFunc("/item/4.json", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprint(w, "{\"error\":\"Service Unavailable\"}")
+	})
+	mux.HandleFunc("/user/test_user.json", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "{\"about\":\"Hi\",\"created\":1173923446,\"id\":\"test_user\",\"karma\":2937,\"submitted\":[8265435,8168423]}")
+	})
 	server := httptest.NewServer(mux)
 	return server.URL, func() {
 		server.Close()
@@ -45,6 +62,51 @@ func TestClient_defaultify(t *testing.T) {
 	}
 }
 
+func TestClient_defaultify_env(t *testing.T) {
+	os.Setenv(APIBaseEnv, "http://localhost:8080/v0/")
+	defer os.Unsetenv(APIBaseEnv)
+
+	var c Client
+	c.defaultify()
+	if c.apiBase != "http://localhost:8080/v0" {
+		t.Errorf("c.apiBase: want %s, got %s", "http://localhost:8080/v0", c.apiBase)
+	}
+}
+
+func TestNewClient_WithBaseURL(t *testing.T) {
+	baseURL, teardown := setup()
+	defer teardown()
+
+	c := NewClient(WithBaseURL(baseURL + "/"))
+	if c.apiBase != baseURL {
+		t.Errorf("c.apiBase: want %s, got %s", baseURL, c.apiBase)
+	}
+	ids, err := c.TopItems()
+	if err != nil {
+		t.Errorf("client.TopItems() received an error: %s", err.Error())
+	}
+	if len(ids) != 5 {
+		t.Errorf("len(ids): want %d, got %d", 5, len(ids))
+	}
+}
+
+func TestNewClient_WithUserAgent(t *testing.T) {
+	var ua string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ua = r.Header.Get("User-Agent")
+		fmt.Fprint(w, "[]")
+	}))
+	defer server.Close()
+
+	c := NewClient(WithBaseURL(server.URL), WithUserAgent("quiet_hn/1.0"))
+	if _, err := c.TopItems(); err != nil {
+		t.Fatalf("client.TopItems() received an error: %s", err.Error())
+	}
+	if ua != "quiet_hn/1.0" {
+		t.Errorf("User-Agent: want quiet_hn/1.0, got %s", ua)
+	}
+}
+
 func TestClient_GetItem(t *testing.T) {
 	baseURL, teardown := setup()
 	defer teardown()
@@ -62,3 +124,96 @@ func TestClient_GetItem(t *testing.T) {
 		t.Errorf("item.By: want %s, got %s", "test_user", item.By)
 	}
 }
+
+func TestClient_GetItem_unavailable(t *testing.T) {
+	baseURL, teardown := setup()
+	defer teardown()
+
+	c := Client{
+		apiBase: baseURL,
+	}
+	// the error of Firebase is JSON, which mustn't pass for a missing item
+	_, err := c.GetItem(4)
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("client.GetItem() of a 503: want a *StatusError, got %v", err)
+	}
+}
+
+func TestClient_GetItem_timeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(time.Second):
+		}
+	}))
+	defer server.Close()
+
+	c := NewClient(WithBaseURL(server.URL), WithItemTimeout(10*time.Millisecond))
+	if _, err := c.GetItem(1); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("client.GetItem(): want context.DeadlineExceeded, got %v", err)
+	}
+}
+
+func TestClient_metrics(t *testing.T) {
+	baseURL, teardown := setup()
+	defer teardown()
+
+	var m Metrics
+	c := NewClient(WithBaseURL(baseURL), WithMetrics(&m))
+	for i := 0; i < 2; i++ {
+		if _, err := c.GetItem(1); err != nil {
+			t.Fatalf("client.GetItem() received an error: %s", err.Error())
+		}
+	}
+	stats := m.Stats()
+	if stats.Requests != 2 || stats.TimeToFirstByte.Count != 2 {
+		t.Errorf("requests: want 2 with a time to first byte, got %+v", stats)
+	}
+	if stats.Connect.Count != 1 || stats.ReusedConns != 1 {
+		t.Errorf("connections: want 1 connect and 1 reused, got %+v", stats)
+	}
+}
+
+func TestClient_GetItem_deleted(t *testing.T) {
+	baseURL, teardown := setup()
+	defer teardown()
+
+	c := Client{
+		apiBase: baseURL,
+	}
+	item, err := c.GetItem(2)
+	if err != nil {
+		t.Errorf("client.GetItem() received an error: %s", err.Error())
+	}
+	if !item.Deleted {
+		t.Errorf("item.Deleted: want %t, got %t", true, item.Deleted)
+	}
+	if item.Alive() {
+		t.Errorf("item.Alive(): want %t, got %t", false, item.Alive())
+	}
+
+	item, err = c.GetItem(3)
+	if err != nil {
+		t.Errorf("client.GetItem() received an error: %s", err.Error())
+	}
+	if item.Alive() {
+		t.Errorf("item.Alive() for missing item: want %t, got %t", false, item.Alive())
+	}
+}
+
+func TestClient_GetUser(t *testing.T) {
+	baseURL, teardown := setup()
+	defer teardown()
+
+	c := Client{
+		apiBase: baseURL,
+	}
+	user, err := c.GetUser("test_user")
+	if err != nil {
+		t.Errorf("client.GetUser() received an error: %s", err.Error())
+	}
+	if user.Karma != 2937 || len(user.Submitted) != 2 {
+		t.Errorf("user: want karma %d and %d submissions, got %d and %d", 2937, 2, user.Karma, len(user.Submitted))
+	}
+}
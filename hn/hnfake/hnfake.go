@@ -0,0 +1,184 @@
+// Package hnfake implements a fake Hacker News API server for tests.
+//
+// The server mimics the parts of the Firebase API used by the hn package and
+// is seeded from a Fixture, which can be loaded from JSON:
+//
+//	srv, err := hnfake.NewFromFile("testdata/frontpage.json")
+//	if err != nil {
+//		t.Fatal(err)
+//	}
+//	defer srv.Close()
+//	client := srv.Client()
+package hnfake
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/mmxmb/quiet_hn/hn"
+)
+
+// Fixture is the data served by a Server. It is also the JSON format read by
+// Load and NewFromFile:
+//
+//	{
+//	  "topstories": [1, 2],
+//	  "items": [{"id": 1, "type": "story", ...}, {"id": 2, ...}],
+//	  "users": [{"id": "pg", "karma": 155111, ...}]
+//	}
+type Fixture struct {
+	TopStories []int     `json:"topstories"`
+	Items      []hn.Item `json:"items"`
+	Users      []hn.User `json:"users"`
+}
+
+// Load decodes a Fixture from r.
+func Load(r io.Reader) (Fixture, error) {
+	var f Fixture
+	dec := json.NewDecoder(r)
+	err := dec.Decode(&f)
+	if err != nil {
+		return f, err
+	}
+	return f, nil
+}
+
+// Server is a fake HN API running on a local httptest.Server. It is safe to
+// change its data while it serves requests.
+type Server struct {
+	*httptest.Server
+
+	mu         sync.RWMutex
+	topStories []int
+	items      map[int]hn.Item
+	users      map[string]hn.User
+}
+
+// New starts a Server serving f. Callers should call Close when finished.
+func New(f Fixture) *Server {
+	s := &Server{
+		topStories: f.TopStories,
+		items:      make(map[int]hn.Item, len(f.Items)),
+		users:      make(map[string]hn.User, len(f.Users)),
+	}
+	for _, item := range f.Items {
+		s.items[item.ID] = item
+	}
+	for _, user := range f.Users {
+		s.users[user.ID] = user
+	}
+	s.Server = httptest.NewServer(s.handler())
+	return s
+}
+
+// NewFromFile starts a Server serving the fixture stored in the JSON file at
+// path.
+func NewFromFile(path string) (*Server, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	f, err := Load(file)
+	if err != nil {
+		return nil, fmt.Errorf("hnfake: loading %s: %w", path, err)
+	}
+	return New(f), nil
+}
+
+// BaseURL returns the API base URL of the server, suitable for
+// hn.WithBaseURL.
+func (s *Server) BaseURL() string {
+	return s.URL + "/v0"
+}
+
+// Client returns an hn.Client talking to the server.
+func (s *Server) Client() *hn.Client {
+	return hn.NewClient(hn.WithBaseURL(s.BaseURL()))
+}
+
+// SetTopStories replaces the ids returned by /topstories.json.
+func (s *Server) SetTopStories(ids []int) {
+	s.mu.Lock()
+	s.topStories = ids
+	s.mu.Unlock()
+}
+
+// SetItem adds or replaces the item with item.ID.
+func (s *Server) SetItem(item hn.Item) {
+	s.mu.Lock()
+	s.items[item.ID] = item
+	s.mu.Unlock()
+}
+
+// RemoveItem removes the item with id, after which the server responds with
+// null for it, like the real API does for unknown ids.
+func (s *Server) RemoveItem(id int) {
+	s.mu.Lock()
+	delete(s.items, id)
+	s.mu.Unlock()
+}
+
+// SetUser adds or replaces the user with user.ID.
+func (s *Server) SetUser(user hn.User) {
+	s.mu.Lock()
+	s.users[user.ID] = user
+	s.mu.Unlock()
+}
+
+func (s *Server) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v0/topstories.json", func(w http.ResponseWriter, r *http.Request) {
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+		writeJSON(w, s.topStories)
+	})
+	mux.HandleFunc("/v0/item/", func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(r.URL.Path, "/v0/item/")
+		id, err := strconv.Atoi(strings.TrimSuffix(name, ".json"))
+		if err != nil || !strings.HasSuffix(name, ".json") {
+			http.NotFound(w, r)
+			return
+		}
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+		item, ok := s.items[id]
+		if !ok {
+			writeJSON(w, nil)
+			return
+		}
+		writeJSON(w, item)
+	})
+	mux.HandleFunc("/v0/user/", func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(r.URL.Path, "/v0/user/")
+		if !strings.HasSuffix(name, ".json") {
+			http.NotFound(w, r)
+			return
+		}
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+		user, ok := s.users[strings.TrimSuffix(name, ".json")]
+		if !ok {
+			writeJSON(w, nil)
+			return
+		}
+		writeJSON(w, user)
+	})
+	return mux
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	enc := json.NewEncoder(w)
+	err := enc.Encode(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
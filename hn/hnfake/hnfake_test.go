@@ -0,0 +1,87 @@
+package hnfake
+
+import (
+	"testing"
+
+	"github.com/mmxmb/quiet_hn/hn"
+)
+
+func TestNewFromFile(t *testing.T) {
+	srv, err := NewFromFile("testdata/frontpage.json")
+	if err != nil {
+		t.Fatalf("NewFromFile() received an error: %s", err.Error())
+	}
+	defer srv.Close()
+
+	c := srv.Client()
+	ids, err := c.TopItems()
+	if err != nil {
+		t.Errorf("client.TopItems() received an error: %s", err.Error())
+	}
+	if len(ids) != 40 {
+		t.Errorf("len(ids): want %d, got %d", 40, len(ids))
+	}
+	item, err := c.GetItem(15)
+	if err != nil {
+		t.Errorf("client.GetItem() received an error: %s", err.Error())
+	}
+	if item.Type != "poll" || len(item.Parts) != 3 {
+		t.Errorf("item: want poll with 3 parts, got %s with %d parts", item.Type, len(item.Parts))
+	}
+}
+
+func TestServer_SetItem(t *testing.T) {
+	srv := New(Fixture{TopStories: []int{1}})
+	defer srv.Close()
+	c := srv.Client()
+
+	item, err := c.GetItem(1)
+	if err != nil {
+		t.Errorf("client.GetItem() received an error: %s", err.Error())
+	}
+	if item.Alive() {
+		t.Errorf("item.Alive() for missing item: want %t, got %t", false, item.Alive())
+	}
+
+	srv.SetItem(hn.Item{ID: 1, Type: "story", Title: "Hello"})
+	item, err = c.GetItem(1)
+	if err != nil {
+		t.Errorf("client.GetItem() received an error: %s", err.Error())
+	}
+	if item.Title != "Hello" {
+		t.Errorf("item.Title: want %s, got %s", "Hello", item.Title)
+	}
+
+	srv.RemoveItem(1)
+	item, err = c.GetItem(1)
+	if err != nil {
+		t.Errorf("client.GetItem() received an error: %s", err.Error())
+	}
+	if item.Alive() {
+		t.Errorf("item.Alive() for removed item: want %t, got %t", false, item.Alive())
+	}
+}
+
+func TestServer_users(t *testing.T) {
+	srv, err := NewFromFile("testdata/frontpage.json")
+	if err != nil {
+		t.Fatalf("NewFromFile() received an error: %s", err.Error())
+	}
+	defer srv.Close()
+
+	c := srv.Client()
+	user, err := c.GetUser("user1")
+	if err != nil {
+		t.Errorf("client.GetUser() received an error: %s", err.Error())
+	}
+	if user.ID != "user1" || user.Karma != 2937 {
+		t.Errorf("user: want user1 with karma %d, got %s with karma %d", 2937, user.ID, user.Karma)
+	}
+	user, err = c.GetUser("nobody")
+	if err != nil {
+		t.Errorf("client.GetUser() received an error: %s", err.Error())
+	}
+	if user.ID != "" {
+		t.Errorf("user.ID for missing user: want empty, got %s", user.ID)
+	}
+}
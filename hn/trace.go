@@ -0,0 +1,138 @@
+package hn
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+// Metrics collects the timings of the requests of the clients it is given
+// to with WithMetrics: DNS lookups, connection and TLS handshakes, and the
+// time to the first byte of the responses. It is safe for concurrent use.
+type Metrics struct {
+	mu       sync.Mutex
+	requests int64
+	reused   int64
+	dns      timing
+	connect  timing
+	tls      timing
+	ttfb     timing
+}
+
+type timing struct {
+	count int64
+	total time.Duration
+	max   time.Duration
+}
+
+func (t *timing) observe(d time.Duration) {
+	t.count++
+	t.total += d
+	if d > t.max {
+		t.max = d
+	}
+}
+
+func (t timing) stats() Timing {
+	s := Timing{Count: t.count, MaxMS: ms(t.max)}
+	if t.count > 0 {
+		s.MeanMS = ms(t.total / time.Duration(t.count))
+	}
+	return s
+}
+
+func ms(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// Timing summarizes the durations of a phase of the requests
+type Timing struct {
+	Count  int64   `json:"count"`
+	MeanMS float64 `json:"mean_ms"`
+	MaxMS  float64 `json:"max_ms"`
+}
+
+// MetricsStats is a snapshot of Metrics. Requests made on a reused
+// connection don't have DNS, connect or TLS timings.
+type MetricsStats struct {
+	Requests        int64  `json:"requests"`
+	ReusedConns     int64  `json:"reused_conns"`
+	DNS             Timing `json:"dns"`
+	Connect         Timing `json:"connect"`
+	TLSHandshake    Timing `json:"tls_handshake"`
+	TimeToFirstByte Timing `json:"time_to_first_byte"`
+}
+
+// Stats returns a snapshot of m
+func (m *Metrics) Stats() MetricsStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return MetricsStats{
+		Requests:        m.requests,
+		ReusedConns:     m.reused,
+		DNS:             m.dns.stats(),
+		Connect:         m.connect.stats(),
+		TLSHandshake:    m.tls.stats(),
+		TimeToFirstByte: m.ttfb.stats(),
+	}
+}
+
+func (m *Metrics) observe(t *timing, d time.Duration) {
+	m.mu.Lock()
+	t.observe(d)
+	m.mu.Unlock()
+}
+
+// trace returns ctx with an httptrace.ClientTrace recording the request
+// about to be made in m
+func (m *Metrics) trace(ctx context.Context) context.Context {
+	start := time.Now()
+	// connections to several addresses may be attempted at once
+	var mu sync.Mutex
+	var dnsStart, tlsStart time.Time
+	connectStarts := make(map[string]time.Time)
+	m.mu.Lock()
+	m.requests++
+	m.mu.Unlock()
+	return httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) {
+			dnsStart = time.Now()
+		},
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			m.observe(&m.dns, time.Since(dnsStart))
+		},
+		ConnectStart: func(network, addr string) {
+			mu.Lock()
+			connectStarts[addr] = time.Now()
+			mu.Unlock()
+		},
+		ConnectDone: func(network, addr string, err error) {
+			mu.Lock()
+			d := time.Since(connectStarts[addr])
+			mu.Unlock()
+			if err == nil {
+				m.observe(&m.connect, d)
+			}
+		},
+		TLSHandshakeStart: func() {
+			tlsStart = time.Now()
+		},
+		TLSHandshakeDone: func(_ tls.ConnectionState, err error) {
+			if err == nil {
+				m.observe(&m.tls, time.Since(tlsStart))
+			}
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			if info.Reused {
+				m.mu.Lock()
+				m.reused++
+				m.mu.Unlock()
+			}
+		},
+		GotFirstResponseByte: func() {
+			m.observe(&m.ttfb, time.Since(start))
+		},
+	})
+}
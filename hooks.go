@@ -0,0 +1,100 @@
+package main
+
+import (
+	"crypto/subtle"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/mmxmb/quiet_hn/notify"
+	"github.com/mmxmb/quiet_hn/router"
+)
+
+// hookWindow is how far from now the timestamp of a signed hook request may
+// be
+const hookWindow = 5 * time.Minute
+
+// hookJob is the status of an action run by a hook request
+type hookJob struct {
+	ID       string    `json:"id"`
+	Action   string    `json:"action"`
+	Status   string    `json:"status"` // done or failed
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished"`
+	Result   string    `json:"result,omitempty"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// hookRunner runs the tasks on behalf of incoming hooks, which let
+// schedulers such as cron or CI jobs refresh the front page, purge the cache
+// or run the cross-poster.
+type hookRunner struct {
+	secret string
+	tasks  *tasks
+	jobs   int64
+}
+
+// authorized reports whether r, with the given body, is authenticated with
+// the secret of the hooks: either as a bearer token, handy for curl in a
+// crontab, or as a signature like the one of the outgoing webhooks, of the
+// hookPayload rather than only the body.
+func (h *hookRunner) authorized(r *http.Request, body []byte) bool {
+	if token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "); token != r.Header.Get("Authorization") {
+		return subtle.ConstantTimeCompare([]byte(token), []byte(h.secret)) == 1
+	}
+	ts, sig := r.Header.Get(notify.TimestampHeader), r.Header.Get(notify.SignatureHeader)
+	return sig != "" && notify.Verify(h.secret, ts, sig, hookPayload(r.Method, r.RequestURI, body), hookWindow, time.Now()) == nil
+}
+
+// hookPayload returns what the hook requests are signed over: the method,
+// the request URI, with the action and its parameters, and the body, eg
+//
+//	POST /api/hooks/purge?key=top
+//	{}
+//
+// so that a captured request can't be replayed as another action or with
+// other parameters
+func hookPayload(method, requestURI string, body []byte) []byte {
+	return append([]byte(method+" "+requestURI+"\n"), body...)
+}
+
+// hookHandler serves POST /api/hooks/{action}, running one of the
+// taskActions with the query parameters and responding with its hookJob
+func hookHandler(h *hookRunner) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, 1<<20))
+		if err != nil {
+			writeAPIError(w, http.StatusRequestEntityTooLarge, "request body too large")
+			return
+		}
+		if !h.authorized(r, body) {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="quiet_hn hooks"`)
+			writeAPIError(w, http.StatusUnauthorized, "missing or invalid hook secret")
+			return
+		}
+		name := router.Param(r, "action")
+		action, ok := taskActions[name]
+		if !ok {
+			writeAPIError(w, http.StatusNotFound, "unknown action "+strconv.Quote(name))
+			return
+		}
+
+		job := hookJob{
+			ID:      strconv.FormatInt(atomic.AddInt64(&h.jobs, 1), 10),
+			Action:  name,
+			Started: time.Now().UTC(),
+		}
+		result, err := action(h.tasks, r.Context(), r.URL.Query())
+		job.Finished = time.Now().UTC()
+		job.Status, job.Result = "done", result
+		status := http.StatusOK
+		if err != nil {
+			job.Status, job.Error = "failed", err.Error()
+			status = http.StatusInternalServerError
+		}
+		writeJSONStatus(w, status, job)
+	})
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mmxmb/quiet_hn/notify"
+)
+
+func TestHookHandler(t *testing.T) {
+	cache := &Cache{ExpirationDuration: time.Hour}
+	cache.Set("stale", nil)
+	h := routed("/api/hooks/{action}", hookHandler(&hookRunner{
+		secret: "s3cret",
+		tasks:  &tasks{client: newFakeProvider(5), cache: cache, cfg: config{NumStories: 3, Concurrency: 2}},
+	}))
+	hook := func(path, token string) (int, hookJob) {
+		req := httptest.NewRequest(http.MethodPost, path, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		var job hookJob
+		json.Unmarshal(rec.Body.Bytes(), &job)
+		return rec.Code, job
+	}
+
+	if code, job := hook("/api/hooks/purge?key=stale", "s3cret"); code != http.StatusOK || job.Status != "done" || job.Result != "purged 1 lists" {
+		t.Errorf("purge: want %d and done, got %d and %+v", http.StatusOK, code, job)
+	}
+	if code, job := hook("/api/hooks/refresh", "s3cret"); code != http.StatusOK || job.Status != "done" || job.ID != "2" {
+		t.Errorf("refresh: want %d and job 2 done, got %d and %+v", http.StatusOK, code, job)
+	}
+	if cache.Get(newFilter(config{}, preferences{}).key()) == nil {
+		t.Errorf("refresh didn't cache the front page")
+	}
+	if code, job := hook("/api/hooks/post", "s3cret"); code != http.StatusInternalServerError || job.Status != "failed" {
+		t.Errorf("post without sinks: want %d and failed, got %d and %+v", http.StatusInternalServerError, code, job)
+	}
+	if code, _ := hook("/api/hooks/refresh", "wrong"); code != http.StatusUnauthorized {
+		t.Errorf("status code of a wrong secret: want %d, got %d", http.StatusUnauthorized, code)
+	}
+	if code, _ := hook("/api/hooks/refresh", ""); code != http.StatusUnauthorized {
+		t.Errorf("status code without a secret: want %d, got %d", http.StatusUnauthorized, code)
+	}
+	if code, _ := hook("/api/hooks/reboot", "s3cret"); code != http.StatusNotFound {
+		t.Errorf("status code of an unknown action: want %d, got %d", http.StatusNotFound, code)
+	}
+
+	signed := func(target, signedTarget string) int {
+		body := `{}`
+		ts := strconv.FormatInt(time.Now().Unix(), 10)
+		req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(body))
+		req.Header.Set(notify.TimestampHeader, ts)
+		req.Header.Set(notify.SignatureHeader, notify.Sign("s3cret", ts, hookPayload(http.MethodPost, signedTarget, []byte(body))))
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	if code := signed("/api/hooks/purge?key=top", "/api/hooks/purge?key=top"); code != http.StatusOK {
+		t.Errorf("status code of a signed request: want %d, got %d", http.StatusOK, code)
+	}
+	if code := signed("/api/hooks/refresh", "/api/hooks/purge"); code != http.StatusUnauthorized {
+		t.Errorf("status code of a request signed for another action: want %d, got %d", http.StatusUnauthorized, code)
+	}
+	if code := signed("/api/hooks/purge?key=", "/api/hooks/purge?key=top"); code != http.StatusUnauthorized {
+		t.Errorf("status code of a request signed with other parameters: want %d, got %d", http.StatusUnauthorized, code)
+	}
+}
@@ -0,0 +1,199 @@
+// Package i18n implements message catalogs for translating the UI.
+//
+// Catalogs are JSON files mapping message keys to fmt format strings, named
+// after the language they translate to, eg en.json or pt-BR.json:
+//
+//	{
+//	  "rendered_in": "This page was rendered in %s",
+//	  "points.one": "%d point",
+//	  "points.other": "%d points"
+//	}
+//
+// Messages that depend on a count are stored under the key followed by
+// ".one" and ".other" and looked up with Plural.
+package i18n
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Bundle is a set of catalogs, one per language, with a default language
+// used for unsupported languages and missing messages.
+type Bundle struct {
+	defaultLang string
+	catalogs    map[string]map[string]string
+}
+
+// NewBundle returns an empty Bundle with the given default language.
+func NewBundle(defaultLang string) *Bundle {
+	return &Bundle{
+		defaultLang: defaultLang,
+		catalogs:    make(map[string]map[string]string),
+	}
+}
+
+// LoadDir returns a Bundle with all the catalogs (*.json files) in dir. The
+// catalog of defaultLang must be one of them.
+func LoadDir(dir, defaultLang string) (*Bundle, error) {
+	return LoadFS(defaultLang, os.DirFS(dir))
+}
+
+// LoadFS returns a Bundle with all the catalogs (*.json files) at the root of
+// the file systems fsyss, the messages of the later ones replacing the ones
+// of the earlier ones with the same language and key, eg to override some of
+// the embedded catalogs. The catalog of defaultLang must be one of them.
+func LoadFS(defaultLang string, fsyss ...fs.FS) (*Bundle, error) {
+	b := NewBundle(defaultLang)
+	for _, fsys := range fsyss {
+		paths, err := fs.Glob(fsys, "*.json")
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range paths {
+			data, err := fs.ReadFile(fsys, p)
+			if err != nil {
+				return nil, err
+			}
+			var messages map[string]string
+			if err := json.Unmarshal(data, &messages); err != nil {
+				return nil, fmt.Errorf("i18n: loading %s: %w", p, err)
+			}
+			b.Add(strings.TrimSuffix(path.Base(p), ".json"), messages)
+		}
+	}
+	if _, ok := b.catalogs[defaultLang]; !ok {
+		return nil, fmt.Errorf("i18n: no catalog for the default language %q", defaultLang)
+	}
+	return b, nil
+}
+
+// Add adds the messages of lang to the bundle, replacing existing ones with
+// the same key.
+func (b *Bundle) Add(lang string, messages map[string]string) {
+	lang = canonical(lang)
+	catalog, ok := b.catalogs[lang]
+	if !ok {
+		catalog = make(map[string]string, len(messages))
+		b.catalogs[lang] = catalog
+	}
+	for key, msg := range messages {
+		catalog[key] = msg
+	}
+}
+
+// DefaultLanguage returns the default language of the bundle.
+func (b *Bundle) DefaultLanguage() string {
+	return b.defaultLang
+}
+
+// Languages returns the languages with a catalog, sorted.
+func (b *Bundle) Languages() []string {
+	langs := make([]string, 0, len(b.catalogs))
+	for lang := range b.catalogs {
+		langs = append(langs, lang)
+	}
+	sort.Strings(langs)
+	return langs
+}
+
+// Match returns the first of the preferred languages with a catalog. A
+// language also matches the catalog of its base language, eg "de-AT" matches
+// "de". The default language is returned if none match.
+func (b *Bundle) Match(preferred ...string) string {
+	for _, lang := range preferred {
+		lang = canonical(lang)
+		if _, ok := b.catalogs[lang]; ok {
+			return lang
+		}
+		if i := strings.Index(lang, "-"); i > 0 {
+			if _, ok := b.catalogs[lang[:i]]; ok {
+				return lang[:i]
+			}
+		}
+	}
+	return b.defaultLang
+}
+
+// Translate returns the message key in lang formatted with args. Messages
+// missing from the catalog of lang are taken from the default language, and
+// the key itself is returned if that is missing too.
+func (b *Bundle) Translate(lang, key string, args ...interface{}) string {
+	msg, ok := b.catalogs[lang][key]
+	if !ok {
+		msg, ok = b.catalogs[b.defaultLang][key]
+	}
+	if !ok {
+		return key
+	}
+	if len(args) == 0 {
+		return msg
+	}
+	return fmt.Sprintf(msg, args...)
+}
+
+// Plural translates the message key with the form matching n, which is the
+// first argument of the message followed by args.
+func (b *Bundle) Plural(lang, key string, n int, args ...interface{}) string {
+	form := ".other"
+	if n == 1 {
+		form = ".one"
+	}
+	return b.Translate(lang, key+form, append([]interface{}{n}, args...)...)
+}
+
+// ParseAcceptLanguage returns the languages listed in an Accept-Language
+// header, most preferred first.
+func ParseAcceptLanguage(header string) []string {
+	type weighted struct {
+		lang string
+		q    float64
+	}
+	var langs []weighted
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(strings.TrimSpace(part), ";")
+		lang := strings.TrimSpace(fields[0])
+		if lang == "" || lang == "*" {
+			continue
+		}
+		q := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if v, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					q = v
+				}
+			}
+		}
+		if q > 0 {
+			langs = append(langs, weighted{lang: lang, q: q})
+		}
+	}
+	sort.SliceStable(langs, func(i, j int) bool {
+		return langs[i].q > langs[j].q
+	})
+	ret := make([]string, len(langs))
+	for i, l := range langs {
+		ret[i] = l.lang
+	}
+	return ret
+}
+
+// canonical returns the canonical form of a language tag: lower case language
+// and upper case region, eg "pt-BR"
+func canonical(lang string) string {
+	parts := strings.Split(strings.Replace(lang, "_", "-", -1), "-")
+	parts[0] = strings.ToLower(parts[0])
+	for i := 1; i < len(parts); i++ {
+		if len(parts[i]) == 2 {
+			parts[i] = strings.ToUpper(parts[i])
+		}
+	}
+	return strings.Join(parts, "-")
+}
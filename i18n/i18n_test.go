@@ -0,0 +1,122 @@
+package i18n
+
+import (
+	"reflect"
+	"testing"
+	"testing/fstest"
+)
+
+func testBundle() *Bundle {
+	b := NewBundle("en")
+	b.Add("en", map[string]string{
+		"hello":        "Hello",
+		"rendered_in":  "Rendered in %s",
+		"points.one":   "%d point",
+		"points.other": "%d points",
+	})
+	b.Add("de", map[string]string{
+		"hello":        "Hallo",
+		"points.one":   "%d Punkt",
+		"points.other": "%d Punkte",
+	})
+	return b
+}
+
+func TestBundle_Translate(t *testing.T) {
+	b := testBundle()
+	tests := []struct {
+		lang, key string
+		args      []interface{}
+		want      string
+	}{
+		{"en", "hello", nil, "Hello"},
+		{"de", "hello", nil, "Hallo"},
+		{"de", "rendered_in", []interface{}{"1s"}, "Rendered in 1s"},
+		{"fr", "hello", nil, "Hello"},
+		{"en", "missing", nil, "missing"},
+	}
+	for _, tc := range tests {
+		if got := b.Translate(tc.lang, tc.key, tc.args...); got != tc.want {
+			t.Errorf("Translate(%s, %s): want %q, got %q", tc.lang, tc.key, tc.want, got)
+		}
+	}
+}
+
+func TestBundle_Plural(t *testing.T) {
+	b := testBundle()
+	if got := b.Plural("en", "points", 1); got != "1 point" {
+		t.Errorf("Plural(en, points, 1): want %q, got %q", "1 point", got)
+	}
+	if got := b.Plural("de", "points", 3); got != "3 Punkte" {
+		t.Errorf("Plural(de, points, 3): want %q, got %q", "3 Punkte", got)
+	}
+}
+
+func TestBundle_Match(t *testing.T) {
+	b := testBundle()
+	tests := []struct {
+		preferred []string
+		want      string
+	}{
+		{[]string{"de"}, "de"},
+		{[]string{"de-AT", "en"}, "de"},
+		{[]string{"fr", "DE"}, "de"},
+		{[]string{"fr"}, "en"},
+		{nil, "en"},
+	}
+	for _, tc := range tests {
+		if got := b.Match(tc.preferred...); got != tc.want {
+			t.Errorf("Match(%v): want %s, got %s", tc.preferred, tc.want, got)
+		}
+	}
+}
+
+func TestParseAcceptLanguage(t *testing.T) {
+	got := ParseAcceptLanguage("fr-CH, fr;q=0.9, en;q=0.8, de;q=0.95, *;q=0.5, it;q=0")
+	want := []string{"fr-CH", "de", "fr", "en"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseAcceptLanguage(): want %v, got %v", want, got)
+	}
+}
+
+func TestLoadDir(t *testing.T) {
+	b, err := LoadDir("../locales", "en")
+	if err != nil {
+		t.Fatalf("LoadDir() received an error: %s", err.Error())
+	}
+	if len(b.Languages()) < 2 {
+		t.Errorf("b.Languages(): want at least 2 languages, got %v", b.Languages())
+	}
+	// every language should translate every message of the default one
+	for _, lang := range b.Languages() {
+		for key := range b.catalogs["en"] {
+			if _, ok := b.catalogs[lang][key]; !ok {
+				t.Errorf("catalog %s is missing %s", lang, key)
+			}
+		}
+	}
+}
+
+func TestLoadFS(t *testing.T) {
+	base := fstest.MapFS{"en.json": {Data: []byte(`{"hello": "Hello", "bye": "Bye"}`)}}
+	override := fstest.MapFS{
+		"en.json": {Data: []byte(`{"hello": "Hi"}`)},
+		"fr.json": {Data: []byte(`{"hello": "Salut"}`)},
+	}
+	b, err := LoadFS("en", base, override)
+	if err != nil {
+		t.Fatalf("LoadFS() received an error: %s", err.Error())
+	}
+	if got := b.Translate("en", "hello"); got != "Hi" {
+		t.Errorf("Translate(en, hello): want the overridden message, got %q", got)
+	}
+	if got := b.Translate("en", "bye"); got != "Bye" {
+		t.Errorf("Translate(en, bye): want the message that isn't overridden, got %q", got)
+	}
+	if got := b.Translate("fr", "hello"); got != "Salut" {
+		t.Errorf("Translate(fr, hello): want the added language, got %q", got)
+	}
+	if _, err := LoadFS("de", base); err == nil {
+		t.Errorf("LoadFS() without the default language: want an error")
+	}
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/mmxmb/quiet_hn/hn"
+	"github.com/mmxmb/quiet_hn/router"
+)
+
+// itemHandler serves the page of an item. trees is nil if comments are
+// disabled, and discussions if the earlier discussions of stories aren't
+// looked up.
+func itemHandler(client StoryProvider, cfg config, trees *commentTrees, discussions discussionFinder, tpls *templateSet) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		// /item/{id}, or /item?id= like on HN
+		rawID := router.Param(r, "id")
+		if rawID == "" {
+			rawID = r.URL.Query().Get("id")
+		}
+		id, err := strconv.Atoi(rawID)
+		if err != nil || id <= 0 {
+			tpls.renderError(w, r, http.StatusBadRequest, "error.invalid_item_id")
+			return
+		}
+		// the permalinks of comments, like on HN
+		if rawCID := r.URL.Query().Get("comment"); rawCID != "" {
+			cid, err := strconv.Atoi(rawCID)
+			if err != nil || cid <= 0 {
+				tpls.renderError(w, r, http.StatusBadRequest, "error.invalid_item_id")
+				return
+			}
+			serveComment(w, r, client, cfg, trees, tpls, cid)
+			return
+		}
+
+		prefs, err := loadPreferences(w, r, cfg.Defaults)
+		if err != nil {
+			tpls.renderQueryError(w, r, err)
+			return
+		}
+		q := newQueryParams(r)
+		view := newThreadView(r, q)
+		page := q.Int("page", 1, 1, maxCommentPage)
+		if err := q.Err(); err != nil {
+			tpls.renderQueryError(w, r, err)
+			return
+		}
+		hnItem, err := client.GetItem(id)
+		if err != nil {
+			tpls.renderError(w, r, http.StatusInternalServerError, "error.load_item")
+			return
+		}
+		if !hnItem.Alive() {
+			tpls.renderError(w, r, http.StatusNotFound, "error.not_found")
+			return
+		}
+
+		data := itemTemplateData{
+			Item:       decorateItem(cfg, parseHNItem(hnItem)),
+			View:       view,
+			Orders:     commentOrders,
+			KeyboardJS: cfg.KeyboardJS,
+			Order:      prefs.CommentOrder,
+			Lang:       languagePref(w, r, cfg.Messages),
+			TZ:         prefs.Timezone,
+			// the og: meta tags need absolute URLs
+			BaseURL: baseURL(r),
+		}
+		if hnItem.Type == "poll" {
+			data.PollOptions, err = client.GetItems(r.Context(), hnItem.Parts, cfg.Concurrency)
+			if err != nil {
+				tpls.renderError(w, r, http.StatusInternalServerError, "error.load_item")
+				return
+			}
+		}
+		if trees != nil {
+			var more bool
+			if view.Query != "" {
+				// the matches can be on any page
+				data.Comments, err = trees.Get(r.Context(), hnItem)
+			} else {
+				data.Comments, more, err = trees.Page(r.Context(), hnItem, page)
+			}
+			if err != nil {
+				tpls.renderError(w, r, http.StatusInternalServerError, "error.load_item")
+				return
+			}
+			if view.Query != "" {
+				data.Comments = filterComments(data.Comments, view.Query)
+			}
+			data.Comments = sortComments(data.Comments, prefs.CommentOrder)
+			if page > 1 && view.Query == "" {
+				data.PrevPage = commentPageURL(r, page-1)
+			}
+			if more {
+				data.NextPage = commentPageURL(r, page+1)
+			}
+		}
+		if discussions != nil && hnItem.Type == "story" {
+			// the page beats failing for want of the earlier discussions
+			ctx, cancel := context.WithTimeout(r.Context(), discussionsTimeout)
+			data.Discussions, err = discussions.Discussions(ctx, data.Item)
+			cancel()
+			if err != nil {
+				log.Printf("looking up the discussions of item %d: %s", id, err)
+			}
+		}
+		data.Time = time.Now().Sub(start)
+
+		err = tpls.render(w, "item", data)
+		if err != nil {
+			http.Error(w, "Failed to process the template", http.StatusInternalServerError)
+			return
+		}
+	})
+}
+
+// maxCommentPage bounds the page query parameter of item pages
+const maxCommentPage = 10000
+
+// commentPageURL returns the URL of the page-th page of the comments of the
+// item page of r
+func commentPageURL(r *http.Request, page int) string {
+	u := *r.URL
+	q := u.Query()
+	if page > 1 {
+		q.Set("page", strconv.Itoa(page))
+	} else {
+		q.Del("page")
+	}
+	u.RawQuery = q.Encode()
+	return u.RequestURI()
+}
+
+// decorateItem returns itm decorated like the stories of the front page
+func decorateItem(cfg config, itm item) item {
+	decorated := []item{itm}
+	cfg.Archive.decorate(decorated)
+	markPaywalled(decorated, cfg.PaywallDomains)
+	if cfg.Redirect {
+		redirectLinks(decorated)
+	}
+	return decorated[0]
+}
+
+// commentHandler serves /comment/{id}, the permalink of a comment: the
+// comment and its replies, below the story they are part of. trees is nil if
+// comments are disabled, in which case the replies aren't rendered.
+func commentHandler(client StoryProvider, cfg config, trees *commentTrees, tpls *templateSet) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(router.Param(r, "id"))
+		if err != nil || id <= 0 {
+			tpls.renderError(w, r, http.StatusBadRequest, "error.invalid_item_id")
+			return
+		}
+		serveComment(w, r, client, cfg, trees, tpls, id)
+	})
+}
+
+// serveComment renders the item page of the story of the comment id, with
+// only the subtree of the comment
+func serveComment(w http.ResponseWriter, r *http.Request, client StoryProvider, cfg config, trees *commentTrees, tpls *templateSet, id int) {
+	start := time.Now()
+
+	prefs, err := loadPreferences(w, r, cfg.Defaults)
+	if err != nil {
+		tpls.renderQueryError(w, r, err)
+		return
+	}
+	q := newQueryParams(r)
+	view := newThreadView(r, q)
+	if err := q.Err(); err != nil {
+		tpls.renderQueryError(w, r, err)
+		return
+	}
+	hnItem, err := client.GetItem(id)
+	if err != nil {
+		tpls.renderError(w, r, http.StatusInternalServerError, "error.load_item")
+		return
+	}
+	if !hnItem.Alive() || hnItem.Type != "comment" {
+		tpls.renderError(w, r, http.StatusNotFound, "error.not_found")
+		return
+	}
+	story, parent, err := threadOf(client, hnItem)
+	if err != nil {
+		tpls.renderError(w, r, http.StatusInternalServerError, "error.load_item")
+		return
+	}
+
+	root := newComment(hnItem, story.By, cfg.Comments.NotableUsers)
+	if trees != nil {
+		// the subtree is the one of the comment in the thread of the story
+		sub := hnItem
+		sub.By = story.By
+		if root.Replies, err = trees.Get(r.Context(), sub); err != nil {
+			tpls.renderError(w, r, http.StatusInternalServerError, "error.load_item")
+			return
+		}
+		if view.Query != "" {
+			root.Replies = filterComments(root.Replies, view.Query)
+		}
+	}
+	data := itemTemplateData{
+		Item:       decorateItem(cfg, parseHNItem(story)),
+		Comments:   []*comment{root},
+		Focus:      id,
+		KeyboardJS: cfg.KeyboardJS,
+		Parent:     parent,
+		View:       view,
+		Lang:       languagePref(w, r, cfg.Messages),
+		TZ:         prefs.Timezone,
+		BaseURL:    baseURL(r),
+		Time:       time.Now().Sub(start),
+	}
+	if err := tpls.render(w, "item", data); err != nil {
+		http.Error(w, "Failed to process the template", http.StatusInternalServerError)
+	}
+}
+
+type itemTemplateData struct {
+	Item        item
+	PollOptions []hn.Item
+	Comments    []*comment
+	// Focus is the ID of the comment whose permalink the page is, with
+	// only its subtree in Comments, and Parent the comment it replies to,
+	// if it isn't a top level comment
+	Focus  int
+	Parent int
+	// View are the comments collapsed and searched
+	View threadView
+	// Orders are the orders the comments can be sorted in, Order the one
+	// they are, or empty if they can't be sorted
+	Orders []string
+	Order  string
+	// PrevPage and NextPage are the URLs of the previous and next pages of
+	// comments, if any
+	PrevPage string
+	NextPage string
+	// Discussions are the earlier discussions of the story
+	Discussions []discussion
+	// KeyboardJS is set if the page links to the keyboard navigation
+	KeyboardJS bool
+	Lang       string
+	TZ         string
+	BaseURL    string
+	Time       time.Duration
+}
@@ -0,0 +1,296 @@
+// Package jobs is a small queue of background work: a pool of workers runs
+// the jobs submitted, retrying the failed ones with an exponential backoff
+// and writing those failing past their retries to a dead-letter log. It also
+// runs the long lived loops of the server, so that everything running in the
+// background stops with the queue.
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"time"
+)
+
+// maxDead is the number of failures kept for Dead
+const maxDead = 100
+
+// Job is a unit of background work
+type Job struct {
+	// Name identifies the job in the logs, eg "enrich https://example.com"
+	Name string
+	Run  func(ctx context.Context) error
+	// Retries is how many more times the job is run after failing
+	Retries int
+	// Delay is how long to wait before running the job the first time
+	Delay time.Duration
+}
+
+// Failure is a job which failed past its retries
+type Failure struct {
+	Name     string    `json:"name"`
+	Attempts int       `json:"attempts"`
+	Error    string    `json:"error"`
+	Time     time.Time `json:"time"`
+}
+
+// Config configures a Queue
+type Config struct {
+	// Workers is the number of jobs run concurrently, 1 if not set
+	Workers int
+	// Size is the number of jobs which may wait for a worker, past which
+	// Submit drops them
+	Size int
+	// Backoff is how long to wait before the first retry of a job, doubled
+	// for each retry after that. It defaults to a second.
+	Backoff time.Duration
+	// Timeout, if set, bounds each run of a job
+	Timeout time.Duration
+	// DeadLetter is where the failures are written, as JSON lines. They are
+	// logged if it is nil.
+	DeadLetter io.Writer
+}
+
+// Stats are the counters of a Queue
+type Stats struct {
+	Queued  int   `json:"queued"`
+	Running int   `json:"running"`
+	Loops   int   `json:"loops"`
+	Done    int64 `json:"done"`
+	Retried int64 `json:"retried"`
+	Failed  int64 `json:"failed"`
+	// Dropped counts the jobs submitted while the queue was full or closed
+	Dropped int64 `json:"dropped"`
+}
+
+// attempt is a job and how many times it was run
+type attempt struct {
+	job Job
+	n   int
+}
+
+// Queue runs jobs on a pool of workers. Its methods are safe to call
+// concurrently.
+type Queue struct {
+	cfg     Config
+	pending chan attempt
+	ctx     context.Context
+	cancel  context.CancelFunc
+	// workers tracks the workers and loops, and timers the jobs waiting to
+	// be queued
+	workers sync.WaitGroup
+	timers  sync.WaitGroup
+
+	mu     sync.Mutex
+	closed bool
+	stats  Stats
+	dead   []Failure
+}
+
+// New returns a Queue whose workers are started
+func New(cfg Config) *Queue {
+	if cfg.Workers < 1 {
+		cfg.Workers = 1
+	}
+	if cfg.Backoff <= 0 {
+		cfg.Backoff = time.Second
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	q := &Queue{cfg: cfg, pending: make(chan attempt, cfg.Size), ctx: ctx, cancel: cancel}
+	q.workers.Add(cfg.Workers)
+	for i := 0; i < cfg.Workers; i++ {
+		go q.work()
+	}
+	return q
+}
+
+// Submit queues j, after its Delay if any, and reports whether it was
+// accepted: jobs are dropped rather than waited on when the queue is full.
+func (q *Queue) Submit(j Job) bool {
+	if j.Delay > 0 {
+		return q.after(j.Delay, attempt{job: j})
+	}
+	return q.enqueue(attempt{job: j})
+}
+
+func (q *Queue) enqueue(a attempt) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if !q.closed {
+		select {
+		case q.pending <- a:
+			q.stats.Queued++
+			return true
+		default:
+		}
+	}
+	q.stats.Dropped++
+	return false
+}
+
+// after queues a once delay has passed, unless the queue is closed by then
+func (q *Queue) after(delay time.Duration, a attempt) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		q.stats.Dropped++
+		return false
+	}
+	q.timers.Add(1)
+	go func() {
+		defer q.timers.Done()
+		t := time.NewTimer(delay)
+		defer t.Stop()
+		select {
+		case <-t.C:
+			q.enqueue(a)
+		case <-q.ctx.Done():
+			q.mu.Lock()
+			q.stats.Dropped++
+			q.mu.Unlock()
+		}
+	}()
+	return true
+}
+
+func (q *Queue) work() {
+	defer q.workers.Done()
+	for a := range q.pending {
+		q.mu.Lock()
+		q.stats.Queued--
+		q.stats.Running++
+		q.mu.Unlock()
+
+		a.n++
+		err := q.run(a.job)
+
+		q.mu.Lock()
+		q.stats.Running--
+		switch {
+		case err == nil:
+			q.stats.Done++
+		case a.n <= a.job.Retries && q.ctx.Err() == nil:
+			q.stats.Retried++
+		default:
+			q.stats.Failed++
+		}
+		q.mu.Unlock()
+		if err == nil {
+			continue
+		}
+		if a.n <= a.job.Retries && q.ctx.Err() == nil {
+			q.after(q.cfg.Backoff<<(a.n-1), a)
+			continue
+		}
+		q.deadLetter(Failure{Name: a.job.Name, Attempts: a.n, Error: err.Error(), Time: time.Now().UTC()})
+	}
+}
+
+// run runs j, turning its panics into errors so that one bad job doesn't
+// take the server down
+func (q *Queue) run(j Job) (err error) {
+	ctx := q.ctx
+	if q.cfg.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, q.cfg.Timeout)
+		defer cancel()
+	}
+	defer func() {
+		if v := recover(); v != nil {
+			err = fmt.Errorf("panic: %v", v)
+		}
+	}()
+	return j.Run(ctx)
+}
+
+func (q *Queue) deadLetter(f Failure) {
+	q.mu.Lock()
+	q.dead = append(q.dead, f)
+	if len(q.dead) > maxDead {
+		q.dead = q.dead[len(q.dead)-maxDead:]
+	}
+	q.mu.Unlock()
+	if q.cfg.DeadLetter == nil {
+		log.Printf("job %s failed after %d attempts: %s", f.Name, f.Attempts, f.Error)
+		return
+	}
+	b, _ := json.Marshal(f)
+	q.mu.Lock()
+	_, err := q.cfg.DeadLetter.Write(append(b, '\n'))
+	q.mu.Unlock()
+	if err != nil {
+		log.Printf("writing the failure of job %s to the dead-letter log: %s", f.Name, err)
+	}
+}
+
+// Go runs loop in its own goroutine until it returns, with a context done
+// when the queue is closed. It is meant for the long lived loops of the
+// server, such as the ones running something periodically until their
+// context is done; a panic ends the loop without taking the server down.
+func (q *Queue) Go(name string, loop func(ctx context.Context)) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return
+	}
+	q.stats.Loops++
+	q.workers.Add(1)
+	go func() {
+		defer func() {
+			if v := recover(); v != nil {
+				log.Printf("loop %s panicked: %v", name, v)
+			}
+			q.mu.Lock()
+			q.stats.Loops--
+			q.mu.Unlock()
+			q.workers.Done()
+		}()
+		loop(q.ctx)
+	}()
+}
+
+// Stats returns the counters of q
+func (q *Queue) Stats() Stats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.stats
+}
+
+// Dead returns the last failures, oldest first
+func (q *Queue) Dead() []Failure {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return append([]Failure(nil), q.dead...)
+}
+
+// Close stops accepting jobs, cancels the context of the ones running and
+// of the loops, and waits up to timeout for them to return. The jobs still
+// queued are run with the cancelled context, so they return quickly.
+func (q *Queue) Close(timeout time.Duration) error {
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		return nil
+	}
+	q.closed = true
+	q.mu.Unlock()
+	q.cancel()
+
+	done := make(chan struct{})
+	go func() {
+		// the timers hold off closing pending until they have given up
+		q.timers.Wait()
+		close(q.pending)
+		q.workers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-time.After(timeout):
+		return fmt.Errorf("jobs: still running after %s", timeout)
+	}
+}
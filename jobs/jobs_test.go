@@ -0,0 +1,103 @@
+package jobs
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestQueue_retries(t *testing.T) {
+	var dead bytes.Buffer
+	q := New(Config{Workers: 2, Size: 10, Backoff: time.Millisecond, DeadLetter: &dead})
+	var mu sync.Mutex
+	runs := map[string]int{}
+	done := make(chan struct{}, 2)
+	run := func(name string, failures int) func(ctx context.Context) error {
+		return func(ctx context.Context) error {
+			mu.Lock()
+			defer mu.Unlock()
+			runs[name]++
+			if runs[name] <= failures {
+				if runs[name] == failures && name == "hopeless" {
+					done <- struct{}{}
+				}
+				return errors.New("try again")
+			}
+			done <- struct{}{}
+			return nil
+		}
+	}
+	q.Submit(Job{Name: "flaky", Run: run("flaky", 2), Retries: 2})
+	q.Submit(Job{Name: "hopeless", Run: run("hopeless", 2), Retries: 1})
+	for i := 0; i < 2; i++ {
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatal("the jobs didn't complete")
+		}
+	}
+	if err := q.Close(time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	if runs["flaky"] != 3 || runs["hopeless"] != 2 {
+		t.Errorf("runs: want 3 and 2, got %v", runs)
+	}
+	s := q.Stats()
+	if s.Done != 1 || s.Retried != 3 || s.Failed != 1 {
+		t.Errorf("stats: want 1 done, 3 retried and 1 failed, got %+v", s)
+	}
+	var f Failure
+	if err := json.Unmarshal(dead.Bytes(), &f); err != nil || f.Name != "hopeless" || f.Attempts != 2 {
+		t.Errorf("dead-letter log: want the failure of hopeless after 2 attempts, got %q (%v)", dead.String(), err)
+	}
+	if got := q.Dead(); len(got) != 1 || got[0].Error != "try again" {
+		t.Errorf("Dead(): want one failure, got %v", got)
+	}
+}
+
+func TestQueue_full(t *testing.T) {
+	q := New(Config{Workers: 1, Size: 1})
+	block := make(chan struct{})
+	started := make(chan struct{})
+	q.Submit(Job{Name: "busy", Run: func(ctx context.Context) error {
+		close(started)
+		<-block
+		return nil
+	}})
+	<-started
+	if !q.Submit(Job{Name: "queued", Run: func(ctx context.Context) error { return nil }}) {
+		t.Errorf("a job was dropped while the queue had room")
+	}
+	if q.Submit(Job{Name: "dropped", Run: func(ctx context.Context) error { return nil }}) {
+		t.Errorf("a job was accepted while the queue was full")
+	}
+	close(block)
+	q.Close(time.Second)
+	if s := q.Stats(); s.Dropped != 1 || s.Done != 2 {
+		t.Errorf("stats: want 1 dropped and 2 done, got %+v", s)
+	}
+	if q.Submit(Job{Name: "late", Run: func(ctx context.Context) error { return nil }}) {
+		t.Errorf("a job was accepted after Close")
+	}
+}
+
+func TestQueue_panicsAndLoops(t *testing.T) {
+	q := New(Config{Workers: 1, Size: 1})
+	q.Go("ticker", func(ctx context.Context) { <-ctx.Done() })
+	q.Go("broken", func(ctx context.Context) { panic("oops") })
+	q.Submit(Job{Name: "panics", Run: func(ctx context.Context) error { panic("oops") }})
+	if err := q.Close(time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if got := q.Dead(); len(got) != 1 || got[0].Error != "panic: oops" {
+		t.Errorf("Dead(): want the panic, got %v", got)
+	}
+	if s := q.Stats(); s.Loops != 0 {
+		t.Errorf("loops still running after Close: %d", s.Loops)
+	}
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	_ "embed"
+	"net/http"
+	"strconv"
+)
+
+// keysJS is the script adding the keyboard navigation of the pages, j and k
+// to move between the stories or comments and o or enter to open them
+//
+//go:embed static/keys.js
+var keysJS []byte
+
+// keysJSHandler serves /keys.js, the optional keyboard navigation, which the
+// pages link to with the version of the binary so it can be cached for good
+func keysJSHandler() http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/javascript; charset=utf-8")
+		w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+		w.Header().Set("Content-Length", strconv.Itoa(len(keysJS)))
+		w.Write(keysJS)
+	})
+}
@@ -0,0 +1,331 @@
+// Package leader elects one replica among several to run the background
+// work that must only run once, such as recording the front page or posting
+// to the notifiers, while every replica serves requests.
+//
+// The election is a lease: the leader renews it well before it expires, and
+// another replica takes it over once it has. A leader which can't renew its
+// lease, eg because it lost access to the backend, steps down before the
+// lease expires so that two replicas never lead at once, clocks permitting.
+package leader
+
+import (
+	"context"
+	crand "crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"os"
+	"sync"
+	"time"
+)
+
+// Backend stores the lease
+type Backend interface {
+	// Acquire takes the lease for id until now+ttl if it is free or expired,
+	// or renews it if id holds it already, and reports whether id holds it
+	Acquire(ctx context.Context, id string, ttl time.Duration, now time.Time) (bool, error)
+	// Release gives up the lease if id holds it
+	Release(ctx context.Context, id string) error
+}
+
+// Elector takes part in the election on behalf of a replica. Its methods are
+// safe to call concurrently.
+type Elector struct {
+	Backend Backend
+	// ID identifies the replica and must be unique
+	ID string
+	// TTL is how long the lease lasts; it is renewed every third of it,
+	// shortened by up to a tenth so that the replicas don't all try at once
+	TTL time.Duration
+
+	mu      sync.Mutex
+	leading bool
+	// changed is closed, and replaced, whenever leading changes
+	changed chan struct{}
+}
+
+// Leading reports whether the replica currently leads
+func (e *Elector) Leading() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.leading
+}
+
+// watch returns whether the replica leads and a channel closed when that
+// changes
+func (e *Elector) watch() (bool, <-chan struct{}) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.changed == nil {
+		e.changed = make(chan struct{})
+	}
+	return e.leading, e.changed
+}
+
+func (e *Elector) set(leading bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.leading == leading {
+		return
+	}
+	e.leading = leading
+	if e.changed != nil {
+		close(e.changed)
+	}
+	e.changed = make(chan struct{})
+	if leading {
+		log.Printf("%s is now the leader", e.ID)
+	} else {
+		log.Printf("%s is no longer the leader", e.ID)
+	}
+}
+
+// Run takes part in the election until ctx is done, then releases the lease
+// if the replica holds it
+func (e *Elector) Run(ctx context.Context) {
+	var renewed time.Time
+	for {
+		now := time.Now()
+		next := e.TTL / 3
+		ok, err := e.Backend.Acquire(ctx, e.ID, e.TTL, now)
+		switch {
+		case err == nil:
+			if ok {
+				renewed = now
+			}
+			e.set(ok)
+		case errors.Is(err, errLocked) && (!e.Leading() || now.Sub(renewed) < e.TTL*2/3):
+			// another replica is reading the lease, which isn't lost to it
+			// while it's ours: try again shortly
+			next = e.TTL / 10
+		case e.Leading() && now.Sub(renewed) >= e.TTL*2/3:
+			// step down while the lease is still ours, before another
+			// replica may take it
+			log.Printf("renewing the leader lease: %s", err)
+			e.set(false)
+		default:
+			log.Printf("acquiring the leader lease: %s", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			if e.Leading() {
+				e.set(false)
+				// ctx is done, the release gets a context of its own
+				rctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				if err := e.Backend.Release(rctx, e.ID); err != nil {
+					log.Printf("releasing the leader lease: %s", err)
+				}
+				cancel()
+			}
+			return
+		case <-time.After(next - time.Duration(rand.Float64()*float64(next)/10)):
+		}
+	}
+}
+
+// Lead runs loop while the replica leads, until ctx is done: loop gets a
+// context which is done when the replica stops leading, and is run again
+// when it leads again. A nil Elector always leads.
+func (e *Elector) Lead(ctx context.Context, loop func(ctx context.Context)) {
+	if e == nil {
+		loop(ctx)
+		return
+	}
+	for {
+		leading, changed := e.watch()
+		if !leading {
+			select {
+			case <-ctx.Done():
+				return
+			case <-changed:
+				continue
+			}
+		}
+		lctx, cancel := context.WithCancel(ctx)
+		done := make(chan struct{})
+		go func() {
+			loop(lctx)
+			close(done)
+		}()
+		select {
+		case <-changed:
+		case <-ctx.Done():
+		case <-done:
+			// the loop returned on its own
+			cancel()
+			return
+		}
+		cancel()
+		<-done
+		if ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+// lease is the content of a lease file
+type lease struct {
+	Holder  string    `json:"holder"`
+	Expires time.Time `json:"expires"`
+}
+
+// FileBackend keeps the lease in a file, for replicas sharing a volume. A
+// lock file next to it, Path+".lock", guards its updates.
+type FileBackend struct {
+	Path string
+}
+
+// errLocked is the error of a lock file held by another replica, which is
+// updating the lease
+var errLocked = errors.New("leader: the lease file is locked")
+
+// lockAttempts is how many times lock tries to create the lock file, a
+// millisecond or so apart, before giving up with errLocked
+const lockAttempts = 5
+
+// staleLock is how old a lock file must be to be taken for one left over by
+// a replica which died while holding it, since the lock is only held for a
+// read and a write
+const staleLock = time.Minute
+
+// lock creates the lock file, holding a nonce of its own, and returns the
+// function removing it. A lock file left over by a replica which died while
+// holding it is taken over first.
+func (b FileBackend) lock(ctx context.Context, now time.Time) (func(), error) {
+	path := b.Path + ".lock"
+	nonce, err := newNonce()
+	if err != nil {
+		return nil, err
+	}
+	for i := 0; i < lockAttempts; i++ {
+		if i > 0 {
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(time.Duration(500+rand.Intn(1000)) * time.Microsecond):
+			}
+		}
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err == nil {
+			_, err = f.WriteString(nonce)
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+			if err != nil {
+				os.Remove(path)
+				return nil, fmt.Errorf("leader: %w", err)
+			}
+			return func() { removeLock(path, nonce) }, nil
+		}
+		if !os.IsExist(err) {
+			return nil, fmt.Errorf("leader: %w", err)
+		}
+		if fi, err := os.Stat(path); err == nil && now.Sub(fi.ModTime()) > staleLock {
+			takeOver(path, nonce)
+		}
+	}
+	return nil, errLocked
+}
+
+// newNonce returns a random nonce identifying a lock file
+func newNonce() (string, error) {
+	var b [16]byte
+	if _, err := crand.Read(b[:]); err != nil {
+		return "", fmt.Errorf("leader: %w", err)
+	}
+	return hex.EncodeToString(b[:]), nil
+}
+
+// removeLock removes the lock file at path if it still holds nonce, rather
+// than the lock of another replica which took over ours
+func removeLock(path, nonce string) {
+	if data, err := ioutil.ReadFile(path); err == nil && string(data) == nonce {
+		os.Remove(path)
+	}
+}
+
+// takeOver removes the stale lock file at path. Removing it outright could
+// remove the fresh lock of another replica which took it over since it was
+// found stale, so its nonce is read, then the file moved aside, which only
+// one replica does, and only removed if it is the one read; otherwise it is
+// moved back unless the lock was created again since.
+func takeOver(path, nonce string) {
+	stale, err := ioutil.ReadFile(path)
+	if err != nil {
+		return
+	}
+	aside := path + ".stale-" + nonce
+	if err := os.Rename(path, aside); err != nil {
+		return
+	}
+	defer os.Remove(aside)
+	if moved, err := ioutil.ReadFile(aside); err != nil || string(moved) != string(stale) {
+		// a link fails rather than replace a lock created since
+		os.Link(aside, path)
+	}
+}
+
+func (b FileBackend) read() (lease, error) {
+	var l lease
+	data, err := ioutil.ReadFile(b.Path)
+	if os.IsNotExist(err) {
+		return l, nil
+	}
+	if err != nil {
+		return l, fmt.Errorf("leader: %w", err)
+	}
+	if err := json.Unmarshal(data, &l); err != nil {
+		return l, fmt.Errorf("leader: parsing %s: %w", b.Path, err)
+	}
+	return l, nil
+}
+
+// Acquire implements Backend
+func (b FileBackend) Acquire(ctx context.Context, id string, ttl time.Duration, now time.Time) (bool, error) {
+	unlock, err := b.lock(ctx, now)
+	if err != nil {
+		return false, err
+	}
+	defer unlock()
+	l, err := b.read()
+	if err != nil {
+		return false, err
+	}
+	if l.Holder != id && now.Before(l.Expires) {
+		return false, nil
+	}
+	data, err := json.Marshal(lease{Holder: id, Expires: now.Add(ttl)})
+	if err != nil {
+		return false, err
+	}
+	tmp := b.Path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return false, fmt.Errorf("leader: %w", err)
+	}
+	if err := os.Rename(tmp, b.Path); err != nil {
+		return false, fmt.Errorf("leader: %w", err)
+	}
+	return true, nil
+}
+
+// Release implements Backend
+func (b FileBackend) Release(ctx context.Context, id string) error {
+	unlock, err := b.lock(ctx, time.Now())
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	l, err := b.read()
+	if err != nil || l.Holder != id {
+		return err
+	}
+	if err := os.Remove(b.Path); err != nil {
+		return fmt.Errorf("leader: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,214 @@
+package leader
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestFileBackend(t *testing.T) {
+	b := FileBackend{Path: filepath.Join(t.TempDir(), "lease")}
+	ctx := context.Background()
+	now := time.Now()
+	acquire := func(id string, at time.Time) bool {
+		ok, err := b.Acquire(ctx, id, time.Minute, at)
+		if err != nil {
+			t.Fatalf("Acquire(%s) received an error: %s", id, err)
+		}
+		return ok
+	}
+	if !acquire("a", now) {
+		t.Fatal("a didn't get the free lease")
+	}
+	if acquire("b", now.Add(30*time.Second)) {
+		t.Error("b took the lease held by a")
+	}
+	if !acquire("a", now.Add(50*time.Second)) {
+		t.Error("a couldn't renew its lease")
+	}
+	if acquire("b", now.Add(100*time.Second)) {
+		t.Error("b took the renewed lease of a")
+	}
+	if !acquire("b", now.Add(111*time.Second)) {
+		t.Error("b didn't get the expired lease")
+	}
+	if err := b.Release(ctx, "a"); err != nil {
+		t.Fatal(err)
+	}
+	if acquire("a", now.Add(112*time.Second)) {
+		t.Error("a released the lease of b")
+	}
+	if err := b.Release(ctx, "b"); err != nil {
+		t.Fatal(err)
+	}
+	if !acquire("a", now.Add(113*time.Second)) {
+		t.Error("a didn't get the released lease")
+	}
+}
+
+func TestFileBackend_lock(t *testing.T) {
+	b := FileBackend{Path: filepath.Join(t.TempDir(), "lease")}
+	ctx := context.Background()
+	lock := b.Path + ".lock"
+	if err := ioutil.WriteFile(lock, []byte("other"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.Acquire(ctx, "a", time.Minute, time.Now()); !errors.Is(err, errLocked) {
+		t.Errorf("Acquire() with the lock of another replica: want errLocked, got %v", err)
+	}
+	if data, err := ioutil.ReadFile(lock); err != nil || string(data) != "other" {
+		t.Errorf("the lock of another replica: want it kept, got %q, %v", data, err)
+	}
+
+	// the lock of a replica which died holding it is taken over
+	old := time.Now().Add(-2 * staleLock)
+	if err := os.Chtimes(lock, old, old); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := b.Acquire(ctx, "a", time.Minute, time.Now()); !ok || err != nil {
+		t.Errorf("Acquire() with a stale lock: want the lease, got %v, %v", ok, err)
+	}
+	if _, err := os.Stat(lock); !os.IsNotExist(err) {
+		t.Errorf("lock file after Acquire(): want it removed, got %v", err)
+	}
+	if matches, _ := filepath.Glob(lock + ".stale-*"); len(matches) != 0 {
+		t.Errorf("stale locks moved aside: want them removed, got %v", matches)
+	}
+
+	// a lock taken over isn't removed by the replica it was taken from
+	unlock, err := b.lock(ctx, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(lock, []byte("other"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	unlock()
+	if data, err := ioutil.ReadFile(lock); err != nil || string(data) != "other" {
+		t.Errorf("the lock of the replica which took over: want it kept, got %q, %v", data, err)
+	}
+}
+
+func TestElector(t *testing.T) {
+	b := FileBackend{Path: filepath.Join(t.TempDir(), "lease")}
+	a := &Elector{Backend: b, ID: "a", TTL: 30 * time.Millisecond}
+	other := &Elector{Backend: b, ID: "b", TTL: 30 * time.Millisecond}
+	ctx, cancel := context.WithCancel(context.Background())
+	started := make(chan string, 2)
+	stopped := make(chan string, 2)
+	loop := func(id string) func(ctx context.Context) {
+		return func(ctx context.Context) {
+			started <- id
+			<-ctx.Done()
+			stopped <- id
+		}
+	}
+	// the electors release the lease on return, which must be before the
+	// TempDir is removed
+	aDone, bDone := make(chan struct{}), make(chan struct{})
+	go func() {
+		a.Run(ctx)
+		close(aDone)
+	}()
+	go a.Lead(ctx, loop("a"))
+	if id := <-started; id != "a" {
+		t.Fatalf("started: want a, got %s", id)
+	}
+
+	// b takes over once a steps down
+	bctx, bcancel := context.WithCancel(context.Background())
+	defer func() {
+		bcancel()
+		<-bDone
+	}()
+	go func() {
+		other.Run(bctx)
+		close(bDone)
+	}()
+	go other.Lead(bctx, loop("b"))
+	time.Sleep(50 * time.Millisecond)
+	if other.Leading() {
+		t.Fatal("b leads while a does")
+	}
+	cancel()
+	if id := <-stopped; id != "a" {
+		t.Fatalf("stopped: want a, got %s", id)
+	}
+	<-aDone
+	select {
+	case id := <-started:
+		if id != "b" {
+			t.Fatalf("started: want b, got %s", id)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("b didn't take over")
+	}
+}
+
+// lockedBackend holds the lease for anyone, but the lease file is locked on
+// every other try. tried gets the number of tries after each.
+type lockedBackend struct {
+	mu    sync.Mutex
+	tries int
+	tried chan int
+}
+
+func (b *lockedBackend) Acquire(ctx context.Context, id string, ttl time.Duration, now time.Time) (bool, error) {
+	b.mu.Lock()
+	b.tries++
+	tries := b.tries
+	b.mu.Unlock()
+	select {
+	case b.tried <- tries:
+	case <-ctx.Done():
+	}
+	if tries%2 == 0 {
+		return false, errLocked
+	}
+	return true, nil
+}
+
+func (b *lockedBackend) Release(ctx context.Context, id string) error { return nil }
+
+func TestElector_locked(t *testing.T) {
+	// each renewal, a third of the TTL after the previous one, is locked
+	// and retried a tenth of the TTL later, well within the two thirds of
+	// the TTL past which the leader steps down
+	backend := &lockedBackend{tried: make(chan int)}
+	e := &Elector{Backend: backend, ID: "a", TTL: 600 * time.Millisecond}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		e.Run(ctx)
+		close(done)
+	}()
+	// the tries are counted rather than timed: the first one gets the
+	// lease, and the replica must still lead after three more renewals
+	for tries := range backend.tried {
+		if tries == 1 {
+			continue
+		}
+		if !e.Leading() {
+			t.Fatalf("a stepped down after %d tries because the lease file was locked", tries)
+		}
+		if tries == 6 {
+			break
+		}
+	}
+	cancel()
+	<-done
+}
+
+func TestElector_nil(t *testing.T) {
+	var e *Elector
+	ran := false
+	e.Lead(context.Background(), func(ctx context.Context) { ran = true })
+	if !ran {
+		t.Error("a nil Elector didn't run the loop")
+	}
+}
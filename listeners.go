@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// The kinds of listeners
+const (
+	// listenHTTP serves the site over plain HTTP
+	listenHTTP = "http"
+	// listenTLS serves the site over HTTPS, telling browsers to stick to it
+	listenTLS = "tls"
+	// listenRedirect redirects every request to the HTTPS listener
+	listenRedirect = "redirect"
+	// listenAdmin serves the operational endpoints instead of the site
+	listenAdmin = "admin"
+	// listenStories serves the story service, for other backend services,
+	// instead of the site
+	listenStories = "stories"
+)
+
+// listener is an address the server accepts connections on, and what it
+// does with them
+type listener struct {
+	// Network is "tcp" or "unix"
+	Network string
+	// Addr is a host:port, or the path of a unix socket
+	Addr string
+	Kind string
+}
+
+// parseListener parses a listener spec: an address, either host:port or
+// unix:/path/to/socket, optionally followed by a comma and its kind, eg
+// ":443,tls". The kind defaults to http.
+func parseListener(spec string) (listener, error) {
+	l := listener{Network: "tcp", Addr: spec, Kind: listenHTTP}
+	if i := strings.LastIndexByte(spec, ','); i >= 0 {
+		l.Addr, l.Kind = spec[:i], spec[i+1:]
+	}
+	switch l.Kind {
+	case listenHTTP, listenTLS, listenRedirect, listenAdmin, listenStories:
+	default:
+		return l, fmt.Errorf("listener %q: unknown kind %q", spec, l.Kind)
+	}
+	if strings.HasPrefix(l.Addr, "unix:") {
+		l.Network, l.Addr = "unix", strings.TrimPrefix(l.Addr, "unix:")
+		if l.Addr == "" {
+			return l, fmt.Errorf("listener %q: missing socket path", spec)
+		}
+		return l, nil
+	}
+	if _, _, err := net.SplitHostPort(l.Addr); err != nil {
+		return l, fmt.Errorf("listener %q: %w", spec, err)
+	}
+	return l, nil
+}
+
+func (l listener) String() string {
+	if l.Network == "unix" {
+		return "unix:" + l.Addr + " (" + l.Kind + ")"
+	}
+	return l.Addr + " (" + l.Kind + ")"
+}
+
+// listenFlag is a flag.Value collecting the listeners of a repeated flag
+type listenFlag []listener
+
+func (f *listenFlag) String() string {
+	specs := make([]string, len(*f))
+	for i, l := range *f {
+		specs[i] = l.String()
+	}
+	return strings.Join(specs, " ")
+}
+
+func (f *listenFlag) Set(spec string) error {
+	l, err := parseListener(spec)
+	if err != nil {
+		return err
+	}
+	*f = append(*f, l)
+	return nil
+}
+
+// tlsConfig holds the certificate of the tls listeners
+type tlsConfig struct {
+	CertFile, KeyFile string
+}
+
+// servers returns the servers of listeners. Each kind has its own
+// middleware around h: tls listeners add HSTS, and redirect, admin and
+// stories listeners don't serve h at all, admin listeners serving admin and
+// stories listeners stories instead.
+func servers(listeners []listener, h, admin, stories http.Handler) []*http.Server {
+	httpsPort := ""
+	for _, l := range listeners {
+		if l.Kind == listenTLS && l.Network == "tcp" {
+			_, httpsPort, _ = net.SplitHostPort(l.Addr)
+			break
+		}
+	}
+	srvs := make([]*http.Server, len(listeners))
+	for i, l := range listeners {
+		handler := h
+		switch l.Kind {
+		case listenTLS:
+			handler = strictTransportSecurity(h)
+		case listenRedirect:
+			handler = redirectHTTPS(httpsPort)
+		case listenAdmin:
+			handler = admin
+		case listenStories:
+			handler = stories
+		}
+		srvs[i] = &http.Server{
+			Addr:              l.Addr,
+			Handler:           handler,
+			ReadHeaderTimeout: 10 * time.Second,
+			IdleTimeout:       2 * time.Minute,
+		}
+		if l.Kind == listenStories {
+			// the streams of updates only end with their context, which
+			// shutting down the server would otherwise wait for
+			ctx, cancel := context.WithCancel(context.Background())
+			srvs[i].BaseContext = func(net.Listener) context.Context { return ctx }
+			srvs[i].RegisterOnShutdown(cancel)
+		}
+	}
+	return srvs
+}
+
+// serve serves h, admin and stories on listeners until the process is
+// interrupted or terminated, then shuts the servers down gracefully, giving
+// the requests in flight up to shutdownTimeout to complete.
+func serve(listeners []listener, h, admin, stories http.Handler, tls tlsConfig, shutdownTimeout time.Duration) error {
+	srvs := servers(listeners, h, admin, stories)
+	errs := make(chan error, len(srvs))
+	for i, l := range listeners {
+		if l.Network == "unix" {
+			// a socket left over by a previous run would fail the listen
+			os.Remove(l.Addr)
+		}
+		ln, err := net.Listen(l.Network, l.Addr)
+		if err != nil {
+			shutdown(srvs[:i], shutdownTimeout)
+			return err
+		}
+		log.Printf("%s listening on %s", currentBuild(), l)
+		go func(srv *http.Server, l listener) {
+			var err error
+			if l.Kind == listenTLS {
+				err = srv.ServeTLS(ln, tls.CertFile, tls.KeyFile)
+			} else {
+				err = srv.Serve(ln)
+			}
+			if !errors.Is(err, http.ErrServerClosed) {
+				errs <- fmt.Errorf("%s: %w", l, err)
+			}
+		}(srvs[i], l)
+	}
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(stop)
+	var err error
+	select {
+	case sig := <-stop:
+		log.Printf("received %s, shutting down", sig)
+	case err = <-errs:
+	}
+	shutdown(srvs, shutdownTimeout)
+	return err
+}
+
+// shutdown shuts srvs down concurrently, waiting up to timeout for their
+// requests in flight
+func shutdown(srvs []*http.Server, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	done := make(chan struct{}, len(srvs))
+	for _, srv := range srvs {
+		go func(srv *http.Server) {
+			if err := srv.Shutdown(ctx); err != nil {
+				log.Printf("shutting down %s: %s", srv.Addr, err)
+			}
+			done <- struct{}{}
+		}(srv)
+	}
+	for range srvs {
+		<-done
+	}
+}
+
+// strictTransportSecurity tells browsers to only ever use HTTPS with the
+// site for the next two years
+func strictTransportSecurity(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Strict-Transport-Security", "max-age=63072000")
+		h.ServeHTTP(w, r)
+	})
+}
+
+// redirectHTTPS redirects every request to the same URL over HTTPS, on
+// httpsPort unless it is empty or the default port
+func redirectHTTPS(httpsPort string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if strings.Contains(host, ":") {
+			// an IPv6 literal
+			host = "[" + host + "]"
+		}
+		if httpsPort != "" && httpsPort != "443" {
+			host += ":" + httpsPort
+		}
+		status := http.StatusMovedPermanently
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			// keep the method and body
+			status = http.StatusPermanentRedirect
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), status)
+	}
+}
@@ -0,0 +1,1703 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mmxmb/quiet_hn/history"
+	"github.com/mmxmb/quiet_hn/hn"
+	"github.com/mmxmb/quiet_hn/hn/hnfake"
+	"github.com/mmxmb/quiet_hn/i18n"
+	"github.com/mmxmb/quiet_hn/jobs"
+	"github.com/mmxmb/quiet_hn/router"
+	"github.com/mmxmb/quiet_hn/search"
+)
+
+// fakeProvider is a StoryProvider serving items from memory
+type fakeProvider struct {
+	ids   []int
+	items map[int]hn.Item
+	users map[string]hn.User
+	// fetched is the number of items fetched with GetItems
+	fetched int
+}
+
+func (p *fakeProvider) TopItems() ([]int, error) {
+	return p.ids, nil
+}
+
+func (p *fakeProvider) GetItem(id int) (hn.Item, error) {
+	itm, ok := p.items[id]
+	if !ok {
+		return hn.Item{}, fmt.Errorf("item %d not found", id)
+	}
+	return itm, nil
+}
+
+func (p *fakeProvider) GetItems(ctx context.Context, ids []int, concurrency int) ([]hn.Item, error) {
+	p.fetched += len(ids)
+	items := make([]hn.Item, len(ids))
+	var errs hn.ItemErrors
+	for i, id := range ids {
+		var err error
+		if items[i], err = p.GetItem(id); err != nil {
+			errs = append(errs, hn.ItemError{ID: id, Err: err})
+		}
+	}
+	if errs != nil {
+		return items, errs
+	}
+	return items, nil
+}
+
+func (p *fakeProvider) GetUser(username string) (hn.User, error) {
+	return p.users[username], nil
+}
+
+func newFakeProvider(n int) *fakeProvider {
+	p := &fakeProvider{items: make(map[int]hn.Item), users: make(map[string]hn.User)}
+	for id := 1; id <= n; id++ {
+		p.ids = append(p.ids, id)
+		p.items[id] = hn.Item{
+			ID:    id,
+			Title: fmt.Sprintf("Story %d", id),
+			Type:  "story",
+			URL:   fmt.Sprintf("https://example.com/%d", id),
+		}
+	}
+	return p
+}
+
+func testMessages(t testing.TB) *i18n.Bundle {
+	messages, err := loadMessages("", "en")
+	if err != nil {
+		t.Fatalf("loadMessages() received an error: %s", err.Error())
+	}
+	return messages
+}
+
+// routed returns a router serving h at pattern, to test handlers reading
+// path parameters
+func routed(pattern string, h http.Handler) http.Handler {
+	rt := router.New()
+	rt.Handle(pattern, h)
+	return rt
+}
+
+func testTemplates(t testing.TB) *templateSet {
+	tpls, err := loadTemplates(testMessages(t), "")
+	if err != nil {
+		t.Fatalf("loadTemplates() received an error: %s", err.Error())
+	}
+	return tpls
+}
+
+func TestGetTopStories(t *testing.T) {
+	p := newFakeProvider(20)
+	p.items[2] = hn.Item{ID: 2, Type: "job", URL: "https://example.com/jobs"}
+	p.items[3] = hn.Item{ID: 3, Type: "story", Deleted: true}
+	p.items[4] = hn.Item{ID: 4, Type: "story", Text: "Ask HN: ?"}
+	delete(p.items, 5)
+
+	stories, err := getTopStories(context.Background(), p, 5, 2, filter{HideJobs: true})
+	if err != nil {
+		t.Fatalf("getTopStories() received an error: %s", err.Error())
+	}
+	want := []int{1, 6, 7, 8, 9}
+	if len(stories) != len(want) {
+		t.Fatalf("len(stories): want %d, got %d", len(want), len(stories))
+	}
+	for i, id := range want {
+		if stories[i].ID != id {
+			t.Errorf("stories[%d].ID: want %d, got %d", i, id, stories[i].ID)
+		}
+		// ids are 1-based ranks in the fake
+		if stories[i].Rank != id {
+			t.Errorf("stories[%d].Rank: want %d, got %d", i, id, stories[i].Rank)
+		}
+	}
+}
+
+func TestGetTopStories_notEnoughItems(t *testing.T) {
+	p := newFakeProvider(3)
+	stories, err := getTopStories(context.Background(), p, 5, 2, filter{HideJobs: true})
+	if err != nil {
+		t.Fatalf("getTopStories() received an error: %s", err.Error())
+	}
+	if len(stories) != 3 {
+		t.Errorf("len(stories): want %d, got %d", 3, len(stories))
+	}
+}
+
+func TestGetTopStories_weightedBackfill(t *testing.T) {
+	p := newFakeProvider(100)
+	// every other story is a job posting
+	for id := 2; id <= 100; id += 2 {
+		p.items[id] = hn.Item{ID: id, Type: "job", URL: "https://example.com/jobs"}
+	}
+
+	stories, err := getTopStories(context.Background(), p, 10, 2, filter{HideJobs: true})
+	if err != nil {
+		t.Fatalf("getTopStories() received an error: %s", err.Error())
+	}
+	if len(stories) != 10 || stories[9].ID != 19 {
+		t.Fatalf("stories: want the first 10 odd ids, got %d stories ending with %d", len(stories), stories[len(stories)-1].ID)
+	}
+	// 10 items, then 10 for the 5 missing stories at a keep ratio of 1/2
+	if p.fetched != 20 {
+		t.Errorf("fetched items: want %d, got %d", 20, p.fetched)
+	}
+}
+
+func TestGetTopStories_scanLimit(t *testing.T) {
+	p := newFakeProvider(100)
+	for id := 1; id <= 100; id++ {
+		p.items[id] = hn.Item{ID: id, Type: "job", URL: "https://example.com/jobs"}
+	}
+
+	stories, err := getTopStories(context.Background(), p, 5, 2, filter{HideJobs: true})
+	if err != nil {
+		t.Fatalf("getTopStories() received an error: %s", err.Error())
+	}
+	if len(stories) != 0 {
+		t.Errorf("len(stories): want %d, got %d", 0, len(stories))
+	}
+	if p.fetched != 5*maxScanFactor {
+		t.Errorf("fetched items: want %d, got %d", 5*maxScanFactor, p.fetched)
+	}
+}
+
+// slowProvider blocks GetItems until ctx is done for batches with an id
+// above fast
+type slowProvider struct {
+	*fakeProvider
+	fast int
+}
+
+func (p slowProvider) GetItems(ctx context.Context, ids []int, concurrency int) ([]hn.Item, error) {
+	for _, id := range ids {
+		if id > p.fast {
+			<-ctx.Done()
+			return make([]hn.Item, len(ids)), ctx.Err()
+		}
+	}
+	return p.fakeProvider.GetItems(ctx, ids, concurrency)
+}
+
+func TestGetTopStories_deadline(t *testing.T) {
+	p := newFakeProvider(20)
+	for id := 2; id <= 5; id++ {
+		p.items[id] = hn.Item{ID: id, Type: "job", URL: "https://example.com/jobs"}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	stories, err := getTopStories(ctx, slowProvider{fakeProvider: p, fast: 5}, 5, 2, filter{HideJobs: true})
+	if err != nil {
+		t.Fatalf("getTopStories() received an error: %s", err.Error())
+	}
+	if len(stories) != 1 || stories[0].ID != 1 {
+		t.Errorf("stories: want the story found before the deadline, got %v", stories)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := getTopStories(ctx, slowProvider{fakeProvider: p, fast: 0}, 5, 2, filter{HideJobs: true}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("getTopStories() without any story in time: want context.DeadlineExceeded, got %v", err)
+	}
+}
+
+func TestGetTopStories_windows(t *testing.T) {
+	dead := func(id int) hn.Item { return hn.Item{ID: id, Type: "story", URL: "https://example.com", Dead: true} }
+	deleted := func(id int) hn.Item { return hn.Item{ID: id, Type: "story", Deleted: true} }
+	job := func(id int) hn.Item { return hn.Item{ID: id, Type: "job", URL: "https://example.com/jobs"} }
+	comment := func(id int) hn.Item { return hn.Item{ID: id, Type: "comment", Text: "First!"} }
+
+	tests := []struct {
+		name string
+		// replace maps ids to the item replacing the story with that id
+		replace map[int]func(int) hn.Item
+		f       filter
+		want    []int
+	}{
+		{"no filtered items", nil, filter{HideJobs: true}, []int{1, 2, 3, 4, 5}},
+		{"dead and deleted", map[int]func(int) hn.Item{1: dead, 3: deleted, 4: dead}, filter{HideJobs: true}, []int{2, 5, 6, 7, 8}},
+		{"job heavy", map[int]func(int) hn.Item{1: job, 2: job, 3: job, 4: job, 6: job, 7: job}, filter{HideJobs: true}, []int{5, 8, 9, 10, 11}},
+		{"jobs shown", map[int]func(int) hn.Item{1: job, 2: job}, filter{}, []int{1, 2, 3, 4, 5}},
+		{"comments", map[int]func(int) hn.Item{2: comment, 5: comment}, filter{}, []int{1, 3, 4, 6, 7}},
+		{"everything filtered at the top", map[int]func(int) hn.Item{1: dead, 2: deleted, 3: job, 4: comment, 5: job}, filter{HideJobs: true}, []int{6, 7, 8, 9, 10}},
+	}
+	for _, tc := range tests {
+		p := newFakeProvider(30)
+		for id, replace := range tc.replace {
+			p.items[id] = replace(id)
+		}
+		stories, err := getTopStories(context.Background(), p, 5, 2, tc.f)
+		if err != nil {
+			t.Fatalf("%s: getTopStories() received an error: %s", tc.name, err.Error())
+		}
+		var ids []int
+		for _, story := range stories {
+			ids = append(ids, story.ID)
+			if story.Rank != story.ID {
+				t.Errorf("%s: rank of story %d: want %d, got %d", tc.name, story.ID, story.ID, story.Rank)
+			}
+		}
+		if fmt.Sprint(ids) != fmt.Sprint(tc.want) {
+			t.Errorf("%s: want stories %v, got %v", tc.name, tc.want, ids)
+		}
+	}
+}
+
+func TestHandler(t *testing.T) {
+	p := newFakeProvider(10)
+	tpl := testTemplates(t)
+	cache := &Cache{ExpirationDuration: time.Minute}
+
+	rec := httptest.NewRecorder()
+	handler(p, cache, config{Messages: testMessages(t), NumStories: 3, Concurrency: 2}, nil, nil, nil, nil, nil, tpl).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status code: want %d, got %d", http.StatusOK, rec.Code)
+	}
+	body := rec.Body.String()
+	for _, title := range []string{"Story 1", "Story 2", "Story 3"} {
+		if !strings.Contains(body, title) {
+			t.Errorf("body does not contain %q", title)
+		}
+	}
+	if strings.Contains(body, "Story 4") {
+		t.Errorf("body contains %q", "Story 4")
+	}
+}
+
+func TestHandler_hnfake(t *testing.T) {
+	srv, err := hnfake.NewFromFile("hn/hnfake/testdata/frontpage.json")
+	if err != nil {
+		t.Fatalf("hnfake.NewFromFile() received an error: %s", err.Error())
+	}
+	defer srv.Close()
+
+	tpl := testTemplates(t)
+	cache := &Cache{ExpirationDuration: time.Minute}
+
+	rec := httptest.NewRecorder()
+	handler(srv.Client(), cache, config{Messages: testMessages(t), NumStories: 30, Concurrency: 10, Defaults: preferences{HideJobs: true}}, nil, nil, nil, nil, nil, tpl).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status code: want %d, got %d", http.StatusOK, rec.Code)
+	}
+	if n := strings.Count(rec.Body.String(), "<li "); n != 30 {
+		t.Errorf("number of stories: want %d, got %d", 30, n)
+	}
+	if strings.Contains(rec.Body.String(), "is hiring") {
+		t.Errorf("body contains the job posting")
+	}
+}
+
+func TestHandler_showJobs(t *testing.T) {
+	p := newFakeProvider(10)
+	p.items[2] = hn.Item{ID: 2, Title: "Acme is hiring", Type: "job", URL: "https://example.com/jobs"}
+	tpl := testTemplates(t)
+	cache := &Cache{ExpirationDuration: time.Minute}
+	h := handler(p, cache, config{Messages: testMessages(t), NumStories: 3, Concurrency: 2, Defaults: preferences{HideJobs: true}}, nil, nil, nil, nil, nil, tpl)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if strings.Contains(rec.Body.String(), "Acme is hiring") {
+		t.Errorf("body contains the job posting with hide_jobs=true")
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/?hide_jobs=false", nil))
+	if !strings.Contains(rec.Body.String(), "Acme is hiring") {
+		t.Errorf("body does not contain the job posting with hide_jobs=false")
+	}
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != "hide_jobs" || cookies[0].Value != "false" {
+		t.Errorf("cookies: want hide_jobs=false, got %v", cookies)
+	}
+}
+
+func TestHandler_pinned(t *testing.T) {
+	pins, err := newPinBoard(filePins{path: filepath.Join(t.TempDir(), "pinned.json")}, []int{8})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache := &Cache{ExpirationDuration: time.Minute}
+	h := handler(newFakeProvider(10), cache, config{Messages: testMessages(t), NumStories: 3, Concurrency: 2}, nil, nil, nil, nil, pins, testTemplates(t))
+	count := func(body string) int {
+		return strings.Count(body, `rel="noopener noreferrer" title=`)
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/?pin=5,2", nil))
+	body := rec.Body.String()
+	// 8, 5 and 2 on top, in that order, and 1 and 3 left out to keep 3 stories
+	i8, i5, i2 := strings.Index(body, ">Story 8<"), strings.Index(body, ">Story 5<"), strings.Index(body, ">Story 2<")
+	if i8 < 0 || i5 < i8 || i2 < i5 || count(body) != 3 {
+		t.Errorf("body: want stories 8, 5 and 2, got %s", body)
+	}
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != "pin" || cookies[0].Value != "5,2" {
+		t.Errorf("cookies: want pin=5,2, got %v", cookies)
+	}
+	// only the stories pinned by the operator are cached, the ones of the
+	// user are theirs
+	for _, entry := range cache.Stats().Entries {
+		if strings.HasPrefix(entry.Key, "pinned=") && entry.Key != "pinned=8" {
+			t.Errorf("cache entries: want the stories pinned by the operator, got %s", entry.Key)
+		}
+	}
+
+	if err := pins.Unpin(8); err != nil {
+		t.Fatal(err)
+	}
+	reloaded, err := newPinBoard(pins.backend, []int{8})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids := reloaded.IDs(); len(ids) != 0 {
+		t.Errorf("reloaded pins: want none, got %v", ids)
+	}
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/?pin=", nil))
+	if body := rec.Body.String(); strings.Index(body, ">Story 1<") < 0 || count(body) != 3 {
+		t.Errorf("body after unpinning: want stories 1 to 3, got %s", body)
+	}
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/?pin=1,x", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("status code of an invalid pin: want %d, got %d", http.StatusBadRequest, rec.Code)
+	}
+}
+
+func TestHandler_muted(t *testing.T) {
+	p := newFakeProvider(5)
+	for id, by := range map[int]string{1: "alice", 2: "alice", 3: "Bob", 4: "carol"} {
+		itm := p.items[id]
+		itm.By = by
+		p.items[id] = itm
+	}
+	cache := &Cache{ExpirationDuration: time.Minute}
+	h := handler(p, cache, config{Messages: testMessages(t), NumStories: 5, Concurrency: 2, MutedUsers: []string{"bob"}}, nil, nil, nil, nil, nil, testTemplates(t))
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/?mute=Alice", nil))
+	body := rec.Body.String()
+	for id, want := range map[int]bool{1: false, 2: false, 3: false, 4: true, 5: true} {
+		if got := strings.Contains(body, fmt.Sprintf(">Story %d<", id)); got != want {
+			t.Errorf("story %d shown: want %v, got %v", id, want, got)
+		}
+	}
+	if !strings.Contains(body, `href="/user/carol">by carol</a>`) || !strings.Contains(body, `href="/?mute=Alice%2Ccarol"`) {
+		t.Errorf("body: want the author of story 4 with a link muting them, got %s", body)
+	}
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != "mute" || cookies[0].Value != "Alice" {
+		t.Errorf("cookies: want mute=Alice, got %v", cookies)
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if body := rec.Body.String(); !strings.Contains(body, ">Story 1<") || strings.Contains(body, ">Story 3<") {
+		t.Errorf("body without muting: want only the stories of bob hidden, got %s", body)
+	}
+	// the users muted by a user are theirs, and don't get lists of their own
+	if entries := cache.Stats().Entries; len(entries) != 1 || strings.Contains(entries[0].Key, "alice") || !strings.Contains(entries[0].Key, "muted=bob") {
+		t.Errorf("cache entries: want one list, without the users muted by the user, got %+v", entries)
+	}
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/?mute=not+a+user", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("status code of an invalid mute: want %d, got %d", http.StatusBadRequest, rec.Code)
+	}
+}
+
+func TestParseHNItem_selfReferential(t *testing.T) {
+	tests := []struct {
+		item     hn.Item
+		link     string
+		label    string
+		hnItemID int
+	}{
+		{hn.Item{ID: 1, Title: "A story", URL: "https://www.example.com/a"}, "https://www.example.com/a", "", 0},
+		{hn.Item{ID: 2, Title: "Launch HN: Acme", URL: "https://news.ycombinator.com/item?id=42"}, "/item/42", "Launch HN", 42},
+		{hn.Item{ID: 3, Title: "Tell HN: Hi", URL: "https://news.ycombinator.com/newsguidelines.html"}, "/item/3", "Tell HN", 3},
+		{hn.Item{ID: 4, Title: "Comments on the outage", URL: "https://news.ycombinator.com/item?id=7"}, "/item/7", "HN", 7},
+	}
+	for _, tc := range tests {
+		itm := parseHNItem(tc.item)
+		if itm.Link() != tc.link {
+			t.Errorf("item %d: Link(): want %s, got %s", tc.item.ID, tc.link, itm.Link())
+		}
+		if itm.Label != tc.label {
+			t.Errorf("item %d: Label: want %q, got %q", tc.item.ID, tc.label, itm.Label)
+		}
+		if itm.HNItemID != tc.hnItemID {
+			t.Errorf("item %d: HNItemID: want %d, got %d", tc.item.ID, tc.hnItemID, itm.HNItemID)
+		}
+	}
+}
+
+func TestArchiveConfig_decorate(t *testing.T) {
+	c := archiveConfig{Service: "wayback", AutoDomains: []string{"paywalled.com"}}
+	stories := []item{
+		parseHNItem(hn.Item{ID: 1, URL: "https://example.com/a"}),
+		parseHNItem(hn.Item{ID: 2, URL: "https://www.paywalled.com/b"}),
+		parseHNItem(hn.Item{ID: 3, URL: "https://news.paywalled.com/c"}),
+		parseHNItem(hn.Item{ID: 4, Type: "poll"}),
+	}
+	c.decorate(stories)
+	if stories[0].ArchiveURL != "https://web.archive.org/web/https://example.com/a" {
+		t.Errorf("stories[0].ArchiveURL: got %s", stories[0].ArchiveURL)
+	}
+	if stories[0].Link() != "https://example.com/a" {
+		t.Errorf("stories[0].Link(): want the story URL, got %s", stories[0].Link())
+	}
+	for _, i := range []int{1, 2} {
+		if stories[i].Link() != stories[i].ArchiveURL {
+			t.Errorf("stories[%d].Link(): want the archive URL, got %s", i, stories[i].Link())
+		}
+	}
+	if stories[3].ArchiveURL != "" {
+		t.Errorf("stories[3].ArchiveURL: want none for a poll, got %s", stories[3].ArchiveURL)
+	}
+}
+
+func TestFilter_hidePaywalled(t *testing.T) {
+	f := newFilter(config{PaywallDomains: []string{"paywalled.com"}}, preferences{HidePaywalled: true})
+	if !f.keep(parseHNItem(hn.Item{ID: 1, Type: "story", URL: "https://example.com/a"})) {
+		t.Errorf("f.keep(): want free story to be kept")
+	}
+	if f.keep(parseHNItem(hn.Item{ID: 2, Type: "story", URL: "https://www.paywalled.com/b"})) {
+		t.Errorf("f.keep(): want paywalled story to be filtered out")
+	}
+}
+
+func TestReadHandler(t *testing.T) {
+	page := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprint(w, "<html><body><nav>Menu</nav><article><p>The article text.</p></article></body></html>")
+	}))
+	defer page.Close()
+
+	p := newFakeProvider(1)
+	p.items[1] = hn.Item{ID: 1, Title: "Story 1", Type: "story", URL: page.URL + "/article"}
+	fetcher := newArticleFetcher(readerConfig{Enabled: true, Timeout: time.Second, MaxBytes: 1 << 20, CacheDuration: time.Minute}, defaultUserAgent(defaultContactURL))
+	tpl := testTemplates(t)
+	h := routed("/read/{id}", readHandler(p, config{Messages: testMessages(t)}, fetcher, nil, tpl))
+
+	// the test server listens on a loopback address, which the fetcher refuses
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/read/1", nil))
+	if rec.Code != http.StatusFound {
+		t.Errorf("status code: want %d, got %d", http.StatusFound, rec.Code)
+	}
+
+	fetcher.client = page.Client()
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/read/1", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status code: want %d, got %d", http.StatusOK, rec.Code)
+	}
+	if !strings.Contains(rec.Body.String(), "The article text.") {
+		t.Errorf("body does not contain the article text")
+	}
+	if strings.Contains(rec.Body.String(), "Menu") {
+		t.Errorf("body contains the page navigation")
+	}
+}
+
+func TestHandler_language(t *testing.T) {
+	p := newFakeProvider(3)
+	tpl := testTemplates(t)
+	cache := &Cache{ExpirationDuration: time.Minute}
+	h := handler(p, cache, config{Messages: testMessages(t), NumStories: 3, Concurrency: 2}, nil, nil, nil, nil, nil, tpl)
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Accept-Language", "de-DE,de;q=0.9,en;q=0.8")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if !strings.Contains(rec.Body.String(), `<html lang="de">`) {
+		t.Errorf("body is not in German for Accept-Language: de-DE")
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/?lang=xx", nil))
+	if !strings.Contains(rec.Body.String(), `<html lang="en">`) {
+		t.Errorf("body is not in English for an unsupported lang")
+	}
+}
+
+func TestUserHandler(t *testing.T) {
+	srv, err := hnfake.NewFromFile("hn/hnfake/testdata/frontpage.json")
+	if err != nil {
+		t.Fatalf("hnfake.NewFromFile() received an error: %s", err.Error())
+	}
+	defer srv.Close()
+
+	h := userHandler(srv.Client(), config{Messages: testMessages(t), Concurrency: 2}, testTemplates(t))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/user?id=user1", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status code: want %d, got %d", http.StatusOK, rec.Code)
+	}
+	body := rec.Body.String()
+	for _, want := range []string{"2,937 karma", "Fixture user number one.", "Fixture story 1", "Fixture story 22"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("body does not contain %q", want)
+		}
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/user?id=nobody", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("status code for an unknown user: want %d, got %d", http.StatusNotFound, rec.Code)
+	}
+}
+
+func TestFollowingHandler(t *testing.T) {
+	p := newFakeProvider(4)
+	for id, tm := range map[int]int{1: 100, 2: 300, 3: 200, 4: 400} {
+		itm := p.items[id]
+		itm.Time = tm
+		p.items[id] = itm
+	}
+	p.users["alice"] = hn.User{ID: "alice", Submitted: []int{3, 1}}
+	p.users["bob"] = hn.User{ID: "bob", Submitted: []int{2}}
+	cfg := config{Messages: testMessages(t), NumStories: 10, Concurrency: 2, Following: []string{"alice", "bob", "nobody"}}
+	h := followingHandler(p, &Cache{ExpirationDuration: time.Minute}, cfg, testTemplates(t))
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/following", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status code: want %d, got %d", http.StatusOK, rec.Code)
+	}
+	body := rec.Body.String()
+	// the most recent first, and story 4 submitted by nobody followed left out
+	i2, i3, i1 := strings.Index(body, ">Story 2<"), strings.Index(body, ">Story 3<"), strings.Index(body, ">Story 1<")
+	if i2 < 0 || i3 < i2 || i1 < i3 || strings.Contains(body, ">Story 4<") {
+		t.Errorf("body: want stories 2, 3 and 1, got %s", body)
+	}
+	if !strings.Contains(body, "alice, bob, nobody") {
+		t.Errorf("body doesn't list the users followed: %s", body)
+	}
+}
+
+func TestItemHandler_errorPage(t *testing.T) {
+	p := newFakeProvider(1)
+	cfg := config{Messages: testMessages(t)}
+	h := itemHandler(p, cfg, newCommentTrees(p, cfg), nil, testTemplates(t))
+
+	req := httptest.NewRequest(http.MethodGet, "/item?id=abc", nil)
+	req.Header.Set("Accept-Language", "de")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("status code: want %d, got %d", http.StatusBadRequest, rec.Code)
+	}
+	if !strings.Contains(rec.Body.String(), "Das ist keine gültige Beitrags-ID.") {
+		t.Errorf("body does not contain the translated error message")
+	}
+	if !strings.Contains(rec.Body.String(), `<html lang="de">`) {
+		t.Errorf("error page is not rendered with the layout")
+	}
+}
+
+func TestOpenSearchHandler(t *testing.T) {
+	rec := httptest.NewRecorder()
+	openSearchHandler(config{Messages: testMessages(t)}).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://quiet.example.com/opensearch.xml", nil))
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "application/opensearchdescription+xml") {
+		t.Errorf("content type: want application/opensearchdescription+xml, got %q", ct)
+	}
+	var desc openSearchDescription
+	if err := xml.Unmarshal(rec.Body.Bytes(), &desc); err != nil {
+		t.Fatalf("xml.Unmarshal() received an error: %s", err.Error())
+	}
+	if len(desc.URLs) == 0 || desc.URLs[0].Template != "http://quiet.example.com/search?q={searchTerms}" {
+		t.Errorf("search URL template: want %q, got %+v", "http://quiet.example.com/search?q={searchTerms}", desc.URLs)
+	}
+}
+
+func TestSitemapHandler(t *testing.T) {
+	cfg := config{Messages: testMessages(t), NumStories: 3, Concurrency: 2}
+	cache := &Cache{ExpirationDuration: time.Minute}
+	handler(newFakeProvider(10), cache, cfg, nil, nil, nil, nil, nil, testTemplates(t)).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	rec := httptest.NewRecorder()
+	sitemapHandler(cache, cfg).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://quiet.example.com/sitemap.xml", nil))
+	var sm sitemap
+	if err := xml.Unmarshal(rec.Body.Bytes(), &sm); err != nil {
+		t.Fatalf("xml.Unmarshal() received an error: %s", err.Error())
+	}
+	var locs []string
+	for _, u := range sm.URLs {
+		locs = append(locs, u.Loc)
+	}
+	want := []string{"http://quiet.example.com/", "http://quiet.example.com/item/1", "http://quiet.example.com/item/2", "http://quiet.example.com/item/3"}
+	if strings.Join(locs, " ") != strings.Join(want, " ") {
+		t.Errorf("sitemap URLs: want %v, got %v", want, locs)
+	}
+
+	rec = httptest.NewRecorder()
+	robotsHandler(defaultRobots).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://quiet.example.com/robots.txt", nil))
+	if !strings.Contains(rec.Body.String(), "Sitemap: http://quiet.example.com/sitemap.xml") {
+		t.Errorf("robots.txt does not point at the sitemap: %s", rec.Body.String())
+	}
+}
+
+func TestHandler_newSinceLastVisit(t *testing.T) {
+	now := time.Now()
+	hist := history.NewStore()
+	hist.Record(history.Snapshot{Time: now.Add(-time.Hour), Stories: []history.Story{{ID: 1, Rank: 1}, {ID: 2, Rank: 2}}})
+	hist.Record(history.Snapshot{Time: now.Add(-10 * time.Minute), Stories: []history.Story{{ID: 1, Rank: 1}, {ID: 3, Rank: 2}, {ID: 2, Rank: 3}}})
+	cache := &Cache{ExpirationDuration: time.Minute}
+	h := handler(newFakeProvider(3), cache, config{Messages: testMessages(t), NumStories: 3, Concurrency: 2}, nil, nil, hist, nil, nil, testTemplates(t))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.AddCookie(&http.Cookie{Name: lastVisitCookie, Value: strconv.FormatInt(now.Add(-30*time.Minute).Unix(), 10)})
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if n := strings.Count(rec.Body.String(), `class="label new"`); n != 1 {
+		t.Errorf("number of new stories: want %d, got %d", 1, n)
+	}
+
+	// first visits have nothing to compare with
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if strings.Contains(rec.Body.String(), `class="label new"`) {
+		t.Errorf("stories are marked as new on the first visit")
+	}
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != lastVisitCookie {
+		t.Errorf("cookies: want %s, got %v", lastVisitCookie, cookies)
+	}
+}
+
+func TestBestHandler(t *testing.T) {
+	now := time.Now()
+	hist := history.NewStore()
+	hist.Record(history.Snapshot{Time: now.Add(-3 * 24 * time.Hour), Stories: []history.Story{{ID: 1, Rank: 1, Title: "Story 1", Score: 900}}})
+	hist.Record(history.Snapshot{Time: now.Add(-2 * time.Hour), Stories: []history.Story{{ID: 2, Rank: 1, Title: "Story 2", Score: 300}, {ID: 3, Rank: 2, Title: "Story 3", Score: 100}}})
+	hist.Record(history.Snapshot{Time: now.Add(-time.Hour), Stories: []history.Story{{ID: 3, Rank: 1, Title: "Story 3", Score: 400}, {ID: 2, Rank: 2, Title: "Story 2", Score: 310}}})
+	h := routed("/best/{period}", bestHandler(config{Messages: testMessages(t), NumStories: 30}, hist, testTemplates(t)))
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/best/day", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status code: want %d, got %d", http.StatusOK, rec.Code)
+	}
+	body := rec.Body.String()
+	if strings.Contains(body, "Story 1") {
+		t.Errorf("/best/day contains a story from three days ago")
+	}
+	if i, j := strings.Index(body, "Story 3"), strings.Index(body, "Story 2"); i < 0 || j < 0 || i > j {
+		t.Errorf("/best/day: want Story 3 (400 points) before Story 2 (310 points)")
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/best/week", nil))
+	if !strings.Contains(rec.Body.String(), "900 points") {
+		t.Errorf("/best/week does not contain the story from three days ago")
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/best/year", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("status code for /best/year: want %d, got %d", http.StatusNotFound, rec.Code)
+	}
+}
+
+func TestRecordSnapshots(t *testing.T) {
+	cfg := config{NumStories: 3, Concurrency: 2}
+	cache := &Cache{ExpirationDuration: time.Minute}
+	hist := history.NewStore()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		recordSnapshots(ctx, newFakeProvider(10), cache, cfg, hist, time.Hour)
+		close(done)
+	}()
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		if _, ok := hist.Start(); ok {
+			break
+		}
+	}
+	cancel()
+	<-done
+
+	snap, ok := hist.At(time.Now())
+	if !ok || len(snap.Stories) != 3 {
+		t.Fatalf("recorded snapshot: want 3 stories, got %v", snap)
+	}
+	if cache.IsEmpty(newFilter(cfg, cfg.Defaults).key()) {
+		t.Errorf("the recorded front page wasn't cached")
+	}
+}
+
+func TestMarkCommentDeltas(t *testing.T) {
+	now := time.Now()
+	hist := history.NewStore()
+	hist.Record(history.Snapshot{Time: now.Add(-2 * time.Hour), Stories: []history.Story{{ID: 1, Comments: 5}}})
+	hist.Record(history.Snapshot{Time: now.Add(-time.Hour), Stories: []history.Story{{ID: 1, Comments: 10}}})
+	hist.Record(history.Snapshot{Time: now.Add(-10 * time.Minute), Stories: []history.Story{{ID: 1, Comments: 30}, {ID: 2, Comments: 3}}})
+
+	stories := []item{
+		{Item: hn.Item{ID: 1, Descendants: 52}},
+		{Item: hn.Item{ID: 2, Descendants: 7}},
+		{Item: hn.Item{ID: 3, Descendants: 100}},
+	}
+	markCommentDeltas(stories, hist, now, time.Hour)
+	for i, want := range []int{42, 4, 0} {
+		if stories[i].CommentDelta != want {
+			t.Errorf("CommentDelta of story %d: want %d, got %d", stories[i].ID, want, stories[i].CommentDelta)
+		}
+	}
+}
+
+func TestMarkSecondChance(t *testing.T) {
+	now := time.Now()
+	hist := history.NewStore()
+	hist.Record(history.Snapshot{Time: now.Add(-24 * time.Hour), Stories: []history.Story{{ID: 1}}})
+	hist.Record(history.Snapshot{Time: now.Add(-10 * time.Minute), Stories: []history.Story{{ID: 1}, {ID: 2}, {ID: 3}, {ID: 4}}})
+
+	stories := []item{
+		// on the front page since before the history started
+		{Item: hn.Item{ID: 1, Time: int(now.Add(-30 * time.Hour).Unix())}},
+		// submitted a day before making it
+		{Item: hn.Item{ID: 2, Time: int(now.Add(-20 * time.Hour).Unix())}},
+		{Item: hn.Item{ID: 3, Time: int(now.Add(-time.Hour).Unix())}},
+		// not recorded yet
+		{Item: hn.Item{ID: 5, Time: int(now.Add(-20 * time.Hour).Unix())}},
+	}
+	markSecondChance(stories, hist)
+	for i, want := range []bool{false, true, false, false} {
+		if stories[i].SecondChance != want {
+			t.Errorf("SecondChance of story %d: want %v, got %v", stories[i].ID, want, stories[i].SecondChance)
+		}
+	}
+}
+
+func TestMarkControversial(t *testing.T) {
+	now := time.Now()
+	hist := history.NewStore()
+	hist.Record(history.Snapshot{Time: now.Add(-30 * time.Minute), Stories: []history.Story{{ID: 3, Rank: 2}, {ID: 4, Rank: 5}}})
+
+	stories := []item{
+		{Item: hn.Item{ID: 1, Score: 50, Descendants: 120}, Rank: 1},
+		// too few comments to tell
+		{Item: hn.Item{ID: 2, Score: 5, Descendants: 20}, Rank: 2},
+		// down from 2 to 25 in half an hour
+		{Item: hn.Item{ID: 3, Score: 200, Descendants: 80}, Rank: 25},
+		{Item: hn.Item{ID: 4, Score: 200, Descendants: 80}, Rank: 10},
+		{Item: hn.Item{ID: 5, Score: 10, Descendants: 99}, Rank: 30, Pinned: true},
+	}
+	markControversial(stories, hist, now)
+	for i, want := range []bool{true, false, true, false, true} {
+		if stories[i].Controversial != want {
+			t.Errorf("Controversial of story %d: want %v, got %v", stories[i].ID, want, stories[i].Controversial)
+		}
+	}
+	if kept := dropControversial(stories); len(kept) != 3 || kept[0].ID != 2 || kept[1].ID != 4 || kept[2].ID != 5 {
+		t.Errorf("dropControversial(): want stories 2, 4 and the pinned 5, got %+v", kept)
+	}
+}
+
+func TestHistoryHandler(t *testing.T) {
+	hist := history.NewStore()
+	hist.Record(history.Snapshot{Time: time.Date(2018, 4, 1, 10, 0, 0, 0, time.UTC), Stories: []history.Story{{ID: 1, Rank: 1, Title: "Morning story", URL: "https://example.com/1"}}})
+	hist.Record(history.Snapshot{Time: time.Date(2018, 4, 1, 20, 0, 0, 0, time.UTC), Stories: []history.Story{{ID: 2, Rank: 1, Title: "Evening story", URL: "https://example.com/2"}}})
+	h := routed("/history/{date}", historyHandler(config{Messages: testMessages(t), Defaults: preferences{Timezone: "UTC"}}, hist, testTemplates(t)))
+
+	tests := []struct {
+		path string
+		code int
+		want string
+	}{
+		{"/history/2018-04-01", http.StatusOK, "Evening story"},
+		{"/history/2018-04-01T12:30", http.StatusOK, "Morning story"},
+		{"/history/2018-03-31", http.StatusNotFound, ""},
+		{"/history/yesterday", http.StatusBadRequest, ""},
+	}
+	for _, tc := range tests {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tc.path, nil))
+		if rec.Code != tc.code {
+			t.Errorf("%s: status code: want %d, got %d", tc.path, tc.code, rec.Code)
+		}
+		if !strings.Contains(rec.Body.String(), tc.want) {
+			t.Errorf("%s: body does not contain %q", tc.path, tc.want)
+		}
+	}
+}
+
+func TestHiringHandler(t *testing.T) {
+	srv := hnfake.New(hnfake.Fixture{})
+	defer srv.Close()
+	srv.SetUser(hn.User{ID: hiringUser, Submitted: []int{500, 501}})
+	srv.SetItem(hn.Item{ID: 500, Type: "story", Title: "Ask HN: Who wants to be hired? (April 2018)", Kids: []int{600}})
+	srv.SetItem(hn.Item{ID: 501, Type: "story", Title: "Ask HN: Who is hiring? (April 2018)", Kids: []int{601, 602, 603}})
+	srv.SetItem(hn.Item{ID: 600, Type: "comment", Text: "Location: Anywhere | Remote: Yes"})
+	srv.SetItem(hn.Item{ID: 601, Type: "comment", By: "acme", Text: "Acme Corp | Berlin | REMOTE | Full-time<p>We make anvils."})
+	srv.SetItem(hn.Item{ID: 602, Type: "comment", By: "initech", Text: "Initech | Austin, TX | Onsite<p>TPS reports."})
+	srv.SetItem(hn.Item{ID: 603, Type: "comment", Dead: true, Text: "Spam | Everywhere"})
+
+	cfg := config{Messages: testMessages(t), Concurrency: 2, Hiring: hiringConfig{Enabled: true, CacheDuration: time.Minute}}
+	h := hiringHandler(cfg, newHiringBoard(srv.Client(), cfg), testTemplates(t))
+
+	tests := []struct {
+		query   string
+		want    []string
+		notWant []string
+	}{
+		{"", []string{"Ask HN: Who is hiring? (April 2018)", "Acme Corp", "Initech", "2 of 2 job postings"}, []string{"Spam", "Anywhere"}},
+		{"?remote=1", []string{"Acme Corp"}, []string{"Initech"}},
+		{"?location=austin", []string{"Initech"}, []string{"Acme Corp"}},
+		{"?q=anvils", []string{"Acme Corp", "1 of 2 job postings"}, []string{"Initech"}},
+	}
+	for _, tc := range tests {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/hiring"+tc.query, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("/hiring%s: status code: want %d, got %d", tc.query, http.StatusOK, rec.Code)
+		}
+		body := rec.Body.String()
+		for _, want := range tc.want {
+			if !strings.Contains(body, want) {
+				t.Errorf("/hiring%s: body does not contain %q", tc.query, want)
+			}
+		}
+		for _, notWant := range tc.notWant {
+			if strings.Contains(body, notWant) {
+				t.Errorf("/hiring%s: body contains %q", tc.query, notWant)
+			}
+		}
+	}
+}
+
+func TestTitleTerms(t *testing.T) {
+	got := strings.Join(titleTerms("Show HN: A C++ to Node.js compiler, written in Rust (2018)"), " ")
+	if want := "c++ node.js compiler written rust"; got != want {
+		t.Errorf("titleTerms(): want %q, got %q", want, got)
+	}
+}
+
+func TestTrendsHandler(t *testing.T) {
+	now := time.Now()
+	hist := history.NewStore()
+	hist.Record(history.Snapshot{Time: now.Add(-30 * time.Hour), Stories: []history.Story{{ID: 1, Title: "Go 1.10 is released"}, {ID: 2, Title: "Why Go"}}})
+	hist.Record(history.Snapshot{Time: now.Add(-2 * time.Hour), Stories: []history.Story{{ID: 3, Title: "Rust in production"}, {ID: 4, Title: "Go generics"}, {ID: 5, Title: "Learning Rust"}}})
+	hist.Record(history.Snapshot{Time: now.Add(-time.Hour), Stories: []history.Story{{ID: 3, Title: "Rust in production"}, {ID: 6, Title: "Rust and Go"}}})
+
+	rec := httptest.NewRecorder()
+	trendsHandler(hist).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/trends", nil))
+	var resp struct {
+		Window string
+		Terms  []trend
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("json.Unmarshal() received an error: %s", err.Error())
+	}
+	want := []trend{{Term: "rust", Stories: 3}, {Term: "go", Stories: 2, Previous: 2}}
+	if len(resp.Terms) != len(want) || resp.Terms[0] != want[0] || resp.Terms[1] != want[1] {
+		t.Errorf("trends: want %v, got %v", want, resp.Terms)
+	}
+
+	rec = httptest.NewRecorder()
+	trendsHandler(hist).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/trends?window=1y", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("status code for an unknown window: want %d, got %d", http.StatusBadRequest, rec.Code)
+	}
+}
+
+func TestGraphQLHandler(t *testing.T) {
+	srv, err := hnfake.NewFromFile("hn/hnfake/testdata/frontpage.json")
+	if err != nil {
+		t.Fatalf("hnfake.NewFromFile() received an error: %s", err.Error())
+	}
+	defer srv.Close()
+	h := graphQLHandler(graphQLSchema(srv.Client(), &Cache{ExpirationDuration: time.Minute}, config{NumStories: 30, Concurrency: 4, Defaults: preferences{HideJobs: true}}))
+
+	body := `{"query": "query($n: Int) { stories(first: $n, offset: 1) { rank title host by { id karma } } }", "variables": {"n": 2}}`
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(body)))
+	want := `{"data":{"stories":[{"rank":2,"title":"Fixture story 2","host":"blog.example.org","by":null},{"rank":3,"title":"Fixture story 3","host":"github.com","by":null}]}}`
+	if got := strings.TrimSpace(rec.Body.String()); got != want {
+		t.Errorf("POST /graphql:\nwant %s\ngot  %s", want, got)
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/graphql?query="+url.QueryEscape(`{ user(id: "user1") { karma submitted(first: 3) { id } } }`), nil))
+	want = `{"data":{"user":{"karma":2937,"submitted":[{"id":1},{"id":7}]}}}`
+	if got := strings.TrimSpace(rec.Body.String()); got != want {
+		t.Errorf("GET /graphql:\nwant %s\ngot  %s", want, got)
+	}
+
+	// aliases would otherwise fetch any number of items in one query
+	var query strings.Builder
+	query.WriteString("{")
+	for i := 0; i <= maxGraphQLItems; i++ {
+		fmt.Fprintf(&query, " i%d: item(id: 1) { id }", i)
+	}
+	query.WriteString(" }")
+	budgeted, _ := json.Marshal(graphQLRequest{Query: query.String()})
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/graphql", bytes.NewReader(budgeted)))
+	var resp struct {
+		Data   map[string]interface{}
+		Errors []struct{ Message string }
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("json.Unmarshal() received an error: %s", err.Error())
+	}
+	if len(resp.Errors) != 1 || resp.Data["i0"] == nil || resp.Data[fmt.Sprintf("i%d", maxGraphQLItems)] != nil {
+		t.Errorf("a query fetching more than %d items: want the last one null with an error, got errors %v", maxGraphQLItems, resp.Errors)
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/graphql", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("status code for DELETE: want %d, got %d", http.StatusMethodNotAllowed, rec.Code)
+	}
+}
+
+func TestStoriesAPIHandler(t *testing.T) {
+	p := newFakeProvider(10)
+	p.items[2] = hn.Item{ID: 2, Title: "Acme is hiring", Type: "job", URL: "https://example.com/jobs"}
+	h := storiesAPIHandler(p, &Cache{ExpirationDuration: time.Minute}, config{NumStories: 3, Concurrency: 2, Defaults: preferences{HideJobs: true}})
+
+	tests := []struct {
+		query string
+		ids   []int
+	}{
+		{"", []int{1, 3, 4}},
+		{"?hide_jobs=false", []int{1, 2, 3}},
+		{"?n=2&page=2", []int{4}},
+		{"?sort=score", []int{4, 3, 1}},
+	}
+	for id := 1; id <= 10; id++ {
+		itm := p.items[id]
+		itm.Score = id
+		p.items[id] = itm
+	}
+	for _, tc := range tests {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/stories"+tc.query, nil))
+		var resp struct{ Stories []apiStory }
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("json.Unmarshal() received an error: %s", err.Error())
+		}
+		var ids []int
+		for _, story := range resp.Stories {
+			ids = append(ids, story.ID)
+		}
+		if fmt.Sprint(ids) != fmt.Sprint(tc.ids) {
+			t.Errorf("/api/stories%s: want stories %v, got %v", tc.query, tc.ids, ids)
+		}
+		if len(rec.Result().Cookies()) != 0 {
+			t.Errorf("/api/stories%s set cookies", tc.query)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/stories?hide_jobs=maybe&n=100&sort=random", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("status code for invalid parameters: want %d, got %d", http.StatusBadRequest, rec.Code)
+	}
+	var resp struct{ Params []paramError }
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("json.Unmarshal() received an error: %s", err.Error())
+	}
+	if len(resp.Params) != 3 || resp.Params[0].Param != "hide_jobs" || resp.Params[1].Param != "n" || resp.Params[2].Param != "sort" {
+		t.Errorf("params: want errors for hide_jobs, n and sort, got %+v", resp.Params)
+	}
+}
+
+func TestHandler_invalidPreference(t *testing.T) {
+	h := handler(newFakeProvider(3), &Cache{ExpirationDuration: time.Minute}, config{Messages: testMessages(t), NumStories: 3, Concurrency: 2}, nil, nil, nil, nil, nil, testTemplates(t))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/?hide_jobs=maybe&tz=Nowhere/Special", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("status code: want %d, got %d", http.StatusBadRequest, rec.Code)
+	}
+	if !strings.Contains(rec.Body.String(), "hide_jobs, tz") {
+		t.Errorf("body does not name the invalid parameters:\n%s", rec.Body.String())
+	}
+	if len(rec.Result().Cookies()) != 0 {
+		t.Errorf("invalid preferences were saved")
+	}
+}
+
+func TestOpenAPIHandler(t *testing.T) {
+	rec := httptest.NewRecorder()
+	openAPIHandler(true, true).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://quiet.example.com/api/openapi.json", nil))
+	var doc struct {
+		OpenAPI string
+		Paths   map[string]interface{}
+		Servers []struct{ URL string }
+
+		Components struct {
+			Schemas map[string]struct {
+				Properties map[string]interface{}
+			}
+		}
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &doc); err != nil {
+		t.Fatalf("json.Unmarshal() received an error: %s", err.Error())
+	}
+	for _, path := range []string{"/api/stories", "/api/item/{id}", "/api/trends", "/api/upstream/stats", "/api/clicks", "/api/stories/{id}/clicks", "/graphql"} {
+		if doc.Paths[path] == nil {
+			t.Errorf("the document does not describe %s", path)
+		}
+	}
+	if len(doc.Servers) != 1 || doc.Servers[0].URL != "http://quiet.example.com" {
+		t.Errorf("servers: want http://quiet.example.com, got %v", doc.Servers)
+	}
+	if doc.Components.Schemas["Story"].Properties["archive_url"] == nil {
+		t.Errorf("the Story schema has no archive_url property")
+	}
+	if doc.Components.Schemas["Item"].Properties["descendants"] == nil {
+		t.Errorf("the Item schema has no descendants property")
+	}
+}
+
+func TestWithCORS(t *testing.T) {
+	cfg := corsConfig{Origins: []string{"https://app.example.com"}, Methods: []string{"GET", "POST"}, MaxAge: time.Minute}
+	h := withCORS(cfg, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("{}"))
+	}))
+
+	tests := []struct {
+		name        string
+		method      string
+		origin      string
+		preflight   string
+		code        int
+		allowOrigin string
+	}{
+		{"same origin", "GET", "", "", http.StatusOK, ""},
+		{"allowed origin", "GET", "https://app.example.com", "", http.StatusOK, "https://app.example.com"},
+		{"other origin", "GET", "https://evil.example.com", "", http.StatusOK, ""},
+		{"preflight", "OPTIONS", "https://app.example.com", "POST", http.StatusNoContent, "https://app.example.com"},
+		{"preflight for another method", "OPTIONS", "https://app.example.com", "DELETE", http.StatusForbidden, ""},
+		{"preflight from another origin", "OPTIONS", "https://evil.example.com", "GET", http.StatusForbidden, ""},
+	}
+	for _, tc := range tests {
+		req := httptest.NewRequest(tc.method, "/api/stories", nil)
+		if tc.origin != "" {
+			req.Header.Set("Origin", tc.origin)
+		}
+		if tc.preflight != "" {
+			req.Header.Set("Access-Control-Request-Method", tc.preflight)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != tc.code {
+			t.Errorf("%s: status code: want %d, got %d", tc.name, tc.code, rec.Code)
+		}
+		if got := rec.Header().Get("Access-Control-Allow-Origin"); got != tc.allowOrigin {
+			t.Errorf("%s: Access-Control-Allow-Origin: want %q, got %q", tc.name, tc.allowOrigin, got)
+		}
+	}
+
+	req := httptest.NewRequest("OPTIONS", "/api/stories", nil)
+	req.Header.Set("Origin", "https://app.example.com")
+	req.Header.Set("Access-Control-Request-Method", "GET")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if got := rec.Header().Get("Access-Control-Allow-Methods"); got != "GET, POST" {
+		t.Errorf("Access-Control-Allow-Methods: want %q, got %q", "GET, POST", got)
+	}
+	if got := rec.Header().Get("Access-Control-Max-Age"); got != "60" {
+		t.Errorf("Access-Control-Max-Age: want %q, got %q", "60", got)
+	}
+	if got := rec.Header().Get("Access-Control-Allow-Headers"); got != "Content-Type, Authorization, X-API-Key" {
+		t.Errorf("Access-Control-Allow-Headers: want the API key headers allowed, got %q", got)
+	}
+}
+
+func TestWithAPIKeys(t *testing.T) {
+	keys := newAPIKeys([]apiKey{{Name: "app", Key: "secret", RequestsPerMinute: 2}})
+	h := withAPIKeys(keys, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("{}"))
+	}))
+
+	tests := []struct {
+		name string
+		set  func(r *http.Request)
+		code int
+	}{
+		{"no key", func(r *http.Request) {}, http.StatusUnauthorized},
+		{"wrong key", func(r *http.Request) { r.Header.Set("X-API-Key", "guess") }, http.StatusUnauthorized},
+		{"bearer token", func(r *http.Request) { r.Header.Set("Authorization", "Bearer secret") }, http.StatusOK},
+		{"query parameter", func(r *http.Request) { r.URL.RawQuery = "api_key=secret" }, http.StatusOK},
+		{"over the limit", func(r *http.Request) { r.Header.Set("X-API-Key", "secret") }, http.StatusTooManyRequests},
+	}
+	for _, tc := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/api/stories", nil)
+		tc.set(req)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != tc.code {
+			t.Errorf("%s: status code: want %d, got %d", tc.name, tc.code, rec.Code)
+		}
+	}
+
+	usage := keys.Usage("app")
+	if usage.Requests != 3 || usage.Limited != 1 {
+		t.Errorf("usage: want 3 requests and 1 limited, got %+v", usage)
+	}
+	backend := fileUsage{path: filepath.Join(t.TempDir(), "usage.json")}
+	if err := keys.save(backend); err != nil {
+		t.Fatalf("save() received an error: %s", err.Error())
+	}
+	loaded := newAPIKeys(nil)
+	if err := loaded.load(backend); err != nil {
+		t.Fatalf("load() received an error: %s", err.Error())
+	}
+	if got := loaded.Usage("app"); got.Requests != 3 {
+		t.Errorf("loaded usage: want 3 requests, got %+v", got)
+	}
+}
+
+func TestAPIKeys_allow(t *testing.T) {
+	k := apiKey{Name: "app", Key: "secret", RequestsPerMinute: 60}
+	keys := newAPIKeys([]apiKey{k})
+	now := time.Now()
+	for i := 0; i < 60; i++ {
+		if ok, _ := keys.allow(k, now); !ok {
+			t.Fatalf("request %d was limited", i+1)
+		}
+	}
+	ok, wait := keys.allow(k, now)
+	if ok || wait < time.Second-time.Millisecond || wait > time.Second {
+		t.Errorf("request 61: want limited for about 1s, got %t, %v", ok, wait)
+	}
+	if ok, _ := keys.allow(k, now.Add(time.Second)); !ok {
+		t.Errorf("request a second later was limited")
+	}
+}
+
+func TestLocalTime(t *testing.T) {
+	if got := localTime(1522599083, "UTC"); got != "2018-04-01 16:11 UTC" {
+		t.Errorf("localTime(UTC): want %q, got %q", "2018-04-01 16:11 UTC", got)
+	}
+	if got := localTime(1522599083, "Asia/Tokyo"); got != "2018-04-02 01:11 JST" {
+		t.Errorf("localTime(Asia/Tokyo): want %q, got %q", "2018-04-02 01:11 JST", got)
+	}
+	if got := localTime(1522599083, "Nowhere/Special"); got != "2018-04-01 16:11 UTC" {
+		t.Errorf("localTime(invalid): want %q, got %q", "2018-04-01 16:11 UTC", got)
+	}
+}
+
+func TestWarmCache(t *testing.T) {
+	p := newFakeProvider(5)
+	cache := &Cache{ExpirationDuration: time.Hour}
+	cfg := config{NumStories: 3, Concurrency: 2}
+	var ready readiness
+	h := readyzHandler(&ready)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("/readyz before warming: want %d, got %d", http.StatusServiceUnavailable, rec.Code)
+	}
+
+	warmCache(p, cache, cfg, time.Second, &ready)
+	if cache.IsEmpty(newFilter(cfg, cfg.Defaults).key()) {
+		t.Errorf("cache is empty after warming")
+	}
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("/readyz after warming: want %d, got %d", http.StatusOK, rec.Code)
+	}
+}
+
+func TestAllowMethods(t *testing.T) {
+	tpls := testTemplates(t)
+	p := newFakeProvider(3)
+	cfg := config{Messages: testMessages(t), NumStories: 3, Concurrency: 2}
+	mux := http.NewServeMux()
+	mux.Handle("/", allowMethods(handler(p, &Cache{ExpirationDuration: time.Minute}, cfg, nil, nil, nil, nil, nil, tpls), rejectPage(tpls), http.MethodGet))
+	mux.Handle("/api/stories", allowMethods(storiesAPIHandler(p, &Cache{ExpirationDuration: time.Minute}, cfg), rejectAPI, http.MethodGet))
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	for _, path := range []string{"/", "/api/stories"} {
+		get, err := http.Get(srv.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(get.Body)
+		get.Body.Close()
+
+		head, err := http.Head(srv.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		head.Body.Close()
+		if get.ContentLength != int64(len(body)) {
+			t.Errorf("GET %s: want a Content-Length of %d, got %d", path, len(body), get.ContentLength)
+		}
+		// the render time in the footer may differ
+		if head.StatusCode != http.StatusOK || head.ContentLength <= 0 {
+			t.Errorf("HEAD %s: want 200 with a Content-Length, got %d with %d", path, head.StatusCode, head.ContentLength)
+		}
+
+		post, err := http.Post(srv.URL+path, "text/plain", strings.NewReader(""))
+		if err != nil {
+			t.Fatal(err)
+		}
+		post.Body.Close()
+		if post.StatusCode != http.StatusMethodNotAllowed || post.Header.Get("Allow") != "GET, HEAD" {
+			t.Errorf("POST %s: want 405 allowing GET, HEAD, got %d allowing %q", path, post.StatusCode, post.Header.Get("Allow"))
+		}
+	}
+}
+
+func TestUserAgentTransport(t *testing.T) {
+	var ua string
+	page := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ua = r.Header.Get("User-Agent")
+	}))
+	defer page.Close()
+
+	want := defaultUserAgent("https://quiet.example.com/about")
+	client := &http.Client{Transport: userAgentTransport{http.DefaultTransport, want}}
+	req, _ := http.NewRequest(http.MethodGet, page.URL, nil)
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("client.Do() received an error: %s", err.Error())
+	}
+	resp.Body.Close()
+	if ua != want {
+		t.Errorf("User-Agent: want %s, got %s", want, ua)
+	}
+	if req.Header.Get("User-Agent") != "" {
+		t.Errorf("the transport modified the request")
+	}
+	if want != "quiet_hn/dev (+https://quiet.example.com/about)" {
+		t.Errorf("defaultUserAgent(): got %s", want)
+	}
+}
+
+func TestVersionHandler(t *testing.T) {
+	rec := httptest.NewRecorder()
+	versionHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/version", nil))
+	var info buildInfo
+	if err := json.Unmarshal(rec.Body.Bytes(), &info); err != nil {
+		t.Fatalf("json.Unmarshal() received an error: %s", err.Error())
+	}
+	if info.Version != "dev" || info.GoVersion == "" {
+		t.Errorf("build info: want version dev and a Go version, got %+v", info)
+	}
+}
+
+func TestFeatureFlags(t *testing.T) {
+	f := defaultFeatures()
+	if !f.Enabled(featureComments) {
+		t.Errorf("comments: want enabled by default")
+	}
+	if err := f.Set("-comments"); err != nil {
+		t.Fatalf("f.Set() received an error: %s", err.Error())
+	}
+	if f.Enabled(featureComments) {
+		t.Errorf("comments: want disabled")
+	}
+	if err := f.Set("comments"); err != nil || !f.Enabled(featureComments) {
+		t.Errorf("comments: want enabled again, got error %v", err)
+	}
+	if err := f.Set("teleportation"); err == nil {
+		t.Errorf("f.Set(): want an error for an unknown feature")
+	}
+}
+
+func TestParseListener(t *testing.T) {
+	tests := []struct {
+		spec string
+		want listener
+	}{
+		{":8080", listener{Network: "tcp", Addr: ":8080", Kind: listenHTTP}},
+		{":443,tls", listener{Network: "tcp", Addr: ":443", Kind: listenTLS}},
+		{"0.0.0.0:80,redirect", listener{Network: "tcp", Addr: "0.0.0.0:80", Kind: listenRedirect}},
+		{"unix:/run/quiet_hn.sock", listener{Network: "unix", Addr: "/run/quiet_hn.sock", Kind: listenHTTP}},
+		{"127.0.0.1:3002,stories", listener{Network: "tcp", Addr: "127.0.0.1:3002", Kind: listenStories}},
+	}
+	for _, tc := range tests {
+		got, err := parseListener(tc.spec)
+		if err != nil {
+			t.Errorf("parseListener(%q) received an error: %s", tc.spec, err.Error())
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("parseListener(%q): want %+v, got %+v", tc.spec, tc.want, got)
+		}
+	}
+	for _, spec := range []string{"8080", ":443,ftp", "unix:"} {
+		if _, err := parseListener(spec); err == nil {
+			t.Errorf("parseListener(%q): want an error", spec)
+		}
+	}
+}
+
+func TestServers(t *testing.T) {
+	listeners := []listener{
+		{Network: "tcp", Addr: ":80", Kind: listenRedirect},
+		{Network: "tcp", Addr: ":8443", Kind: listenTLS},
+	}
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	srvs := servers(listeners, ok, ok, ok)
+
+	rec := httptest.NewRecorder()
+	srvs[0].Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://quiet.example.com/item/1?lang=de", nil))
+	if rec.Code != http.StatusMovedPermanently || rec.Header().Get("Location") != "https://quiet.example.com:8443/item/1?lang=de" {
+		t.Errorf("redirect: want a 301 to the tls listener, got %d to %s", rec.Code, rec.Header().Get("Location"))
+	}
+	rec = httptest.NewRecorder()
+	srvs[1].Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "https://quiet.example.com/", nil))
+	if rec.Header().Get("Strict-Transport-Security") == "" {
+		t.Errorf("tls: want HSTS")
+	}
+}
+
+func TestAdminRouter(t *testing.T) {
+	clicks := newClickCounter()
+	clicks.add(1)
+	var ready readiness
+	admin := adminRouter([]string{"admin.internal"}, &Cache{ExpirationDuration: time.Minute}, &hn.Metrics{}, clicks, jobs.New(jobs.Config{}), nil, nil, nil, nil, &ready, testTemplates(t))
+	tests := []struct {
+		path, want string
+	}{
+		{"/admin", "Front page cache"},
+		{"/metrics", "quiet_hn_clicks_total 1\n"},
+		{"/metrics", "quiet_hn_jobs_failed_total 0\n"},
+		{"/debug/pprof/", "goroutine"},
+		{"/debug/pprof/goroutine?debug=1", "goroutine profile"},
+	}
+	for _, tc := range tests {
+		rec := httptest.NewRecorder()
+		admin.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://127.0.0.1:3001"+tc.path, nil))
+		if rec.Code != http.StatusOK {
+			t.Errorf("%s: status code: want %d, got %d", tc.path, http.StatusOK, rec.Code)
+			continue
+		}
+		if !strings.Contains(rec.Body.String(), tc.want) {
+			t.Errorf("%s: body does not contain %q:\n%s", tc.path, tc.want, rec.Body.String())
+		}
+	}
+
+	// a page of a site resolving to the admin address is of the same origin
+	// as the dashboard, but not of the same host
+	for host, want := range map[string]int{
+		"localhost:3001":      http.StatusOK,
+		"[::1]:3001":          http.StatusOK,
+		"Admin.Internal":      http.StatusOK,
+		"evil.example:3001":   http.StatusForbidden,
+		"192.168.1.10:3001":   http.StatusForbidden,
+		"localhost.evil.test": http.StatusForbidden,
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/debug/pprof/cmdline", nil)
+		req.Host = host
+		rec := httptest.NewRecorder()
+		admin.ServeHTTP(rec, req)
+		if rec.Code != want {
+			t.Errorf("Host %s: status code: want %d, got %d", host, want, rec.Code)
+		}
+	}
+}
+
+func TestParseListener_ipv6(t *testing.T) {
+	for _, spec := range []string{"[::1]:3000", "[::]:443,tls"} {
+		l, err := parseListener(spec)
+		if err != nil {
+			t.Errorf("parseListener(%q) received an error: %s", spec, err.Error())
+			continue
+		}
+		if host, _, _ := net.SplitHostPort(l.Addr); net.ParseIP(host).To4() != nil {
+			t.Errorf("parseListener(%q): want an IPv6 address, got %s", spec, l.Addr)
+		}
+	}
+	if _, err := parseListener("::1"); err == nil {
+		t.Errorf("parseListener(\"::1\"): want an error for an IPv6 literal without brackets and port")
+	}
+}
+
+func TestSearchHandler(t *testing.T) {
+	hist := history.NewStore()
+	hist.Record(history.Snapshot{Time: time.Now().Add(-30 * 24 * time.Hour), Stories: []history.Story{
+		{ID: 1, Rank: 1, Title: "Concurrency in Go", URL: "https://example.com/1"},
+		{ID: 2, Rank: 2, Title: "Caching strategies", URL: "https://example.com/2"},
+	}})
+	idx := search.New()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	indexHistory(ctx, idx, hist, time.Hour)
+
+	h := searchHandler(config{NumStories: 30, Messages: testMessages(t)}, idx, testTemplates(t))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/search?q=concurrency&scope=local", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status code: want %d, got %d", http.StatusOK, rec.Code)
+	}
+	body := rec.Body.String()
+	if !strings.Contains(body, "Concurrency in Go") || strings.Contains(body, "Caching strategies") {
+		t.Errorf("body should only list the matching story: %s", body)
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/search?q=go&scope=algolia", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("status code of an unknown scope: want %d, got %d", http.StatusBadRequest, rec.Code)
+	}
+}
+
+func TestDomainsHandler(t *testing.T) {
+	now := time.Now()
+	hist := history.NewStore()
+	hist.Record(history.Snapshot{Time: now.Add(-2 * time.Hour), Stories: []history.Story{
+		{ID: 1, Rank: 1, Title: "Old example story", URL: "https://example.com/1", Score: 100},
+		{ID: 2, Rank: 2, Title: "Golang story", URL: "https://golang.org/2", Score: 300},
+		{ID: 3, Rank: 3, Title: "Ask HN: Anything?", URL: "https://news.ycombinator.com/item?id=3", Score: 50},
+	}})
+	hist.Record(history.Snapshot{Time: now.Add(-time.Hour), Stories: []history.Story{
+		{ID: 4, Rank: 1, Title: "New example story", URL: "https://www.example.com/4", Score: 200},
+	}})
+
+	stats := domainStats(hist.Peaks(time.Time{}, now), func(id int) time.Time {
+		t, _ := hist.FirstSeen(id)
+		return t
+	})
+	if len(stats) != 2 {
+		t.Fatalf("domainStats(): want 2 domains, got %+v", stats)
+	}
+	if s := stats[0]; s.Host != "example.com" || s.Stories != 2 || s.AvgScore != 150 || len(s.Recent) != 2 || s.Recent[0].ID != 4 {
+		t.Errorf("domainStats()[0]: want example.com with stories 4 and 1 averaging 150 points, got %+v", s)
+	}
+
+	h := domainsHandler(config{NumStories: 30, Messages: testMessages(t)}, hist, testTemplates(t))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/domains?period=week", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status code: want %d, got %d", http.StatusOK, rec.Code)
+	}
+	if body := rec.Body.String(); !strings.Contains(body, "golang.org") || !strings.Contains(body, "New example story") {
+		t.Errorf("body does not list the domains and their stories: %s", body)
+	}
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/domains?period=year", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("status code of an unknown period: want %d, got %d", http.StatusBadRequest, rec.Code)
+	}
+}
+
+func TestGenerateSite(t *testing.T) {
+	now := time.Now()
+	hist := history.NewStore()
+	hist.Record(history.Snapshot{Time: now.AddDate(0, 0, -1), Stories: []history.Story{{ID: 42, Rank: 1, Title: "Yesterday's story", URL: "https://example.com/42"}}})
+	s := &site{
+		client:      newFakeProvider(5),
+		cache:       &Cache{ExpirationDuration: time.Hour},
+		cfg:         config{NumStories: 3, Concurrency: 2, Messages: testMessages(t)},
+		tpls:        testTemplates(t),
+		compression: func(h http.Handler) http.Handler { return h },
+		hist:        hist,
+	}
+	out := t.TempDir()
+	n, err := generateSite(s, out, "en", 3, now)
+	if err != nil {
+		t.Fatalf("generateSite() received an error: %s", err.Error())
+	}
+	// the front page, both best pages and yesterday, but not the days before
+	// the history starts
+	if n != 4 {
+		t.Errorf("pages generated: want %d, got %d", 4, n)
+	}
+	index, err := os.ReadFile(filepath.Join(out, "index.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(index), "https://example.com/1") {
+		t.Errorf("the front page does not list its stories: %s", index)
+	}
+	day := now.AddDate(0, 0, -1).UTC().Format("2006-01-02")
+	page, err := os.ReadFile(filepath.Join(out, "history", day, "index.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(page), "Yesterday&#39;s story") {
+		t.Errorf("the history page of %s does not list its story", day)
+	}
+	if _, ok := hist.FirstSeen(1); !ok {
+		t.Errorf("the front page wasn't recorded to the history")
+	}
+
+	got := string(staticPage([]byte(`<a href="/item/42">1 comment</a> <a href="/user/pg">pg</a> <a href="/best/week">`)))
+	want := `<a href="https://news.ycombinator.com/item?id=42">1 comment</a> <a href="https://news.ycombinator.com/user?id=pg">pg</a> <a href="/best/week">`
+	if got != want {
+		t.Errorf("staticPage(): want %s, got %s", want, got)
+	}
+}
+
+func TestKeyboardJS(t *testing.T) {
+	for _, enabled := range []bool{false, true} {
+		s := &site{
+			client:      newFakeProvider(5),
+			cache:       &Cache{ExpirationDuration: time.Hour},
+			cfg:         config{NumStories: 3, Concurrency: 2, Messages: testMessages(t), KeyboardJS: enabled},
+			tpls:        testTemplates(t),
+			compression: func(h http.Handler) http.Handler { return h },
+		}
+		h := s.routes()
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		if linked := strings.Contains(rec.Body.String(), `<script src="/keys.js`); linked != enabled {
+			t.Errorf("keyboard_js=%v: want the script linked %v, got %v", enabled, enabled, linked)
+		}
+		if csp := rec.Header().Get("Content-Security-Policy"); strings.Contains(csp, "script-src") != enabled {
+			t.Errorf("keyboard_js=%v: want scripts allowed %v, got %q", enabled, enabled, csp)
+		}
+		rec = httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/keys.js", nil))
+		if served := rec.Code == http.StatusOK; served != enabled {
+			t.Errorf("keyboard_js=%v: want /keys.js served %v, got status %d", enabled, enabled, rec.Code)
+		}
+	}
+}
+
+func TestCardHandler(t *testing.T) {
+	p := newFakeProvider(3)
+	cfg := config{Messages: testMessages(t)}
+	h := routed("/item/{id}/card.png", cardHandler(p, cfg))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/item/1/card.png", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status code: want %d, got %d", http.StatusOK, rec.Code)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "image/png" {
+		t.Errorf("Content-Type: want image/png, got %s", ct)
+	}
+	if _, err := png.Decode(rec.Body); err != nil {
+		t.Errorf("the card isn't a PNG: %s", err.Error())
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/item/x/card.png", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("status code of an invalid id: want %d, got %d", http.StatusBadRequest, rec.Code)
+	}
+
+	// the item page points to its card
+	items := routed("/item/{id}", itemHandler(p, cfg, nil, nil, testTemplates(t)))
+	rec = httptest.NewRecorder()
+	items.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://quiet.example.com/item/1", nil))
+	if !strings.Contains(rec.Body.String(), `<meta property="og:image" content="http://quiet.example.com/item/1/card.png">`) {
+		t.Errorf("the item page has no og:image: %s", rec.Body.String())
+	}
+}
+
+func TestPublicAddressesOnly(t *testing.T) {
+	for _, tc := range []struct {
+		address string
+		public  bool
+	}{
+		{"93.184.216.34:443", true},
+		{"[2606:2800:220:1::]:443", true},
+		{"172.32.0.1:80", true},
+		{"127.0.0.1:80", false},
+		{"10.1.2.3:80", false},
+		{"172.16.0.1:80", false},
+		{"192.168.1.1:80", false},
+		{"[fd00::1]:80", false},
+		{"169.254.169.254:80", false},
+	} {
+		if err := publicAddressesOnly("tcp", tc.address, nil); (err == nil) != tc.public {
+			t.Errorf("publicAddressesOnly(%s): want public %v, got %v", tc.address, tc.public, err)
+		}
+	}
+}
+
+// unavailableTransport answers every request with a 503, once release is
+// closed, counting them
+type unavailableTransport struct {
+	release  chan struct{}
+	requests int32
+}
+
+func (u *unavailableTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	<-u.release
+	atomic.AddInt32(&u.requests, 1)
+	return &http.Response{StatusCode: http.StatusServiceUnavailable, Status: "503 Service Unavailable", Body: ioutil.NopCloser(strings.NewReader("")), Request: req}, nil
+}
+
+func TestFaviconFetcher_Get_failed(t *testing.T) {
+	f := newFaviconFetcher(faviconConfig{CacheDuration: 24 * time.Hour, MaxBytes: 1 << 10}, "test")
+	transport := &unavailableTransport{release: make(chan struct{})}
+	f.client = &http.Client{Transport: transport}
+
+	var wg sync.WaitGroup
+	icons := make([]favicon, 5)
+	for i := range icons {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			icons[i] = f.Get(context.Background(), "example.com")
+		}(i)
+	}
+	// the requests of the five Gets are blocked until they all share a
+	// single fetch
+	for {
+		f.mu.Lock()
+		pending := f.calls["example.com"] != nil
+		f.mu.Unlock()
+		if pending {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	close(transport.release)
+	wg.Wait()
+	// one fetch, of the home page and then of /favicon.ico
+	if n := atomic.LoadInt32(&transport.requests); n != 2 {
+		t.Errorf("requests: want 2, got %d", n)
+	}
+	for i, icon := range icons {
+		if !icon.failed || string(icon.data) != fallbackFavicon {
+			t.Fatalf("Get() #%d: want the fallback icon, got %+v", i+1, icon)
+		}
+	}
+	// the failure is only cached for a short while
+	if ttl := time.Until(f.icons["example.com"].expiration); ttl > faviconRetry {
+		t.Errorf("fallback icon: want it cached for %s, got %s", faviconRetry, ttl)
+	}
+}
+
+func TestFaviconFetcher_allowed(t *testing.T) {
+	f := newFaviconFetcher(faviconConfig{CacheDuration: time.Hour}, "test")
+	f.decorate([]item{{Host: "example.com"}})
+	if !f.Allowed("example.com") || f.Allowed("other.example.com") {
+		t.Errorf("Allowed(): want only the host of the story allowed")
+	}
+	f.allowed["example.com"] = time.Now().Add(-time.Second)
+	f.decorate(nil)
+	if f.Allowed("example.com") || len(f.allowed) != 0 {
+		t.Errorf("Allowed(): want the host expired and dropped, got %v", f.allowed)
+	}
+}
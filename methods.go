@@ -0,0 +1,44 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// allowMethods serves the requests to h made with one of methods, and the
+// HEAD ones if GET is allowed: net/http drops the body of the responses to
+// HEAD requests but keeps their headers, Content-Length included. The others
+// are answered by reject, after setting the Allow header.
+func allowMethods(h http.Handler, reject http.HandlerFunc, methods ...string) http.Handler {
+	allowed := make(map[string]bool, len(methods)+1)
+	list := make([]string, 0, len(methods)+1)
+	for _, m := range methods {
+		allowed[m] = true
+		list = append(list, m)
+		if m == http.MethodGet && !allowed[http.MethodHead] {
+			allowed[http.MethodHead] = true
+			list = append(list, http.MethodHead)
+		}
+	}
+	allow := strings.Join(list, ", ")
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !allowed[r.Method] {
+			w.Header().Set("Allow", allow)
+			reject(w, r)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// rejectPage responds with the 405 Method Not Allowed error page
+func rejectPage(tpls *templateSet) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		tpls.renderError(w, r, http.StatusMethodNotAllowed, "error.method_not_allowed")
+	}
+}
+
+// rejectAPI responds with the 405 Method Not Allowed error of the JSON API
+func rejectAPI(w http.ResponseWriter, r *http.Request) {
+	writeAPIError(w, http.StatusMethodNotAllowed, "method not allowed")
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"compress/gzip"
+	"log"
+	"net/http"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mmxmb/quiet_hn/router"
+)
+
+// The middleware of the routes, applied per group in main.
+
+// statusRecorder is a ResponseWriter remembering the status and size of the
+// response, for logging
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	if s.status == 0 {
+		s.status = status
+	}
+	s.ResponseWriter.WriteHeader(status)
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	n, err := s.ResponseWriter.Write(b)
+	s.bytes += n
+	return n, err
+}
+
+// logRequests logs every request with the status, size and duration of its
+// response
+func logRequests(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		h.ServeHTTP(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		log.Printf("%s %s %d %dB %s", r.Method, r.URL.RequestURI(), rec.status, rec.bytes, time.Since(start).Round(time.Microsecond))
+	})
+}
+
+// recoverPanics responds with a 500 to the requests whose handler panics,
+// logging the panic, instead of dropping the connection. It must be inside
+// logRequests for the 500 to be logged.
+func recoverPanics(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			if err := recover(); err != nil {
+				if err == http.ErrAbortHandler {
+					panic(err)
+				}
+				log.Printf("panic serving %s: %v\n%s", r.URL.Path, err, debug.Stack())
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			}
+		}()
+		h.ServeHTTP(w, r)
+	})
+}
+
+// contentSecurityPolicy only allows the inline styles of the templates,
+// images (thumbnails are hotlinked) and forms submitted to the site itself.
+const contentSecurityPolicy = "default-src 'none'; style-src 'unsafe-inline'; img-src 'self' data: https:; form-action 'self'; base-uri 'none'; frame-ancestors 'none'"
+
+// scriptPolicy is contentSecurityPolicy also allowing the scripts of the
+// site itself, for the optional keyboard navigation
+const scriptPolicy = contentSecurityPolicy + "; script-src 'self'"
+
+// securityHeaders sets the headers keeping browsers from framing the pages,
+// sniffing content types, sending full referrers to the linked sites or
+// running scripts
+var securityHeaders = securityHeadersWith(contentSecurityPolicy)
+
+// securityHeadersWith returns the middleware setting the security headers,
+// with policy as the Content-Security-Policy
+func securityHeadersWith(policy string) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			header := w.Header()
+			header.Set("Content-Security-Policy", policy)
+			header.Set("X-Content-Type-Options", "nosniff")
+			header.Set("X-Frame-Options", "DENY")
+			header.Set("Referrer-Policy", "strict-origin-when-cross-origin")
+			h.ServeHTTP(w, r)
+		})
+	}
+}
+
+// minCompressSize is the size under which responses with a Content-Length
+// aren't worth compressing
+const minCompressSize = 512
+
+var gzipWriters = sync.Pool{New: func() interface{} { return gzip.NewWriter(nil) }}
+
+// compress gzips the responses of clients accepting it, except images and
+// small responses, which don't shrink much
+func compress(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		if r.Method == http.MethodHead || !acceptsGzip(r.Header.Get("Accept-Encoding")) {
+			h.ServeHTTP(w, r)
+			return
+		}
+		gw := &gzipResponseWriter{ResponseWriter: w}
+		defer gw.close()
+		h.ServeHTTP(gw, r)
+	})
+}
+
+// acceptsGzip reports whether the Accept-Encoding header value accepts gzip
+func acceptsGzip(accept string) bool {
+	for _, enc := range strings.Split(accept, ",") {
+		enc = strings.TrimSpace(enc)
+		name, q := enc, ""
+		if i := strings.Index(enc, ";"); i >= 0 {
+			name, q = strings.TrimSpace(enc[:i]), strings.ReplaceAll(enc[i+1:], " ", "")
+		}
+		if name == "gzip" || name == "*" {
+			return q != "q=0" && q != "q=0.0"
+		}
+	}
+	return false
+}
+
+// gzipResponseWriter compresses the response, once the headers tell that
+// it is worth it
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	gz          *gzip.Writer
+	wroteHeader bool
+}
+
+func (g *gzipResponseWriter) WriteHeader(status int) {
+	if g.wroteHeader {
+		return
+	}
+	g.wroteHeader = true
+	header := g.Header()
+	size, err := strconv.Atoi(header.Get("Content-Length"))
+	small := err == nil && size < minCompressSize
+	if status == http.StatusOK && header.Get("Content-Encoding") == "" && !strings.HasPrefix(header.Get("Content-Type"), "image/") && !small {
+		header.Del("Content-Length")
+		header.Set("Content-Encoding", "gzip")
+		g.gz = gzipWriters.Get().(*gzip.Writer)
+		g.gz.Reset(g.ResponseWriter)
+	}
+	g.ResponseWriter.WriteHeader(status)
+}
+
+func (g *gzipResponseWriter) Write(b []byte) (int, error) {
+	if !g.wroteHeader {
+		if g.Header().Get("Content-Type") == "" {
+			g.Header().Set("Content-Type", http.DetectContentType(b))
+		}
+		g.WriteHeader(http.StatusOK)
+	}
+	if g.gz != nil {
+		return g.gz.Write(b)
+	}
+	return g.ResponseWriter.Write(b)
+}
+
+func (g *gzipResponseWriter) close() {
+	if g.gz != nil {
+		g.gz.Close()
+		gzipWriters.Put(g.gz)
+	}
+}
+
+// methods is allowMethods as a Middleware
+func methods(reject http.HandlerFunc, allowed ...string) router.Middleware {
+	return func(h http.Handler) http.Handler {
+		return allowMethods(h, reject, allowed...)
+	}
+}
+
+// cors is withCORS as a Middleware
+func cors(cfg corsConfig) router.Middleware {
+	return func(h http.Handler) http.Handler {
+		return withCORS(cfg, h)
+	}
+}
+
+// authenticate is withAPIKeys as a Middleware
+func authenticate(keys *apiKeys) router.Middleware {
+	return func(h http.Handler) http.Handler {
+		return withAPIKeys(keys, h)
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/mmxmb/quiet_hn/pg"
+)
+
+// migrateLock is the key of the advisory lock held while migrating, so that
+// replicas starting together don't run the same migrations
+const migrateLock = 0x71686e
+
+//go:embed migrations/*.sql
+var embeddedMigrations embed.FS
+
+// migration is a versioned change of the database schema, from a file such
+// as migrations/0002_rules.sql. Migrations are applied in the order of
+// their versions, each in a transaction, and never edited once released.
+type migration struct {
+	Version int
+	Name    string
+	SQL     string
+}
+
+// loadMigrations returns the migrations of fsys, sorted by version
+func loadMigrations(fsys fs.FS) ([]migration, error) {
+	names, err := fs.Glob(fsys, "migrations/*.sql")
+	if err != nil {
+		return nil, err
+	}
+	var ms []migration
+	seen := make(map[int]string)
+	for _, name := range names {
+		base := path.Base(name)
+		i := strings.IndexByte(base, '_')
+		if i < 0 {
+			return nil, fmt.Errorf("migration %s isn't named like 0001_name.sql", name)
+		}
+		v, err := strconv.Atoi(base[:i])
+		if err != nil || v < 1 {
+			return nil, fmt.Errorf("migration %s isn't named like 0001_name.sql", name)
+		}
+		if other, ok := seen[v]; ok {
+			return nil, fmt.Errorf("migrations %s and %s have the same version", other, name)
+		}
+		seen[v] = name
+		b, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return nil, err
+		}
+		ms = append(ms, migration{Version: v, Name: strings.TrimSuffix(base[i+1:], ".sql"), SQL: string(b)})
+	}
+	sort.Slice(ms, func(i, j int) bool { return ms[i].Version < ms[j].Version })
+	return ms, nil
+}
+
+// migrate applies the migrations db doesn't have yet and returns how many
+// it applied
+func migrate(ctx context.Context, db *pg.DB, ms []migration) (int, error) {
+	if err := db.ExecScript(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version integer PRIMARY KEY,
+		name text NOT NULL,
+		applied_at timestamptz NOT NULL DEFAULT now()
+	)`); err != nil {
+		return 0, fmt.Errorf("migrating: %w", err)
+	}
+	if _, err := db.Query(ctx, `SELECT pg_advisory_lock($1)`, migrateLock); err != nil {
+		return 0, fmt.Errorf("migrating: %w", err)
+	}
+	defer db.Query(context.Background(), `SELECT pg_advisory_unlock($1)`, migrateLock)
+
+	rows, err := db.Query(ctx, `SELECT version FROM schema_migrations`)
+	if err != nil {
+		return 0, fmt.Errorf("migrating: %w", err)
+	}
+	applied := make(map[int]bool)
+	for _, row := range rows.Rows {
+		v, _ := strconv.Atoi(*row[0])
+		applied[v] = true
+	}
+	n := 0
+	for _, m := range ms {
+		if applied[m.Version] {
+			continue
+		}
+		// the statements of a script without BEGIN run in one transaction,
+		// the version with them
+		script := m.SQL + fmt.Sprintf("\n;INSERT INTO schema_migrations (version, name) VALUES (%d, %s);", m.Version, pgQuote(m.Name))
+		if err := db.ExecScript(ctx, script); err != nil {
+			return n, fmt.Errorf("migration %d %s: %w", m.Version, m.Name, err)
+		}
+		n++
+	}
+	return n, nil
+}
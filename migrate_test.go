@@ -0,0 +1,30 @@
+package main
+
+import (
+	"testing"
+	"testing/fstest"
+)
+
+func TestLoadMigrations(t *testing.T) {
+	ms, err := loadMigrations(embeddedMigrations)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ms) == 0 || ms[0].Version != 1 || ms[0].Name != "history" {
+		t.Fatalf("embedded migrations: want 0001_history first, got %+v", ms)
+	}
+	for i := 1; i < len(ms); i++ {
+		if ms[i].Version <= ms[i-1].Version {
+			t.Errorf("migrations out of order: %d after %d", ms[i].Version, ms[i-1].Version)
+		}
+	}
+
+	for _, fsys := range []fstest.MapFS{
+		{"migrations/rules.sql": {Data: []byte("SELECT 1;")}},
+		{"migrations/0002_a.sql": {Data: []byte("SELECT 1;")}, "migrations/2_b.sql": {Data: []byte("SELECT 2;")}},
+	} {
+		if _, err := loadMigrations(fsys); err == nil {
+			t.Errorf("loadMigrations(%v): want an error", fsys)
+		}
+	}
+}
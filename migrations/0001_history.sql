@@ -0,0 +1,6 @@
+-- the snapshots of the front page, stored whole as JSON since their times
+-- are kept to the nanosecond there but only to the microsecond in time
+CREATE TABLE IF NOT EXISTS history_snapshots (
+	time timestamptz PRIMARY KEY,
+	snapshot jsonb NOT NULL
+);
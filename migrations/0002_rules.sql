@@ -0,0 +1,6 @@
+-- the alert rules, in the order they are listed on the admin dashboard
+CREATE TABLE IF NOT EXISTS alert_rules (
+	name text PRIMARY KEY,
+	position integer NOT NULL,
+	rule jsonb NOT NULL
+);
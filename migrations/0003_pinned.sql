@@ -0,0 +1,7 @@
+-- the stories pinned on the admin dashboard, in the only row of the table
+-- once they are first saved, which replaces -pinned from then on even if
+-- there are none left
+CREATE TABLE IF NOT EXISTS pinned_stories (
+	board boolean PRIMARY KEY DEFAULT true CHECK (board),
+	ids jsonb NOT NULL
+);
@@ -0,0 +1,6 @@
+-- the threads watched from the admin dashboard, in the order they were added
+CREATE TABLE IF NOT EXISTS watched_threads (
+	id integer PRIMARY KEY,
+	position integer NOT NULL,
+	thread jsonb NOT NULL
+);
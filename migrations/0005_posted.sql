@@ -0,0 +1,7 @@
+-- the stories cross-posted to each sink, so that they are only posted once
+CREATE TABLE IF NOT EXISTS posted_stories (
+	sink text NOT NULL,
+	story integer NOT NULL,
+	posted timestamptz NOT NULL,
+	PRIMARY KEY (sink, story)
+);
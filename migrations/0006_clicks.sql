@@ -0,0 +1,5 @@
+-- the click counts of the links of stories redirected by /out
+CREATE TABLE IF NOT EXISTS story_clicks (
+	story integer PRIMARY KEY,
+	clicks integer NOT NULL
+);
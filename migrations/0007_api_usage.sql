@@ -0,0 +1,7 @@
+-- the usage counters of the API keys
+CREATE TABLE IF NOT EXISTS api_key_usage (
+	name text PRIMARY KEY,
+	requests bigint NOT NULL,
+	limited bigint NOT NULL,
+	last_used timestamptz NOT NULL
+);
@@ -0,0 +1,6 @@
+-- the lease of the leader election, in the only row of the table
+CREATE TABLE IF NOT EXISTS leader_lease (
+	name text PRIMARY KEY,
+	holder text NOT NULL,
+	expires timestamptz NOT NULL
+);
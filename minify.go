@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+)
+
+// rawElements are the elements whose content is written as is by
+// minifyHTML, as their whitespace is significant or isn't HTML. Styles
+// aren't, collapsing the whitespace of CSS is harmless.
+var rawElements = []string{"pre", "textarea", "script"}
+
+var (
+	commentStart = []byte("<!--")
+	commentEnd   = []byte("-->")
+)
+
+// minifyHTML writes src to dst without its comments and with its runs of
+// whitespace collapsed to a single space, except in the attribute values and
+// the content of rawElements. The text of the pages is escaped by the
+// templates, so every < starts a tag or a comment.
+func minifyHTML(dst *bytes.Buffer, src []byte) {
+	space := false // a run of whitespace is pending
+	for i := 0; i < len(src); {
+		switch c := src[i]; {
+		case isHTMLSpace(c):
+			space = true
+			i++
+			continue
+		case bytes.HasPrefix(src[i:], commentStart):
+			end := bytes.Index(src[i+len(commentStart):], commentEnd)
+			if end < 0 {
+				return
+			}
+			i += len(commentStart) + end + len(commentEnd)
+			continue
+		}
+		if space {
+			if dst.Len() > 0 {
+				dst.WriteByte(' ')
+			}
+			space = false
+		}
+		if src[i] != '<' {
+			dst.WriteByte(src[i])
+			i++
+			continue
+		}
+		start := i
+		i = writeTag(dst, src, i)
+		if name := rawElement(src[start:i]); name != "" {
+			end := indexClosingTag(src[i:], name)
+			dst.Write(src[i : i+end])
+			i += end
+		}
+	}
+}
+
+// writeTag writes the tag starting at src[i] to dst, collapsing the
+// whitespace between its attributes, and returns the index past its end
+func writeTag(dst *bytes.Buffer, src []byte, i int) int {
+	var quote byte
+	space := false
+	for ; i < len(src); i++ {
+		c := src[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case isHTMLSpace(c):
+			space = true
+			continue
+		case c == '"' || c == '\'':
+			quote = c
+		}
+		if space {
+			if c != '>' {
+				dst.WriteByte(' ')
+			}
+			space = false
+		}
+		dst.WriteByte(c)
+		if c == '>' && quote == 0 {
+			return i + 1
+		}
+	}
+	return i
+}
+
+// rawElement returns the name of the raw element tag opens, if any
+func rawElement(tag []byte) string {
+	name := tag[1:]
+	for i, c := range name {
+		if isHTMLSpace(c) || c == '>' || c == '/' {
+			name = name[:i]
+			break
+		}
+	}
+	for _, raw := range rawElements {
+		if bytes.EqualFold(name, []byte(raw)) {
+			return raw
+		}
+	}
+	return ""
+}
+
+// indexClosingTag returns the index of the closing tag of the element name
+// in src, or len(src) if it isn't closed
+func indexClosingTag(src []byte, name string) int {
+	closing := []byte("</" + name)
+	for i := 0; i+len(closing) <= len(src); i++ {
+		if src[i] == '<' && bytes.EqualFold(src[i:i+len(closing)], closing) {
+			return i
+		}
+	}
+	return len(src)
+}
+
+func isHTMLSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f'
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestMinifyHTML(t *testing.T) {
+	tests := []struct {
+		name, src, want string
+	}{
+		{"whitespace", "<p>\n    quiet\t\thacker   news\n</p>\n", "<p> quiet hacker news </p>"},
+		{"comments", "<p>a <!-- note --> b</p><!-- unterminated", "<p>a b</p>"},
+		{"attributes", "<a\n  href=\"/item/1\"   title=\"a  b\" >x</a>", `<a href="/item/1" title="a  b">x</a>`},
+		{"pre", "<pre>  keep\n   this </pre>  <p> x </p>", "<pre>  keep\n   this </pre> <p> x </p>"},
+		{"script", "<SCRIPT>\n  let a = 1;\n</SCRIPT>", "<SCRIPT>\n  let a = 1;\n</SCRIPT>"},
+		{"style", "<style>\n  a > b { color: red; }\n</style>", "<style> a > b { color: red; } </style>"},
+		{"leading", "\n\n<!DOCTYPE html>\n<html>", "<!DOCTYPE html> <html>"},
+	}
+	for _, tc := range tests {
+		var buf bytes.Buffer
+		minifyHTML(&buf, []byte(tc.src))
+		if got := buf.String(); got != tc.want {
+			t.Errorf("%s: minifyHTML(%q) = %q, want %q", tc.name, tc.src, got, tc.want)
+		}
+	}
+}
+
+func TestTemplateSet_minify(t *testing.T) {
+	tpls := testTemplates(t)
+	data := errorTemplateData{Lang: "en", Status: 404, StatusText: "Not Found", Message: "gone"}
+	full := httptest.NewRecorder()
+	if err := tpls.render(full, "error", data); err != nil {
+		t.Fatal(err)
+	}
+	tpls.Minify = true
+	min := httptest.NewRecorder()
+	if err := tpls.render(min, "error", data); err != nil {
+		t.Fatal(err)
+	}
+	if min.Body.Len() >= full.Body.Len() {
+		t.Errorf("minified page is %d bytes, want less than %d", min.Body.Len(), full.Body.Len())
+	}
+	if strings.Contains(min.Body.String(), "\n  ") {
+		t.Errorf("minified page is still indented:\n%s", min.Body)
+	}
+	if got, want := min.Header().Get("Content-Length"), strconv.Itoa(min.Body.Len()); got != want {
+		t.Errorf("Content-Length = %s, want %s", got, want)
+	}
+}
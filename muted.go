@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// maxMuted bounds the users muted by a user, which are kept in a cookie
+const maxMuted = 50
+
+// usernameRE matches the names HN accepts for its users
+var usernameRE = regexp.MustCompile(`^[A-Za-z0-9_-]{2,15}$`)
+
+// parseUsers parses a comma separated list of HN usernames, dropping the
+// duplicates. Usernames are compared regardless of case, as HN does.
+func parseUsers(s string) ([]string, error) {
+	var names []string
+	for _, field := range strings.Split(s, ",") {
+		if field = strings.TrimSpace(field); field == "" {
+			continue
+		}
+		if !usernameRE.MatchString(field) {
+			return nil, fmt.Errorf("invalid username %q", field)
+		}
+		if !containsUser(names, field) {
+			names = append(names, field)
+		}
+	}
+	return names, nil
+}
+
+func containsUser(names []string, name string) bool {
+	for _, other := range names {
+		if strings.EqualFold(other, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// mutedUsers returns the users of lists, lowercased, sorted and without
+// duplicates
+func mutedUsers(lists ...[]string) []string {
+	var names []string
+	for _, list := range lists {
+		for _, name := range list {
+			if name = strings.ToLower(name); !containsUser(names, name) {
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// mutedPref reads the users muted by the user, a comma separated list of
+// usernames, from the mute query parameter (saving it) or cookie. An empty
+// mute parameter unmutes them all.
+func mutedPref(w http.ResponseWriter, r *http.Request, q *queryParams, def []string) []string {
+	if values, ok := r.URL.Query()["mute"]; ok {
+		names, err := parseUsers(values[0])
+		if err == nil && len(names) > maxMuted {
+			err = fmt.Errorf("at most %d users can be muted", maxMuted)
+		}
+		if err == nil {
+			setPrefCookie(w, "mute", strings.Join(names, ","))
+			return names
+		}
+		q.fail("mute", err.Error())
+	}
+	if c, err := r.Cookie("mute"); err == nil {
+		if names, err := parseUsers(c.Value); err == nil && len(names) <= maxMuted {
+			return names
+		}
+	}
+	return def
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/mmxmb/quiet_hn/hn"
+)
+
+// errRecentlyFailed is the error of items that aren't fetched because they
+// failed to be fetched recently
+var errRecentlyFailed = errors.New("recently failed to be fetched")
+
+// negativeCache is a StoryProvider remembering the items that failed to be
+// fetched, don't exist or are dead or deleted for TTL, so that refreshes
+// don't keep fetching items that won't make it to the front page anyway.
+// Dead and deleted items are returned as they were last fetched, and failed
+// ones fail again with errRecentlyFailed. If MaxEntries is set, the least
+// recently used items are forgotten past it.
+type negativeCache struct {
+	StoryProvider
+	TTL        time.Duration
+	MaxEntries int
+
+	mu      sync.Mutex
+	entries map[int]negativeEntry
+	// lru has the ids, most recently used first
+	lru *list.List
+}
+
+type negativeEntry struct {
+	// item is the dead or deleted item, or the zero Item for failures
+	item       hn.Item
+	expiration time.Time
+	elem       *list.Element
+}
+
+func newNegativeCache(client StoryProvider, ttl time.Duration) *negativeCache {
+	return &negativeCache{StoryProvider: client, TTL: ttl, entries: make(map[int]negativeEntry), lru: list.New()}
+}
+
+// lookup returns the entry of id if it hasn't expired
+func (c *negativeCache) lookup(id int, now time.Time) (negativeEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[id]
+	if !ok {
+		return negativeEntry{}, false
+	}
+	if !now.Before(entry.expiration) {
+		c.lru.Remove(entry.elem)
+		delete(c.entries, id)
+		return negativeEntry{}, false
+	}
+	c.lru.MoveToFront(entry.elem)
+	return entry, true
+}
+
+// remember records the result of fetching id if it is a negative one
+func (c *negativeCache) remember(id int, itm hn.Item, err error, now time.Time) {
+	if err == nil && itm.Alive() {
+		return
+	}
+	if err != nil || itm.ID == 0 {
+		itm = hn.Item{}
+	}
+	// failures caused by the request being canceled or the circuit breaker
+	// being open say nothing of the item
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) || errors.Is(err, errCircuitOpen) {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if old, ok := c.entries[id]; ok {
+		c.lru.Remove(old.elem)
+	}
+	c.entries[id] = negativeEntry{item: itm, expiration: now.Add(c.TTL), elem: c.lru.PushFront(id)}
+	for c.MaxEntries > 0 && c.lru.Len() > c.MaxEntries {
+		delete(c.entries, c.lru.Remove(c.lru.Back()).(int))
+	}
+}
+
+func (c *negativeCache) GetItem(id int) (hn.Item, error) {
+	now := time.Now()
+	if entry, ok := c.lookup(id, now); ok {
+		if entry.item.ID == 0 {
+			return hn.Item{}, hn.ItemError{ID: id, Err: errRecentlyFailed}
+		}
+		return entry.item, nil
+	}
+	itm, err := c.StoryProvider.GetItem(id)
+	c.remember(id, itm, err, now)
+	return itm, err
+}
+
+// GetItems only fetches the items that aren't in the cache, and returns
+// hn.ItemErrors for the ones that failed, like the client does.
+func (c *negativeCache) GetItems(ctx context.Context, ids []int, concurrency int) ([]hn.Item, error) {
+	now := time.Now()
+	items := make([]hn.Item, len(ids))
+	var errs hn.ItemErrors
+	var fetch []int
+	var fetchIdx []int
+	for i, id := range ids {
+		entry, ok := c.lookup(id, now)
+		switch {
+		case !ok:
+			fetch = append(fetch, id)
+			fetchIdx = append(fetchIdx, i)
+		case entry.item.ID == 0:
+			errs = append(errs, hn.ItemError{ID: id, Err: errRecentlyFailed})
+		default:
+			items[i] = entry.item
+		}
+	}
+
+	if len(fetch) > 0 {
+		fetched, err := c.StoryProvider.GetItems(ctx, fetch, concurrency)
+		failed := make(map[int]error)
+		var itemErrs hn.ItemErrors
+		if errors.As(err, &itemErrs) {
+			for _, e := range itemErrs {
+				failed[e.ID] = e.Err
+			}
+			errs = append(errs, itemErrs...)
+		} else if err != nil {
+			return nil, err
+		}
+		for j, id := range fetch {
+			items[fetchIdx[j]] = fetched[j]
+			c.remember(id, fetched[j], failed[id], now)
+		}
+	}
+
+	if len(errs) > 0 {
+		// in input order, like the client
+		pos := make(map[int]int, len(ids))
+		for i, id := range ids {
+			pos[id] = i
+		}
+		sort.SliceStable(errs, func(i, j int) bool {
+			return pos[errs[i].ID] < pos[errs[j].ID]
+		})
+		return items, errs
+	}
+	return items, nil
+}
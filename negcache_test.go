@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/mmxmb/quiet_hn/hn"
+)
+
+func TestNegativeCache(t *testing.T) {
+	p := newFakeProvider(5)
+	p.items[2] = hn.Item{ID: 2, Type: "story", Dead: true}
+	delete(p.items, 4)
+	c := newNegativeCache(p, time.Minute)
+
+	for i := 0; i < 2; i++ {
+		items, err := c.GetItems(context.Background(), []int{1, 2, 3, 4, 5}, 2)
+		var itemErrs hn.ItemErrors
+		if !errors.As(err, &itemErrs) || len(itemErrs) != 1 || itemErrs[0].ID != 4 {
+			t.Fatalf("GetItems() #%d: want an error for item 4, got %v", i+1, err)
+		}
+		if !items[1].Dead || items[2].ID != 3 {
+			t.Errorf("GetItems() #%d: want the dead item 2 and item 3, got %v", i+1, items)
+		}
+	}
+	// the second call only fetched the alive items
+	if p.fetched != 5+3 {
+		t.Errorf("fetched items: want %d, got %d", 8, p.fetched)
+	}
+
+	if _, err := c.GetItem(4); !errors.Is(err, errRecentlyFailed) {
+		t.Errorf("GetItem(4): want errRecentlyFailed, got %v", err)
+	}
+
+	c.TTL = 0
+	c.entries = make(map[int]negativeEntry)
+	c.lru.Init()
+	c.GetItems(context.Background(), []int{2}, 2)
+	c.GetItems(context.Background(), []int{2}, 2)
+	if p.fetched != 8+2 {
+		t.Errorf("fetched items with expired entries: want %d, got %d", 10, p.fetched)
+	}
+}
+
+func TestNegativeCache_MaxEntries(t *testing.T) {
+	p := newFakeProvider(5)
+	for id := 1; id <= 5; id++ {
+		delete(p.items, id)
+	}
+	c := newNegativeCache(p, time.Minute)
+	c.MaxEntries = 2
+	c.GetItem(1)
+	c.GetItem(2)
+	c.GetItem(1)
+	c.GetItem(3)
+	if len(c.entries) != 2 {
+		t.Fatalf("entries: want 2, got %d", len(c.entries))
+	}
+	if _, ok := c.entries[2]; ok {
+		t.Errorf("want the least recently used item 2 forgotten")
+	}
+}
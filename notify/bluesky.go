@@ -0,0 +1,157 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// blueskyMaxLength is the maximum length of the text of a Bluesky post
+const blueskyMaxLength = 300
+
+// Bluesky posts to a Bluesky account, or any account of an AT Protocol
+// service
+type Bluesky struct {
+	// Service is the base URL of the service of the account, defaulting to
+	// https://bsky.social
+	Service string
+	// Handle (or DID) and AppPassword log in to the account. App passwords
+	// are created in the settings of the account.
+	Handle      string
+	AppPassword string
+	Client      *http.Client
+
+	mu      sync.Mutex
+	session *blueskySession
+}
+
+type blueskySession struct {
+	AccessJwt string `json:"accessJwt"`
+	DID       string `json:"did"`
+}
+
+type blueskyRecord struct {
+	Type      string         `json:"$type"`
+	Text      string         `json:"text"`
+	CreatedAt string         `json:"createdAt"`
+	Facets    []blueskyFacet `json:"facets,omitempty"`
+	Embed     *blueskyEmbed  `json:"embed,omitempty"`
+}
+
+// blueskyFacet makes the bytes [ByteStart, ByteEnd) of the text of a post a
+// link
+type blueskyFacet struct {
+	Index struct {
+		ByteStart int `json:"byteStart"`
+		ByteEnd   int `json:"byteEnd"`
+	} `json:"index"`
+	Features []blueskyFeature `json:"features"`
+}
+
+type blueskyFeature struct {
+	Type string `json:"$type"`
+	URI  string `json:"uri"`
+}
+
+// blueskyEmbed is the link card of a post
+type blueskyEmbed struct {
+	Type     string `json:"$type"`
+	External struct {
+		URI         string `json:"uri"`
+		Title       string `json:"title"`
+		Description string `json:"description"`
+	} `json:"external"`
+}
+
+func (b *Bluesky) service() string {
+	if b.Service == "" {
+		return "https://bsky.social"
+	}
+	return strings.TrimSuffix(b.Service, "/")
+}
+
+// login returns the current session, creating one if needed
+func (b *Bluesky) login(ctx context.Context) (*blueskySession, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.session != nil {
+		return b.session, nil
+	}
+	creds := struct {
+		Identifier string `json:"identifier"`
+		Password   string `json:"password"`
+	}{b.Handle, b.AppPassword}
+	var s blueskySession
+	url := b.service() + "/xrpc/com.atproto.server.createSession"
+	if err := postJSON(ctx, b.Client, "bluesky", http.MethodPost, url, nil, creds, &s); err != nil {
+		return nil, err
+	}
+	b.session = &s
+	return &s, nil
+}
+
+// Notify posts msg.Text, cut to the maximum length of a post. The URLs of
+// the story in the text are made links and the story gets a link card.
+func (b *Bluesky) Notify(ctx context.Context, msg Message) error {
+	record := blueskyRecord{
+		Type:      "app.bsky.feed.post",
+		Text:      truncate(msg.Text, blueskyMaxLength),
+		CreatedAt: time.Now().UTC().Format(time.RFC3339),
+	}
+	for _, link := range []string{msg.Story.URL, msg.Story.Discussion} {
+		if link == "" {
+			continue
+		}
+		if i := strings.Index(record.Text, link); i >= 0 {
+			var f blueskyFacet
+			f.Index.ByteStart, f.Index.ByteEnd = i, i+len(link)
+			f.Features = []blueskyFeature{{Type: "app.bsky.richtext.facet#link", URI: link}}
+			record.Facets = append(record.Facets, f)
+		}
+	}
+	if msg.Story.URL != "" {
+		record.Embed = &blueskyEmbed{Type: "app.bsky.embed.external"}
+		record.Embed.External.URI = msg.Story.URL
+		record.Embed.External.Title = msg.Story.Title
+		record.Embed.External.Description = msg.Story.Host
+	}
+
+	err := b.createRecord(ctx, record)
+	var apiErr *Error
+	if errors.As(err, &apiErr) && (apiErr.Status == http.StatusUnauthorized || strings.Contains(apiErr.Body, "ExpiredToken")) {
+		// sessions expire after a couple of hours
+		b.mu.Lock()
+		b.session = nil
+		b.mu.Unlock()
+		err = b.createRecord(ctx, record)
+	}
+	return err
+}
+
+func (b *Bluesky) createRecord(ctx context.Context, record blueskyRecord) error {
+	s, err := b.login(ctx)
+	if err != nil {
+		return err
+	}
+	req := struct {
+		Repo       string        `json:"repo"`
+		Collection string        `json:"collection"`
+		Record     blueskyRecord `json:"record"`
+	}{s.DID, record.Type, record}
+	headers := map[string]string{"Authorization": "Bearer " + s.AccessJwt}
+	url := b.service() + "/xrpc/com.atproto.repo.createRecord"
+	return postJSON(ctx, b.Client, "bluesky", http.MethodPost, url, headers, req, nil)
+}
+
+// truncate cuts s to n runes, ending it with an ellipsis if it was cut
+func truncate(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	runes := []rune(s)
+	return strings.TrimSpace(string(runes[:n-1])) + "…"
+}
@@ -0,0 +1,203 @@
+package notify
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// ircMaxLine is the maximum length of the text of a PRIVMSG, leaving room
+// in the 512 bytes of an IRC line for the prefix the server adds
+const ircMaxLine = 400
+
+// ErrNotConnected is returned by IRC.Notify while the bot isn't connected
+var ErrNotConnected = errors.New("notify: irc: not connected")
+
+// IRC is a bot announcing stories in IRC channels. Run keeps it connected,
+// reconnecting with a backoff, and Notify sends messages to the channels,
+// spaced by FloodDelay so the server doesn't kick the bot for flooding.
+type IRC struct {
+	// Server is the host:port of the server
+	Server string
+	// TLS is whether to connect with TLS, usually on port 6697
+	TLS      bool
+	Nick     string
+	Password string
+	Channels []string
+	// FloodDelay is the minimum time between two lines sent, defaulting to
+	// 2 seconds
+	FloodDelay time.Duration
+	// Dial connects to the server, defaulting to a net.Dialer
+	Dial func(ctx context.Context, network, addr string) (net.Conn, error)
+
+	mu   sync.Mutex
+	conn net.Conn
+	next time.Time
+}
+
+// Run connects to the server and keeps the bot connected until ctx is done
+func (b *IRC) Run(ctx context.Context) {
+	backoff := time.Second
+	for ctx.Err() == nil {
+		start := time.Now()
+		err := b.session(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if time.Since(start) > 5*time.Minute {
+			backoff = time.Second
+		}
+		log.Printf("irc: disconnected from %s: %s, reconnecting in %s", b.Server, err, backoff)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > 5*time.Minute {
+			backoff = 5 * time.Minute
+		}
+	}
+}
+
+// session connects to the server and handles its messages until the
+// connection is lost
+func (b *IRC) session(ctx context.Context) error {
+	dial := b.Dial
+	if dial == nil {
+		dial = (&net.Dialer{Timeout: 30 * time.Second}).DialContext
+	}
+	conn, err := dial(ctx, "tcp", b.Server)
+	if err != nil {
+		return err
+	}
+	if b.TLS {
+		host, _, _ := net.SplitHostPort(b.Server)
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: host})
+		if err := tlsConn.Handshake(); err != nil {
+			conn.Close()
+			return err
+		}
+		conn = tlsConn
+	}
+	defer conn.Close()
+	// closing the connection unblocks the reads when ctx is done
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-stop:
+		}
+	}()
+
+	nick := b.Nick
+	if b.Password != "" {
+		fmt.Fprintf(conn, "PASS %s\r\n", b.Password)
+	}
+	fmt.Fprintf(conn, "NICK %s\r\nUSER %s 0 * :quiet_hn\r\n", nick, b.Nick)
+	defer func() {
+		b.mu.Lock()
+		b.conn = nil
+		b.mu.Unlock()
+	}()
+
+	r := bufio.NewReader(conn)
+	for {
+		// servers ping idle clients every few minutes
+		conn.SetReadDeadline(time.Now().Add(10 * time.Minute))
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		_, command, params := parseIRCLine(line)
+		switch command {
+		case "PING":
+			fmt.Fprintf(conn, "PONG :%s\r\n", strings.Join(params, " "))
+		case "001":
+			// registered: the channels can be joined and messages sent
+			for _, channel := range b.Channels {
+				fmt.Fprintf(conn, "JOIN %s\r\n", channel)
+			}
+			b.mu.Lock()
+			b.conn = conn
+			b.mu.Unlock()
+		case "433":
+			// nickname in use
+			nick += "_"
+			fmt.Fprintf(conn, "NICK %s\r\n", nick)
+		case "ERROR":
+			return fmt.Errorf("server error: %s", strings.Join(params, " "))
+		}
+	}
+}
+
+// parseIRCLine splits an IRC line into its prefix, command and parameters,
+// the last of which may contain spaces if it starts with :
+func parseIRCLine(line string) (prefix, command string, params []string) {
+	line = strings.TrimRight(line, "\r\n")
+	if strings.HasPrefix(line, ":") {
+		i := strings.IndexByte(line, ' ')
+		if i < 0 {
+			return line[1:], "", nil
+		}
+		prefix, line = line[1:i], strings.TrimLeft(line[i+1:], " ")
+	}
+	if i := strings.Index(line, " :"); i >= 0 {
+		params = append(strings.Fields(line[:i]), line[i+2:])
+	} else {
+		params = strings.Fields(line)
+	}
+	if len(params) == 0 {
+		return prefix, "", nil
+	}
+	return prefix, strings.ToUpper(params[0]), params[1:]
+}
+
+// Notify sends each line of msg.Text to the channels
+func (b *IRC) Notify(ctx context.Context, msg Message) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.conn == nil {
+		return ErrNotConnected
+	}
+	delay := b.FloodDelay
+	if delay == 0 {
+		delay = 2 * time.Second
+	}
+	for _, channel := range b.Channels {
+		for _, line := range strings.Split(msg.Text, "\n") {
+			line = strings.TrimSpace(strings.ReplaceAll(line, "\r", ""))
+			if line == "" {
+				continue
+			}
+			if len(line) > ircMaxLine {
+				cut := ircMaxLine
+				for !utf8.RuneStart(line[cut]) {
+					cut--
+				}
+				line = line[:cut] + "…"
+			}
+			if wait := time.Until(b.next); wait > 0 {
+				select {
+				case <-ctx.Done():
+					return ctx.Err()
+				case <-time.After(wait):
+				}
+			}
+			if _, err := fmt.Fprintf(b.conn, "PRIVMSG %s :%s\r\n", channel, line); err != nil {
+				return fmt.Errorf("notify: irc: %w", err)
+			}
+			b.next = time.Now().Add(delay)
+		}
+	}
+	return nil
+}
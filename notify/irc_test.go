@@ -0,0 +1,99 @@
+package notify
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseIRCLine(t *testing.T) {
+	tests := []struct {
+		line            string
+		prefix, command string
+		params          []string
+	}{
+		{"PING :irc.example.com\r\n", "", "PING", []string{"irc.example.com"}},
+		{":irc.example.com 001 quiet :Welcome to the network\r\n", "irc.example.com", "001", []string{"quiet", "Welcome to the network"}},
+		{":nick!user@host JOIN #news", "nick!user@host", "JOIN", []string{"#news"}},
+	}
+	for _, tc := range tests {
+		prefix, command, params := parseIRCLine(tc.line)
+		if prefix != tc.prefix || command != tc.command || !reflect.DeepEqual(params, tc.params) {
+			t.Errorf("parseIRCLine(%q): want %q %q %q, got %q %q %q", tc.line, tc.prefix, tc.command, tc.params, prefix, command, params)
+		}
+	}
+}
+
+func TestIRC(t *testing.T) {
+	client, server := net.Pipe()
+	b := &IRC{
+		Server:     "irc.example.com:6667",
+		Nick:       "quiet",
+		Channels:   []string{"#news", "#hn"},
+		FloodDelay: 10 * time.Millisecond,
+		Dial: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return client, nil
+		},
+	}
+	if err := b.Notify(context.Background(), Message{Text: "too early"}); err != ErrNotConnected {
+		t.Errorf("Notify() before connecting: want ErrNotConnected, got %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go b.Run(ctx)
+
+	r := bufio.NewReader(server)
+	readLine := func() string {
+		server.SetReadDeadline(time.Now().Add(5 * time.Second))
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("server: %s", err)
+		}
+		return strings.TrimRight(line, "\r\n")
+	}
+	if line := readLine(); line != "NICK quiet" {
+		t.Fatalf("want NICK quiet, got %q", line)
+	}
+	readLine() // USER
+	server.Write([]byte(":irc.example.com 433 * quiet :Nickname is already in use\r\n"))
+	if line := readLine(); line != "NICK quiet_" {
+		t.Errorf("want NICK quiet_ once the nick is taken, got %q", line)
+	}
+	server.Write([]byte(":irc.example.com 001 quiet_ :Welcome\r\nPING :12345\r\n"))
+	want := []string{"JOIN #news", "JOIN #hn", "PONG :12345"}
+	for _, w := range want {
+		if line := readLine(); line != w {
+			t.Errorf("want %q, got %q", w, line)
+		}
+	}
+
+	errc := make(chan error, 1)
+	go func() {
+		// the connection is only registered once 001 has been handled
+		for {
+			err := b.Notify(ctx, Message{Text: "A story (example.com)\nhttps://example.com"})
+			if err != ErrNotConnected {
+				errc <- err
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}()
+	want = []string{
+		"PRIVMSG #news :A story (example.com)", "PRIVMSG #news :https://example.com",
+		"PRIVMSG #hn :A story (example.com)", "PRIVMSG #hn :https://example.com",
+	}
+	for _, w := range want {
+		if line := readLine(); line != w {
+			t.Errorf("want %q, got %q", w, line)
+		}
+	}
+	if err := <-errc; err != nil {
+		t.Errorf("Notify() received an error: %s", err.Error())
+	}
+}
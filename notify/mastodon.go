@@ -0,0 +1,36 @@
+package notify
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Mastodon posts statuses ("toots") to a Mastodon account
+type Mastodon struct {
+	// Server is the base URL of the instance of the account, eg
+	// https://mastodon.social
+	Server string
+	// Token is an access token of the account with the write:statuses scope
+	Token string
+	// Visibility is public, unlisted, private or direct, defaulting to the
+	// setting of the account
+	Visibility string
+	Client     *http.Client
+}
+
+// Notify posts msg.Text as a status. The ID of the story is the idempotency
+// key of the request, so retries don't post it twice.
+func (m *Mastodon) Notify(ctx context.Context, msg Message) error {
+	status := struct {
+		Status     string `json:"status"`
+		Visibility string `json:"visibility,omitempty"`
+	}{msg.Text, m.Visibility}
+	headers := map[string]string{
+		"Authorization":   "Bearer " + m.Token,
+		"Idempotency-Key": "quiet_hn-" + strconv.Itoa(msg.Story.ID),
+	}
+	url := strings.TrimSuffix(m.Server, "/") + "/api/v1/statuses"
+	return postJSON(ctx, m.Client, "mastodon", http.MethodPost, url, headers, status, nil)
+}
@@ -0,0 +1,55 @@
+package notify
+
+import (
+	"context"
+	"html"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Matrix sends messages to a Matrix room
+type Matrix struct {
+	// Homeserver is the base URL of the homeserver of the account, eg
+	// https://matrix.org
+	Homeserver string
+	// Token is the access token of the account, which must have joined
+	// the room
+	Token string
+	// RoomID is the ID of the room, eg !abcdef:matrix.org, not its alias
+	RoomID string
+	Client *http.Client
+}
+
+// Notify sends msg.Text to the room as a notice, the message type of bots,
+// with the title of the story linked in its formatted body. The ID of the
+// story is the transaction ID, so retries don't send it twice.
+func (m *Matrix) Notify(ctx context.Context, msg Message) error {
+	event := struct {
+		MsgType       string `json:"msgtype"`
+		Body          string `json:"body"`
+		Format        string `json:"format,omitempty"`
+		FormattedBody string `json:"formatted_body,omitempty"`
+	}{MsgType: "m.notice", Body: msg.Text}
+	if msg.Story.URL != "" || msg.Story.Discussion != "" {
+		event.Format, event.FormattedBody = "org.matrix.custom.html", matrixHTML(msg)
+	}
+	path := "/_matrix/client/v3/rooms/" + url.PathEscape(m.RoomID) + "/send/m.room.message/quiet_hn-" + strconv.Itoa(msg.Story.ID)
+	headers := map[string]string{"Authorization": "Bearer " + m.Token}
+	return postJSON(ctx, m.Client, "matrix", http.MethodPut, strings.TrimSuffix(m.Homeserver, "/")+path, headers, event, nil)
+}
+
+// matrixHTML returns msg.Text as HTML, its lines separated by <br> and the
+// URLs of the story made links
+func matrixHTML(msg Message) string {
+	text := html.EscapeString(msg.Text)
+	for _, link := range []string{msg.Story.URL, msg.Story.Discussion} {
+		if link == "" {
+			continue
+		}
+		escaped := html.EscapeString(link)
+		text = strings.Replace(text, escaped, `<a href="`+escaped+`">`+escaped+`</a>`, 1)
+	}
+	return strings.ReplaceAll(text, "\n", "<br>")
+}
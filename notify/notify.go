@@ -0,0 +1,84 @@
+// Package notify sends stories to chat and social networks: the sinks of the
+// cross-poster announcing the stories of the front page.
+//
+// Each sink implements Notifier. Messages carry both the text to post,
+// rendered by the caller, and the story itself, for the sinks that can
+// attach links or previews to their posts.
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+)
+
+// Story is a story as announced by the notifiers
+type Story struct {
+	ID       int
+	Title    string
+	URL      string
+	Host     string
+	Score    int
+	Comments int
+	// Discussion is the URL of the comments of the story
+	Discussion string
+}
+
+// Message is a post about a story
+type Message struct {
+	Text  string
+	Story Story
+}
+
+// Notifier posts messages to a sink
+type Notifier interface {
+	Notify(ctx context.Context, msg Message) error
+}
+
+// Error is the error response of the API of a sink
+type Error struct {
+	Sink   string
+	Status int
+	Body   string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("notify: %s: status %d: %s", e.Sink, e.Status, e.Body)
+}
+
+// postJSON sends v as JSON with method to url, with the given headers, and
+// decodes the JSON response into out, if not nil
+func postJSON(ctx context.Context, client *http.Client, sink, method, url string, headers map[string]string, v, out interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("notify: %s: %w", sink, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return &Error{Sink: sink, Status: resp.StatusCode, Body: string(bytes.TrimSpace(body))}
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
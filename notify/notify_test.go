@@ -0,0 +1,193 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestMastodon(t *testing.T) {
+	var got struct {
+		Status     string `json:"status"`
+		Visibility string `json:"visibility"`
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/statuses" || r.Header.Get("Authorization") != "Bearer secret" {
+			http.Error(w, `{"error":"The access token is invalid"}`, http.StatusUnauthorized)
+			return
+		}
+		if key := r.Header.Get("Idempotency-Key"); key != "quiet_hn-42" {
+			t.Errorf("Idempotency-Key: want quiet_hn-42, got %s", key)
+		}
+		json.NewDecoder(r.Body).Decode(&got)
+		w.Write([]byte(`{"id":"1"}`))
+	}))
+	defer srv.Close()
+
+	m := &Mastodon{Server: srv.URL + "/", Token: "secret", Visibility: "unlisted", Client: srv.Client()}
+	msg := Message{Text: "A story https://example.com", Story: Story{ID: 42}}
+	if err := m.Notify(context.Background(), msg); err != nil {
+		t.Fatalf("Notify() received an error: %s", err.Error())
+	}
+	if got.Status != msg.Text || got.Visibility != "unlisted" {
+		t.Errorf("status: want %q (unlisted), got %q (%s)", msg.Text, got.Status, got.Visibility)
+	}
+
+	m.Token = "wrong"
+	err := m.Notify(context.Background(), msg)
+	var apiErr *Error
+	if !errors.As(err, &apiErr) || apiErr.Status != http.StatusUnauthorized {
+		t.Errorf("Notify() with a wrong token: want a 401 Error, got %v", err)
+	}
+}
+
+func TestBluesky(t *testing.T) {
+	sessions := 0
+	var posted []blueskyRecord
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/xrpc/com.atproto.server.createSession":
+			sessions++
+			w.Write([]byte(`{"accessJwt":"jwt` + strconv.Itoa(sessions) + `","did":"did:plc:abc"}`))
+		case "/xrpc/com.atproto.repo.createRecord":
+			if r.Header.Get("Authorization") != "Bearer jwt"+strconv.Itoa(sessions) || sessions == 1 && len(posted) == 1 {
+				http.Error(w, `{"error":"ExpiredToken","message":"Token has expired"}`, http.StatusBadRequest)
+				return
+			}
+			var req struct {
+				Repo   string        `json:"repo"`
+				Record blueskyRecord `json:"record"`
+			}
+			json.NewDecoder(r.Body).Decode(&req)
+			if req.Repo != "did:plc:abc" {
+				t.Errorf("repo: want did:plc:abc, got %s", req.Repo)
+			}
+			posted = append(posted, req.Record)
+			w.Write([]byte(`{"uri":"at://did:plc:abc/app.bsky.feed.post/1"}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	b := &Bluesky{Service: srv.URL, Handle: "quiet.bsky.social", AppPassword: "app", Client: srv.Client()}
+	story := Story{ID: 1, Title: "Über Go", URL: "https://example.com/1", Host: "example.com"}
+	msg := Message{Text: "Über Go https://example.com/1", Story: story}
+	if err := b.Notify(context.Background(), msg); err != nil {
+		t.Fatalf("Notify() received an error: %s", err.Error())
+	}
+	// the session expired, so it is created again
+	if err := b.Notify(context.Background(), msg); err != nil {
+		t.Fatalf("Notify() with an expired session received an error: %s", err.Error())
+	}
+	if sessions != 2 || len(posted) != 2 {
+		t.Fatalf("want 2 sessions and 2 posts, got %d and %d", sessions, len(posted))
+	}
+	rec := posted[0]
+	if len(rec.Facets) != 1 || rec.Facets[0].Index.ByteStart != 9 || rec.Facets[0].Index.ByteEnd != len(msg.Text) {
+		t.Errorf("facets: want the link at bytes [9, %d), got %+v", len(msg.Text), rec.Facets)
+	}
+	if rec.Embed == nil || rec.Embed.External.URI != story.URL {
+		t.Errorf("embed: want a link card of %s, got %+v", story.URL, rec.Embed)
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	if got := truncate("ünïcode text", 8); got != "ünïcode…" {
+		t.Errorf("truncate(): want %q, got %q", "ünïcode…", got)
+	}
+	if got := truncate("short", 8); got != "short" {
+		t.Errorf("truncate() of a short text: want %q, got %q", "short", got)
+	}
+}
+
+func TestMatrix(t *testing.T) {
+	var got map[string]string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut || r.URL.EscapedPath() != "/_matrix/client/v3/rooms/%21room:example.com/send/m.room.message/quiet_hn-7" {
+			t.Errorf("request: want a PUT of the event, got %s %s", r.Method, r.URL.EscapedPath())
+		}
+		if r.Header.Get("Authorization") != "Bearer token" {
+			http.Error(w, `{"errcode":"M_UNKNOWN_TOKEN"}`, http.StatusUnauthorized)
+			return
+		}
+		json.NewDecoder(r.Body).Decode(&got)
+		w.Write([]byte(`{"event_id":"$1"}`))
+	}))
+	defer srv.Close()
+
+	m := &Matrix{Homeserver: srv.URL, Token: "token", RoomID: "!room:example.com", Client: srv.Client()}
+	msg := Message{
+		Text:  "Q&A https://example.com/7?a=1&b=2\nDiscussion",
+		Story: Story{ID: 7, URL: "https://example.com/7?a=1&b=2"},
+	}
+	if err := m.Notify(context.Background(), msg); err != nil {
+		t.Fatalf("Notify() received an error: %s", err.Error())
+	}
+	if got["msgtype"] != "m.notice" || got["body"] != msg.Text {
+		t.Errorf("event: want a notice of the text, got %v", got)
+	}
+	want := `Q&amp;A <a href="https://example.com/7?a=1&amp;b=2">https://example.com/7?a=1&amp;b=2</a><br>Discussion`
+	if got["formatted_body"] != want {
+		t.Errorf("formatted_body: want %s, got %s", want, got["formatted_body"])
+	}
+}
+
+func TestWebhook(t *testing.T) {
+	var got struct {
+		Text  string `json:"text"`
+		Story struct {
+			ID    int    `json:"id"`
+			Title string `json:"title"`
+		} `json:"story"`
+	}
+	var verifyErr error
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		verifyErr = Verify("secret", r.Header.Get(TimestampHeader), r.Header.Get(SignatureHeader), body, 5*time.Minute, time.Now())
+		json.Unmarshal(body, &got)
+	}))
+	defer srv.Close()
+
+	h := &Webhook{URL: srv.URL, Secret: "secret", Client: srv.Client()}
+	if err := h.Notify(context.Background(), Message{Text: "A story", Story: Story{ID: 3, Title: "A story"}}); err != nil {
+		t.Fatalf("Notify() received an error: %s", err.Error())
+	}
+	if verifyErr != nil {
+		t.Errorf("Verify() of the request received an error: %s", verifyErr.Error())
+	}
+	if got.Text != "A story" || got.Story.ID != 3 {
+		t.Errorf("payload: want the message of story 3, got %+v", got)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	now := time.Unix(1522598400, 0)
+	body := []byte(`{"text":"hi"}`)
+	ts := strconv.FormatInt(now.Unix(), 10)
+	sig := Sign("secret", ts, body)
+	tests := []struct {
+		name          string
+		ts, sig, body string
+		at            time.Time
+		want          error
+	}{
+		{"valid", ts, sig, string(body), now.Add(time.Minute), nil},
+		{"rotated secret", ts, "v1=00, " + sig, string(body), now, nil},
+		{"tampered body", ts, sig, `{"text":"bye"}`, now, ErrBadSignature},
+		{"wrong secret", ts, Sign("other", ts, body), string(body), now, ErrBadSignature},
+		{"replayed", ts, sig, string(body), now.Add(time.Hour), ErrExpired},
+		{"invalid timestamp", "yesterday", sig, string(body), now, ErrBadSignature},
+	}
+	for _, tc := range tests {
+		if err := Verify("secret", tc.ts, tc.sig, []byte(tc.body), 5*time.Minute, tc.at); err != tc.want {
+			t.Errorf("Verify() of a %s request: want %v, got %v", tc.name, tc.want, err)
+		}
+	}
+}
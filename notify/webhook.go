@@ -0,0 +1,133 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The headers of signed webhook requests. The signature is
+//
+//	v1=hex(HMAC-SHA256(secret, timestamp + "." + body))
+//
+// with the timestamp in unix seconds, so receivers can both check that the
+// body was sent by a holder of the secret and reject replayed requests.
+const (
+	TimestampHeader = "X-Quiet-HN-Timestamp"
+	SignatureHeader = "X-Quiet-HN-Signature"
+)
+
+// Webhook posts messages as JSON to a URL:
+//
+//	{"text": "...", "story": {"id": 1, "title": "...", "url": "...", ...}}
+//
+// With a Secret, requests are signed (see SignatureHeader).
+type Webhook struct {
+	URL    string
+	Secret string
+	Client *http.Client
+}
+
+// webhookStory is the JSON of a story posted to webhooks
+type webhookStory struct {
+	ID         int    `json:"id"`
+	Title      string `json:"title"`
+	URL        string `json:"url,omitempty"`
+	Host       string `json:"host,omitempty"`
+	Score      int    `json:"score"`
+	Comments   int    `json:"comments"`
+	Discussion string `json:"discussion"`
+}
+
+// Notify posts msg to the URL of the webhook
+func (h *Webhook) Notify(ctx context.Context, msg Message) error {
+	s := msg.Story
+	body, err := json.Marshal(struct {
+		Text  string       `json:"text"`
+		Story webhookStory `json:"story"`
+	}{msg.Text, webhookStory{s.ID, s.Title, s.URL, s.Host, s.Score, s.Comments, s.Discussion}})
+	if err != nil {
+		return err
+	}
+	return PostSigned(ctx, h.Client, h.URL, h.Secret, body)
+}
+
+// PostSigned posts the JSON body to url, signed with secret if it isn't
+// empty
+func PostSigned(ctx context.Context, client *http.Client, url, secret string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if secret != "" {
+		ts := strconv.FormatInt(time.Now().Unix(), 10)
+		req.Header.Set(TimestampHeader, ts)
+		req.Header.Set(SignatureHeader, Sign(secret, ts, body))
+	}
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("notify: webhook: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		b, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return &Error{Sink: "webhook", Status: resp.StatusCode, Body: string(bytes.TrimSpace(b))}
+	}
+	return nil
+}
+
+// Sign returns the signature of body sent at timestamp, the value of the
+// SignatureHeader
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return "v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+var (
+	// ErrBadSignature is returned by Verify for requests that weren't signed
+	// with the secret
+	ErrBadSignature = errors.New("notify: invalid webhook signature")
+	// ErrExpired is returned by Verify for requests signed outside of the
+	// replay window
+	ErrExpired = errors.New("notify: webhook timestamp outside of the replay window")
+)
+
+// Verify checks the signature of a webhook request with the given timestamp
+// and signature headers and body, as received at now. Requests signed more
+// than window away from now are rejected, so a captured request can't be
+// replayed later.
+func Verify(secret, timestamp, signature string, body []byte, window time.Duration, now time.Time) error {
+	sec, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ErrBadSignature
+	}
+	if d := now.Sub(time.Unix(sec, 0)); d > window || d < -window {
+		return ErrExpired
+	}
+	// a signature header may list several signatures, eg during a secret
+	// rotation
+	want := Sign(secret, timestamp, body)
+	for _, sig := range strings.Split(signature, ",") {
+		if hmac.Equal([]byte(strings.TrimSpace(sig)), []byte(want)) {
+			return nil
+		}
+	}
+	return ErrBadSignature
+}
@@ -0,0 +1,239 @@
+package notify
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// XMPP sends chat messages to a JID from an XMPP account. Every message
+// opens its own connection: stories are rare enough that keeping a session
+// alive isn't worth its reconnection logic.
+//
+// The connection is always encrypted with STARTTLS and the account logs in
+// with SASL PLAIN, which every server supports.
+type XMPP struct {
+	// JID and Password log in to the account, eg quiet_hn@example.com
+	JID      string
+	Password string
+	// To is the JID messages are sent to
+	To string
+	// Server is the host:port to connect to, defaulting to the server
+	// advertised by the DNS SRV records of the domain of the JID, or its
+	// port 5222
+	Server string
+	// TLSConfig is the configuration of the TLS handshake, defaulting to
+	// verifying the certificate of the domain of the JID
+	TLSConfig *tls.Config
+	Timeout   time.Duration
+}
+
+const (
+	nsStream = "http://etherx.jabber.org/streams"
+	nsTLS    = "urn:ietf:params:xml:ns:xmpp-tls"
+	nsSASL   = "urn:ietf:params:xml:ns:xmpp-sasl"
+	nsBind   = "urn:ietf:params:xml:ns:xmpp-bind"
+)
+
+// xmppFeatures are the stream features offered by the server
+type xmppFeatures struct {
+	StartTLS   *struct{} `xml:"urn:ietf:params:xml:ns:xmpp-tls starttls"`
+	Mechanisms []string  `xml:"urn:ietf:params:xml:ns:xmpp-sasl mechanisms>mechanism"`
+	Bind       *struct{} `xml:"urn:ietf:params:xml:ns:xmpp-bind bind"`
+}
+
+// xmppConn is a stream with the server
+type xmppConn struct {
+	conn   net.Conn
+	dec    *xml.Decoder
+	domain string
+}
+
+// Notify sends msg.Text to x.To as a chat message
+func (x *XMPP) Notify(ctx context.Context, msg Message) error {
+	if err := x.send(ctx, msg.Text); err != nil {
+		return fmt.Errorf("notify: xmpp: %w", err)
+	}
+	return nil
+}
+
+func (x *XMPP) send(ctx context.Context, text string) error {
+	at := strings.LastIndex(x.JID, "@")
+	if at <= 0 {
+		return fmt.Errorf("invalid JID %q", x.JID)
+	}
+	user, domain := x.JID[:at], x.JID[at+1:]
+	if slash := strings.Index(domain, "/"); slash >= 0 {
+		domain = domain[:slash]
+	}
+	timeout := x.Timeout
+	if timeout == 0 {
+		timeout = 30 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	conn, err := x.dial(ctx, domain)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	c := &xmppConn{conn: conn, domain: domain}
+
+	features, err := c.open()
+	if err != nil {
+		return err
+	}
+	if features.StartTLS == nil {
+		return errors.New("the server doesn't offer STARTTLS")
+	}
+	if _, err := io.WriteString(c.conn, "<starttls xmlns='"+nsTLS+"'/>"); err != nil {
+		return err
+	}
+	if name, err := c.next(nil); err != nil {
+		return err
+	} else if name.Local != "proceed" {
+		return errors.New("the server refused STARTTLS")
+	}
+	cfg := x.TLSConfig
+	if cfg == nil {
+		cfg = &tls.Config{ServerName: domain}
+	}
+	tlsConn := tls.Client(conn, cfg)
+	if err := tlsConn.Handshake(); err != nil {
+		return err
+	}
+	c.conn = tlsConn
+
+	if features, err = c.open(); err != nil {
+		return err
+	}
+	if !contains(features.Mechanisms, "PLAIN") {
+		return fmt.Errorf("the server doesn't offer SASL PLAIN, only %v", features.Mechanisms)
+	}
+	creds := base64.StdEncoding.EncodeToString([]byte("\x00" + user + "\x00" + x.Password))
+	if _, err := io.WriteString(c.conn, "<auth xmlns='"+nsSASL+"' mechanism='PLAIN'>"+creds+"</auth>"); err != nil {
+		return err
+	}
+	if name, err := c.next(nil); err != nil {
+		return err
+	} else if name.Local != "success" {
+		return errors.New("authentication failed")
+	}
+
+	if features, err = c.open(); err != nil {
+		return err
+	}
+	if features.Bind != nil {
+		bind := "<iq type='set' id='bind'><bind xmlns='" + nsBind + "'><resource>quiet_hn</resource></bind></iq>"
+		if _, err := io.WriteString(c.conn, bind); err != nil {
+			return err
+		}
+		var iq struct {
+			Type string `xml:"type,attr"`
+		}
+		if _, err := c.next(&iq); err != nil {
+			return err
+		}
+		if iq.Type != "result" {
+			return errors.New("binding a resource failed")
+		}
+	}
+
+	var body strings.Builder
+	xml.EscapeText(&body, []byte(text))
+	to := new(strings.Builder)
+	xml.EscapeText(to, []byte(x.To))
+	message := "<message to='" + to.String() + "' type='chat' id='" + strconv.FormatInt(time.Now().UnixNano(), 36) + "'><body>" + body.String() + "</body></message>"
+	if _, err := io.WriteString(c.conn, message+"</stream:stream>"); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (x *XMPP) dial(ctx context.Context, domain string) (net.Conn, error) {
+	addr := x.Server
+	if addr == "" {
+		addr = net.JoinHostPort(domain, "5222")
+		if _, srvs, err := net.DefaultResolver.LookupSRV(ctx, "xmpp-client", "tcp", domain); err == nil && len(srvs) > 0 {
+			addr = net.JoinHostPort(strings.TrimSuffix(srvs[0].Target, "."), strconv.Itoa(int(srvs[0].Port)))
+		}
+	}
+	var d net.Dialer
+	return d.DialContext(ctx, "tcp", addr)
+}
+
+// open starts a new stream, as needed after connecting and after each of
+// STARTTLS and authentication, and returns its features
+func (c *xmppConn) open() (xmppFeatures, error) {
+	var features xmppFeatures
+	header := "<?xml version='1.0'?><stream:stream to='" + c.domain + "' version='1.0' xmlns='jabber:client' xmlns:stream='" + nsStream + "'>"
+	if _, err := io.WriteString(c.conn, header); err != nil {
+		return features, err
+	}
+	c.dec = xml.NewDecoder(c.conn)
+	for {
+		tok, err := c.dec.Token()
+		if err != nil {
+			return features, err
+		}
+		if start, ok := tok.(xml.StartElement); ok {
+			if start.Name.Space != nsStream || start.Name.Local != "stream" {
+				return features, fmt.Errorf("unexpected <%s> opening the stream", start.Name.Local)
+			}
+			break
+		}
+	}
+	if name, err := c.next(&features); err != nil {
+		return features, err
+	} else if name.Local != "features" {
+		return features, fmt.Errorf("unexpected <%s> instead of the stream features", name.Local)
+	}
+	return features, nil
+}
+
+// next decodes the next element of the stream into v, if not nil, and
+// returns its name. Stream errors are returned as errors.
+func (c *xmppConn) next(v interface{}) (xml.Name, error) {
+	for {
+		tok, err := c.dec.Token()
+		if err != nil {
+			return xml.Name{}, err
+		}
+		start, ok := tok.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		if start.Name.Space == nsStream && start.Name.Local == "error" {
+			var streamErr struct {
+				Inner []byte `xml:",innerxml"`
+			}
+			c.dec.DecodeElement(&streamErr, &start)
+			return start.Name, fmt.Errorf("stream error: %s", streamErr.Inner)
+		}
+		if v == nil {
+			v = new(struct{})
+		}
+		return start.Name, c.dec.DecodeElement(v, &start)
+	}
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
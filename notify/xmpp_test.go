@@ -0,0 +1,144 @@
+package notify
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/xml"
+	"io"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+)
+
+// testCertificate returns a self-signed certificate for example.com
+func testCertificate(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "example.com"},
+		DNSNames:     []string{"example.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IsCA:         true,
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tpl, tpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, pool
+}
+
+// fakeXMPPServer accepts a single client on l, sending the body of the
+// message it gets to bodies
+func fakeXMPPServer(t *testing.T, l net.Listener, cert tls.Certificate, bodies chan<- string) {
+	conn, err := l.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	var rw io.ReadWriter = conn
+	dec := xml.NewDecoder(rw)
+	// expect reads elements up to the start of the element local
+	expect := func(local string) xml.StartElement {
+		for {
+			tok, err := dec.Token()
+			if err != nil {
+				t.Errorf("server: reading <%s>: %s", local, err)
+				return xml.StartElement{}
+			}
+			if start, ok := tok.(xml.StartElement); ok && start.Name.Local == local {
+				return start
+			}
+		}
+	}
+	stream := func(features string) {
+		expect("stream")
+		io.WriteString(rw, "<stream:stream xmlns='jabber:client' xmlns:stream='"+nsStream+"' from='example.com' version='1.0'><stream:features>"+features+"</stream:features>")
+	}
+
+	stream("<starttls xmlns='" + nsTLS + "'><required/></starttls>")
+	expect("starttls")
+	io.WriteString(rw, "<proceed xmlns='"+nsTLS+"'/>")
+	tlsConn := tls.Server(conn, &tls.Config{Certificates: []tls.Certificate{cert}})
+	rw, dec = tlsConn, xml.NewDecoder(tlsConn)
+
+	stream("<mechanisms xmlns='" + nsSASL + "'><mechanism>SCRAM-SHA-1</mechanism><mechanism>PLAIN</mechanism></mechanisms>")
+	start := expect("auth")
+	var auth string
+	dec.DecodeElement(&auth, &start)
+	if creds, _ := base64.StdEncoding.DecodeString(auth); string(creds) != "\x00bot\x00secret" {
+		io.WriteString(rw, "<failure xmlns='"+nsSASL+"'><not-authorized/></failure>")
+		return
+	}
+	io.WriteString(rw, "<success xmlns='"+nsSASL+"'/>")
+
+	stream("<bind xmlns='" + nsBind + "'/>")
+	expect("iq")
+	io.WriteString(rw, "<iq type='result' id='bind'><bind xmlns='"+nsBind+"'><jid>bot@example.com/quiet_hn</jid></bind></iq>")
+	start = expect("message")
+	var msg struct {
+		To   string `xml:"to,attr"`
+		Body string `xml:"body"`
+	}
+	dec.DecodeElement(&msg, &start)
+	if msg.To != "alice@example.com" {
+		t.Errorf("server: message to: want alice@example.com, got %s", msg.To)
+	}
+	bodies <- msg.Body
+}
+
+func TestXMPP(t *testing.T) {
+	cert, pool := testCertificate(t)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	bodies := make(chan string, 1)
+	go fakeXMPPServer(t, l, cert, bodies)
+
+	x := &XMPP{
+		JID:       "bot@example.com",
+		Password:  "secret",
+		To:        "alice@example.com",
+		Server:    l.Addr().String(),
+		TLSConfig: &tls.Config{ServerName: "example.com", RootCAs: pool},
+		Timeout:   5 * time.Second,
+	}
+	text := "Go <3 & XMPP https://example.com"
+	if err := x.Notify(context.Background(), Message{Text: text}); err != nil {
+		t.Fatalf("Notify() received an error: %s", err.Error())
+	}
+	select {
+	case body := <-bodies:
+		if body != text {
+			t.Errorf("body: want %q, got %q", text, body)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the server got no message")
+	}
+
+	// a wrong password fails
+	go fakeXMPPServer(t, l, cert, bodies)
+	x.Password = "wrong"
+	if err := x.Notify(context.Background(), Message{Text: text}); err == nil {
+		t.Errorf("Notify() with a wrong password: want an error")
+	}
+}
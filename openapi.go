@@ -0,0 +1,280 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/mmxmb/quiet_hn/hn"
+)
+
+// openAPIDocument returns the OpenAPI 3 description of the JSON API. The
+// schemas are generated from the types the handlers encode, so they can't
+// drift from the responses. historyEnabled and clicksEnabled add the
+// endpoints that need the front page history and the /out redirector.
+func openAPIDocument(server string, historyEnabled, clicksEnabled bool) map[string]interface{} {
+	errorResponse := func(description string) map[string]interface{} {
+		return map[string]interface{}{
+			"description": description,
+			"content":     jsonContent(map[string]interface{}{"$ref": "#/components/schemas/Error"}),
+		}
+	}
+	boolParam := func(name, description string) map[string]interface{} {
+		return map[string]interface{}{
+			"name": name, "in": "query", "description": description,
+			"schema": map[string]interface{}{"type": "boolean"},
+		}
+	}
+
+	intParam := func(name, description string) map[string]interface{} {
+		return map[string]interface{}{
+			"name": name, "in": "query", "description": description,
+			"schema": map[string]interface{}{"type": "integer", "minimum": 1},
+		}
+	}
+	queryErrorResponse := map[string]interface{}{
+		"description": "Invalid query parameters",
+		"content":     jsonContent(map[string]interface{}{"$ref": "#/components/schemas/QueryError"}),
+	}
+
+	paths := map[string]interface{}{
+		"/api/stories": map[string]interface{}{
+			"get": map[string]interface{}{
+				"operationId": "listStories",
+				"summary":     "The front page stories, in order",
+				"parameters": []interface{}{
+					boolParam("hide_jobs", "leave out job postings"),
+					boolParam("hide_paywalled", "leave out stories on paywalled domains"),
+					intParam("n", "the number of stories per page, at most the number of front page stories"),
+					intParam("page", "the page of stories, from 1"),
+					map[string]interface{}{
+						"name": "sort", "in": "query", "description": "the order of the stories",
+						"schema": map[string]interface{}{"type": "string", "enum": storyOrders, "default": sortByRank},
+					},
+				},
+				"responses": map[string]interface{}{
+					"200": map[string]interface{}{
+						"description": "The stories",
+						"content": jsonContent(map[string]interface{}{
+							"type":       "object",
+							"properties": map[string]interface{}{"stories": map[string]interface{}{"type": "array", "items": map[string]interface{}{"$ref": "#/components/schemas/Story"}}},
+						}),
+					},
+					"400": queryErrorResponse,
+					"502": errorResponse("The stories couldn't be loaded from HN"),
+				},
+			},
+		},
+		"/api/item/{id}": map[string]interface{}{
+			"get": map[string]interface{}{
+				"operationId": "getItem",
+				"summary":     "Any item: a story, comment, job, poll or poll option",
+				"parameters": []interface{}{map[string]interface{}{
+					"name": "id", "in": "path", "required": true,
+					"schema": map[string]interface{}{"type": "integer"},
+				}},
+				"responses": map[string]interface{}{
+					"200": map[string]interface{}{
+						"description": "The item",
+						"content":     jsonContent(map[string]interface{}{"$ref": "#/components/schemas/Item"}),
+					},
+					"400": errorResponse("Invalid item id"),
+					"404": errorResponse("The item doesn't exist, is dead or was deleted"),
+					"502": errorResponse("The item couldn't be loaded from HN"),
+				},
+			},
+		},
+		"/api/cache/stats": map[string]interface{}{
+			"get": map[string]interface{}{
+				"operationId": "getCacheStats",
+				"summary":     "Statistics of the front page cache",
+				"responses": map[string]interface{}{
+					"200": map[string]interface{}{
+						"description": "The statistics",
+						"content":     jsonContent(jsonSchema(reflect.TypeOf(CacheStats{}))),
+					},
+				},
+			},
+		},
+		"/api/upstream/stats": map[string]interface{}{
+			"get": map[string]interface{}{
+				"operationId": "getUpstreamStats",
+				"summary":     "Timings of the requests to the HN API",
+				"responses": map[string]interface{}{
+					"200": map[string]interface{}{
+						"description": "The statistics",
+						"content":     jsonContent(jsonSchema(reflect.TypeOf(hn.MetricsStats{}))),
+					},
+				},
+			},
+		},
+		"/graphql": map[string]interface{}{
+			"post": map[string]interface{}{
+				"operationId": "graphql",
+				"summary":     "GraphQL queries over stories, items and users",
+				"requestBody": map[string]interface{}{
+					"required": true,
+					"content":  jsonContent(jsonSchema(reflect.TypeOf(graphQLRequest{}))),
+				},
+				"responses": map[string]interface{}{
+					"200": map[string]interface{}{
+						"description": "The GraphQL response, with data and errors",
+						"content":     jsonContent(map[string]interface{}{"type": "object"}),
+					},
+				},
+			},
+		},
+	}
+	if historyEnabled {
+		paths["/api/trends"] = map[string]interface{}{
+			"get": map[string]interface{}{
+				"operationId": "listTrends",
+				"summary":     "The terms in the most front page titles over a window",
+				"parameters": []interface{}{map[string]interface{}{
+					"name": "window", "in": "query",
+					"schema": map[string]interface{}{"type": "string", "enum": []string{"24h", "7d"}, "default": "24h"},
+				}},
+				"responses": map[string]interface{}{
+					"200": map[string]interface{}{
+						"description": "The trends",
+						"content": jsonContent(map[string]interface{}{
+							"type": "object",
+							"properties": map[string]interface{}{
+								"window": map[string]interface{}{"type": "string"},
+								"terms":  map[string]interface{}{"type": "array", "items": map[string]interface{}{"$ref": "#/components/schemas/Trend"}},
+							},
+						}),
+					},
+					"400": queryErrorResponse,
+				},
+			},
+		}
+	}
+
+	if clicksEnabled {
+		paths["/api/clicks"] = map[string]interface{}{
+			"get": map[string]interface{}{
+				"operationId": "listClicks",
+				"summary":     "The stories whose links were clicked the most",
+				"parameters":  []interface{}{intParam("n", "the number of stories")},
+				"responses": map[string]interface{}{
+					"200": map[string]interface{}{
+						"description": "The stories and their clicks",
+						"content": jsonContent(map[string]interface{}{
+							"type":       "object",
+							"properties": map[string]interface{}{"stories": map[string]interface{}{"type": "array", "items": map[string]interface{}{"$ref": "#/components/schemas/Clicks"}}},
+						}),
+					},
+					"400": queryErrorResponse,
+				},
+			},
+		}
+		paths["/api/stories/{id}/clicks"] = map[string]interface{}{
+			"get": map[string]interface{}{
+				"operationId": "getStoryClicks",
+				"summary":     "The number of clicks on the link of a story",
+				"parameters": []interface{}{map[string]interface{}{
+					"name": "id", "in": "path", "required": true,
+					"schema": map[string]interface{}{"type": "integer"},
+				}},
+				"responses": map[string]interface{}{
+					"200": map[string]interface{}{
+						"description": "The clicks",
+						"content":     jsonContent(map[string]interface{}{"$ref": "#/components/schemas/Clicks"}),
+					},
+					"400": errorResponse("Invalid story id"),
+				},
+			},
+		}
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":   "Quiet Hacker News",
+			"version": "1",
+		},
+		"servers": []interface{}{map[string]interface{}{"url": server}},
+		"paths":   paths,
+		"components": map[string]interface{}{
+			"schemas": map[string]interface{}{
+				"Story":  jsonSchema(reflect.TypeOf(apiStory{})),
+				"Item":   jsonSchema(reflect.TypeOf(hn.Item{})),
+				"Trend":  jsonSchema(reflect.TypeOf(trend{})),
+				"Clicks": jsonSchema(reflect.TypeOf(storyClicks{})),
+				"QueryError": map[string]interface{}{
+					"type": "object",
+					"properties": map[string]interface{}{
+						"error":  map[string]interface{}{"type": "string"},
+						"params": map[string]interface{}{"type": "array", "items": jsonSchema(reflect.TypeOf(paramError{}))},
+					},
+				},
+				"Error": map[string]interface{}{
+					"type":       "object",
+					"properties": map[string]interface{}{"error": map[string]interface{}{"type": "string"}},
+				},
+			},
+		},
+	}
+}
+
+func jsonContent(schema map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{"application/json": map[string]interface{}{"schema": schema}}
+}
+
+// jsonSchema returns the OpenAPI schema of the JSON encoding of values of
+// type t
+func jsonSchema(t reflect.Type) map[string]interface{} {
+	switch t.Kind() {
+	case reflect.Ptr:
+		return jsonSchema(t.Elem())
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": jsonSchema(t.Elem())}
+	case reflect.Struct:
+		props := make(map[string]interface{})
+		addStructFields(t, props)
+		return map[string]interface{}{"type": "object", "properties": props}
+	}
+	// maps and interfaces can hold anything
+	return map[string]interface{}{"type": "object"}
+}
+
+// addStructFields adds the schemas of the fields of the struct type t,
+// including the fields of embedded structs, to props
+func addStructFields(t reflect.Type, props map[string]interface{}) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" && !f.Anonymous {
+			continue
+		}
+		name := f.Name
+		if tag := f.Tag.Get("json"); tag != "" {
+			if tag == "-" {
+				continue
+			}
+			if n := strings.Split(tag, ",")[0]; n != "" {
+				name = n
+			}
+		} else if f.Anonymous && f.Type.Kind() == reflect.Struct {
+			addStructFields(f.Type, props)
+			continue
+		}
+		props[name] = jsonSchema(f.Type)
+	}
+}
+
+// openAPIHandler serves /api/openapi.json
+func openAPIHandler(historyEnabled, clicksEnabled bool) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, openAPIDocument(baseURL(r), historyEnabled, clicksEnabled))
+	})
+}
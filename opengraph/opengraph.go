@@ -0,0 +1,146 @@
+// Package opengraph reads the Open Graph metadata of HTML pages, eg the
+// description and preview image used when a link is shared.
+//
+// Only the <head> of the page is read, falling back to the Twitter card and
+// standard description meta tags when the Open Graph ones are missing.
+package opengraph
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"io/ioutil"
+	"regexp"
+	"strings"
+)
+
+// Metadata is the metadata of a page. Fields the page doesn't define are
+// empty.
+type Metadata struct {
+	Title       string
+	Description string
+	Image       string
+	SiteName    string
+	// Icon is the href of the first <link rel="icon"> of the page
+	Icon string
+}
+
+// IsZero reports whether no metadata was found.
+func (m Metadata) IsZero() bool {
+	return m == Metadata{}
+}
+
+// rawText matches elements whose contents routinely contain characters that
+// confuse the decoder
+var rawText = regexp.MustCompile(`(?is)<script\b.*?</script\s*>|<style\b.*?</style\s*>|<!--.*?-->`)
+
+// Parse reads the metadata of the HTML page read from r. Parsing stops at the
+// end of the <head> element, but r is read completely, so callers should
+// limit its size.
+func Parse(r io.Reader) (Metadata, error) {
+	page, err := ioutil.ReadAll(r)
+	if err != nil {
+		return Metadata{}, err
+	}
+	dec := xml.NewDecoder(bytes.NewReader(rawText.ReplaceAll(page, nil)))
+	dec.Strict = false
+	dec.AutoClose = xml.HTMLAutoClose
+	dec.Entity = xml.HTMLEntity
+
+	var og, fallback Metadata
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			// malformed pages still have whatever metadata came before the error
+			if og.IsZero() && fallback.IsZero() {
+				return og, err
+			}
+			break
+		}
+		if end, ok := tok.(xml.EndElement); ok && strings.EqualFold(end.Name.Local, "head") {
+			break
+		}
+		start, ok := tok.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		if strings.EqualFold(start.Name.Local, "body") {
+			break
+		}
+		if strings.EqualFold(start.Name.Local, "link") {
+			if og.Icon == "" {
+				og.Icon = iconHref(start.Attr)
+			}
+			continue
+		}
+		if !strings.EqualFold(start.Name.Local, "meta") {
+			continue
+		}
+		var key, content string
+		for _, attr := range start.Attr {
+			switch strings.ToLower(attr.Name.Local) {
+			case "property", "name":
+				key = strings.ToLower(attr.Value)
+			case "content":
+				content = strings.TrimSpace(attr.Value)
+			}
+		}
+		switch key {
+		case "og:title":
+			og.Title = content
+		case "og:description":
+			og.Description = content
+		case "og:image", "og:image:url", "og:image:secure_url":
+			if og.Image == "" {
+				og.Image = content
+			}
+		case "og:site_name":
+			og.SiteName = content
+		case "twitter:title":
+			fallback.Title = content
+		case "twitter:description", "description":
+			if fallback.Description == "" {
+				fallback.Description = content
+			}
+		case "twitter:image", "twitter:image:src":
+			fallback.Image = content
+		}
+	}
+
+	if og.Title == "" {
+		og.Title = fallback.Title
+	}
+	if og.Description == "" {
+		og.Description = fallback.Description
+	}
+	if og.Image == "" {
+		og.Image = fallback.Image
+	}
+	return og, nil
+}
+
+// iconHref returns the href of a <link> element with the given attributes if
+// it links to an icon of the page
+func iconHref(attrs []xml.Attr) string {
+	var isIcon bool
+	var href string
+	for _, attr := range attrs {
+		switch strings.ToLower(attr.Name.Local) {
+		case "rel":
+			for _, rel := range strings.Fields(strings.ToLower(attr.Value)) {
+				if rel == "icon" {
+					isIcon = true
+				}
+			}
+		case "href":
+			href = strings.TrimSpace(attr.Value)
+		}
+	}
+	if !isIcon {
+		return ""
+	}
+	return href
+}
@@ -0,0 +1,58 @@
+package opengraph
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	page := `<!DOCTYPE html>
+<html>
+<head>
+  <meta charset="utf-8">
+  <title>Page title</title>
+  <script>if (a < b) { console.log("<meta>") }</script>
+  <meta name="description" content="Plain description">
+  <meta property="og:title" content="OG title">
+  <meta property="og:description" content="  OG description &amp; more ">
+  <meta property="og:image" content="https://example.com/a.png">
+  <meta property="og:image" content="https://example.com/b.png">
+  <meta property="og:site_name" content="Example">
+  <link rel="stylesheet" href="/style.css">
+  <link rel="shortcut icon" href="/static/icon.png">
+  <link rel="icon" href="/other.png">
+</head>
+<body><meta property="og:description" content="not in head"></body>
+</html>`
+	m, err := Parse(strings.NewReader(page))
+	if err != nil {
+		t.Fatalf("Parse() received an error: %s", err.Error())
+	}
+	want := Metadata{
+		Title:       "OG title",
+		Description: "OG description & more",
+		Image:       "https://example.com/a.png",
+		SiteName:    "Example",
+		Icon:        "/static/icon.png",
+	}
+	if m != want {
+		t.Errorf("metadata: want %+v, got %+v", want, m)
+	}
+}
+
+func TestParse_fallback(t *testing.T) {
+	page := `<html><head>
+  <meta name="twitter:image" content="https://example.com/t.png">
+  <meta name="description" content="Plain description">
+</head><body></body></html>`
+	m, err := Parse(strings.NewReader(page))
+	if err != nil {
+		t.Fatalf("Parse() received an error: %s", err.Error())
+	}
+	if m.Description != "Plain description" {
+		t.Errorf("m.Description: want %q, got %q", "Plain description", m.Description)
+	}
+	if m.Image != "https://example.com/t.png" {
+		t.Errorf("m.Image: want %q, got %q", "https://example.com/t.png", m.Image)
+	}
+}
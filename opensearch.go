@@ -0,0 +1,47 @@
+package main
+
+import (
+	"encoding/xml"
+	"net/http"
+)
+
+// openSearchDescription is an OpenSearch 1.1 description document, letting
+// browsers add quiet_hn as a search engine.
+// See https://github.com/dewitt/opensearch.
+type openSearchDescription struct {
+	XMLName       xml.Name        `xml:"http://a9.com/-/spec/opensearch/1.1/ OpenSearchDescription"`
+	ShortName     string          `xml:"ShortName"`
+	Description   string          `xml:"Description"`
+	InputEncoding string          `xml:"InputEncoding"`
+	URLs          []openSearchURL `xml:"Url"`
+}
+
+type openSearchURL struct {
+	Type     string `xml:"type,attr"`
+	Method   string `xml:"method,attr"`
+	Template string `xml:"template,attr"`
+}
+
+// openSearchHandler serves the OpenSearch description of the /search route.
+// The templates must be absolute URLs, so they are built from the host the
+// request was made to.
+func openSearchHandler(cfg config) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		base := baseURL(r)
+		lang := languagePref(w, r, cfg.Messages)
+		desc := openSearchDescription{
+			ShortName:     cfg.Messages.Translate(lang, "title"),
+			Description:   cfg.Messages.Translate(lang, "opensearch.description"),
+			InputEncoding: "UTF-8",
+			URLs: []openSearchURL{
+				{Type: "text/html", Method: "get", Template: base + "/search?q={searchTerms}"},
+				{Type: "application/opensearchdescription+xml", Method: "get", Template: base + "/opensearch.xml"},
+			},
+		}
+		w.Header().Set("Content-Type", "application/opensearchdescription+xml; charset=utf-8")
+		w.Write([]byte(xml.Header))
+		enc := xml.NewEncoder(w)
+		enc.Indent("", "  ")
+		enc.Encode(desc)
+	})
+}
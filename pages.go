@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+)
+
+// newPageClient returns the HTTP client used to fetch the pages stories link
+// to, eg for reader mode and metadata enrichment. Requests time out after
+// timeout, can only reach public addresses and are sent with userAgent.
+func newPageClient(timeout time.Duration, userAgent string) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: timeout,
+		Control: publicAddressesOnly,
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = dialer.DialContext
+	return &http.Client{Timeout: timeout, Transport: userAgentTransport{transport, userAgent}}
+}
+
+// publicAddressesOnly is a net.Dialer Control function refusing to connect to
+// loopback, private and link-local addresses. Story URLs are chosen by HN
+// users, so without it anyone could make the server fetch pages from the
+// network it runs in.
+func publicAddressesOnly(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || ip.IsLoopback() || isPrivateIP(ip) || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsUnspecified() {
+		return fmt.Errorf("refusing to connect to non-public address %s", host)
+	}
+	return nil
+}
+
+// privateNetworks are the private IPv4 (RFC 1918) and IPv6 (RFC 4193)
+// networks
+var privateNetworks = func() []*net.IPNet {
+	var networks []*net.IPNet
+	for _, cidr := range []string{"10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "fc00::/7"} {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks = append(networks, n)
+	}
+	return networks
+}()
+
+// isPrivateIP reports whether ip is in one of privateNetworks, like
+// net.IP.IsPrivate, which needs Go 1.17
+func isPrivateIP(ip net.IP) bool {
+	for _, n := range privateNetworks {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,111 @@
+package pg
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// scram is a SCRAM-SHA-256 exchange (RFC 5802 and 7677). Postgres ignores
+// the user name of the exchange, which is thus left empty, and the channel
+// binding isn't supported.
+type scram struct {
+	password    string
+	nonce       string
+	clientBare  string
+	authMessage string
+	salted      []byte
+}
+
+func newSCRAM(password string) *scram {
+	b := make([]byte, 18)
+	rand.Read(b)
+	return &scram{password: password, nonce: base64.StdEncoding.EncodeToString(b)}
+}
+
+// clientFirst returns the client-first-message
+func (s *scram) clientFirst() string {
+	s.clientBare = "n=,r=" + s.nonce
+	return "n,," + s.clientBare
+}
+
+// clientFinal returns the client-final-message answering serverFirst
+func (s *scram) clientFinal(serverFirst string) (string, error) {
+	attrs := scramAttributes(serverFirst)
+	nonce, salt64, iter := attrs["r"], attrs["s"], attrs["i"]
+	if !strings.HasPrefix(nonce, s.nonce) || len(nonce) == len(s.nonce) {
+		return "", errors.New("pg: SCRAM: the server nonce doesn't extend ours")
+	}
+	salt, err := base64.StdEncoding.DecodeString(salt64)
+	if err != nil {
+		return "", errors.New("pg: SCRAM: bad salt")
+	}
+	n, err := strconv.Atoi(iter)
+	if err != nil || n < 1 {
+		return "", errors.New("pg: SCRAM: bad iteration count")
+	}
+	s.salted = pbkdf2SHA256([]byte(s.password), salt, n)
+	withoutProof := "c=biws,r=" + nonce
+	s.authMessage = s.clientBare + "," + serverFirst + "," + withoutProof
+	clientKey := hmacSHA256(s.salted, "Client Key")
+	storedKey := sha256.Sum256(clientKey)
+	signature := hmacSHA256(storedKey[:], s.authMessage)
+	proof := make([]byte, len(clientKey))
+	for i := range proof {
+		proof[i] = clientKey[i] ^ signature[i]
+	}
+	return withoutProof + ",p=" + base64.StdEncoding.EncodeToString(proof), nil
+}
+
+// verify checks the signature of the server-final-message
+func (s *scram) verify(serverFinal string) error {
+	attrs := scramAttributes(serverFinal)
+	if e, ok := attrs["e"]; ok {
+		return errors.New("pg: SCRAM: " + e)
+	}
+	want := hmacSHA256(hmacSHA256(s.salted, "Server Key"), s.authMessage)
+	got, err := base64.StdEncoding.DecodeString(attrs["v"])
+	if err != nil || !hmac.Equal(got, want) {
+		return errors.New("pg: SCRAM: bad server signature")
+	}
+	return nil
+}
+
+func scramAttributes(msg string) map[string]string {
+	attrs := make(map[string]string)
+	for _, attr := range strings.Split(msg, ",") {
+		if len(attr) >= 2 && attr[1] == '=' {
+			attrs[attr[:1]] = attr[2:]
+		}
+	}
+	return attrs
+}
+
+func hmacSHA256(key []byte, msg string) []byte {
+	m := hmac.New(sha256.New, key)
+	m.Write([]byte(msg))
+	return m.Sum(nil)
+}
+
+// pbkdf2SHA256 is PBKDF2 (RFC 8018) with HMAC-SHA-256, for a single block
+// of output as needed by SCRAM
+func pbkdf2SHA256(password, salt []byte, iter int) []byte {
+	m := hmac.New(sha256.New, password)
+	m.Write(salt)
+	m.Write([]byte{0, 0, 0, 1})
+	u := m.Sum(nil)
+	out := append([]byte(nil), u...)
+	for i := 1; i < iter; i++ {
+		m.Reset()
+		m.Write(u)
+		u = m.Sum(u[:0])
+		for j := range out {
+			out[j] ^= u[j]
+		}
+	}
+	return out
+}
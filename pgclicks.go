@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/mmxmb/quiet_hn/pg"
+)
+
+// pgClicks keeps the click counts in the story_clicks table
+type pgClicks struct {
+	db *pg.DB
+}
+
+func (p *pgClicks) Load() (map[int]int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), pgTimeout)
+	defer cancel()
+	rows, err := p.db.Query(ctx, `SELECT story, clicks FROM story_clicks`)
+	if err != nil {
+		return nil, err
+	}
+	counts := make(map[int]int, len(rows.Rows))
+	for _, row := range rows.Rows {
+		id, err := strconv.Atoi(*row[0])
+		if err != nil {
+			return nil, err
+		}
+		if counts[id], err = strconv.Atoi(*row[1]); err != nil {
+			return nil, err
+		}
+	}
+	return counts, nil
+}
+
+// Save replaces the counts of the table with counts, in a transaction
+func (p *pgClicks) Save(counts map[int]int) error {
+	var script strings.Builder
+	script.WriteString("BEGIN;\nDELETE FROM story_clicks;\n")
+	for id, n := range counts {
+		fmt.Fprintf(&script, "INSERT INTO story_clicks (story, clicks) VALUES (%d, %d);\n", id, n)
+	}
+	script.WriteString("COMMIT;")
+	ctx, cancel := context.WithTimeout(context.Background(), pgTimeout)
+	defer cancel()
+	return p.db.ExecScript(ctx, script.String())
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/mmxmb/quiet_hn/history"
+	"github.com/mmxmb/quiet_hn/pg"
+)
+
+// pgTimeout bounds the queries of the Postgres backends
+const pgTimeout = 10 * time.Second
+
+// pgHistory keeps the front page history in the history_snapshots table, so
+// that replicas can share it. The snapshots are stored whole as JSON, which
+// keeps their times to the nanosecond, and by time, which Postgres keeps to
+// the microsecond.
+type pgHistory struct {
+	db *pg.DB
+}
+
+func (h *pgHistory) Load(after time.Time, fn func(history.Snapshot) error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), pgTimeout)
+	defer cancel()
+	// the times stored may be rounded up past after, the snapshots
+	// themselves aren't
+	rows, err := h.db.Query(ctx, `SELECT snapshot FROM history_snapshots WHERE time > $1 ORDER BY time`, after.Truncate(time.Microsecond))
+	if err != nil {
+		return err
+	}
+	for _, row := range rows.Rows {
+		var snap history.Snapshot
+		if err := json.Unmarshal([]byte(*row[0]), &snap); err != nil {
+			return fmt.Errorf("history: %w", err)
+		}
+		if !snap.Time.After(after) {
+			continue
+		}
+		if err := fn(snap); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (h *pgHistory) Append(snap history.Snapshot) error {
+	b, err := json.Marshal(snap)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), pgTimeout)
+	defer cancel()
+	_, err = h.db.Exec(ctx, `INSERT INTO history_snapshots (time, snapshot) VALUES ($1, $2) ON CONFLICT (time) DO NOTHING`, snap.Time, string(b))
+	return err
+}
+
+func (h *pgHistory) Compact(keep []history.Snapshot) error {
+	ctx, cancel := context.WithTimeout(context.Background(), pgTimeout)
+	defer cancel()
+	if len(keep) == 0 {
+		_, err := h.db.Exec(ctx, `DELETE FROM history_snapshots`)
+		return err
+	}
+	// the time of the oldest snapshot kept may have been rounded down, but
+	// not below its truncation
+	_, err := h.db.Exec(ctx, `DELETE FROM history_snapshots WHERE time < $1`, keep[0].Time.Truncate(time.Microsecond))
+	return err
+}
+
+// Close does nothing, the database is closed by its pgStore
+func (h *pgHistory) Close() error {
+	return nil
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/mmxmb/quiet_hn/pg"
+)
+
+// pgLease keeps the lease of the leader election in the leader_lease table.
+// It is taken with a single statement, only updating the row if the replica
+// holds the lease or it has expired, and its times are the ones of the
+// database so that the clocks of the replicas don't matter.
+type pgLease struct {
+	db *pg.DB
+}
+
+// Acquire implements leader.Backend
+func (l *pgLease) Acquire(ctx context.Context, id string, ttl time.Duration, now time.Time) (bool, error) {
+	n, err := l.db.Exec(ctx, `INSERT INTO leader_lease (name, holder, expires) VALUES ('leader', $1, now() + $2::interval)
+		ON CONFLICT (name) DO UPDATE SET holder = excluded.holder, expires = excluded.expires
+		WHERE leader_lease.holder = $1 OR leader_lease.expires < now()`, id, fmt.Sprintf("%d microseconds", ttl.Microseconds()))
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
+}
+
+// Release implements leader.Backend
+func (l *pgLease) Release(ctx context.Context, id string) error {
+	_, err := l.db.Exec(ctx, `DELETE FROM leader_lease WHERE name = 'leader' AND holder = $1`, id)
+	return err
+}
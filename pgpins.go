@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/mmxmb/quiet_hn/pg"
+)
+
+// pgPins keeps the stories pinned in the only row of the pinned_stories
+// table, so that the replicas share them
+type pgPins struct {
+	db *pg.DB
+}
+
+func (p *pgPins) Load() ([]int, bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), pgTimeout)
+	defer cancel()
+	rows, err := p.db.Query(ctx, `SELECT ids FROM pinned_stories`)
+	if err != nil {
+		return nil, false, err
+	}
+	if len(rows.Rows) == 0 {
+		return nil, false, nil
+	}
+	var ids []int
+	if err := json.Unmarshal([]byte(*rows.Rows[0][0]), &ids); err != nil {
+		return nil, false, err
+	}
+	return ids, true, nil
+}
+
+func (p *pgPins) Save(ids []int) error {
+	if ids == nil {
+		// saved as an empty list rather than null
+		ids = []int{}
+	}
+	b, err := json.Marshal(ids)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), pgTimeout)
+	defer cancel()
+	_, err = p.db.Exec(ctx, `INSERT INTO pinned_stories (ids) VALUES ($1) ON CONFLICT (board) DO UPDATE SET ids = excluded.ids`, string(b))
+	return err
+}
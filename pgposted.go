@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mmxmb/quiet_hn/pg"
+)
+
+// pgPosted keeps the stories posted in the posted_stories table, so that a
+// new leader doesn't post them again
+type pgPosted struct {
+	db *pg.DB
+}
+
+func (p *pgPosted) Load() (map[string]map[int]time.Time, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), pgTimeout)
+	defer cancel()
+	rows, err := p.db.Query(ctx, `SELECT sink, story, `+pgMicros("posted")+` FROM posted_stories`)
+	if err != nil {
+		return nil, err
+	}
+	posted := make(map[string]map[int]time.Time)
+	for _, row := range rows.Rows {
+		id, err := strconv.Atoi(*row[1])
+		if err != nil {
+			return nil, err
+		}
+		at, err := parsePGMicros(*row[2])
+		if err != nil {
+			return nil, err
+		}
+		if posted[*row[0]] == nil {
+			posted[*row[0]] = make(map[int]time.Time)
+		}
+		posted[*row[0]][id] = at
+	}
+	return posted, nil
+}
+
+// Save replaces the stories of the table with posted, in a transaction
+func (p *pgPosted) Save(posted map[string]map[int]time.Time) error {
+	var script strings.Builder
+	script.WriteString("BEGIN;\nDELETE FROM posted_stories;\n")
+	for sink, ids := range posted {
+		for id, at := range ids {
+			fmt.Fprintf(&script, "INSERT INTO posted_stories (sink, story, posted) VALUES (%s, %d, %s);\n", pgQuote(sink), id, pgQuote(pgTimestamp(at)))
+		}
+	}
+	script.WriteString("COMMIT;")
+	ctx, cancel := context.WithTimeout(context.Background(), pgTimeout)
+	defer cancel()
+	return p.db.ExecScript(ctx, script.String())
+}
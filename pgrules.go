@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mmxmb/quiet_hn/pg"
+)
+
+// pgRules keeps the alert rules in the alert_rules table, so that the
+// replicas share them
+type pgRules struct {
+	db *pg.DB
+}
+
+func (p *pgRules) Load() ([]rule, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), pgTimeout)
+	defer cancel()
+	rows, err := p.db.Query(ctx, `SELECT rule FROM alert_rules ORDER BY position`)
+	if err != nil {
+		return nil, err
+	}
+	var rules []rule
+	for _, row := range rows.Rows {
+		var r rule
+		if err := json.Unmarshal([]byte(*row[0]), &r); err != nil {
+			return nil, err
+		}
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
+
+// Save replaces the rules of the table with rules, in a transaction. The
+// parameters of the queries can't span several statements, so the rules are
+// quoted into the script.
+func (p *pgRules) Save(rules []rule) error {
+	var script strings.Builder
+	script.WriteString("BEGIN;\nDELETE FROM alert_rules;\n")
+	for i, r := range rules {
+		b, err := json.Marshal(r)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(&script, "INSERT INTO alert_rules (name, position, rule) VALUES (%s, %d, %s);\n", pgQuote(r.Name), i, pgQuote(string(b)))
+	}
+	script.WriteString("COMMIT;")
+	ctx, cancel := context.WithTimeout(context.Background(), pgTimeout)
+	defer cancel()
+	return p.db.ExecScript(ctx, script.String())
+}
+
+// pgQuote returns s as a string literal, with standard_conforming_strings
+// on as it is by default
+func pgQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// pgTimestamp returns t as a timestamptz literal, to the microsecond kept by
+// Postgres
+func pgTimestamp(t time.Time) string {
+	return t.UTC().Format("2006-01-02 15:04:05.999999Z07:00")
+}
+
+// pgMicros returns the expression of the timestamptz column as microseconds
+// since the epoch, which parsePGMicros parses back without the formats of
+// the server
+func pgMicros(column string) string {
+	return "(extract(epoch FROM " + column + ") * 1000000)::bigint"
+}
+
+func parsePGMicros(s string) (time.Time, error) {
+	us, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(0, us*int64(time.Microsecond)), nil
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/mmxmb/quiet_hn/pg"
+)
+
+// pgUsage keeps the usage counters of the API keys in the api_key_usage
+// table
+type pgUsage struct {
+	db *pg.DB
+}
+
+func (p *pgUsage) Load() (map[string]*apiUsage, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), pgTimeout)
+	defer cancel()
+	rows, err := p.db.Query(ctx, `SELECT name, requests, limited, `+pgMicros("last_used")+` FROM api_key_usage`)
+	if err != nil {
+		return nil, err
+	}
+	usage := make(map[string]*apiUsage, len(rows.Rows))
+	for _, row := range rows.Rows {
+		var u apiUsage
+		if u.Requests, err = strconv.ParseInt(*row[1], 10, 64); err != nil {
+			return nil, err
+		}
+		if u.Limited, err = strconv.ParseInt(*row[2], 10, 64); err != nil {
+			return nil, err
+		}
+		if u.LastUsed, err = parsePGMicros(*row[3]); err != nil {
+			return nil, err
+		}
+		usage[*row[0]] = &u
+	}
+	return usage, nil
+}
+
+// Save replaces the counters of the table with usage, in a transaction
+func (p *pgUsage) Save(usage map[string]*apiUsage) error {
+	var script strings.Builder
+	script.WriteString("BEGIN;\nDELETE FROM api_key_usage;\n")
+	for name, u := range usage {
+		fmt.Fprintf(&script, "INSERT INTO api_key_usage (name, requests, limited, last_used) VALUES (%s, %d, %d, %s);\n", pgQuote(name), u.Requests, u.Limited, pgQuote(pgTimestamp(u.LastUsed)))
+	}
+	script.WriteString("COMMIT;")
+	ctx, cancel := context.WithTimeout(context.Background(), pgTimeout)
+	defer cancel()
+	return p.db.ExecScript(ctx, script.String())
+}
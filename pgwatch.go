@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/mmxmb/quiet_hn/pg"
+)
+
+// pgWatched keeps the threads watched in the watched_threads table, so that
+// the replicas share them
+type pgWatched struct {
+	db *pg.DB
+}
+
+func (p *pgWatched) Load() ([]watchedThread, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), pgTimeout)
+	defer cancel()
+	rows, err := p.db.Query(ctx, `SELECT thread FROM watched_threads ORDER BY position`)
+	if err != nil {
+		return nil, err
+	}
+	var threads []watchedThread
+	for _, row := range rows.Rows {
+		var t watchedThread
+		if err := json.Unmarshal([]byte(*row[0]), &t); err != nil {
+			return nil, err
+		}
+		threads = append(threads, t)
+	}
+	return threads, nil
+}
+
+// Save replaces the threads of the table with threads, in a transaction,
+// quoted into the script like the rules of pgRules
+func (p *pgWatched) Save(threads []watchedThread) error {
+	var script strings.Builder
+	script.WriteString("BEGIN;\nDELETE FROM watched_threads;\n")
+	for i, t := range threads {
+		b, err := json.Marshal(t)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(&script, "INSERT INTO watched_threads (id, position, thread) VALUES (%d, %d, %s);\n", t.ID, i, pgQuote(string(b)))
+	}
+	script.WriteString("COMMIT;")
+	ctx, cancel := context.WithTimeout(context.Background(), pgTimeout)
+	defer cancel()
+	return p.db.ExecScript(ctx, script.String())
+}
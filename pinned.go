@@ -0,0 +1,304 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// maxPinned bounds the stories pinned by a user, which are kept in a cookie
+const maxPinned = 10
+
+// pinBoard holds the stories the operator pinned to the top of the front
+// page of every user, set with -pinned and on the admin dashboard, and saved
+// to backend if set
+type pinBoard struct {
+	backend pinBackend
+
+	mu  sync.RWMutex
+	ids []int
+}
+
+// pinBackend is where the stories pinned are kept
+type pinBackend interface {
+	// Load returns the stories pinned, and false if they were never saved
+	Load() ([]int, bool, error)
+	Save(ids []int) error
+}
+
+// filePins keeps the stories pinned in a JSON file
+type filePins struct {
+	path string
+}
+
+func (f filePins) Load() ([]int, bool, error) {
+	data, err := ioutil.ReadFile(f.path)
+	if os.IsNotExist(err) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	var ids []int
+	if err := json.Unmarshal(data, &ids); err != nil {
+		return nil, false, fmt.Errorf("parsing %s: %w", f.path, err)
+	}
+	return ids, true, nil
+}
+
+// Save writes ids to the file, replacing it atomically
+func (f filePins) Save(ids []int) error {
+	data, err := json.Marshal(ids)
+	if err != nil {
+		return err
+	}
+	tmp := f.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, f.path)
+}
+
+// newPinBoard returns the board of the stories pinned with ids or, if they
+// were ever saved, the ones of backend
+func newPinBoard(backend pinBackend, ids []int) (*pinBoard, error) {
+	b := &pinBoard{backend: backend, ids: ids}
+	if backend == nil {
+		return b, nil
+	}
+	saved, ok, err := backend.Load()
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		b.ids = saved
+	}
+	return b, nil
+}
+
+// IDs returns the stories pinned, in order
+func (b *pinBoard) IDs() []int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return append([]int(nil), b.ids...)
+}
+
+// with returns the stories pinned, and the ones of user which aren't among
+// them. b may be nil.
+func (b *pinBoard) with(user []int) (pinned, own []int) {
+	if b != nil {
+		pinned = b.IDs()
+	}
+	for _, id := range user {
+		if !containsID(pinned, id) {
+			own = append(own, id)
+		}
+	}
+	return pinned, own
+}
+
+func containsID(ids []int, id int) bool {
+	for _, other := range ids {
+		if other == id {
+			return true
+		}
+	}
+	return false
+}
+
+// Pin adds id below the stories pinned, if it isn't one of them, and saves
+// them
+func (b *pinBoard) Pin(id int) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if containsID(b.ids, id) {
+		return nil
+	}
+	return b.save(append(append([]int(nil), b.ids...), id))
+}
+
+// Unpin removes id from the stories pinned and saves them
+func (b *pinBoard) Unpin(id int) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var ids []int
+	for _, pinned := range b.ids {
+		if pinned != id {
+			ids = append(ids, pinned)
+		}
+	}
+	return b.save(ids)
+}
+
+// save makes ids the stories pinned, saving them to b.backend, if set.
+// b.mu must be held.
+func (b *pinBoard) save(ids []int) error {
+	if b.backend != nil {
+		if err := b.backend.Save(ids); err != nil {
+			return err
+		}
+	}
+	b.ids = ids
+	return nil
+}
+
+// parseIDs parses a comma separated list of item IDs, dropping the
+// duplicates
+func parseIDs(s string) ([]int, error) {
+	var ids []int
+	seen := make(map[int]bool)
+	for _, field := range strings.Split(s, ",") {
+		if field = strings.TrimSpace(field); field == "" {
+			continue
+		}
+		id, err := strconv.Atoi(field)
+		if err != nil || id <= 0 {
+			return nil, fmt.Errorf("invalid item ID %q", field)
+		}
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+// formatIDs is the inverse of parseIDs
+func formatIDs(ids []int) string {
+	fields := make([]string, len(ids))
+	for i, id := range ids {
+		fields[i] = strconv.Itoa(id)
+	}
+	return strings.Join(fields, ",")
+}
+
+// pinnedPref reads the stories pinned by the user, a comma separated list
+// of item IDs, from the pin query parameter (saving it) or cookie. An empty
+// pin parameter unpins them all.
+func pinnedPref(w http.ResponseWriter, r *http.Request, q *queryParams, def []int) []int {
+	if values, ok := r.URL.Query()["pin"]; ok {
+		ids, err := parseIDs(values[0])
+		if err == nil && len(ids) > maxPinned {
+			err = fmt.Errorf("at most %d stories can be pinned", maxPinned)
+		}
+		if err == nil {
+			setPrefCookie(w, "pin", formatIDs(ids))
+			return ids
+		}
+		q.fail("pin", err.Error())
+	}
+	if c, err := r.Cookie("pin"); err == nil {
+		if ids, err := parseIDs(c.Value); err == nil && len(ids) <= maxPinned {
+			return ids
+		}
+	}
+	return def
+}
+
+// cachedPinnedStories returns the stories pinned by the operator followed by
+// the ones pinned by the user, own, which are alive, in order, marked
+// Pinned. Only the ones of the operator are cached like the front page: the
+// few pinned by the user are fetched every time, so that the lists of the
+// users neither take the place of the front page in the cache nor show in
+// its stats.
+func cachedPinnedStories(ctx context.Context, client StoryProvider, cache *Cache, cfg config, pinned, own []int) ([]item, error) {
+	var stories []item
+	if len(pinned) > 0 {
+		var err error
+		stories, err = cache.Fetch(ctx, "pinned="+formatIDs(pinned), func(ctx context.Context) ([]item, error) {
+			return getPinnedStories(ctx, client, cfg, pinned)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(own) == 0 {
+		return stories, nil
+	}
+	more, err := getPinnedStories(ctx, client, cfg, own)
+	if err != nil {
+		return nil, err
+	}
+	return append(stories, more...), nil
+}
+
+// getPinnedStories returns the stories with the given IDs which are alive,
+// in order, marked Pinned
+func getPinnedStories(ctx context.Context, client StoryProvider, cfg config, ids []int) ([]item, error) {
+	hnItems, err := client.GetItems(ctx, ids, cfg.Concurrency)
+	if err != nil {
+		return nil, err
+	}
+	var stories []item
+	for _, hnItem := range hnItems {
+		if itm := parseHNItem(hnItem); itm.Alive() {
+			itm.Pinned = true
+			stories = append(stories, itm)
+		}
+	}
+	return stories, nil
+}
+
+// pinStories returns pinned followed by the stories which aren't among
+// them, the rank from the front page kept for the pinned ones which are on
+// it, bounded to n stories. stories is released.
+func pinStories(pinned, stories []item, n int) []item {
+	ranks := make(map[int]int, len(stories))
+	for _, itm := range stories {
+		ranks[itm.ID] = itm.Rank
+	}
+	ids := make(map[int]bool, len(pinned))
+	for i := range pinned {
+		pinned[i].Rank = ranks[pinned[i].ID]
+		ids[pinned[i].ID] = true
+	}
+	for _, itm := range stories {
+		if len(pinned) >= n {
+			break
+		}
+		if !ids[itm.ID] {
+			pinned = append(pinned, itm)
+		}
+	}
+	releaseItems(stories)
+	if len(pinned) > n {
+		pinned = pinned[:n]
+	}
+	return pinned
+}
+
+// pinnedAdminHandler serves POST /admin/pinned, which pins the story of
+// the pin field or unpins the one of unpin, and redirects back to the
+// dashboard
+func pinnedAdminHandler(board *pinBoard) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !sameOrigin(r) {
+			http.Error(w, "cross-origin requests are forbidden", http.StatusForbidden)
+			return
+		}
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		op, field := board.Pin, "pin"
+		if r.PostForm.Get("unpin") != "" {
+			op, field = board.Unpin, "unpin"
+		}
+		id, err := strconv.Atoi(strings.TrimSpace(r.PostForm.Get(field)))
+		if err != nil || id <= 0 {
+			http.Error(w, "invalid item ID", http.StatusBadRequest)
+			return
+		}
+		if err := op(id); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		http.Redirect(w, r, "/admin", http.StatusSeeOther)
+	})
+}
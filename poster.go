@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/mmxmb/quiet_hn/notify"
+)
+
+// defaultPostTemplate is the default text of the posts of the cross-poster,
+// a text/template executed with the notify.Story
+const defaultPostTemplate = "{{.Title}} {{.URL}}\n\nDiscussion: {{.Discussion}}"
+
+// postedRetention is how long the stories posted are remembered, long after
+// they have left the front page
+const postedRetention = 30 * 24 * time.Hour
+
+// postSink is a notifier the cross-poster posts to and which stories it
+// gets
+type postSink struct {
+	Name     string
+	Notifier notify.Notifier
+	// MinScore is the score past which a story is posted
+	MinScore int
+	Template *template.Template
+	// MinInterval is the minimum time between two posts, so that a burst of
+	// stories crossing the threshold doesn't get the account rate limited.
+	// The stories held back are posted in the next rounds.
+	MinInterval time.Duration
+}
+
+// crossPoster posts the stories of the front page crossing the score
+// threshold of its sinks, once per sink
+type crossPoster struct {
+	sinks []*postSink
+
+	mu sync.Mutex
+	// posted is when each story was posted, by sink name and story ID
+	posted map[string]map[int]time.Time
+	// last is when each sink was last posted to
+	last map[string]time.Time
+}
+
+func newCrossPoster(sinks ...*postSink) *crossPoster {
+	return &crossPoster{
+		sinks:  sinks,
+		posted: make(map[string]map[int]time.Time),
+		last:   make(map[string]time.Time),
+	}
+}
+
+// parsePostTemplate parses the text/template of the posts of a sink,
+// defaultPostTemplate if text is empty
+func parsePostTemplate(name, text string) (*template.Template, error) {
+	if text == "" {
+		text = defaultPostTemplate
+	}
+	// flags can't easily hold newlines
+	text = strings.ReplaceAll(text, `\n`, "\n")
+	return template.New(name).Parse(text)
+}
+
+// notifyStory returns the story as passed to the notifiers
+func notifyStory(itm item) notify.Story {
+	return notify.Story{
+		ID:         itm.ID,
+		Title:      itm.Title,
+		URL:        itm.URL,
+		Host:       itm.Host,
+		Score:      itm.Score,
+		Comments:   itm.Descendants,
+		Discussion: "https://" + hnHost + "/item?id=" + strconv.Itoa(itm.ID),
+	}
+}
+
+// post posts the stories crossing the threshold of each sink which weren't
+// posted to it yet, and returns how many posts were made
+func (p *crossPoster) post(ctx context.Context, stories []item, now time.Time) int {
+	n := 0
+	for _, sink := range p.sinks {
+		for _, itm := range stories {
+			if itm.Score < sink.MinScore || p.wasPosted(sink.Name, itm.ID) {
+				continue
+			}
+			p.mu.Lock()
+			next := p.last[sink.Name].Add(sink.MinInterval)
+			p.mu.Unlock()
+			if now.Before(next) {
+				break
+			}
+			story := notifyStory(itm)
+			var text strings.Builder
+			if err := sink.Template.Execute(&text, story); err != nil {
+				log.Printf("rendering the %s post of story %d: %s", sink.Name, itm.ID, err)
+				break
+			}
+			if err := sink.Notifier.Notify(ctx, notify.Message{Text: text.String(), Story: story}); err != nil {
+				// retried in the next round
+				log.Printf("posting story %d to %s: %s", itm.ID, sink.Name, err)
+				break
+			}
+			p.mu.Lock()
+			if p.posted[sink.Name] == nil {
+				p.posted[sink.Name] = make(map[int]time.Time)
+			}
+			p.posted[sink.Name][itm.ID] = now
+			p.last[sink.Name] = now
+			p.mu.Unlock()
+			n++
+		}
+	}
+	return n
+}
+
+func (p *crossPoster) wasPosted(sink string, id int) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, ok := p.posted[sink][id]
+	return ok
+}
+
+// postedBackend is where the stories posted are kept, by sink name and
+// story ID
+type postedBackend interface {
+	Load() (map[string]map[int]time.Time, error)
+	Save(posted map[string]map[int]time.Time) error
+}
+
+// filePosted keeps the stories posted in a JSON file
+type filePosted struct {
+	path string
+}
+
+// Load reads the stories posted of the file, none if it doesn't exist
+func (f filePosted) Load() (map[string]map[int]time.Time, error) {
+	b, err := ioutil.ReadFile(f.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var posted map[string]map[int]time.Time
+	if err := json.Unmarshal(b, &posted); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", f.path, err)
+	}
+	return posted, nil
+}
+
+// Save writes posted to the file, replacing it atomically
+func (f filePosted) Save(posted map[string]map[int]time.Time) error {
+	b, err := json.MarshalIndent(posted, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := f.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, f.path)
+}
+
+// save saves the stories posted in the last postedRetention to backend
+func (p *crossPoster) save(backend postedBackend, now time.Time) error {
+	p.mu.Lock()
+	posted := make(map[string]map[int]time.Time, len(p.posted))
+	for sink, ids := range p.posted {
+		for id, at := range ids {
+			if now.Sub(at) > postedRetention {
+				delete(ids, id)
+			}
+		}
+		posted[sink] = make(map[int]time.Time, len(ids))
+		for id, at := range ids {
+			posted[sink][id] = at
+		}
+	}
+	p.mu.Unlock()
+	return backend.Save(posted)
+}
+
+// load reads the stories posted saved to backend, replacing the ones known
+func (p *crossPoster) load(backend postedBackend) error {
+	posted, err := backend.Load()
+	if err != nil {
+		return err
+	}
+	if posted == nil {
+		posted = make(map[string]map[int]time.Time)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.posted = posted
+	return nil
+}
+
+// runCrossPoster posts the front page as seen with the default preferences
+// every interval until ctx is done, saving the stories posted to posted, if
+// set
+func runCrossPoster(ctx context.Context, client StoryProvider, cache *Cache, cfg config, p *crossPoster, interval time.Duration, posted postedBackend) {
+	f := newFilter(cfg, cfg.Defaults)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		stories, err := cachedTopStories(ctx, client, cache, cfg, f)
+		if err != nil && ctx.Err() == nil {
+			log.Printf("cross-posting the front page: %s", err)
+		}
+		if n := p.post(ctx, stories, time.Now()); n > 0 && posted != nil {
+			if err := p.save(posted, time.Now()); err != nil {
+				log.Printf("saving the stories posted: %s", err)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
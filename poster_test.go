@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mmxmb/quiet_hn/hn"
+	"github.com/mmxmb/quiet_hn/notify"
+)
+
+// fakeNotifier records the messages it is sent, failing with err if set
+type fakeNotifier struct {
+	msgs []notify.Message
+	err  error
+}
+
+func (n *fakeNotifier) Notify(ctx context.Context, msg notify.Message) error {
+	if n.err != nil {
+		return n.err
+	}
+	n.msgs = append(n.msgs, msg)
+	return nil
+}
+
+func TestCrossPoster(t *testing.T) {
+	tpl, err := parsePostTemplate("test", `{{.Title}} ({{.Score}})\n{{.Discussion}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := &fakeNotifier{}
+	sink := &postSink{Name: "test", Notifier: n, MinScore: 100, Template: tpl, MinInterval: time.Minute}
+	p := newCrossPoster(sink)
+	stories := []item{
+		parseHNItem(hn.Item{ID: 1, Title: "Popular", URL: "https://example.com/1", Score: 150}),
+		parseHNItem(hn.Item{ID: 2, Title: "Quiet", URL: "https://example.com/2", Score: 20}),
+		parseHNItem(hn.Item{ID: 3, Title: "Also popular", URL: "https://example.com/3", Score: 300}),
+	}
+	now := time.Now()
+
+	if posted := p.post(context.Background(), stories, now); posted != 1 {
+		t.Errorf("post(): want 1 post before the interval, got %d", posted)
+	}
+	if len(n.msgs) != 1 || n.msgs[0].Text != "Popular (150)\nhttps://news.ycombinator.com/item?id=1" {
+		t.Fatalf("messages: want the post of story 1, got %+v", n.msgs)
+	}
+	// story 3 is held back until the interval passed, story 1 isn't posted again
+	if posted := p.post(context.Background(), stories, now.Add(time.Second)); posted != 0 {
+		t.Errorf("post() within the interval: want no posts, got %d", posted)
+	}
+	n.err = errors.New("down")
+	if posted := p.post(context.Background(), stories, now.Add(2*time.Minute)); posted != 0 {
+		t.Errorf("post() to a failing sink: want no posts, got %d", posted)
+	}
+	n.err = nil
+	if posted := p.post(context.Background(), stories, now.Add(3*time.Minute)); posted != 1 || n.msgs[1].Story.ID != 3 {
+		t.Errorf("post() after the interval: want story 3 posted, got %d posts %+v", posted, n.msgs)
+	}
+
+	// the stories posted survive restarts
+	backend := filePosted{path: filepath.Join(t.TempDir(), "posted.json")}
+	if err := p.save(backend, now); err != nil {
+		t.Fatalf("save() received an error: %s", err.Error())
+	}
+	restarted := newCrossPoster(sink)
+	if err := restarted.load(backend); err != nil {
+		t.Fatalf("load() received an error: %s", err.Error())
+	}
+	if posted := restarted.post(context.Background(), stories, now.Add(time.Hour)); posted != 0 {
+		t.Errorf("post() after a restart: want no posts, got %d", posted)
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/mmxmb/quiet_hn/i18n"
+)
+
+const prefsCookieMaxAge = 365 * 24 * time.Hour
+
+// preferences are the per-user settings of the front page. They are stored in
+// cookies and changed by visiting a page with the matching query parameter,
+// eg /?hide_jobs=false.
+type preferences struct {
+	HideJobs      bool
+	HidePaywalled bool
+	// HideControversial hides the stories flagged by markControversial
+	HideControversial bool
+	// Timezone is the IANA name of the timezone times are displayed in
+	Timezone string
+	// Pinned are the IDs of the stories pinned to the top of the front page
+	Pinned []int
+	// CommentOrder is the order of the top level comments of item pages,
+	// one of commentOrders
+	CommentOrder string
+	// Muted are the users whose stories the user doesn't want to see
+	Muted []string
+}
+
+// loadPreferences returns the preferences of the user making r, starting from
+// defaults. Preferences passed as query parameters are also saved in cookies
+// so they stick for subsequent requests. Invalid query parameters are
+// reported with a queryError, while invalid cookies are ignored.
+func loadPreferences(w http.ResponseWriter, r *http.Request, defaults preferences) (preferences, error) {
+	q := newQueryParams(r)
+	prefs := defaults
+	prefs.HideJobs = boolPref(w, r, q, "hide_jobs", prefs.HideJobs)
+	prefs.HidePaywalled = boolPref(w, r, q, "hide_paywalled", prefs.HidePaywalled)
+	prefs.HideControversial = boolPref(w, r, q, "hide_controversial", prefs.HideControversial)
+	prefs.Timezone = stringPref(w, r, q, "tz", prefs.Timezone, validTimezone)
+	prefs.Pinned = pinnedPref(w, r, q, prefs.Pinned)
+	prefs.Muted = mutedPref(w, r, q, prefs.Muted)
+	prefs.CommentOrder = stringPref(w, r, q, "comment_order", prefs.CommentOrder, validCommentOrder)
+	return prefs, q.Err()
+}
+
+// stringPref reads the preference name from the query string (saving it) or
+// the cookies of r, falling back to def if it isn't set or valid reports
+// false for it. Invalid query parameters are recorded in q, unless it is nil.
+func stringPref(w http.ResponseWriter, r *http.Request, q *queryParams, name, def string, valid func(string) bool) string {
+	if v := r.URL.Query().Get(name); v != "" {
+		if valid(v) {
+			setPrefCookie(w, name, v)
+			return v
+		}
+		if q != nil {
+			q.fail(name, "is not valid")
+		}
+	}
+	if c, err := r.Cookie(name); err == nil && valid(c.Value) {
+		return c.Value
+	}
+	return def
+}
+
+func validTimezone(name string) bool {
+	_, err := loadLocation(name)
+	return err == nil
+}
+
+// boolPref reads the boolean preference name from the query string (saving
+// it) or the cookies of r, falling back to def if it isn't set or invalid.
+// Invalid query parameters are recorded in q.
+func boolPref(w http.ResponseWriter, r *http.Request, q *queryParams, name string, def bool) bool {
+	if v := r.URL.Query().Get(name); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err == nil {
+			setPrefCookie(w, name, strconv.FormatBool(b))
+			return b
+		}
+		q.fail(name, "must be true or false")
+	}
+	if c, err := r.Cookie(name); err == nil {
+		b, err := strconv.ParseBool(c.Value)
+		if err == nil {
+			return b
+		}
+	}
+	return def
+}
+
+// languagePref returns the language of the UI for the user making r: the one
+// picked with the lang query parameter (saving it) or cookie if it is
+// supported, or the best match for the Accept-Language header otherwise.
+// Unsupported languages aren't an error: the link may have been shared by a
+// user of another instance.
+func languagePref(w http.ResponseWriter, r *http.Request, messages *i18n.Bundle) string {
+	supported := func(lang string) bool {
+		return messages.Match(lang) == lang
+	}
+	def := messages.Match(i18n.ParseAcceptLanguage(r.Header.Get("Accept-Language"))...)
+	return stringPref(w, r, nil, "lang", def, supported)
+}
+
+func setPrefCookie(w http.ResponseWriter, name, value string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/",
+		MaxAge:   int(prefsCookieMaxAge / time.Second),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
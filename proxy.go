@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mmxmb/quiet_hn/hn"
+	"github.com/mmxmb/quiet_hn/router"
+)
+
+// firebaseProxy serves the HN items, users and top stories in the format of
+// the official Firebase API (/v0/item/{id}.json etc), so that other local
+// tools can point their HN client at the server and share its upstream
+// connections, circuit breaker and caches instead of calling HN directly.
+//
+// The responses are cached for ttl, up to maxEntries of them.
+type firebaseProxy struct {
+	client     StoryProvider
+	ttl        time.Duration
+	maxEntries int
+
+	mu      sync.Mutex
+	entries map[string]proxyEntry
+}
+
+type proxyEntry struct {
+	body       []byte
+	expiration time.Time
+}
+
+func newFirebaseProxy(client StoryProvider, ttl time.Duration, maxEntries int) *firebaseProxy {
+	return &firebaseProxy{client: client, ttl: ttl, maxEntries: maxEntries, entries: make(map[string]proxyEntry)}
+}
+
+// firebaseItem is an hn.Item encoded like the Firebase API does, without
+// the fields the item doesn't have
+type firebaseItem struct {
+	By          string `json:"by,omitempty"`
+	Dead        bool   `json:"dead,omitempty"`
+	Deleted     bool   `json:"deleted,omitempty"`
+	Descendants *int   `json:"descendants,omitempty"`
+	ID          int    `json:"id"`
+	Kids        []int  `json:"kids,omitempty"`
+	Parent      int    `json:"parent,omitempty"`
+	Parts       []int  `json:"parts,omitempty"`
+	Poll        int    `json:"poll,omitempty"`
+	Score       *int   `json:"score,omitempty"`
+	Time        int    `json:"time,omitempty"`
+	Title       string `json:"title,omitempty"`
+	Type        string `json:"type,omitempty"`
+	Text        string `json:"text,omitempty"`
+	URL         string `json:"url,omitempty"`
+}
+
+func newFirebaseItem(itm hn.Item) firebaseItem {
+	fi := firebaseItem{
+		By: itm.By, Dead: itm.Dead, Deleted: itm.Deleted, ID: itm.ID, Kids: itm.Kids,
+		Parent: itm.Parent, Parts: itm.Parts, Poll: itm.Poll, Time: itm.Time, Title: itm.Title, Type: itm.Type,
+		Text: itm.Text, URL: itm.URL,
+	}
+	// stories and polls have a score and descendants even when they are 0
+	if !itm.Deleted && (itm.Type == "story" || itm.Type == "poll") {
+		fi.Descendants = &itm.Descendants
+	}
+	if !itm.Deleted && itm.Type != "comment" {
+		fi.Score = &itm.Score
+	}
+	return fi
+}
+
+// handler serves /v0/topstories.json, /v0/item/{id}.json and
+// /v0/user/{id}.json. Items and users that don't exist are null, like on
+// Firebase.
+func (p *firebaseProxy) handler() http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.URL.Path
+		if body, ok := p.get(key); ok {
+			writeProxied(w, body)
+			return
+		}
+
+		var v interface{}
+		var err error
+		switch {
+		case key == "/v0/topstories.json":
+			v, err = p.client.TopItems()
+		case router.Param(r, "item") != "":
+			id, convErr := strconv.Atoi(strings.TrimSuffix(router.Param(r, "item"), ".json"))
+			if convErr != nil || !strings.HasSuffix(key, ".json") {
+				writeAPIError(w, http.StatusNotFound, "not found")
+				return
+			}
+			var itm hn.Item
+			if itm, err = p.client.GetItem(id); err == nil && itm.ID != 0 {
+				v = newFirebaseItem(itm)
+			}
+		case router.Param(r, "user") != "":
+			name := strings.TrimSuffix(router.Param(r, "user"), ".json")
+			if name == "" || !strings.HasSuffix(key, ".json") {
+				writeAPIError(w, http.StatusNotFound, "not found")
+				return
+			}
+			var user hn.User
+			if user, err = p.client.GetUser(name); err == nil && user.ID != "" {
+				v = user
+			}
+		default:
+			writeAPIError(w, http.StatusNotFound, "not found")
+			return
+		}
+		if err != nil {
+			writeAPIError(w, http.StatusBadGateway, "failed to load from HN")
+			return
+		}
+		body, err := json.Marshal(v)
+		if err != nil {
+			writeAPIError(w, http.StatusInternalServerError, "failed to encode the response")
+			return
+		}
+		p.set(key, body)
+		writeProxied(w, body)
+	})
+}
+
+func writeProxied(w http.ResponseWriter, body []byte) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.Write(body)
+}
+
+func (p *firebaseProxy) get(key string) ([]byte, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	e, ok := p.entries[key]
+	if !ok || time.Now().After(e.expiration) {
+		return nil, false
+	}
+	return e.body, true
+}
+
+func (p *firebaseProxy) set(key string, body []byte) {
+	now := time.Now()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.maxEntries > 0 && len(p.entries) >= p.maxEntries {
+		for k, e := range p.entries {
+			if now.After(e.expiration) {
+				delete(p.entries, k)
+			}
+		}
+		if len(p.entries) >= p.maxEntries {
+			// full of fresh responses, which will expire soon enough
+			return
+		}
+	}
+	p.entries[key] = proxyEntry{body: body, expiration: now.Add(p.ttl)}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mmxmb/quiet_hn/hn"
+	"github.com/mmxmb/quiet_hn/router"
+)
+
+// countingProvider counts the GetItem calls reaching it
+type countingProvider struct {
+	*fakeProvider
+	calls int
+}
+
+func (p *countingProvider) GetItem(id int) (hn.Item, error) {
+	p.calls++
+	return p.fakeProvider.GetItem(id)
+}
+
+func TestFirebaseProxy(t *testing.T) {
+	p := &countingProvider{fakeProvider: newFakeProvider(2)}
+	p.users["alice"] = hn.User{ID: "alice", Karma: 42}
+	// HN responds with null for ids that don't exist
+	p.items[99] = hn.Item{}
+	proxy := newFirebaseProxy(p, time.Minute, 100).handler()
+	rt := router.New()
+	rt.Handle("/v0/topstories.json", proxy)
+	rt.Handle("/v0/item/{item}", proxy)
+	rt.Handle("/v0/user/{user}", proxy)
+	srv := httptest.NewServer(rt)
+	defer srv.Close()
+
+	// the proxy must work with an HN client, like the tools sharing it
+	c := hn.NewClient(hn.WithBaseURL(srv.URL + "/v0"))
+	ids, err := c.TopItems()
+	if err != nil || len(ids) != 2 {
+		t.Fatalf("c.TopItems(): want 2 ids, got %v and error %v", ids, err)
+	}
+	for i := 0; i < 2; i++ {
+		itm, err := c.GetItem(1)
+		if err != nil || itm.Title != "Story 1" {
+			t.Fatalf("c.GetItem(1): want Story 1, got %+v and error %v", itm, err)
+		}
+	}
+	if p.calls != 1 {
+		t.Errorf("GetItem calls: want 1 as the second one is cached, got %d", p.calls)
+	}
+	user, err := c.GetUser("alice")
+	if err != nil || user.Karma != 42 {
+		t.Errorf("c.GetUser(alice): want karma 42, got %+v and error %v", user, err)
+	}
+
+	for path, want := range map[string]string{
+		"/v0/item/99.json":    "null",
+		"/v0/user/bob.json":   "null",
+		"/v0/item/1.json":     `{"descendants":0,"id":1,"score":0,"title":"Story 1","type":"story","url":"https://example.com/1"}`,
+		"/v0/item/abc.json":   `{"error":"not found"}`,
+		"/v0/item/1":          `{"error":"not found"}`,
+		"/v0/newstories.json": "404 page not found",
+	} {
+		resp, err := http.Get(srv.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if got := strings.TrimSpace(string(b)); got != want {
+			t.Errorf("%s: want %s, got %s", path, want, got)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// paramError describes an invalid query parameter
+type paramError struct {
+	Param   string `json:"param"`
+	Message string `json:"message"`
+}
+
+// queryError lists the invalid query parameters of a request
+type queryError []paramError
+
+func (e queryError) Error() string {
+	msgs := make([]string, len(e))
+	for i, p := range e {
+		msgs[i] = p.Param + " " + p.Message
+	}
+	return "invalid query parameters: " + strings.Join(msgs, "; ")
+}
+
+// params returns the names of the invalid parameters
+func (e queryError) params() []string {
+	names := make([]string, len(e))
+	for i, p := range e {
+		names[i] = p.Param
+	}
+	return names
+}
+
+// queryParams parses the query parameters of a request into typed values.
+// Parameters that aren't set get their default, but invalid ones are
+// recorded rather than ignored, so that the request can be rejected with all
+// of its errors at once.
+type queryParams struct {
+	values url.Values
+	errs   queryError
+}
+
+func newQueryParams(r *http.Request) *queryParams {
+	return &queryParams{values: r.URL.Query()}
+}
+
+// Has reports whether the parameter name is set and not empty
+func (q *queryParams) Has(name string) bool {
+	return q.values.Get(name) != ""
+}
+
+func (q *queryParams) fail(name, message string) {
+	q.errs = append(q.errs, paramError{Param: name, Message: message})
+}
+
+// Bool returns the boolean parameter name, or def if it isn't set
+func (q *queryParams) Bool(name string, def bool) bool {
+	if !q.Has(name) {
+		return def
+	}
+	b, err := strconv.ParseBool(q.values.Get(name))
+	if err != nil {
+		q.fail(name, "must be true or false")
+		return def
+	}
+	return b
+}
+
+// Int returns the integer parameter name, which must be between min and
+// max, or def if it isn't set
+func (q *queryParams) Int(name string, def, min, max int) int {
+	if !q.Has(name) {
+		return def
+	}
+	n, err := strconv.Atoi(q.values.Get(name))
+	if err != nil || n < min || n > max {
+		q.fail(name, fmt.Sprintf("must be an integer between %d and %d", min, max))
+		return def
+	}
+	return n
+}
+
+// Enum returns the parameter name, which must be one of allowed, or def if
+// it isn't set
+func (q *queryParams) Enum(name, def string, allowed ...string) string {
+	if !q.Has(name) {
+		return def
+	}
+	v := q.values.Get(name)
+	for _, a := range allowed {
+		if v == a {
+			return v
+		}
+	}
+	q.fail(name, "must be one of "+strings.Join(allowed, ", "))
+	return def
+}
+
+// Err returns the queryError listing the invalid parameters, or nil if
+// there are none
+func (q *queryParams) Err() error {
+	if len(q.errs) == 0 {
+		return nil
+	}
+	return q.errs
+}
+
+// writeQueryError responds with a 400 listing the invalid parameters of err,
+// eg {"error": "invalid query parameters", "params": [{"param": "n",
+// "message": "must be an integer between 1 and 30"}]}
+func writeQueryError(w http.ResponseWriter, err error) {
+	qerr, _ := err.(queryError)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(struct {
+		Error  string       `json:"error"`
+		Params []paramError `json:"params"`
+	}{"invalid query parameters", qerr})
+}
+
+// renderQueryError responds with the 400 error page naming the invalid
+// parameters of err
+func (ts *templateSet) renderQueryError(w http.ResponseWriter, r *http.Request, err error) {
+	qerr, _ := err.(queryError)
+	ts.renderError(w, r, http.StatusBadRequest, "error.invalid_query", strings.Join(qerr.params(), ", "))
+}
@@ -0,0 +1,236 @@
+// Package readability extracts the main text of an article from an HTML
+// page, dropping navigation, ads, scripts and the rest of the boilerplate.
+//
+// The extraction is a simplified version of the heuristics used by browser
+// "reader modes": paragraphs are grouped by the elements containing them and
+// the element with the most paragraph text is considered to be the article.
+package readability
+
+import (
+	"encoding/xml"
+	"errors"
+	"io"
+	"io/ioutil"
+	"regexp"
+	"strings"
+)
+
+// Block is a single block of text of an article, eg a paragraph or heading.
+type Block struct {
+	// Kind is one of "p", "h", "pre", "li" or "quote"
+	Kind string
+	Text string
+}
+
+// Article is the text extracted from a page.
+type Article struct {
+	Title  string
+	Blocks []Block
+}
+
+// ErrNoContent is returned by Extract when the page doesn't appear to contain
+// any article text.
+var ErrNoContent = errors.New("readability: no content found")
+
+var (
+	// elements whose contents are never part of the article
+	skipped = map[string]bool{
+		"aside": true, "button": true, "footer": true, "form": true,
+		"header": true, "iframe": true, "nav": true, "noscript": true,
+		"script": true, "select": true, "style": true, "svg": true,
+		"template": true,
+	}
+	// elements that can contain the article
+	containers = map[string]bool{
+		"article": true, "body": true, "div": true, "main": true,
+		"section": true, "td": true,
+	}
+	// elements holding the text blocks of the article
+	blocks = map[string]string{
+		"p": "p", "pre": "pre", "li": "li", "blockquote": "quote",
+		"h1": "h", "h2": "h", "h3": "h", "h4": "h", "h5": "h", "h6": "h",
+	}
+
+	// contents of these are stripped before parsing, since they routinely
+	// contain characters that confuse the decoder
+	rawText = regexp.MustCompile(`(?is)<script\b.*?</script\s*>|<style\b.*?</style\s*>|<!--.*?-->`)
+	spaces  = regexp.MustCompile(`\s+`)
+)
+
+type block struct {
+	Block
+	// ids of the containers enclosing the block, outermost first
+	ancestors []int
+}
+
+type container struct {
+	name  string
+	score float64
+}
+
+// Extract reads the HTML page from r and returns its article text. Pages that
+// are malformed past a certain point are extracted up to that point.
+func Extract(r io.Reader) (Article, error) {
+	var art Article
+	page, err := ioutil.ReadAll(r)
+	if err != nil {
+		return art, err
+	}
+	page = rawText.ReplaceAll(page, nil)
+
+	dec := xml.NewDecoder(strings.NewReader(string(page)))
+	dec.Strict = false
+	dec.AutoClose = xml.HTMLAutoClose
+	dec.Entity = xml.HTMLEntity
+
+	var (
+		stack     []string // names of open elements
+		ancestors []int    // ids of open containers
+		conts     []container
+		blks      []block
+		cur       *block // block being read, if any
+		curDepth  int    // depth of the element starting cur
+		skipDepth int    // depth of the skipped element we are in, if any
+		title     strings.Builder
+		inTitle   bool
+	)
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			// io.EOF or a syntax error we can't recover from; keep what we have
+			break
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			name := strings.ToLower(t.Name.Local)
+			stack = append(stack, name)
+			if skipDepth > 0 {
+				continue
+			}
+			switch {
+			case skipped[name] || hiddenByAttrs(t.Attr):
+				skipDepth = len(stack)
+			case name == "title":
+				inTitle = true
+			case containers[name]:
+				conts = append(conts, container{name: name})
+				ancestors = append(ancestors, len(conts)-1)
+			case blocks[name] != "" && cur == nil:
+				cur = &block{Block: Block{Kind: blocks[name]}, ancestors: append([]int(nil), ancestors...)}
+				curDepth = len(stack)
+			case name == "br" && cur != nil:
+				cur.Text += " "
+			}
+		case xml.EndElement:
+			if len(stack) == 0 {
+				continue
+			}
+			name := stack[len(stack)-1]
+			depth := len(stack)
+			stack = stack[:len(stack)-1]
+			switch {
+			case skipDepth > 0:
+				if depth == skipDepth {
+					skipDepth = 0
+				}
+			case name == "title":
+				inTitle = false
+			case cur != nil && depth == curDepth:
+				if cur.Kind != "pre" {
+					cur.Text = spaces.ReplaceAllString(cur.Text, " ")
+				}
+				cur.Text = strings.TrimSpace(cur.Text)
+				if cur.Text != "" {
+					blks = append(blks, *cur)
+				}
+				cur = nil
+			case containers[name] && len(ancestors) > 0:
+				ancestors = ancestors[:len(ancestors)-1]
+			}
+		case xml.CharData:
+			switch {
+			case skipDepth > 0:
+			case inTitle:
+				title.Write(t)
+			case cur != nil:
+				cur.Text += string(t)
+			}
+		}
+	}
+
+	art.Title = strings.TrimSpace(spaces.ReplaceAllString(title.String(), " "))
+	best := bestContainer(conts, blks)
+	for _, b := range blks {
+		if best < 0 || contains(b.ancestors, best) {
+			art.Blocks = append(art.Blocks, b.Block)
+		}
+	}
+	if len(art.Blocks) == 0 {
+		return art, ErrNoContent
+	}
+	if art.Title == "" && art.Blocks[0].Kind == "h" {
+		art.Title = art.Blocks[0].Text
+	}
+	return art, nil
+}
+
+// bestContainer scores the containers by the amount of paragraph text they
+// hold and returns the index of the best one, or -1 if there are none.
+// Paragraphs count fully towards their parent and half towards their
+// grandparent, so that articles split into several sections are still
+// found.
+func bestContainer(conts []container, blks []block) int {
+	for _, b := range blks {
+		if b.Kind != "p" && b.Kind != "pre" || len(b.ancestors) == 0 {
+			continue
+		}
+		score := float64(len(b.Text)) + 10*float64(strings.Count(b.Text, ","))
+		n := len(b.ancestors)
+		conts[b.ancestors[n-1]].score += score
+		if n > 1 {
+			conts[b.ancestors[n-2]].score += score / 2
+		}
+	}
+	best := -1
+	for i, c := range conts {
+		if c.name == "article" || c.name == "main" {
+			c.score *= 1.25
+		}
+		if c.score > 0 && (best < 0 || c.score > conts[best].score) {
+			best = i
+		}
+	}
+	return best
+}
+
+// hiddenByAttrs reports whether an element's attributes mark it as hidden or
+// as obvious boilerplate such as sharing widgets and comment sections.
+func hiddenByAttrs(attrs []xml.Attr) bool {
+	for _, attr := range attrs {
+		name, value := strings.ToLower(attr.Name.Local), strings.ToLower(attr.Value)
+		switch name {
+		case "hidden":
+			return true
+		case "aria-hidden":
+			if value == "true" {
+				return true
+			}
+		case "class", "id":
+			for _, word := range []string{"comment", "share", "social", "sidebar", "newsletter", "related", "promo", "advert"} {
+				if strings.Contains(value, word) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+func contains(ids []int, id int) bool {
+	for _, v := range ids {
+		if v == id {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,62 @@
+package readability
+
+import (
+	"strings"
+	"testing"
+)
+
+const page = `<!DOCTYPE html>
+<html>
+<head>
+  <meta charset="utf-8">
+  <title>An Article &amp; More</title>
+  <script>if (a < b && c) { document.write("<p>nope</p>") }</script>
+  <style>p > a { color: red }</style>
+</head>
+<body>
+  <header><nav><ul><li>Home</li><li>About</li></ul></nav></header>
+  <div class="sidebar"><p>Subscribe to our newsletter, now, please, today.</p></div>
+  <main>
+    <article>
+      <h1>The Article Title</h1>
+      <p>This is the first paragraph, which has <a href="/x">a link</a> and<br>a line break.</p>
+      <p>The second paragraph&nbsp;is here, with some more text, for scoring.</p>
+      <img src="/foo.png">
+      <pre>code  block</pre>
+    </article>
+  </main>
+  <div id="comments"><p>First!</p></div>
+  <footer><p>Copyright, all rights reserved, forever.</p></footer>
+</body>
+</html>`
+
+func TestExtract(t *testing.T) {
+	art, err := Extract(strings.NewReader(page))
+	if err != nil {
+		t.Fatalf("Extract() received an error: %s", err.Error())
+	}
+	if art.Title != "An Article & More" {
+		t.Errorf("art.Title: want %q, got %q", "An Article & More", art.Title)
+	}
+	want := []Block{
+		{Kind: "h", Text: "The Article Title"},
+		{Kind: "p", Text: "This is the first paragraph, which has a link and a line break."},
+		{Kind: "p", Text: "The second paragraph is here, with some more text, for scoring."},
+		{Kind: "pre", Text: "code  block"},
+	}
+	if len(art.Blocks) != len(want) {
+		t.Fatalf("len(art.Blocks): want %d, got %d: %v", len(want), len(art.Blocks), art.Blocks)
+	}
+	for i, b := range want {
+		if art.Blocks[i] != b {
+			t.Errorf("art.Blocks[%d]: want %v, got %v", i, b, art.Blocks[i])
+		}
+	}
+}
+
+func TestExtract_noContent(t *testing.T) {
+	_, err := Extract(strings.NewReader("<html><body><nav>Menu</nav></body></html>"))
+	if err != ErrNoContent {
+		t.Errorf("err: want %v, got %v", ErrNoContent, err)
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/mmxmb/quiet_hn/readability"
+	"github.com/mmxmb/quiet_hn/router"
+	"github.com/mmxmb/quiet_hn/search"
+)
+
+// readerConfig configures the reader mode served at /read/{id}
+type readerConfig struct {
+	Enabled bool
+	// Timeout bounds the time spent fetching a single article
+	Timeout time.Duration
+	// MaxBytes is the maximum size of the pages that are extracted
+	MaxBytes int64
+	// CacheDuration is how long extracted articles are kept around
+	CacheDuration time.Duration
+}
+
+var errNotHTML = errors.New("not an HTML page")
+
+// articleFetcher fetches and extracts story articles, caching the results
+type articleFetcher struct {
+	cfg    readerConfig
+	client *http.Client
+
+	mu       sync.Mutex
+	articles map[string]cachedArticle
+}
+
+type cachedArticle struct {
+	article    readability.Article
+	expiration time.Time
+}
+
+func newArticleFetcher(cfg readerConfig, userAgent string) *articleFetcher {
+	return &articleFetcher{
+		cfg:      cfg,
+		client:   newPageClient(cfg.Timeout, userAgent),
+		articles: make(map[string]cachedArticle),
+	}
+}
+
+// Get returns the article at pageURL
+func (f *articleFetcher) Get(ctx context.Context, pageURL string) (readability.Article, error) {
+	f.mu.Lock()
+	cached, ok := f.articles[pageURL]
+	f.mu.Unlock()
+	if ok && time.Now().Before(cached.expiration) {
+		return cached.article, nil
+	}
+
+	art, err := f.fetch(ctx, pageURL)
+	if err != nil {
+		return art, err
+	}
+
+	f.mu.Lock()
+	now := time.Now()
+	// drop expired articles so the cache doesn't grow forever
+	for u, cached := range f.articles {
+		if now.After(cached.expiration) {
+			delete(f.articles, u)
+		}
+	}
+	f.articles[pageURL] = cachedArticle{article: art, expiration: now.Add(f.cfg.CacheDuration)}
+	f.mu.Unlock()
+	return art, nil
+}
+
+func (f *articleFetcher) fetch(ctx context.Context, pageURL string) (readability.Article, error) {
+	var art readability.Article
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, pageURL, nil)
+	if err != nil {
+		return art, err
+	}
+	if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+		return art, fmt.Errorf("unsupported URL scheme %q", req.URL.Scheme)
+	}
+	req.Header.Set("Accept", "text/html")
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return art, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return art, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil || (mediaType != "text/html" && mediaType != "application/xhtml+xml") {
+		return art, errNotHTML
+	}
+	return readability.Extract(io.LimitReader(resp.Body, f.cfg.MaxBytes))
+}
+
+// readHandler serves /read/{id}, the article of a story in reader mode. The
+// articles read are added to idx, if not nil.
+func readHandler(client StoryProvider, cfg config, fetcher *articleFetcher, idx *search.Index, tpls *templateSet) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		id, err := strconv.Atoi(router.Param(r, "id"))
+		if err != nil || id <= 0 {
+			tpls.renderError(w, r, http.StatusBadRequest, "error.invalid_item_id")
+			return
+		}
+		hnItem, err := client.GetItem(id)
+		if err != nil {
+			tpls.renderError(w, r, http.StatusInternalServerError, "error.load_item")
+			return
+		}
+		itm := parseHNItem(hnItem)
+		if !isStoryLink(itm) || itm.HNItemID != 0 {
+			tpls.renderError(w, r, http.StatusNotFound, "error.not_found")
+			return
+		}
+
+		art, err := fetcher.Get(r.Context(), itm.URL)
+		if err != nil {
+			// the original page is still one click away
+			http.Redirect(w, r, itm.URL, http.StatusFound)
+			return
+		}
+		if idx != nil {
+			indexArticle(idx, id, art)
+		}
+
+		data := readTemplateData{
+			Item:    itm,
+			Article: art,
+			Lang:    languagePref(w, r, cfg.Messages),
+			Time:    time.Now().Sub(start),
+		}
+		err = tpls.render(w, "read", data)
+		if err != nil {
+			http.Error(w, "Failed to process the template", http.StatusInternalServerError)
+			return
+		}
+	})
+}
+
+type readTemplateData struct {
+	Item    item
+	Article readability.Article
+	Lang    string
+	Time    time.Duration
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// readiness is whether the server is ready to take traffic, as reported by
+// /readyz. The server listens before it is ready so that load balancers and
+// orchestrators can tell a starting instance from a dead one.
+type readiness struct {
+	ready int32
+}
+
+func (r *readiness) setReady() {
+	atomic.StoreInt32(&r.ready, 1)
+}
+
+func (r *readiness) isReady() bool {
+	return atomic.LoadInt32(&r.ready) == 1
+}
+
+// warmCache fetches the front page as seen with the default preferences into
+// cache, so that the first visitors don't pay for fetching every story, and
+// then marks ready. If it fails or takes longer than timeout, the server is
+// marked ready anyway: it can still serve, only slower.
+func warmCache(client StoryProvider, cache *Cache, cfg config, timeout time.Duration, ready *readiness) {
+	defer ready.setReady()
+	start := time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if _, err := cachedTopStories(ctx, client, cache, cfg, newFilter(cfg, cfg.Defaults)); err != nil {
+		log.Printf("warming the cache: %s", err)
+		return
+	}
+	log.Printf("warmed the cache in %s", time.Since(start).Round(time.Millisecond))
+}
+
+// readyzHandler responds with 200 once ready, and 503 until then
+func readyzHandler(ready *readiness) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "no-store")
+		if !ready.isReady() {
+			http.Error(w, "warming up", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("ok\n"))
+	}
+}
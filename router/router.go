@@ -0,0 +1,211 @@
+// Package router routes HTTP requests by path. Patterns are made of
+// segments, each either literal or a parameter in braces matching any
+// single segment:
+//
+//	/item/{id}
+//	/api/item/{id}
+//
+// A parameter may be followed by a literal suffix, in which case it matches
+// the segments ending with the suffix, without it: /item/{id}.txt matches
+// /item/42.txt with the id 42.
+//
+// Literal segments take precedence over parameters, and parameters with a
+// suffix over the ones without, so /best/week is routed to a /best/week
+// route rather than /best/{period} if both exist, and /item/42.txt to
+// /item/{id}.txt rather than /item/{id}. Trailing
+// slashes are ignored, and requests matching no route are handled by
+// NotFound.
+//
+// Middleware applies either to every request, with Router.Use, or to the
+// routes of a Group:
+//
+//	api := rt.Group(withCORS, withAuth)
+//	api.Handle("/api/item/{id}", itemHandler)
+package router
+
+import (
+	"context"
+	"net/http"
+	"strings"
+)
+
+// Middleware wraps a handler, eg to log or authenticate its requests
+type Middleware func(http.Handler) http.Handler
+
+// Chain returns h wrapped in mw, the first of mw being the outermost
+func Chain(h http.Handler, mw ...Middleware) http.Handler {
+	for i := len(mw) - 1; i >= 0; i-- {
+		h = mw[i](h)
+	}
+	return h
+}
+
+// Router is an http.Handler dispatching requests to the handler of the
+// route matching their path. The routes must all be added before serving.
+type Router struct {
+	// NotFound handles the requests matching no route. It defaults to
+	// http.NotFound.
+	NotFound http.Handler
+
+	routes     []route
+	middleware []Middleware
+	handler    http.Handler
+}
+
+type route struct {
+	segments []string
+	handler  http.Handler
+	// literals is the number of literal segments, and suffixes of the
+	// parameters with a suffix, for precedence
+	literals int
+	suffixes int
+}
+
+// New returns a Router without routes
+func New() *Router {
+	rt := &Router{}
+	rt.handler = http.HandlerFunc(rt.dispatch)
+	return rt
+}
+
+// Use wraps the handlers of all the routes, NotFound included, in mw
+func (rt *Router) Use(mw ...Middleware) {
+	rt.middleware = append(rt.middleware, mw...)
+	rt.handler = Chain(http.HandlerFunc(rt.dispatch), rt.middleware...)
+}
+
+// Handle routes the requests matching pattern to h
+func (rt *Router) Handle(pattern string, h http.Handler) {
+	r := route{segments: split(pattern), handler: h}
+	for _, s := range r.segments {
+		if _, suffix, ok := param(s); !ok {
+			r.literals++
+		} else if suffix != "" {
+			r.suffixes++
+		}
+	}
+	rt.routes = append(rt.routes, r)
+}
+
+// HandleFunc routes the requests matching pattern to h
+func (rt *Router) HandleFunc(pattern string, h http.HandlerFunc) {
+	rt.Handle(pattern, h)
+}
+
+// Group returns a group of routes whose handlers are wrapped in mw, within
+// the middleware of the Router
+func (rt *Router) Group(mw ...Middleware) *Group {
+	return &Group{rt: rt, middleware: mw}
+}
+
+// Group is a set of routes of a Router sharing middleware, eg the pages or
+// the JSON API
+type Group struct {
+	rt         *Router
+	middleware []Middleware
+}
+
+// Group returns a group of routes wrapped in the middleware of g, then in mw
+func (g *Group) Group(mw ...Middleware) *Group {
+	all := make([]Middleware, 0, len(g.middleware)+len(mw))
+	all = append(append(all, g.middleware...), mw...)
+	return &Group{rt: g.rt, middleware: all}
+}
+
+// Handle routes the requests matching pattern to h, wrapped in the
+// middleware of g
+func (g *Group) Handle(pattern string, h http.Handler) {
+	g.rt.Handle(pattern, Chain(h, g.middleware...))
+}
+
+// HandleFunc routes the requests matching pattern to h, wrapped in the
+// middleware of g
+func (g *Group) HandleFunc(pattern string, h http.HandlerFunc) {
+	g.Handle(pattern, h)
+}
+
+func (rt *Router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rt.handler.ServeHTTP(w, r)
+}
+
+func (rt *Router) dispatch(w http.ResponseWriter, r *http.Request) {
+	segments := split(r.URL.Path)
+	var best *route
+	for i := range rt.routes {
+		route := &rt.routes[i]
+		if route.match(segments) && (best == nil || route.literals > best.literals || route.literals == best.literals && route.suffixes > best.suffixes) {
+			best = route
+		}
+	}
+	if best == nil {
+		notFound := rt.NotFound
+		if notFound == nil {
+			notFound = http.HandlerFunc(http.NotFound)
+		}
+		notFound.ServeHTTP(w, r)
+		return
+	}
+
+	var params map[string]string
+	for i, s := range best.segments {
+		if name, suffix, ok := param(s); ok {
+			if params == nil {
+				params = make(map[string]string)
+			}
+			params[name] = strings.TrimSuffix(segments[i], suffix)
+		}
+	}
+	if params != nil {
+		r = WithParams(r, params)
+	}
+	best.handler.ServeHTTP(w, r)
+}
+
+func (r route) match(segments []string) bool {
+	if len(segments) != len(r.segments) {
+		return false
+	}
+	for i, s := range r.segments {
+		_, suffix, ok := param(s)
+		switch {
+		case !ok && s != segments[i]:
+			return false
+		case ok && (len(segments[i]) <= len(suffix) || !strings.HasSuffix(segments[i], suffix)):
+			return false
+		}
+	}
+	return true
+}
+
+// split returns the segments of path, ignoring the leading and trailing
+// slashes. The root path has no segments.
+func split(path string) []string {
+	path = strings.Trim(path, "/")
+	if path == "" {
+		return nil
+	}
+	return strings.Split(path, "/")
+}
+
+// param returns the name and suffix of the parameter segment, if it is one
+func param(segment string) (name, suffix string, ok bool) {
+	end := strings.IndexByte(segment, '}')
+	if len(segment) < 3 || segment[0] != '{' || end < 2 {
+		return "", "", false
+	}
+	return segment[1:end], segment[end+1:], true
+}
+
+type paramsKey struct{}
+
+// WithParams returns a shallow copy of r with the path parameters params,
+// as set by the Router. It is mostly useful to test handlers without one.
+func WithParams(r *http.Request, params map[string]string) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), paramsKey{}, params))
+}
+
+// Param returns the path parameter name of r, or "" if it has none
+func Param(r *http.Request, name string) string {
+	params, _ := r.Context().Value(paramsKey{}).(map[string]string)
+	return params[name]
+}
@@ -0,0 +1,108 @@
+package router
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRouter(t *testing.T) {
+	rt := New()
+	handler := func(name string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(name + ":" + Param(r, "id") + Param(r, "period")))
+		}
+	}
+	rt.Handle("/", handler("index"))
+	rt.Handle("/item/{id}", handler("item"))
+	rt.Handle("/item/{id}.txt", handler("text"))
+	rt.Handle("/best/{period}", handler("best"))
+	rt.Handle("/best/week", handler("week"))
+	rt.NotFound = handler("not found")
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/", "index:"},
+		{"/item/42", "item:42"},
+		{"/item/42/", "item:42"},
+		{"/item/42.txt", "text:42"},
+		{"/item/.txt", "item:.txt"},
+		{"/best/day", "best:day"},
+		{"/best/week", "week:"},
+		{"/item", "not found:"},
+		{"/item/42/comments", "not found:"},
+		{"/nothing", "not found:"},
+	}
+	for _, tc := range tests {
+		rec := httptest.NewRecorder()
+		rt.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tc.path, nil))
+		if got := rec.Body.String(); got != tc.want {
+			t.Errorf("%s: want %q, got %q", tc.path, tc.want, got)
+		}
+	}
+}
+
+func TestRouter_Use(t *testing.T) {
+	var order []string
+	mw := func(name string) Middleware {
+		return func(h http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				order = append(order, name)
+				h.ServeHTTP(w, r)
+			})
+		}
+	}
+	rt := New()
+	rt.Handle("/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		order = append(order, "handler")
+	}))
+	rt.Use(mw("a"), mw("b"))
+
+	rt.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	if len(order) != 3 || order[0] != "a" || order[1] != "b" || order[2] != "handler" {
+		t.Errorf("want a, b then the handler, got %v", order)
+	}
+
+	order = nil
+	rec := httptest.NewRecorder()
+	rt.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/missing", nil))
+	if rec.Code != http.StatusNotFound || len(order) != 2 {
+		t.Errorf("unknown path: want a 404 through the middleware, got %d after %v", rec.Code, order)
+	}
+}
+
+func TestGroup(t *testing.T) {
+	header := func(name string) Middleware {
+		return func(h http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Add("X-Middleware", name)
+				h.ServeHTTP(w, r)
+			})
+		}
+	}
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	rt := New()
+	api := rt.Group(header("api"))
+	api.Handle("/api", ok)
+	api.Group(header("admin")).Handle("/api/admin", ok)
+	rt.Handle("/", ok)
+
+	tests := []struct {
+		path string
+		want []string
+	}{
+		{"/", nil},
+		{"/api", []string{"api"}},
+		{"/api/admin", []string{"api", "admin"}},
+	}
+	for _, tc := range tests {
+		rec := httptest.NewRecorder()
+		rt.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tc.path, nil))
+		if got := rec.Header()["X-Middleware"]; fmt.Sprint(got) != fmt.Sprint(tc.want) {
+			t.Errorf("%s: want the middleware %v, got %v", tc.path, tc.want, got)
+		}
+	}
+}
@@ -0,0 +1,493 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mmxmb/quiet_hn/leader"
+	"github.com/mmxmb/quiet_hn/notify"
+)
+
+// The actions of the alert rules
+const (
+	ruleNotify = "notify"
+	rulePin    = "pin"
+	ruleHide   = "hide"
+)
+
+var ruleActions = []string{ruleNotify, rulePin, ruleHide}
+
+// rule is an alert rule: the stories of the front page matching all of its
+// conditions get its action. Notified stories are sent to the sinks of the
+// cross-poster, pinned stories are moved to the top of the front page and
+// hidden ones dropped from it.
+type rule struct {
+	Name        string `json:"name"`
+	MinScore    int    `json:"min_score,omitempty"`
+	MinComments int    `json:"min_comments,omitempty"`
+	// MinVelocity is the minimum score per hour since the story was
+	// submitted, stories younger than an hour counting as an hour old
+	MinVelocity float64 `json:"min_velocity,omitempty"`
+	// Keywords match the stories with any of them in their title, whole
+	// words ignoring the case, or anywhere for keywords of several words
+	Keywords []string `json:"keywords,omitempty"`
+	// Domains match the stories on any of them or their subdomains
+	Domains []string `json:"domains,omitempty"`
+	Action  string   `json:"action"`
+	// Sinks are the names of the sinks notified, eg mastodon, all of them
+	// if empty
+	Sinks []string `json:"sinks,omitempty"`
+}
+
+// loadRules reads path, a JSON list of rule, if it exists
+func loadRules(path string) ([]rule, error) {
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var rules []rule
+	if err := json.Unmarshal(b, &rules); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	return rules, nil
+}
+
+// velocity returns the score per hour of itm since it was submitted
+func velocity(itm item, now time.Time) float64 {
+	hours := now.Sub(time.Unix(int64(itm.Time), 0)).Hours()
+	return float64(itm.Score) / math.Max(hours, 1)
+}
+
+// matches reports whether itm meets the conditions of r at now
+func (r rule) matches(itm item, now time.Time) bool {
+	if itm.Score < r.MinScore || itm.Descendants < r.MinComments {
+		return false
+	}
+	if r.MinVelocity > 0 && velocity(itm, now) < r.MinVelocity {
+		return false
+	}
+	if len(r.Domains) > 0 && !matchesAnyDomain(itm.Host, r.Domains) {
+		return false
+	}
+	if len(r.Keywords) == 0 {
+		return true
+	}
+	title := strings.ToLower(itm.Title)
+	words := make(map[string]bool)
+	for _, w := range titleWords(itm.Title) {
+		words[w] = true
+	}
+	for _, kw := range r.Keywords {
+		kw = strings.ToLower(kw)
+		if words[kw] || strings.ContainsRune(kw, ' ') && strings.Contains(title, kw) {
+			return true
+		}
+	}
+	return false
+}
+
+// ruleBackend is where the rules are kept
+type ruleBackend interface {
+	Load() ([]rule, error)
+	Save(rules []rule) error
+}
+
+// fileRules keeps the rules in a JSON file
+type fileRules struct {
+	path string
+}
+
+func (f fileRules) Load() ([]rule, error) {
+	return loadRules(f.path)
+}
+
+// Save writes rules to the file, replacing it atomically
+func (f fileRules) Save(rules []rule) error {
+	b, err := json.MarshalIndent(rules, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := f.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, f.path)
+}
+
+// ruleStatus is a rule as shown on the admin dashboard, with the stories it
+// matched when the front page was last evaluated
+type ruleStatus struct {
+	rule
+	Matches []int
+}
+
+// ruleEngine applies the rules to the front page. The rules are edited on
+// the admin dashboard and saved to the backend, if any.
+type ruleEngine struct {
+	backend ruleBackend
+	// sinks are the sinks of the cross-poster, by name
+	sinks map[string]*postSink
+
+	mu      sync.RWMutex
+	rules   []rule
+	matches map[string][]int
+	// notified is when each story was notified, by rule name and story ID.
+	// It isn't saved, so a restart notifies the stories still matching
+	// again.
+	notified map[string]map[int]time.Time
+}
+
+// newRuleEngine returns the engine of the rules of backend, which may be nil
+// to keep them in memory, notifying sinks
+func newRuleEngine(backend ruleBackend, sinks []*postSink) (*ruleEngine, error) {
+	e := &ruleEngine{
+		backend:  backend,
+		sinks:    make(map[string]*postSink),
+		matches:  make(map[string][]int),
+		notified: make(map[string]map[int]time.Time),
+	}
+	for _, sink := range sinks {
+		e.sinks[sink.Name] = sink
+	}
+	if err := e.reload(); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// reload reads the rules of the backend again, eg after another replica
+// changed them
+func (e *ruleEngine) reload() error {
+	if e.backend == nil {
+		return nil
+	}
+	rules, err := e.backend.Load()
+	if err != nil {
+		return fmt.Errorf("rules: %w", err)
+	}
+	for _, r := range rules {
+		if err := e.validate(r); err != nil {
+			return err
+		}
+	}
+	e.mu.Lock()
+	e.rules = rules
+	e.mu.Unlock()
+	return nil
+}
+
+func (e *ruleEngine) validate(r rule) error {
+	if r.Name == "" {
+		return errors.New("rules: a rule has no name")
+	}
+	switch r.Action {
+	case rulePin, ruleHide:
+	case ruleNotify:
+		if len(e.sinks) == 0 {
+			return fmt.Errorf("rules: %s: no sinks to notify are configured", r.Name)
+		}
+		for _, name := range r.Sinks {
+			if e.sinks[name] == nil {
+				return fmt.Errorf("rules: %s: unknown sink %q", r.Name, name)
+			}
+		}
+	default:
+		return fmt.Errorf("rules: %s: unknown action %q, want one of %s", r.Name, r.Action, strings.Join(ruleActions, ", "))
+	}
+	if r.MinScore <= 0 && r.MinComments <= 0 && r.MinVelocity <= 0 && len(r.Keywords) == 0 && len(r.Domains) == 0 {
+		return fmt.Errorf("rules: %s: a rule needs at least one condition", r.Name)
+	}
+	return nil
+}
+
+// Rules returns the rules with their last matches
+func (e *ruleEngine) Rules() []ruleStatus {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	ret := make([]ruleStatus, len(e.rules))
+	for i, r := range e.rules {
+		ret[i] = ruleStatus{rule: r, Matches: e.matches[r.Name]}
+	}
+	return ret
+}
+
+// Put adds r, or replaces the rule with the same name, and saves the rules
+func (e *ruleEngine) Put(r rule) error {
+	if err := e.validate(r); err != nil {
+		return err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	rules := append([]rule(nil), e.rules...)
+	replaced := false
+	for i := range rules {
+		if rules[i].Name == r.Name {
+			rules[i], replaced = r, true
+		}
+	}
+	if !replaced {
+		rules = append(rules, r)
+	}
+	return e.save(rules)
+}
+
+// Delete removes the rule named name, if any, and saves the rules
+func (e *ruleEngine) Delete(name string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	var rules []rule
+	for _, r := range e.rules {
+		if r.Name != name {
+			rules = append(rules, r)
+		}
+	}
+	delete(e.matches, name)
+	delete(e.notified, name)
+	return e.save(rules)
+}
+
+// save saves rules to the backend and makes them the rules of e. e.mu must
+// be held.
+func (e *ruleEngine) save(rules []rule) error {
+	if e.backend != nil {
+		if err := e.backend.Save(rules); err != nil {
+			return fmt.Errorf("rules: %w", err)
+		}
+	}
+	e.rules = rules
+	return nil
+}
+
+// apply drops the stories matching a hide rule and moves the ones matching
+// a pin rule to the top, keeping their order and their rank, and returns
+// the stories left
+func (e *ruleEngine) apply(stories []item, now time.Time) []item {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	if len(e.rules) == 0 {
+		return stories
+	}
+	var pinned, rest []item
+	for _, itm := range stories {
+		hide, pin := false, false
+		for _, r := range e.rules {
+			if (r.Action == ruleHide || r.Action == rulePin) && r.matches(itm, now) {
+				hide, pin = hide || r.Action == ruleHide, pin || r.Action == rulePin
+			}
+		}
+		switch {
+		case hide:
+		case pin:
+			itm.Pinned = true
+			pinned = append(pinned, itm)
+		default:
+			rest = append(rest, itm)
+		}
+	}
+	n := copy(stories, pinned)
+	n += copy(stories[n:], rest)
+	// the strings of the hidden stories shouldn't outlive them
+	for i := n; i < len(stories); i++ {
+		stories[i] = item{}
+	}
+	return stories[:n]
+}
+
+// evaluate records the stories matching each rule and, if send, sends the
+// stories matching the notify rules which weren't notified yet to their
+// sinks. It returns how many notifications were sent.
+func (e *ruleEngine) evaluate(ctx context.Context, stories []item, now time.Time, send bool) int {
+	e.mu.RLock()
+	rules := e.rules
+	e.mu.RUnlock()
+	matches := make(map[string][]int)
+	n := 0
+	for _, r := range rules {
+		for _, itm := range stories {
+			if !r.matches(itm, now) {
+				continue
+			}
+			matches[r.Name] = append(matches[r.Name], itm.ID)
+			if send && r.Action == ruleNotify && !e.wasNotified(r.Name, itm.ID) {
+				n += e.notify(ctx, r, itm, now)
+			}
+		}
+	}
+	e.mu.Lock()
+	e.matches = matches
+	for _, notified := range e.notified {
+		for id, at := range notified {
+			if now.Sub(at) > postedRetention {
+				delete(notified, id)
+			}
+		}
+	}
+	e.mu.Unlock()
+	return n
+}
+
+func (e *ruleEngine) wasNotified(name string, id int) bool {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	_, ok := e.notified[name][id]
+	return ok
+}
+
+// notify sends itm to the sinks of r and returns to how many. A story
+// which no sink got is retried in the next round.
+func (e *ruleEngine) notify(ctx context.Context, r rule, itm item, now time.Time) int {
+	names := r.Sinks
+	if len(names) == 0 {
+		for name := range e.sinks {
+			names = append(names, name)
+		}
+	}
+	story := notifyStory(itm)
+	n := 0
+	for _, name := range names {
+		sink := e.sinks[name]
+		var text strings.Builder
+		text.WriteString("[" + r.Name + "] ")
+		if err := sink.Template.Execute(&text, story); err != nil {
+			log.Printf("rendering the %s alert of story %d: %s", sink.Name, itm.ID, err)
+			continue
+		}
+		if err := sink.Notifier.Notify(ctx, notify.Message{Text: text.String(), Story: story}); err != nil {
+			log.Printf("notifying %s of story %d for rule %s: %s", sink.Name, itm.ID, r.Name, err)
+			continue
+		}
+		n++
+	}
+	if n > 0 {
+		e.mu.Lock()
+		if e.notified[r.Name] == nil {
+			e.notified[r.Name] = make(map[int]time.Time)
+		}
+		e.notified[r.Name][itm.ID] = now
+		e.mu.Unlock()
+	}
+	return n
+}
+
+// runRules evaluates the rules on the front page as seen with the default
+// preferences every interval until ctx is done. Every replica reloads the
+// rules, which another one may have changed, but only the leader notifies.
+func runRules(ctx context.Context, client StoryProvider, cache *Cache, cfg config, e *ruleEngine, el *leader.Elector, interval time.Duration) {
+	f := newFilter(cfg, cfg.Defaults)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := e.reload(); err != nil {
+			log.Printf("reloading the rules: %s", err)
+		}
+		stories, err := cachedTopStories(ctx, client, cache, cfg, f)
+		if err != nil && ctx.Err() == nil {
+			log.Printf("evaluating the rules: %s", err)
+		}
+		e.evaluate(ctx, stories, time.Now(), el == nil || el.Leading())
+		releaseItems(stories)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// rulesAdminHandler serves POST /admin/rules, which adds or replaces the
+// rule of the form of the admin dashboard, or deletes the rule named by
+// delete, and redirects back to the dashboard
+func rulesAdminHandler(e *ruleEngine) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !sameOrigin(r) {
+			http.Error(w, "cross-origin requests are forbidden", http.StatusForbidden)
+			return
+		}
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var err error
+		if name := r.PostForm.Get("delete"); name != "" {
+			err = e.Delete(name)
+		} else {
+			var ru rule
+			if ru, err = parseRuleForm(r.PostForm); err == nil {
+				err = e.Put(ru)
+			}
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		http.Redirect(w, r, "/admin", http.StatusSeeOther)
+	})
+}
+
+// parseRuleForm returns the rule of the form of the admin dashboard
+func parseRuleForm(form url.Values) (rule, error) {
+	r := rule{
+		Name:     strings.TrimSpace(form.Get("name")),
+		Action:   form.Get("action"),
+		Keywords: splitList(form.Get("keywords")),
+		Domains:  splitList(form.Get("domains")),
+		Sinks:    splitList(form.Get("sinks")),
+	}
+	for _, field := range []struct {
+		name string
+		dst  *int
+	}{{"min_score", &r.MinScore}, {"min_comments", &r.MinComments}} {
+		if v := form.Get(field.name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				return rule{}, fmt.Errorf("invalid %s %q", field.name, v)
+			}
+			*field.dst = n
+		}
+	}
+	if v := form.Get("min_velocity"); v != "" {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil || f < 0 || math.IsInf(f, 0) || math.IsNaN(f) {
+			return rule{}, fmt.Errorf("invalid min_velocity %q", v)
+		}
+		r.MinVelocity = f
+	}
+	return r, nil
+}
+
+// splitList splits a comma separated list, dropping the empty entries
+func splitList(s string) []string {
+	var l listFlag
+	l.Set(s)
+	return l
+}
+
+// sameOrigin reports whether r wasn't sent by a page of another origin, eg
+// a form of another site posting to the admin listener
+func sameOrigin(r *http.Request) bool {
+	if r.Header.Get("Sec-Fetch-Site") == "cross-site" {
+		return false
+	}
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && u.Host == r.Host
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mmxmb/quiet_hn/hn"
+	"github.com/mmxmb/quiet_hn/jobs"
+)
+
+func TestRule_matches(t *testing.T) {
+	now := time.Now()
+	itm := parseHNItem(hn.Item{ID: 1, Title: "Go 1.22 is released", URL: "https://blog.golang.org/go1.22", Score: 120, Descendants: 40, Time: int(now.Add(-2 * time.Hour).Unix())})
+	for _, tt := range []struct {
+		r    rule
+		want bool
+	}{
+		{rule{MinScore: 100}, true},
+		{rule{MinScore: 200}, false},
+		{rule{MinComments: 50}, false},
+		// 60 points per hour
+		{rule{MinVelocity: 50}, true},
+		{rule{MinVelocity: 80}, false},
+		{rule{Keywords: []string{"rust", "GO"}}, true},
+		{rule{Keywords: []string{"release"}}, false},
+		{rule{Keywords: []string{"is released"}}, true},
+		{rule{Domains: []string{"golang.org"}}, true},
+		{rule{Domains: []string{"go.dev"}}, false},
+		{rule{MinScore: 100, Domains: []string{"go.dev"}}, false},
+	} {
+		if got := tt.r.matches(itm, now); got != tt.want {
+			t.Errorf("%+v.matches(): want %v, got %v", tt.r, tt.want, got)
+		}
+	}
+}
+
+func TestRuleEngine(t *testing.T) {
+	tpl, err := parsePostTemplate("test", "{{.Title}}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := &fakeNotifier{}
+	path := filepath.Join(t.TempDir(), "rules.json")
+	e, err := newRuleEngine(fileRules{path: path}, []*postSink{{Name: "test", Notifier: n, Template: tpl}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range []rule{
+		{Name: "hot", MinScore: 100, Action: ruleNotify},
+		{Name: "mine", Domains: []string{"example.org"}, Action: rulePin},
+		{Name: "crypto", Keywords: []string{"bitcoin"}, Action: ruleHide},
+	} {
+		if err := e.Put(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, r := range []rule{
+		{Name: "everything", Action: rulePin},
+		{Name: "typo", MinScore: 10, Action: "pni"},
+		{Name: "elsewhere", MinScore: 10, Action: ruleNotify, Sinks: []string{"mastodon"}},
+	} {
+		if err := e.Put(r); err == nil {
+			t.Errorf("Put(%+v): want an error", r)
+		}
+	}
+
+	stories := []item{
+		parseHNItem(hn.Item{ID: 1, Title: "Story 1", URL: "https://example.com/1", Score: 150}),
+		parseHNItem(hn.Item{ID: 2, Title: "Bitcoin hits a record", URL: "https://example.com/2", Score: 300}),
+		parseHNItem(hn.Item{ID: 3, Title: "Story 3", URL: "https://example.org/3"}),
+	}
+	now := time.Now()
+	if sent := e.evaluate(context.Background(), stories, now, true); sent != 2 {
+		t.Errorf("evaluate(): want 2 notifications, got %d", sent)
+	}
+	if len(n.msgs) != 2 || n.msgs[0].Text != "[hot] Story 1" {
+		t.Errorf("messages: want stories 1 and 2, got %+v", n.msgs)
+	}
+	if sent := e.evaluate(context.Background(), stories, now.Add(time.Minute), true); sent != 0 {
+		t.Errorf("evaluate() again: want no notifications, got %d", sent)
+	}
+	if rules := e.Rules(); len(rules) != 3 || len(rules[1].Matches) != 1 || rules[1].Matches[0] != 3 {
+		t.Errorf("Rules(): want story 3 matching mine, got %+v", rules)
+	}
+
+	applied := e.apply(stories, now)
+	if len(applied) != 2 || applied[0].ID != 3 || !applied[0].Pinned || applied[1].ID != 1 {
+		t.Errorf("apply(): want story 3 pinned above story 1, got %+v", applied)
+	}
+
+	// the rules survive restarts
+	reloaded, err := newRuleEngine(fileRules{path: path}, []*postSink{{Name: "test", Notifier: n, Template: tpl}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rules := reloaded.Rules(); len(rules) != 3 || rules[2].Name != "crypto" {
+		t.Errorf("reloaded rules: got %+v", rules)
+	}
+}
+
+func TestRulesAdminHandler(t *testing.T) {
+	e, err := newRuleEngine(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := rulesAdminHandler(e)
+	post := func(form url.Values, origin string) int {
+		req := httptest.NewRequest(http.MethodPost, "/admin/rules", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if origin != "" {
+			req.Header.Set("Origin", origin)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	if code := post(url.Values{"name": {"ads"}, "action": {"hide"}, "domains": {"ads.example.com, tracker.example.com"}}, "http://example.com"); code != http.StatusSeeOther {
+		t.Errorf("adding a rule: want status %d, got %d", http.StatusSeeOther, code)
+	}
+	if rules := e.Rules(); len(rules) != 1 || len(rules[0].Domains) != 2 {
+		t.Errorf("rules: want the rule added, got %+v", rules)
+	}
+	rec := httptest.NewRecorder()
+	adminHandler(&Cache{ExpirationDuration: time.Minute}, &hn.Metrics{}, nil, jobs.New(jobs.Config{}), nil, e, nil, nil, &readiness{}, testTemplates(t)).
+		ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin", nil))
+	if body := rec.Body.String(); !strings.Contains(body, "ads.example.com, tracker.example.com") || !strings.Contains(body, "0 matches") {
+		t.Errorf("the dashboard doesn't list the rule: %s", body)
+	}
+	if code := post(url.Values{"name": {"late"}, "action": {"pin"}, "min_score": {"many"}}, ""); code != http.StatusBadRequest {
+		t.Errorf("adding an invalid rule: want status %d, got %d", http.StatusBadRequest, code)
+	}
+	if code := post(url.Values{"delete": {"ads"}}, "http://evil.example"); code != http.StatusForbidden {
+		t.Errorf("a cross-origin request: want status %d, got %d", http.StatusForbidden, code)
+	}
+	if code := post(url.Values{"delete": {"ads"}}, ""); code != http.StatusSeeOther || len(e.Rules()) != 0 {
+		t.Errorf("deleting the rule: got status %d and rules %+v", code, e.Rules())
+	}
+}
@@ -0,0 +1,244 @@
+// Package sanitize makes the HTML of HN texts and comments safe to embed in
+// a page.
+//
+// HN only formats texts with a handful of tags (paragraphs, italics, links
+// and code blocks), so rather than trying to make arbitrary HTML safe, the
+// sanitizer keeps the tags of an allowlist, without any of their attributes
+// except the href of links, and drops everything else. The text itself is
+// escaped again, so stray <, > and & can't start markup.
+package sanitize
+
+import (
+	"html"
+	"net/url"
+	"strings"
+)
+
+var (
+	// allowed are the tags kept, and whether they have an end tag
+	allowed = map[string]bool{
+		"a": true, "b": true, "blockquote": true, "code": true, "em": true,
+		"i": true, "p": true, "pre": true, "strong": true, "br": false,
+	}
+	// dropped are the elements whose content is dropped with them
+	dropped = map[string]bool{
+		"script": true, "style": true, "iframe": true, "object": true,
+		"template": true, "textarea": true, "title": true,
+	}
+	// schemes are the URL schemes links may use
+	schemes = map[string]bool{"http": true, "https": true, "mailto": true}
+)
+
+// Rel is the rel attribute of the links kept: the links are chosen by the
+// users, so they shouldn't be endorsed, and the linked pages mustn't be able
+// to navigate the page linking to them.
+const Rel = "nofollow noopener noreferrer"
+
+// Sanitizer sanitizes HTML.
+type Sanitizer struct {
+	// RewriteLink, if set, returns the URL a link to u should point to
+	// instead, or "" to keep it as is. u is absolute.
+	RewriteLink func(u *url.URL) string
+}
+
+// HTML returns the sanitized s with the default Sanitizer.
+func HTML(s string) string {
+	var z Sanitizer
+	return z.HTML(s)
+}
+
+// HTML returns s without the tags that aren't allowed, with the unsafe links
+// dropped and every element closed.
+func (z *Sanitizer) HTML(s string) string {
+	var b strings.Builder
+	var open []string
+	skip := "" // the dropped element whose content is being skipped
+	for len(s) > 0 {
+		i := strings.IndexByte(s, '<')
+		if i < 0 {
+			i = len(s)
+		}
+		if skip == "" {
+			b.WriteString(html.EscapeString(html.UnescapeString(s[:i])))
+		}
+		s = s[i:]
+		if s == "" {
+			break
+		}
+		t, rest, ok := parseTag(s)
+		if !ok {
+			// not a tag, eg "a < b"
+			if skip == "" {
+				b.WriteString("&lt;")
+			}
+			s = s[1:]
+			continue
+		}
+		s = rest
+		switch {
+		case skip != "":
+			if t.end && t.name == skip {
+				skip = ""
+			}
+		case dropped[t.name]:
+			if !t.end && !t.selfClosing {
+				skip = t.name
+			}
+		case !t.end:
+			hasEnd, ok := allowed[t.name]
+			if !ok {
+				continue
+			}
+			b.WriteString("<" + t.name)
+			if t.name == "a" {
+				if href := z.link(t.attrs["href"]); href != "" {
+					b.WriteString(` href="` + html.EscapeString(href) + `" rel="` + Rel + `"`)
+				}
+			}
+			b.WriteString(">")
+			if hasEnd && !t.selfClosing {
+				open = append(open, t.name)
+			} else if hasEnd {
+				b.WriteString("</" + t.name + ">")
+			}
+		default:
+			// close the elements up to the matching one, keeping the
+			// output balanced whatever the input
+			for j := len(open) - 1; j >= 0; j-- {
+				if open[j] != t.name {
+					continue
+				}
+				for k := len(open) - 1; k >= j; k-- {
+					b.WriteString("</" + open[k] + ">")
+				}
+				open = open[:j]
+				break
+			}
+		}
+	}
+	for k := len(open) - 1; k >= 0; k-- {
+		b.WriteString("</" + open[k] + ">")
+	}
+	return b.String()
+}
+
+// link returns the URL a link to href points to, or "" if it isn't safe
+func (z *Sanitizer) link(href string) string {
+	u, err := url.Parse(strings.TrimSpace(href))
+	if err != nil || !u.IsAbs() || !schemes[strings.ToLower(u.Scheme)] {
+		return ""
+	}
+	if z.RewriteLink != nil {
+		if rewritten := z.RewriteLink(u); rewritten != "" {
+			return rewritten
+		}
+	}
+	return u.String()
+}
+
+// tag is a start or end tag
+type tag struct {
+	name        string
+	end         bool
+	selfClosing bool
+	attrs       map[string]string
+}
+
+// parseTag parses the tag s starts with, returning the rest of s. Comments
+// and doctypes are parsed as tags without names.
+func parseTag(s string) (tag, string, bool) {
+	var t tag
+	if strings.HasPrefix(s, "<!--") {
+		end := strings.Index(s[4:], "-->")
+		if end < 0 {
+			return t, "", true
+		}
+		return t, s[4+end+3:], true
+	}
+	i := 1
+	if strings.HasPrefix(s[i:], "/") {
+		t.end = true
+		i++
+	} else if strings.HasPrefix(s[i:], "!") || strings.HasPrefix(s[i:], "?") {
+		end := strings.IndexByte(s, '>')
+		if end < 0 {
+			return t, "", true
+		}
+		return t, s[end+1:], true
+	}
+	start := i
+	for i < len(s) && isLetterOrDigit(s[i]) {
+		i++
+	}
+	if i == start || !isLetter(s[start]) {
+		return t, s, false
+	}
+	t.name = strings.ToLower(s[start:i])
+	t.attrs = map[string]string{}
+	for i < len(s) {
+		for i < len(s) && isSpace(s[i]) {
+			i++
+		}
+		if i == len(s) {
+			break
+		}
+		switch s[i] {
+		case '>':
+			return t, s[i+1:], true
+		case '/':
+			t.selfClosing = true
+			i++
+			continue
+		}
+		start := i
+		for i < len(s) && !isSpace(s[i]) && s[i] != '=' && s[i] != '>' && s[i] != '/' {
+			i++
+		}
+		name := strings.ToLower(s[start:i])
+		if i == start {
+			i++
+			continue
+		}
+		for i < len(s) && isSpace(s[i]) {
+			i++
+		}
+		value := ""
+		if i < len(s) && s[i] == '=' {
+			i++
+			for i < len(s) && isSpace(s[i]) {
+				i++
+			}
+			if i < len(s) && (s[i] == '"' || s[i] == '\'') {
+				end := strings.IndexByte(s[i+1:], s[i])
+				if end < 0 {
+					return tag{}, "", true
+				}
+				value = s[i+1 : i+1+end]
+				i += end + 2
+			} else {
+				start := i
+				for i < len(s) && !isSpace(s[i]) && s[i] != '>' {
+					i++
+				}
+				value = s[start:i]
+			}
+		}
+		if _, ok := t.attrs[name]; !ok {
+			t.attrs[name] = html.UnescapeString(value)
+		}
+	}
+	// an unterminated tag swallows the rest of the text, like browsers do
+	return tag{}, "", true
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f'
+}
+
+func isLetter(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
+
+func isLetterOrDigit(c byte) bool {
+	return isLetter(c) || '0' <= c && c <= '9'
+}
@@ -0,0 +1,47 @@
+package sanitize
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestHTML(t *testing.T) {
+	tests := []struct {
+		name, in, want string
+	}{
+		{"hn", `Some text<p>A <i>second</i> paragraph with a <a href="https:&#x2F;&#x2F;example.com&#x2F;a?b=1&amp;c=2" rel="nofollow">link</a>`,
+			`Some text<p>A <i>second</i> paragraph with a <a href="https://example.com/a?b=1&amp;c=2" rel="nofollow noopener noreferrer">link</a></p>`},
+		{"code", "<pre><code>  if a &lt; b {\n  }</code></pre>", "<pre><code>  if a &lt; b {\n  }</code></pre>"},
+		{"entities", "it&#x27;s &quot;quoted&quot; &amp; a < b > c", "it&#39;s &#34;quoted&#34; &amp; a &lt; b &gt; c"},
+		{"script", `<script>alert(1)</script>ok<style>p{}</style>`, "ok"},
+		{"attributes", `<p onclick="alert(1)" style="color:red">x</p><img src=x onerror=alert(1)>`, "<p>x</p>"},
+		{"javascript link", `<a href="javascript:alert(1)">x</a> <a href=" JavaScript:alert(1)">y</a>`, "<a>x</a> <a>y</a>"},
+		{"relative link", `<a href="/item?id=1">x</a>`, "<a>x</a>"},
+		{"injected attribute", `<a href="https://example.com/&quot; onmouseover=&quot;alert(1)">x</a>`,
+			`<a href="https://example.com/%22%20onmouseover=%22alert%281%29" rel="nofollow noopener noreferrer">x</a>`},
+		{"unbalanced", "<i><b>x</i> y</b> z<p>", "<i><b>x</b></i> y z<p></p>"},
+		{"case", "<I>x</I><SCRIPT>y</SCRIPT>", "<i>x</i>"},
+		{"comment", "a<!-- <script>x</script> -->b", "ab"},
+		{"unterminated", `a<a href="https://example.com`, "a"},
+		{"br", "a<br>b<br/>c", "a<br>b<br>c"},
+	}
+	for _, tc := range tests {
+		if got := HTML(tc.in); got != tc.want {
+			t.Errorf("%s: HTML(%q) = %q, want %q", tc.name, tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestSanitizer_RewriteLink(t *testing.T) {
+	z := Sanitizer{RewriteLink: func(u *url.URL) string {
+		if u.Host == "news.ycombinator.com" && u.Path == "/item" {
+			return "/item/" + u.Query().Get("id")
+		}
+		return ""
+	}}
+	in := `<a href="https://news.ycombinator.com/item?id=8863">a</a> <a href="https://example.com/">b</a>`
+	want := `<a href="/item/8863" rel="nofollow noopener noreferrer">a</a> <a href="https://example.com/" rel="nofollow noopener noreferrer">b</a>`
+	if got := z.HTML(in); got != want {
+		t.Errorf("HTML(%q) = %q, want %q", in, got, want)
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/mmxmb/quiet_hn/cron"
+	"github.com/mmxmb/quiet_hn/jobs"
+)
+
+// scheduledTask is an entry of the schedule file: a task of taskActions run
+// whenever its cron expression fires, in the local time of the server
+type scheduledTask struct {
+	Name     string            `json:"name"`
+	Schedule string            `json:"schedule"`
+	Task     string            `json:"task"`
+	Params   map[string]string `json:"params,omitempty"`
+	// Retries is how many more times a failed run is tried, with the
+	// backoff of the job queue
+	Retries int `json:"retries,omitempty"`
+}
+
+// loadSchedule reads path, a JSON list of scheduledTask
+func loadSchedule(path string) ([]scheduledTask, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var list []scheduledTask
+	if err := json.Unmarshal(b, &list); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	return list, nil
+}
+
+// scheduleEntry is the state of a scheduled task, as shown on the admin
+// dashboard
+type scheduleEntry struct {
+	scheduledTask
+	Next   time.Time
+	Last   time.Time
+	Result string
+	Error  string
+	// Running is whether the last run hasn't completed yet, in which case
+	// the next one is skipped
+	Running bool
+
+	cron cron.Schedule
+}
+
+// scheduler submits the scheduled tasks to the job queue when they are due
+type scheduler struct {
+	tasks *tasks
+	queue *jobs.Queue
+
+	mu      sync.Mutex
+	entries []*scheduleEntry
+}
+
+// newScheduler returns the scheduler of list, checking its expressions and
+// tasks
+func newScheduler(list []scheduledTask, t *tasks, queue *jobs.Queue, now time.Time) (*scheduler, error) {
+	s := &scheduler{tasks: t, queue: queue}
+	names := make(map[string]bool)
+	for _, st := range list {
+		if st.Name == "" {
+			st.Name = st.Task
+		}
+		if names[st.Name] {
+			return nil, fmt.Errorf("schedule: two tasks are named %q", st.Name)
+		}
+		names[st.Name] = true
+		if _, ok := taskActions[st.Task]; !ok {
+			return nil, fmt.Errorf("schedule: %s: unknown task %q", st.Name, st.Task)
+		}
+		c, err := cron.Parse(st.Schedule)
+		if err != nil {
+			return nil, fmt.Errorf("schedule: %s: %w", st.Name, err)
+		}
+		s.entries = append(s.entries, &scheduleEntry{scheduledTask: st, Next: c.Next(now), cron: c})
+	}
+	return s, nil
+}
+
+// Entries returns the state of the scheduled tasks, the next due first
+func (s *scheduler) Entries() []scheduleEntry {
+	s.mu.Lock()
+	ret := make([]scheduleEntry, len(s.entries))
+	for i, e := range s.entries {
+		ret[i] = *e
+	}
+	s.mu.Unlock()
+	sort.SliceStable(ret, func(i, j int) bool {
+		if ret[i].Next.IsZero() != ret[j].Next.IsZero() {
+			return !ret[i].Next.IsZero()
+		}
+		return ret[i].Next.Before(ret[j].Next)
+	})
+	return ret
+}
+
+// run submits the tasks when they are due until ctx is done
+func (s *scheduler) run(ctx context.Context) {
+	for {
+		var next time.Time
+		s.mu.Lock()
+		for _, e := range s.entries {
+			if !e.Next.IsZero() && (next.IsZero() || e.Next.Before(next)) {
+				next = e.Next
+			}
+		}
+		s.mu.Unlock()
+		if next.IsZero() {
+			// nothing will ever fire
+			<-ctx.Done()
+			return
+		}
+
+		t := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return
+		case <-t.C:
+		}
+		s.runDue(time.Now())
+	}
+}
+
+// runDue submits the tasks due by now and schedules their next run
+func (s *scheduler) runDue(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, e := range s.entries {
+		if e.Next.IsZero() || e.Next.After(now) {
+			continue
+		}
+		e.Next = e.cron.Next(now)
+		if e.Running {
+			continue
+		}
+		e.Running = true
+		e := e
+		ok := s.queue.Submit(jobs.Job{
+			Name:    "scheduled " + e.Name,
+			Retries: e.Retries,
+			Run: func(ctx context.Context) error {
+				return s.runEntry(ctx, e)
+			},
+		})
+		if !ok {
+			e.Running = false
+			e.Error = "dropped, the job queue was full"
+		}
+	}
+}
+
+// runEntry runs the task of e, recording its outcome. It is run with s.mu
+// unlocked.
+func (s *scheduler) runEntry(ctx context.Context, e *scheduleEntry) error {
+	params := make(url.Values)
+	for k, v := range e.Params {
+		params.Set(k, v)
+	}
+	result, err := taskActions[e.Task](s.tasks, ctx, params)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e.Last, e.Result, e.Error = time.Now(), result, ""
+	if err != nil {
+		e.Error = err.Error()
+	}
+	// a retry may follow, but that's no reason to skip the next run
+	e.Running = false
+	return err
+}
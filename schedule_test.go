@@ -0,0 +1,54 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mmxmb/quiet_hn/history"
+	"github.com/mmxmb/quiet_hn/jobs"
+)
+
+func TestScheduler(t *testing.T) {
+	hist := history.NewStore()
+	maintenance := &tasks{client: newFakeProvider(5), cache: &Cache{ExpirationDuration: time.Hour}, cfg: config{NumStories: 3, Concurrency: 2}, hist: hist}
+	queue := jobs.New(jobs.Config{Size: 10})
+	now := time.Date(2024, time.January, 10, 12, 34, 0, 0, time.UTC)
+	sched, err := newScheduler([]scheduledTask{
+		{Name: "front page", Schedule: "*/5 * * * *", Task: "snapshot"},
+		{Schedule: "@daily", Task: "prune", Params: map[string]string{"max": "1"}},
+	}, maintenance, queue, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries := sched.Entries()
+	if len(entries) != 2 || entries[0].Name != "front page" || !entries[0].Next.Equal(now.Add(time.Minute)) || entries[1].Name != "prune" {
+		t.Fatalf("Entries(): want the snapshot due first at 12:35 then prune, got %+v", entries)
+	}
+
+	sched.runDue(now.Add(time.Minute))
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		if entries = sched.Entries(); !entries[0].Running {
+			break
+		}
+	}
+	queue.Close(time.Second)
+	if !entries[0].Next.Equal(now.Add(6*time.Minute)) || entries[0].Result != "recorded 3 stories" || entries[0].Running {
+		t.Errorf("the snapshot should have run and be due at 12:40, got %+v", entries[0])
+	}
+	if !entries[1].Last.IsZero() {
+		t.Errorf("prune isn't due yet but ran: %+v", entries[1])
+	}
+	if _, ok := hist.Start(); !ok {
+		t.Errorf("the scheduled snapshot wasn't recorded")
+	}
+
+	for _, list := range [][]scheduledTask{
+		{{Schedule: "* * * * *", Task: "reboot"}},
+		{{Schedule: "every minute", Task: "refresh"}},
+		{{Schedule: "@hourly", Task: "refresh"}, {Schedule: "@daily", Task: "refresh"}},
+	} {
+		if _, err := newScheduler(list, maintenance, queue, now); err == nil {
+			t.Errorf("newScheduler(%+v): want an error", list)
+		}
+	}
+}
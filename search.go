@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/mmxmb/quiet_hn/history"
+	"github.com/mmxmb/quiet_hn/hn"
+	"github.com/mmxmb/quiet_hn/readability"
+	"github.com/mmxmb/quiet_hn/search"
+)
+
+// searchScopes are the scopes of /search. Only the local archive, the
+// stories seen on the front page according to the history, can be searched.
+var searchScopes = []string{"local"}
+
+// indexHistory adds the stories of the snapshots of hist to idx, then those
+// of the snapshots recorded since every interval until ctx is done.
+func indexHistory(ctx context.Context, idx *search.Index, hist *history.Store, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	var from time.Time
+	for {
+		for _, snap := range hist.Snapshots(from, time.Unix(1<<62, 0)) {
+			for _, story := range snap.Stories {
+				idx.Add(search.Document{ID: story.ID, Title: story.Title, URL: story.URL, Time: snap.Time})
+			}
+			from = snap.Time.Add(time.Nanosecond)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// indexArticle adds the text of the article of the story with the given id
+// to idx, so stories can be found by their content once read in reader mode
+func indexArticle(idx *search.Index, id int, art readability.Article) {
+	texts := make([]string, len(art.Blocks))
+	for i, b := range art.Blocks {
+		texts[i] = b.Text
+	}
+	idx.Add(search.Document{ID: id, Text: strings.Join(texts, "\n")})
+}
+
+// searchHandler serves /search?q=...&scope=local, the stories of the local
+// archive matching the query q
+func searchHandler(cfg config, idx *search.Index, tpls *templateSet) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		q := newQueryParams(r)
+		q.Enum("scope", "local", searchScopes...)
+		if err := q.Err(); err != nil {
+			tpls.renderQueryError(w, r, err)
+			return
+		}
+		prefs, err := loadPreferences(w, r, cfg.Defaults)
+		if err != nil {
+			tpls.renderQueryError(w, r, err)
+			return
+		}
+
+		query := strings.TrimSpace(r.URL.Query().Get("q"))
+		results := idx.Search(query, cfg.NumStories)
+		stories := make([]item, len(results))
+		seen := make(map[int]int, len(results))
+		for i, res := range results {
+			stories[i] = parseHNItem(hn.Item{ID: res.ID, Title: res.Title, URL: res.URL})
+			stories[i].Rank = i + 1
+			seen[res.ID] = int(res.Time.Unix())
+		}
+		cfg.Archive.decorate(stories)
+		markPaywalled(stories, cfg.PaywallDomains)
+		if cfg.Redirect {
+			redirectLinks(stories)
+		}
+
+		data := searchTemplateData{
+			Query:   query,
+			Stories: stories,
+			Seen:    seen,
+			Lang:    languagePref(w, r, cfg.Messages),
+			TZ:      prefs.Timezone,
+			Time:    time.Now().Sub(start),
+		}
+		err = tpls.render(w, "search", data)
+		if err != nil {
+			http.Error(w, "Failed to process the template", http.StatusInternalServerError)
+			return
+		}
+	})
+}
+
+type searchTemplateData struct {
+	Query   string
+	Stories []item
+	// Seen is when each story was first seen on the front page, in unix
+	// time by ID
+	Seen map[int]int
+	Lang string
+	TZ   string
+	Time time.Duration
+}
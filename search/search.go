@@ -0,0 +1,210 @@
+// Package search is a full-text index of the stories seen on the front page,
+// so they can be found again without the search of HN.
+//
+// The index is kept in memory: an inverted index mapping each term to the
+// stories containing it, with the number of times it appears in their title
+// and text. Terms are the lowercased runs of letters and digits of the text,
+// so "Go 1.16's embed" is made of the terms go, 1, 16, s and embed.
+package search
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// titleWeight is how much more a term in a title counts than a term in the
+// text of a story
+const titleWeight = 5
+
+// Document is a story to index
+type Document struct {
+	ID    int
+	Title string
+	URL   string
+	// Text is the text of the article, if any
+	Text string
+	// Time is when the story was seen
+	Time time.Time
+}
+
+// Result is a story matching a query
+type Result struct {
+	ID    int
+	Title string
+	URL   string
+	Time  time.Time
+	Score int
+}
+
+type posting struct {
+	title, text int
+}
+
+type doc struct {
+	title, url string
+	time       time.Time
+	// terms are the terms of the text, kept to remove them when it changes
+	terms map[string]bool
+}
+
+// Index is a full-text index of stories. It is safe for concurrent use.
+type Index struct {
+	mu       sync.RWMutex
+	docs     map[int]*doc
+	postings map[string]map[int]posting
+}
+
+// New returns an empty Index
+func New() *Index {
+	return &Index{docs: make(map[int]*doc), postings: make(map[string]map[int]posting)}
+}
+
+// Len returns the number of stories indexed
+func (x *Index) Len() int {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	return len(x.docs)
+}
+
+// Add indexes d, replacing the title of a story already indexed with the
+// same ID. Stories keep the Time they were first added with, and an empty
+// Text leaves any text already indexed in place, so the titles of the front
+// page can be indexed again and again without dropping article texts.
+func (x *Index) Add(d Document) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	old, ok := x.docs[d.ID]
+	if !ok {
+		old = &doc{time: d.Time}
+		x.docs[d.ID] = old
+	}
+	if d.Title != "" && d.Title != old.title {
+		x.remove(d.ID, Terms(old.title), func(p *posting) { p.title = 0 })
+		for _, t := range Terms(d.Title) {
+			p := x.posting(t, d.ID)
+			p.title++
+			x.postings[t][d.ID] = *p
+		}
+		old.title = d.Title
+	}
+	if d.URL != "" {
+		old.url = d.URL
+	}
+	if d.Text != "" {
+		for t := range old.terms {
+			x.remove(d.ID, []string{t}, func(p *posting) { p.text = 0 })
+		}
+		old.terms = make(map[string]bool)
+		for _, t := range Terms(d.Text) {
+			p := x.posting(t, d.ID)
+			p.text++
+			x.postings[t][d.ID] = *p
+			old.terms[t] = true
+		}
+	}
+}
+
+func (x *Index) posting(term string, id int) *posting {
+	ids, ok := x.postings[term]
+	if !ok {
+		ids = make(map[int]posting)
+		x.postings[term] = ids
+	}
+	p := ids[id]
+	return &p
+}
+
+// remove clears the postings of id for terms with clear, dropping those left
+// empty
+func (x *Index) remove(id int, terms []string, clear func(*posting)) {
+	for _, t := range terms {
+		p, ok := x.postings[t][id]
+		if !ok {
+			continue
+		}
+		clear(&p)
+		if p == (posting{}) {
+			delete(x.postings[t], id)
+			if len(x.postings[t]) == 0 {
+				delete(x.postings, t)
+			}
+		} else {
+			x.postings[t][id] = p
+		}
+	}
+}
+
+// Search returns up to limit stories containing all the terms of query, the
+// last of which may be a prefix since it may not be fully typed yet. Results
+// are sorted by descending score, the number of times the terms appear in
+// the story with those in the title counting titleWeight times, and then by
+// most recent first.
+func (x *Index) Search(query string, limit int) []Result {
+	terms := Terms(query)
+	if len(terms) == 0 || limit <= 0 {
+		return nil
+	}
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+
+	var scores map[int]int
+	for i, t := range terms {
+		matches := make(map[int]int)
+		add := func(ids map[int]posting) {
+			for id, p := range ids {
+				if score := p.title*titleWeight + p.text; score > matches[id] {
+					matches[id] = score
+				}
+			}
+		}
+		if i == len(terms)-1 {
+			for term, ids := range x.postings {
+				if strings.HasPrefix(term, t) {
+					add(ids)
+				}
+			}
+		} else {
+			add(x.postings[t])
+		}
+		if scores == nil {
+			scores = matches
+			continue
+		}
+		for id := range scores {
+			if s, ok := matches[id]; ok {
+				scores[id] += s
+			} else {
+				delete(scores, id)
+			}
+		}
+	}
+
+	results := make([]Result, 0, len(scores))
+	for id, score := range scores {
+		d := x.docs[id]
+		results = append(results, Result{ID: id, Title: d.title, URL: d.url, Time: d.time, Score: score})
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		if !results[i].Time.Equal(results[j].Time) {
+			return results[i].Time.After(results[j].Time)
+		}
+		return results[i].ID > results[j].ID
+	})
+	if len(results) > limit {
+		results = results[:limit]
+	}
+	return results
+}
+
+// Terms returns the terms of s, in order and with duplicates
+func Terms(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
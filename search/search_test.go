@@ -0,0 +1,67 @@
+package search
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+var t0 = time.Date(2018, 4, 1, 16, 0, 0, 0, time.UTC)
+
+func ids(results []Result) []int {
+	ret := []int{}
+	for _, r := range results {
+		ret = append(ret, r.ID)
+	}
+	return ret
+}
+
+func TestTerms(t *testing.T) {
+	want := []string{"go", "1", "16", "s", "embed"}
+	if got := Terms("Go 1.16's embed"); !reflect.DeepEqual(got, want) {
+		t.Errorf("Terms(): want %v, got %v", want, got)
+	}
+}
+
+func TestIndex(t *testing.T) {
+	x := New()
+	x.Add(Document{ID: 1, Title: "Concurrency in Go", Time: t0})
+	x.Add(Document{ID: 2, Title: "Caching strategies", Text: "Go caches everything, caching in Go is easy", Time: t0.Add(time.Hour)})
+	x.Add(Document{ID: 3, Title: "Rust and Go", Time: t0.Add(2 * time.Hour)})
+
+	tests := []struct {
+		query string
+		want  []int
+	}{
+		{"go", []int{3, 1, 2}},
+		{"GO concurrency", []int{1}},
+		{"cach", []int{2}},
+		{"caching go", []int{2}},
+		{"python", []int{}},
+		{"", []int{}},
+	}
+	for _, tc := range tests {
+		if got := ids(x.Search(tc.query, 10)); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("Search(%q): want %v, got %v", tc.query, tc.want, got)
+		}
+	}
+	if got := ids(x.Search("go", 1)); !reflect.DeepEqual(got, []int{3}) {
+		t.Errorf("Search() with a limit of 1: want %v, got %v", []int{3}, got)
+	}
+
+	// a new title replaces the old one, but keeps the text and time
+	x.Add(Document{ID: 2, Title: "Caching in Python", Time: t0.Add(3 * time.Hour)})
+	if got := ids(x.Search("strategies", 10)); len(got) != 0 {
+		t.Errorf("Search() of the old title: want no results, got %v", got)
+	}
+	res := x.Search("python", 10)
+	if len(res) != 1 || res[0].ID != 2 || !res[0].Time.Equal(t0.Add(time.Hour)) {
+		t.Errorf("Search() of the new title: want story 2 first seen at %v, got %+v", t0.Add(time.Hour), res)
+	}
+	if got := ids(x.Search("easy", 10)); !reflect.DeepEqual(got, []int{2}) {
+		t.Errorf("Search() of the text: want %v, got %v", []int{2}, got)
+	}
+	if n := x.Len(); n != 3 {
+		t.Errorf("Len(): want %d, got %d", 3, n)
+	}
+}
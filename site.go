@@ -0,0 +1,148 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/mmxmb/quiet_hn/history"
+	"github.com/mmxmb/quiet_hn/hn"
+	"github.com/mmxmb/quiet_hn/router"
+	"github.com/mmxmb/quiet_hn/search"
+)
+
+// site holds what the handlers of the site need. The optional subsystems
+// (enr, favicons, hist, keys, clicks, trees, hiring, articles, proxy, search,
+// hooks, rules and pins) are nil when disabled.
+type site struct {
+	client      StoryProvider
+	cache       *Cache
+	cfg         config
+	tpls        *templateSet
+	robots      string
+	compression router.Middleware
+
+	enr      *enricher
+	favicons *faviconFetcher
+	hist     *history.Store
+	keys     *apiKeys
+	clicks   *clickCounter
+	trees    *commentTrees
+	hiring   *hiringBoard
+	articles *articleFetcher
+	proxy    *firebaseProxy
+	search   *search.Index
+	hooks    *hookRunner
+	rules    *ruleEngine
+	pins     *pinBoard
+	// discussions looks up the earlier discussions of the stories of the
+	// item pages
+	discussions discussionFinder
+	// searchArticles is whether the articles read in reader mode are added
+	// to search
+	searchArticles bool
+	metrics        *hn.Metrics
+	ready          *readiness
+}
+
+// routes returns the router of the pages and the JSON API of s
+func (s *site) routes() *router.Router {
+	client, cache, cfg, tpls := s.client, s.cache, s.cfg, s.tpls
+
+	// every other path gets the 404 page
+	mux := router.New()
+	mux.NotFound = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tpls.renderError(w, r, http.StatusNotFound, "error.not_found")
+	})
+
+	// pages holds the pages and other resources, which are only ever fetched
+	secure := securityHeaders
+	if cfg.KeyboardJS {
+		secure = securityHeadersWith(scriptPolicy)
+	}
+	pages := mux.Group(secure, s.compression, methods(rejectPage(tpls), http.MethodGet))
+	pages.Handle("/", handler(client, cache, cfg, s.enr, s.favicons, s.hist, s.rules, s.pins, tpls))
+	items := itemHandler(client, cfg, s.trees, s.discussions, tpls)
+	pages.Handle("/item", items)
+	pages.Handle("/item/{id}", items)
+	pages.Handle("/comment/{id}", commentHandler(client, cfg, s.trees, tpls))
+	pages.Handle("/item/{id}/card.png", cardHandler(client, cfg))
+	pages.Handle("/item/{id}.txt", itemTextHandler(client, cfg, s.trees))
+	pages.Handle("/item/{id}.epub", itemEPUBHandler(client, cfg, s.trees))
+	// the print variants of the front page and the item pages
+	printTpls := tpls.print()
+	pages.Handle("/print", handler(client, cache, cfg, s.enr, s.favicons, s.hist, s.rules, s.pins, printTpls))
+	pages.Handle("/print/item/{id}", itemHandler(client, cfg, s.trees, s.discussions, printTpls))
+	users := userHandler(client, cfg, tpls)
+	pages.Handle("/user", users)
+	pages.Handle("/user/{name}", users)
+	if len(cfg.Following) > 0 {
+		pages.Handle("/following", followingHandler(client, cache, cfg, tpls))
+	}
+	if s.favicons != nil {
+		pages.Handle("/favicon", faviconHandler(s.favicons))
+	}
+	if s.hiring != nil {
+		pages.Handle("/hiring", hiringHandler(cfg, s.hiring, tpls))
+	}
+	if s.hist != nil {
+		best := bestHandler(cfg, s.hist, tpls)
+		pages.Handle("/best", best)
+		pages.Handle("/best/{period}", best)
+		pages.Handle("/history/{date}", historyHandler(cfg, s.hist, tpls))
+		pages.Handle("/domains", domainsHandler(cfg, s.hist, tpls))
+	}
+	if s.search != nil {
+		pages.Handle("/search", searchHandler(cfg, s.search, tpls))
+	}
+	pages.Handle("/opensearch.xml", openSearchHandler(cfg))
+	if cfg.KeyboardJS {
+		pages.Handle("/keys.js", keysJSHandler())
+	}
+	pages.Handle("/readyz", readyzHandler(s.ready))
+	pages.Handle("/robots.txt", robotsHandler(s.robots))
+	pages.Handle("/sitemap.xml", sitemapHandler(cache, cfg))
+	if s.clicks != nil {
+		pages.Handle("/out", outHandler(client, cfg, s.clicks, tpls))
+	}
+	if s.articles != nil {
+		var idx *search.Index
+		if s.searchArticles {
+			idx = s.search
+		}
+		pages.Handle("/read/{id}", readHandler(client, cfg, s.articles, idx, tpls))
+	}
+
+	// the JSON API needs a key, if any are set, except for its description,
+	// which is public so clients can be generated before getting one
+	public := mux.Group(s.compression, cors(cfg.CORS))
+	public.Handle("/version", methods(rejectAPI, http.MethodGet)(versionHandler()))
+	public.Handle("/api/openapi.json", methods(rejectAPI, http.MethodGet)(openAPIHandler(s.hist != nil, s.clicks != nil)))
+	public.Group(methods(rejectAPI, http.MethodGet, http.MethodPost), authenticate(s.keys)).
+		Handle("/graphql", graphQLHandler(graphQLSchema(client, cache, cfg)))
+	api := public.Group(methods(rejectAPI, http.MethodGet), authenticate(s.keys))
+	api.Handle("/api/stories", storiesAPIHandler(client, cache, cfg))
+	api.Handle("/api/item/{id}", itemAPIHandler(client))
+	api.Handle("/api/cache/stats", cacheStatsHandler(cache))
+	api.Handle("/api/upstream/stats", upstreamStatsHandler(s.metrics))
+	if s.hist != nil {
+		api.Handle("/api/trends", trendsHandler(s.hist))
+	}
+	if s.keys != nil {
+		api.Handle("/api/usage", apiUsageHandler(s.keys))
+	}
+	if s.clicks != nil {
+		api.Handle("/api/clicks", topClicksHandler(s.clicks))
+		api.Handle("/api/stories/{id}/clicks", storyClicksHandler(s.clicks))
+	}
+	if s.proxy != nil {
+		proxy := s.proxy.handler()
+		api.Handle("/v0/topstories.json", proxy)
+		api.Handle("/v0/item/{item}", proxy)
+		api.Handle("/v0/user/{user}", proxy)
+	}
+	if s.hooks != nil {
+		// the hooks have their own secret rather than API keys
+		public.Group(methods(rejectAPI, http.MethodPost)).
+			Handle("/api/hooks/{action}", hookHandler(s.hooks))
+	}
+	return mux
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/mmxmb/quiet_hn/history"
+	"github.com/mmxmb/quiet_hn/hn"
+	"github.com/mmxmb/quiet_hn/router"
+)
+
+// recordSnapshots records the front page as seen with the default
+// preferences to hist every interval until ctx is done. The stories fetched
+// are also put in the cache, sparing the next visitor the wait.
+func recordSnapshots(ctx context.Context, client StoryProvider, cache *Cache, cfg config, hist *history.Store, interval time.Duration) {
+	f := newFilter(cfg, cfg.Defaults)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		now := time.Now()
+		stories, err := fetchTopStories(ctx, client, cfg, f)
+		if err == nil {
+			cache.Set(f.key(), stories)
+			err = recordSnapshot(hist, f.unmuted(stories, cfg.NumStories), now)
+		}
+		if err != nil && ctx.Err() == nil {
+			log.Printf("recording the front page: %s", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// retention is how much of the front page history is kept. Zero values keep
+// everything.
+type retention struct {
+	Days         int
+	MaxSnapshots int
+	// CompactInterval is how often the history file is rewritten without
+	// the pruned snapshots
+	CompactInterval time.Duration
+}
+
+// pruneHistory drops the snapshots of hist beyond the retention every
+// interval until ctx is done, compacting its file every
+// ret.CompactInterval.
+func pruneHistory(ctx context.Context, hist *history.Store, ret retention, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	var compact <-chan time.Time
+	if ret.CompactInterval > 0 {
+		t := time.NewTicker(ret.CompactInterval)
+		defer t.Stop()
+		compact = t.C
+	}
+	for {
+		var before time.Time
+		if ret.Days > 0 {
+			before = time.Now().AddDate(0, 0, -ret.Days)
+		}
+		if n := hist.Prune(before, ret.MaxSnapshots); n > 0 {
+			log.Printf("pruned %d snapshots from the history", n)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-compact:
+			if n, err := hist.Compact(); err != nil {
+				log.Printf("compacting the history: %s", err)
+			} else if n > 0 {
+				log.Printf("compacted %d snapshots out of the history file", n)
+			}
+		}
+	}
+}
+
+// syncHistory adds the snapshots recorded by other replicas to hist every
+// interval until ctx is done, dropping the ones beyond the retention from
+// memory; the leader compacts the backend.
+func syncHistory(ctx context.Context, hist *history.Store, ret retention, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if _, err := hist.Sync(); err != nil {
+			log.Printf("syncing the history: %s", err)
+		}
+		var before time.Time
+		if ret.Days > 0 {
+			before = time.Now().AddDate(0, 0, -ret.Days)
+		}
+		hist.Prune(before, ret.MaxSnapshots)
+	}
+}
+
+// recordSnapshot adds the front page made of stories to hist
+func recordSnapshot(hist *history.Store, stories []item, at time.Time) error {
+	snap := history.Snapshot{Time: at, Stories: make([]history.Story, len(stories))}
+	for i, story := range stories {
+		snap.Stories[i] = history.Story{
+			ID:       story.ID,
+			Rank:     story.Rank,
+			Title:    story.Title,
+			URL:      story.URL,
+			Score:    story.Score,
+			Comments: story.Descendants,
+		}
+	}
+	return hist.Record(snap)
+}
+
+// markCommentDeltas sets CommentDelta on the stories to the number of
+// comments they got since they were recorded window ago, or since they were
+// first recorded if that was more recently.
+func markCommentDeltas(stories []item, hist *history.Store, now time.Time, window time.Duration) {
+	for i := range stories {
+		seen, ok := hist.FirstSeen(stories[i].ID)
+		if !ok {
+			continue
+		}
+		since := now.Add(-window)
+		if seen.After(since) {
+			since = seen
+		}
+		if then, ok := hist.StoryAt(stories[i].ID, since); ok {
+			stories[i].CommentDelta = stories[i].Descendants - then.Comments
+		}
+	}
+}
+
+// secondChanceAge is the age past which a story making it to the front page
+// for the first time is taken for a second chance: HN gives the stories of the
+// second-chance pool a new timestamp on the front page, but the API keeps the
+// time they were submitted.
+const secondChanceAge = 6 * time.Hour
+
+// markSecondChance sets SecondChance on the stories first recorded on the
+// front page more than secondChanceAge after they were submitted. Stories
+// submitted before the history started are left alone, as they may have
+// made it to the front page before.
+func markSecondChance(stories []item, hist *history.Store) {
+	start, ok := hist.Start()
+	if !ok {
+		return
+	}
+	for i := range stories {
+		submitted := time.Unix(int64(stories[i].Time), 0)
+		if stories[i].Time == 0 || submitted.Before(start) {
+			continue
+		}
+		if seen, ok := hist.FirstSeen(stories[i].ID); ok && seen.Sub(submitted) > secondChanceAge {
+			stories[i].SecondChance = true
+		}
+	}
+}
+
+// historyHandler serves /history/{date}, the front page as it was recorded at
+// date. date is either a day (2006-01-02), for the last snapshot of the day,
+// or a time (2006-01-02T15:04), both in the timezone of the user.
+func historyHandler(cfg config, hist *history.Store, tpls *templateSet) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		prefs, err := loadPreferences(w, r, cfg.Defaults)
+		if err != nil {
+			tpls.renderQueryError(w, r, err)
+			return
+		}
+		loc, err := loadLocation(prefs.Timezone)
+		if err != nil {
+			loc = time.UTC
+		}
+		date := router.Param(r, "date")
+		at, err := time.ParseInLocation("2006-01-02T15:04", date, loc)
+		if err != nil {
+			day, dayErr := time.ParseInLocation("2006-01-02", date, loc)
+			if dayErr != nil {
+				tpls.renderError(w, r, http.StatusBadRequest, "error.invalid_date")
+				return
+			}
+			at = day.AddDate(0, 0, 1).Add(-time.Nanosecond)
+		}
+		snap, ok := hist.At(at)
+		if !ok {
+			tpls.renderError(w, r, http.StatusNotFound, "error.no_snapshot")
+			return
+		}
+
+		stories := make([]item, len(snap.Stories))
+		for i, story := range snap.Stories {
+			stories[i] = parseHNItem(hn.Item{
+				ID:          story.ID,
+				Title:       story.Title,
+				URL:         story.URL,
+				Score:       story.Score,
+				Descendants: story.Comments,
+			})
+			stories[i].Rank = story.Rank
+		}
+		cfg.Archive.decorate(stories)
+		markPaywalled(stories, cfg.PaywallDomains)
+		if cfg.Redirect {
+			redirectLinks(stories)
+		}
+
+		data := templateData{
+			Stories:      stories,
+			Prefs:        prefs,
+			Lang:         languagePref(w, r, cfg.Messages),
+			Languages:    cfg.Messages.Languages(),
+			SnapshotTime: int(snap.Time.Unix()),
+			KeyboardJS:   cfg.KeyboardJS,
+			Time:         time.Now().Sub(start),
+		}
+		err = tpls.render(w, "index", data)
+		if err != nil {
+			http.Error(w, "Failed to process the template", http.StatusInternalServerError)
+			return
+		}
+	})
+}
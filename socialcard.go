@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"strconv"
+
+	"github.com/mmxmb/quiet_hn/card"
+	"github.com/mmxmb/quiet_hn/router"
+)
+
+// cardHandler serves /item/{id}/card.png, the social card of an item: the
+// image of its og:image meta tag, shown by chat apps when the item page is
+// shared.
+func cardHandler(client StoryProvider, cfg config) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(router.Param(r, "id"))
+		if err != nil || id <= 0 {
+			http.Error(w, "Invalid item ID", http.StatusBadRequest)
+			return
+		}
+		hnItem, err := client.GetItem(id)
+		if err != nil {
+			http.Error(w, "Failed to load the item", http.StatusInternalServerError)
+			return
+		}
+		if !hnItem.Alive() {
+			http.NotFound(w, r)
+			return
+		}
+
+		itm := parseHNItem(hnItem)
+		lang := languagePref(w, r, cfg.Messages)
+		c := card.Card{
+			Title:  itm.Title,
+			Host:   itm.Host,
+			Footer: cfg.Messages.Plural(lang, "points", itm.Score) + " · " + cfg.Messages.Plural(lang, "comments", itm.Descendants),
+		}
+		if itm.Label != "" {
+			c.Host = itm.Label
+		}
+		var buf bytes.Buffer
+		if err := card.Render(&buf, c); err != nil {
+			http.Error(w, "Failed to render the card", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "image/png")
+		// the score and comments change, but previews can lag behind
+		w.Header().Set("Cache-Control", "public, max-age=3600")
+		w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+		w.Write(buf.Bytes())
+	})
+}
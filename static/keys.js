@@ -0,0 +1,68 @@
+// Keyboard navigation of the front page and item pages, served with
+// -keyboard_js: j and k move to the next and previous story or comment,
+// o and enter open it. The pages work the same without it.
+(function () {
+  "use strict";
+
+  var selector, open;
+  if (document.querySelector("li.comment")) {
+    // item pages: the comments, opened at their permalinks
+    selector = "li.comment";
+    open = 'a[href^="/comment/"]';
+  } else {
+    // the front page: the stories, opened at their links
+    selector = "ol > li";
+    open = ":scope > a";
+  }
+  var current = -1;
+
+  function visible() {
+    return Array.prototype.filter.call(document.querySelectorAll(selector), function (el) {
+      return el.offsetParent !== null;
+    });
+  }
+
+  function move(by) {
+    var items = visible();
+    if (items.length === 0) {
+      return;
+    }
+    if (items[current]) {
+      items[current].style.outline = "";
+    }
+    current = Math.max(0, Math.min(items.length - 1, current + by));
+    var el = items[current];
+    el.style.outline = "1px dotted #888";
+    el.scrollIntoView({block: "nearest"});
+  }
+
+  document.addEventListener("keydown", function (e) {
+    if (e.altKey || e.ctrlKey || e.metaKey || e.defaultPrevented) {
+      return;
+    }
+    var target = e.target;
+    if (target.isContentEditable || /^(INPUT|TEXTAREA|SELECT|BUTTON)$/.test(target.tagName)) {
+      return;
+    }
+    switch (e.key) {
+      case "j":
+        move(1);
+        break;
+      case "k":
+        move(-1);
+        break;
+      case "o":
+      case "Enter":
+        var item = visible()[current];
+        var link = item && item.querySelector(open);
+        if (!link) {
+          return;
+        }
+        link.click();
+        break;
+      default:
+        return;
+    }
+    e.preventDefault();
+  });
+})();
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/mmxmb/quiet_hn/history"
+	"github.com/mmxmb/quiet_hn/leader"
+	"github.com/mmxmb/quiet_hn/pg"
+)
+
+// store is where the state outliving the process is kept. The subsystems
+// get their backends from it, so that a deployment moves from local files
+// to a database shared by its replicas by setting -database.
+type store interface {
+	// History returns the backend of the front page history, nil if it is
+	// only kept in memory
+	History() (history.Backend, error)
+	// Rules returns the backend of the alert rules, nil if they are only
+	// kept in memory
+	Rules() (ruleBackend, error)
+	// Pins returns the backend of the stories pinned on the admin
+	// dashboard, nil if they are only kept in memory
+	Pins() (pinBackend, error)
+	// Watched returns the backend of the threads watched, nil if they are
+	// only kept in memory
+	Watched() (watchBackend, error)
+	// Posted returns the backend of the stories cross-posted, nil if they
+	// are only kept in memory
+	Posted() (postedBackend, error)
+	// Clicks returns the backend of the click counts of /out, nil if they
+	// are only kept in memory
+	Clicks() (clickBackend, error)
+	// APIUsage returns the backend of the usage counters of the API keys,
+	// nil if they are only kept in memory
+	APIUsage() (usageBackend, error)
+	// Lease returns the backend of the lease of the leader election, nil
+	// if every replica leads
+	Lease() (leader.Backend, error)
+	Close() error
+}
+
+// openStore returns the pgStore of databaseURL, migrated to the current
+// schema, or files otherwise
+func openStore(databaseURL string, files fileStore) (store, error) {
+	if databaseURL == "" {
+		return files, nil
+	}
+	db, err := openDatabase(databaseURL)
+	if err != nil {
+		return nil, err
+	}
+	return pgStore{db: db}, nil
+}
+
+// openDatabase connects to databaseURL and applies the pending migrations
+func openDatabase(databaseURL string) (*pg.DB, error) {
+	db, err := pg.Open(databaseURL)
+	if err != nil {
+		return nil, err
+	}
+	ms, err := loadMigrations(embeddedMigrations)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	n, err := migrate(ctx, db, ms)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	if n > 0 {
+		log.Printf("applied %d migrations to the database", n)
+	}
+	return db, nil
+}
+
+// fileStore keeps the state in local files, or in memory for the files not
+// set
+type fileStore struct {
+	historyFile  string
+	rulesFile    string
+	pinnedFile   string
+	watchFile    string
+	postedFile   string
+	clicksFile   string
+	apiUsageFile string
+	leaseFile    string
+}
+
+func (s fileStore) History() (history.Backend, error) {
+	if s.historyFile == "" {
+		return nil, nil
+	}
+	return history.OpenFile(s.historyFile)
+}
+
+func (s fileStore) Rules() (ruleBackend, error) {
+	if s.rulesFile == "" {
+		return nil, nil
+	}
+	return fileRules{path: s.rulesFile}, nil
+}
+
+func (s fileStore) Pins() (pinBackend, error) {
+	if s.pinnedFile == "" {
+		return nil, nil
+	}
+	return filePins{path: s.pinnedFile}, nil
+}
+
+func (s fileStore) Watched() (watchBackend, error) {
+	if s.watchFile == "" {
+		return nil, nil
+	}
+	return fileWatched{path: s.watchFile}, nil
+}
+
+func (s fileStore) Posted() (postedBackend, error) {
+	if s.postedFile == "" {
+		return nil, nil
+	}
+	return filePosted{path: s.postedFile}, nil
+}
+
+func (s fileStore) Clicks() (clickBackend, error) {
+	if s.clicksFile == "" {
+		return nil, nil
+	}
+	return fileClicks{path: s.clicksFile}, nil
+}
+
+func (s fileStore) APIUsage() (usageBackend, error) {
+	if s.apiUsageFile == "" {
+		return nil, nil
+	}
+	return fileUsage{path: s.apiUsageFile}, nil
+}
+
+func (s fileStore) Lease() (leader.Backend, error) {
+	if s.leaseFile == "" {
+		return nil, nil
+	}
+	return leader.FileBackend{Path: s.leaseFile}, nil
+}
+
+func (s fileStore) Close() error {
+	return nil
+}
+
+// pgStore keeps the state in Postgres, in the tables of the migrations
+type pgStore struct {
+	db *pg.DB
+}
+
+func (s pgStore) History() (history.Backend, error) {
+	return &pgHistory{db: s.db}, nil
+}
+
+func (s pgStore) Rules() (ruleBackend, error) {
+	return &pgRules{db: s.db}, nil
+}
+
+func (s pgStore) Pins() (pinBackend, error) {
+	return &pgPins{db: s.db}, nil
+}
+
+func (s pgStore) Watched() (watchBackend, error) {
+	return &pgWatched{db: s.db}, nil
+}
+
+func (s pgStore) Posted() (postedBackend, error) {
+	return &pgPosted{db: s.db}, nil
+}
+
+func (s pgStore) Clicks() (clickBackend, error) {
+	return &pgClicks{db: s.db}, nil
+}
+
+func (s pgStore) APIUsage() (usageBackend, error) {
+	return &pgUsage{db: s.db}, nil
+}
+
+func (s pgStore) Lease() (leader.Backend, error) {
+	return &pgLease{db: s.db}, nil
+}
+
+func (s pgStore) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sort"
+)
+
+// The story pipeline: the top item ids are fetched in batches, every item
+// goes through filter.keep and filtered out items are backfilled from the
+// next ids. All the lists of stories (the front page, the history snapshots,
+// the JSON and GraphQL APIs) go through getTopStories, so they always have
+// numStories stories unless HN runs out of them, the scan limit is hit or
+// the deadline of the fetch passes, in which case the stories found so far
+// are used.
+
+// getStories gets all items with id in ids from HN API and returns the ones
+// kept by f, in the same order as ids. Items that fail to load are treated
+// like filtered out items. offset is the position of ids[0] in the list of
+// top items and is used to compute the rank of each story.
+func getStories(ctx context.Context, ids []int, offset int, client StoryProvider, concurrency int, f filter) []item {
+	return appendStories(ctx, make([]item, 0, len(ids)), ids, offset, client, concurrency, f)
+}
+
+// appendStories is like getStories, but appends the stories to dst so that
+// the batches of getTopStories fill a single slice.
+func appendStories(ctx context.Context, dst []item, ids []int, offset int, client StoryProvider, concurrency int, f filter) []item {
+	hnItems, _ := client.GetItems(ctx, ids, concurrency)
+	for i, hnItem := range hnItems {
+		itm := parseHNItem(hnItem)
+		itm.Rank = offset + i + 1
+		if f.keep(itm) {
+			dst = append(dst, itm)
+		}
+	}
+	return dst
+}
+
+// maxScanFactor bounds the backfill of filtered out stories: at most
+// maxScanFactor*numStories top items are looked at to find numStories
+// stories, so that filters dropping most stories can't make a request fetch
+// all 500 top items.
+const maxScanFactor = 5
+
+// getTopStories returns the first numStories top stories kept by f. Stories
+// that are filtered out are backfilled with the next top items, fetched in
+// batches sized after the share of stories kept so far: a filter keeping
+// half of the stories gets twice as many items fetched as stories missing.
+//
+// If the deadline of ctx passes, the stories found by then are returned, or
+// the error if there are none: items that didn't make it in time are
+// treated like filtered out ones.
+func getTopStories(ctx context.Context, client StoryProvider, numStories, concurrency int, f filter) ([]item, error) {
+	ids, err := client.TopItems()
+	if err != nil {
+		return nil, err
+	}
+	if limit := maxScanFactor * numStories; len(ids) > limit {
+		ids = ids[:limit]
+	}
+
+	idx := 0
+	stories := make([]item, 0, numStories)
+	for len(stories) < numStories && idx < len(ids) {
+		missing := numStories - len(stories)
+		batch := missing
+		switch {
+		case idx > 0 && len(stories) == 0:
+			// nothing kept so far, the next batch is twice as large
+			batch = 2 * idx
+		case idx > 0:
+			batch = (missing*idx + len(stories) - 1) / len(stories)
+		}
+		end := idx + batch
+		if end > len(ids) {
+			end = len(ids)
+		}
+		stories = appendStories(ctx, stories, ids[idx:end], idx, client, concurrency, f)
+		idx = end
+		if err := ctx.Err(); err != nil {
+			if errors.Is(err, context.DeadlineExceeded) && len(stories) > 0 {
+				break
+			}
+			return nil, err
+		}
+	}
+
+	// larger batches can overshoot
+	if len(stories) > numStories {
+		stories = stories[:numStories]
+	}
+	return stories, nil
+}
+
+// mutedBackfill is how many stories more than the front page the cached
+// lists have, making up for the stories of the users muted by a user
+const mutedBackfill = 10
+
+// fetchTopStories gets the list of top stories cached for f from client:
+// the front page kept by the shared filter, and mutedBackfill more stories.
+// The front page of a user is f.unmuted(stories, cfg.NumStories).
+func fetchTopStories(ctx context.Context, client StoryProvider, cfg config, f filter) ([]item, error) {
+	return getTopStories(ctx, client, cfg.NumStories+mutedBackfill, cfg.Concurrency, f.shared())
+}
+
+// cachedTopStories returns the top stories kept by f from the cache, getting
+// them from client first if they aren't cached or have expired.
+func cachedTopStories(ctx context.Context, client StoryProvider, cache *Cache, cfg config, f filter) ([]item, error) {
+	stories, err := cache.Fetch(ctx, f.key(), func(ctx context.Context) ([]item, error) {
+		if cfg.FetchBudget > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, cfg.FetchBudget)
+			defer cancel()
+		}
+		return fetchTopStories(ctx, client, cfg, f)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return f.unmuted(stories, cfg.NumStories), nil
+}
+
+// The orders of lists of stories
+const (
+	sortByRank     = "rank"
+	sortByScore    = "score"
+	sortByComments = "comments"
+	sortByTime     = "time"
+)
+
+var storyOrders = []string{sortByRank, sortByScore, sortByComments, sortByTime}
+
+// sortStories sorts stories by one of storyOrders, the highest score, most
+// comments or most recent first. Ties keep their rank order.
+func sortStories(stories []item, by string) {
+	var less func(a, b item) bool
+	switch by {
+	case sortByScore:
+		less = func(a, b item) bool { return a.Score > b.Score }
+	case sortByComments:
+		less = func(a, b item) bool { return a.Descendants > b.Descendants }
+	case sortByTime:
+		less = func(a, b item) bool { return a.Time > b.Time }
+	default:
+		less = func(a, b item) bool { return a.Rank < b.Rank }
+	}
+	sort.SliceStable(stories, func(i, j int) bool { return less(stories[i], stories[j]) })
+}
+
+// pageOf returns the page-th (from 1) page of n stories of stories, which
+// is empty past the last one
+func pageOf(stories []item, page, n int) []item {
+	start := (page - 1) * n
+	if start >= len(stories) {
+		return stories[:0]
+	}
+	end := start + n
+	if end > len(stories) {
+		end = len(stories)
+	}
+	return stories[start:end]
+}
@@ -0,0 +1,381 @@
+// Package storyrpc is the quiet_hn story service, giving other backend
+// services the cached and filtered front page on a listener of its own.
+//
+// The service has the calls of a gRPC one, ListStories, GetItem and
+// StreamUpdates, but it only needs the standard library: the calls are made
+// over HTTP/1.1, with JSON messages, eg
+//
+//	POST /quiet_hn.v1.Stories/ListStories HTTP/1.1
+//	Content-Type: application/x-storyrpc+json
+//
+// The request body is one message, and the response body is the messages
+// of the call: one for ListStories and GetItem, and one per update of the
+// front page for StreamUpdates, which lasts until the client hangs up. Like
+// gRPC ones, each message is prefixed with a flags byte, always zero, and
+// its length as a 4 byte big-endian integer. The status of the call is in
+// the StatusTrailer and MessageTrailer trailers of the response.
+package storyrpc
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/mmxmb/quiet_hn/hn"
+)
+
+const (
+	// ContentType is the content type of the requests and responses
+	ContentType = "application/x-storyrpc+json"
+	// StatusTrailer is the trailer of the Code of the call
+	StatusTrailer = "Storyrpc-Status"
+	// MessageTrailer is the trailer of the error message of the call
+	MessageTrailer = "Storyrpc-Message"
+	// servicePath is the prefix of the paths of the calls
+	servicePath = "/quiet_hn.v1.Stories/"
+	// maxMessage is the maximum size of a message
+	maxMessage = 4 << 20
+)
+
+// Preferences are the preferences the front page is filtered with
+type Preferences struct {
+	HideJobs      bool `json:"hide_jobs"`
+	HidePaywalled bool `json:"hide_paywalled"`
+}
+
+// ListStoriesRequest is the request of ListStories
+type ListStoriesRequest struct {
+	// Limit is the number of stories, defaulting to the number on the
+	// front page
+	Limit       int         `json:"limit,omitempty"`
+	Preferences Preferences `json:"preferences"`
+}
+
+// ListStoriesResponse is the front page, the response of ListStories and
+// the updates of StreamUpdates
+type ListStoriesResponse struct {
+	Stories []Story `json:"stories"`
+}
+
+// Story is a story of the front page
+type Story struct {
+	Item hn.Item `json:"item"`
+	// Rank is the 1-based position of the story on HN
+	Rank       int    `json:"rank"`
+	Host       string `json:"host,omitempty"`
+	Paywalled  bool   `json:"paywalled"`
+	ArchiveURL string `json:"archive_url,omitempty"`
+}
+
+// GetItemRequest is the request of GetItem
+type GetItemRequest struct {
+	ID int `json:"id"`
+}
+
+// StreamUpdatesRequest is the request of StreamUpdates
+type StreamUpdatesRequest struct {
+	Preferences Preferences `json:"preferences"`
+}
+
+// Service is the story service
+type Service interface {
+	// ListStories returns the front page, filtered like it is for users
+	// with the given preferences
+	ListStories(ctx context.Context, req *ListStoriesRequest) (*ListStoriesResponse, error)
+	// GetItem returns any item, eg a story, comment or poll
+	GetItem(ctx context.Context, req *GetItemRequest) (*hn.Item, error)
+	// StreamUpdates sends the front page with send every time it changes,
+	// until ctx is done
+	StreamUpdates(ctx context.Context, req *StreamUpdatesRequest, send func(*ListStoriesResponse) error) error
+}
+
+// Code is the status of a call
+type Code string
+
+// The codes of the calls
+const (
+	OK              Code = "ok"
+	InvalidArgument Code = "invalid_argument"
+	NotFound        Code = "not_found"
+	Unimplemented   Code = "unimplemented"
+	Unavailable     Code = "unavailable"
+	Internal        Code = "internal"
+)
+
+// Error is a call which didn't succeed. The errors of a Service which aren't
+// Errors are sent as Internal ones.
+type Error struct {
+	Code    Code
+	Message string
+}
+
+// Errorf returns an Error with the given code and formatted message
+func Errorf(code Code, format string, args ...interface{}) *Error {
+	return &Error{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+func (e *Error) Error() string {
+	return "storyrpc: " + string(e.Code) + ": " + e.Message
+}
+
+// writeMessage writes v to w as a message
+func writeMessage(w io.Writer, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	var prefix [5]byte
+	binary.BigEndian.PutUint32(prefix[1:], uint32(len(data)))
+	if _, err := w.Write(prefix[:]); err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// readMessage reads a message from r into v. It returns io.EOF if r has no
+// more messages.
+func readMessage(r io.Reader, v interface{}) error {
+	var prefix [5]byte
+	if _, err := io.ReadFull(r, prefix[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return errors.New("storyrpc: truncated message")
+		}
+		return err
+	}
+	if prefix[0] != 0 {
+		return fmt.Errorf("storyrpc: unknown message flags %#x", prefix[0])
+	}
+	n := binary.BigEndian.Uint32(prefix[1:])
+	if n > maxMessage {
+		return fmt.Errorf("storyrpc: message of %d bytes, more than %d", n, maxMessage)
+	}
+	data := make([]byte, n)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return errors.New("storyrpc: truncated message")
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("storyrpc: parsing the message: %w", err)
+	}
+	return nil
+}
+
+// Handler serves the calls of svc
+func Handler(svc Service) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method := strings.TrimPrefix(r.URL.Path, servicePath)
+		if method == r.URL.Path {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", ContentType)
+		w.Header().Set("Trailer", StatusTrailer+", "+MessageTrailer)
+		w.WriteHeader(http.StatusOK)
+		err := call(r.Context(), svc, method, http.MaxBytesReader(w, r.Body, maxMessage+5), w)
+		code, message := OK, ""
+		if err != nil {
+			var e *Error
+			if !errors.As(err, &e) {
+				e = &Error{Code: Internal, Message: err.Error()}
+			}
+			code, message = e.Code, e.Message
+		}
+		w.Header().Set(StatusTrailer, string(code))
+		w.Header().Set(MessageTrailer, message)
+	})
+}
+
+// call runs method of svc with the request read from body, writing its
+// response to w
+func call(ctx context.Context, svc Service, method string, body io.Reader, w http.ResponseWriter) error {
+	read := func(req interface{}) error {
+		if err := readMessage(body, req); err != nil {
+			if err == io.EOF {
+				return Errorf(InvalidArgument, "missing request message")
+			}
+			return Errorf(InvalidArgument, "%s", err)
+		}
+		return nil
+	}
+	switch method {
+	case "ListStories":
+		var req ListStoriesRequest
+		if err := read(&req); err != nil {
+			return err
+		}
+		resp, err := svc.ListStories(ctx, &req)
+		if err != nil {
+			return err
+		}
+		return writeMessage(w, resp)
+	case "GetItem":
+		var req GetItemRequest
+		if err := read(&req); err != nil {
+			return err
+		}
+		resp, err := svc.GetItem(ctx, &req)
+		if err != nil {
+			return err
+		}
+		return writeMessage(w, resp)
+	case "StreamUpdates":
+		var req StreamUpdatesRequest
+		if err := read(&req); err != nil {
+			return err
+		}
+		flusher, _ := w.(http.Flusher)
+		return svc.StreamUpdates(ctx, &req, func(resp *ListStoriesResponse) error {
+			if err := writeMessage(w, resp); err != nil {
+				return err
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+			return nil
+		})
+	}
+	return Errorf(Unimplemented, "unknown method %q", method)
+}
+
+// Client calls the story service at URL, eg http://127.0.0.1:3002
+type Client struct {
+	URL string
+	// HTTPClient defaults to http.DefaultClient
+	HTTPClient *http.Client
+	// Header is added to the requests, eg for the Authorization of an API
+	// key
+	Header http.Header
+}
+
+// ListStories calls ListStories
+func (c *Client) ListStories(ctx context.Context, req *ListStoriesRequest) (*ListStoriesResponse, error) {
+	var resp ListStoriesResponse
+	if err := c.unary(ctx, "ListStories", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// GetItem calls GetItem
+func (c *Client) GetItem(ctx context.Context, req *GetItemRequest) (*hn.Item, error) {
+	var resp hn.Item
+	if err := c.unary(ctx, "GetItem", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// StreamUpdates calls StreamUpdates, whose updates are received from the
+// stream returned until ctx is done or the stream is closed
+func (c *Client) StreamUpdates(ctx context.Context, req *StreamUpdatesRequest) (*UpdateStream, error) {
+	s, err := c.start(ctx, "StreamUpdates", req)
+	if err != nil {
+		return nil, err
+	}
+	return &UpdateStream{s}, nil
+}
+
+func (c *Client) unary(ctx context.Context, method string, req, resp interface{}) error {
+	s, err := c.start(ctx, method, req)
+	if err != nil {
+		return err
+	}
+	defer s.close()
+	if err := s.recv(resp); err != nil {
+		if err == io.EOF {
+			return errors.New("storyrpc: missing response message")
+		}
+		return err
+	}
+	return nil
+}
+
+// start makes the request of a call
+func (c *Client) start(ctx context.Context, method string, req interface{}) (*stream, error) {
+	var body strings.Builder
+	if err := writeMessage(&body, req); err != nil {
+		return nil, err
+	}
+	hreq, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(c.URL, "/")+servicePath+method, strings.NewReader(body.String()))
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range c.Header {
+		hreq.Header[name] = values
+	}
+	hreq.Header.Set("Content-Type", ContentType)
+	client := c.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(hreq)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 1<<16))
+		resp.Body.Close()
+		return nil, fmt.Errorf("storyrpc: %s", resp.Status)
+	}
+	return &stream{resp: resp, body: bufio.NewReader(resp.Body)}, nil
+}
+
+// stream is the response of a call
+type stream struct {
+	resp *http.Response
+	body *bufio.Reader
+}
+
+// recv reads the next message of the response into v. At the end of the
+// response, it returns io.EOF if the call succeeded and its Error otherwise.
+func (s *stream) recv(v interface{}) error {
+	err := readMessage(s.body, v)
+	if err != io.EOF {
+		return err
+	}
+	// the trailers are read with the end of the body
+	code := Code(s.resp.Trailer.Get(StatusTrailer))
+	switch code {
+	case OK:
+		return io.EOF
+	case "":
+		return errors.New("storyrpc: the response has no status")
+	}
+	return &Error{Code: code, Message: s.resp.Trailer.Get(MessageTrailer)}
+}
+
+func (s *stream) close() error {
+	return s.resp.Body.Close()
+}
+
+// UpdateStream is the stream of updates of StreamUpdates
+type UpdateStream struct {
+	s *stream
+}
+
+// Recv returns the next update, or an error once the stream ends: io.EOF if
+// the server ended it, an Error if the call failed
+func (u *UpdateStream) Recv() (*ListStoriesResponse, error) {
+	var resp ListStoriesResponse
+	if err := u.s.recv(&resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Close ends the stream
+func (u *UpdateStream) Close() error {
+	return u.s.close()
+}
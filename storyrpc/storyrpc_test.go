@@ -0,0 +1,110 @@
+package storyrpc
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mmxmb/quiet_hn/hn"
+)
+
+// fakeService has the stories 1 to 3 and streams two updates
+type fakeService struct{}
+
+func (fakeService) ListStories(ctx context.Context, req *ListStoriesRequest) (*ListStoriesResponse, error) {
+	var resp ListStoriesResponse
+	for id := 1; id <= 3 && (req.Limit == 0 || id <= req.Limit); id++ {
+		resp.Stories = append(resp.Stories, Story{Item: hn.Item{ID: id, Title: "Story"}, Rank: id})
+	}
+	return &resp, nil
+}
+
+func (fakeService) GetItem(ctx context.Context, req *GetItemRequest) (*hn.Item, error) {
+	if req.ID != 1 {
+		return nil, Errorf(NotFound, "no item %d", req.ID)
+	}
+	return &hn.Item{ID: 1, Title: "Story"}, nil
+}
+
+func (s fakeService) StreamUpdates(ctx context.Context, req *StreamUpdatesRequest, send func(*ListStoriesResponse) error) error {
+	for limit := 1; limit <= 2; limit++ {
+		resp, _ := s.ListStories(ctx, &ListStoriesRequest{Limit: limit})
+		if err := send(resp); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestClient(t *testing.T) {
+	srv := httptest.NewServer(Handler(fakeService{}))
+	defer srv.Close()
+	c := &Client{URL: srv.URL}
+	ctx := context.Background()
+
+	resp, err := c.ListStories(ctx, &ListStoriesRequest{Limit: 2})
+	if err != nil {
+		t.Fatalf("ListStories() received an error: %s", err)
+	}
+	if len(resp.Stories) != 2 || resp.Stories[1].Item.ID != 2 || resp.Stories[1].Rank != 2 {
+		t.Errorf("ListStories(): want stories 1 and 2, got %+v", resp.Stories)
+	}
+
+	if itm, err := c.GetItem(ctx, &GetItemRequest{ID: 1}); err != nil || itm.ID != 1 {
+		t.Errorf("GetItem(1): want item 1, got %+v, %v", itm, err)
+	}
+	_, err = c.GetItem(ctx, &GetItemRequest{ID: 2})
+	var e *Error
+	if !errors.As(err, &e) || e.Code != NotFound || e.Message != "no item 2" {
+		t.Errorf("GetItem(2): want a not_found error, got %v", err)
+	}
+
+	stream, err := c.StreamUpdates(ctx, &StreamUpdatesRequest{})
+	if err != nil {
+		t.Fatalf("StreamUpdates() received an error: %s", err)
+	}
+	defer stream.Close()
+	for want := 1; want <= 2; want++ {
+		update, err := stream.Recv()
+		if err != nil || len(update.Stories) != want {
+			t.Fatalf("update %d: want %d stories, got %+v, %v", want, want, update, err)
+		}
+	}
+	if _, err := stream.Recv(); err != io.EOF {
+		t.Errorf("Recv() at the end of the stream: want io.EOF, got %v", err)
+	}
+}
+
+func TestHandler_errors(t *testing.T) {
+	srv := httptest.NewServer(Handler(fakeService{}))
+	defer srv.Close()
+
+	var e *Error
+	if err := (&Client{URL: srv.URL}).unary(context.Background(), "Reboot", struct{}{}, &struct{}{}); !errors.As(err, &e) || e.Code != Unimplemented {
+		t.Errorf("unknown method: want an unimplemented error, got %v", err)
+	}
+
+	resp, err := http.Post(srv.URL+servicePath+"GetItem", ContentType, strings.NewReader("{}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	if code := resp.Trailer.Get(StatusTrailer); code != string(InvalidArgument) {
+		t.Errorf("request without message prefix: want status %s, got %q", InvalidArgument, code)
+	}
+
+	resp, err = http.Get(srv.URL + servicePath + "GetItem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("GET: want status code %d, got %d", http.StatusMethodNotAllowed, resp.StatusCode)
+	}
+}
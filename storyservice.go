@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/mmxmb/quiet_hn/hn"
+	"github.com/mmxmb/quiet_hn/storyrpc"
+)
+
+// storyUpdatesInterval is how often StreamUpdates looks for changes of the
+// front page, which come from the cache
+const storyUpdatesInterval = 30 * time.Second
+
+// storyService implements the story service of the stories listeners on top
+// of the front page cache
+type storyService struct {
+	client StoryProvider
+	cache  *Cache
+	cfg    config
+	// poll is how often StreamUpdates looks for changes
+	poll time.Duration
+}
+
+func newStoryService(client StoryProvider, cache *Cache, cfg config) *storyService {
+	return &storyService{client: client, cache: cache, cfg: cfg, poll: storyUpdatesInterval}
+}
+
+// stories returns the front page filtered with prefs, up to limit stories
+func (s *storyService) stories(ctx context.Context, prefs storyrpc.Preferences, limit int) (*storyrpc.ListStoriesResponse, error) {
+	fprefs := s.cfg.Defaults
+	fprefs.HideJobs, fprefs.HidePaywalled = prefs.HideJobs, prefs.HidePaywalled
+	stories, err := cachedTopStories(ctx, s.client, s.cache, s.cfg, newFilter(s.cfg, fprefs))
+	if err != nil {
+		return nil, storyrpc.Errorf(storyrpc.Unavailable, "failed to load the top stories")
+	}
+	if limit < len(stories) {
+		stories = stories[:limit]
+	}
+	s.cfg.Archive.decorate(stories)
+	markPaywalled(stories, s.cfg.PaywallDomains)
+	resp := &storyrpc.ListStoriesResponse{Stories: make([]storyrpc.Story, len(stories))}
+	for i, story := range stories {
+		resp.Stories[i] = storyrpc.Story{
+			Item:       story.Item,
+			Rank:       story.Rank,
+			Host:       story.Host,
+			Paywalled:  story.Paywalled,
+			ArchiveURL: story.ArchiveURL,
+		}
+	}
+	return resp, nil
+}
+
+// ListStories implements storyrpc.Service
+func (s *storyService) ListStories(ctx context.Context, req *storyrpc.ListStoriesRequest) (*storyrpc.ListStoriesResponse, error) {
+	limit := req.Limit
+	if limit == 0 {
+		limit = s.cfg.NumStories
+	}
+	if limit < 0 || limit > s.cfg.NumStories {
+		return nil, storyrpc.Errorf(storyrpc.InvalidArgument, "limit must be between 1 and %d", s.cfg.NumStories)
+	}
+	return s.stories(ctx, req.Preferences, limit)
+}
+
+// GetItem implements storyrpc.Service
+func (s *storyService) GetItem(ctx context.Context, req *storyrpc.GetItemRequest) (*hn.Item, error) {
+	if req.ID <= 0 {
+		return nil, storyrpc.Errorf(storyrpc.InvalidArgument, "invalid item id %d", req.ID)
+	}
+	hnItem, err := s.client.GetItem(req.ID)
+	if err != nil {
+		return nil, storyrpc.Errorf(storyrpc.Unavailable, "failed to load the item")
+	}
+	if !hnItem.Alive() {
+		return nil, storyrpc.Errorf(storyrpc.NotFound, "item %d not found", req.ID)
+	}
+	return &hnItem, nil
+}
+
+// StreamUpdates implements storyrpc.Service, sending the front page when its
+// stories or their order change. The front page failing to load doesn't end
+// the stream, it is tried again at the next poll.
+func (s *storyService) StreamUpdates(ctx context.Context, req *storyrpc.StreamUpdatesRequest, send func(*storyrpc.ListStoriesResponse) error) error {
+	ticker := time.NewTicker(s.poll)
+	defer ticker.Stop()
+	var sent []int
+	for {
+		if resp, err := s.stories(ctx, req.Preferences, s.cfg.NumStories); err == nil {
+			ids := make([]int, len(resp.Stories))
+			for i, story := range resp.Stories {
+				ids[i] = story.Item.ID
+			}
+			if sent == nil || !equalIDs(ids, sent) {
+				if err := send(resp); err != nil {
+					return err
+				}
+				sent = ids
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// equalIDs reports whether a and b are the same IDs in the same order
+func equalIDs(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/mmxmb/quiet_hn/hn"
+	"github.com/mmxmb/quiet_hn/storyrpc"
+)
+
+func TestStoryService(t *testing.T) {
+	p := newFakeProvider(4)
+	p.items[2] = hn.Item{ID: 2, Title: "Acme is hiring", Type: "job", URL: "https://example.com/jobs"}
+	cfg := config{NumStories: 3, Concurrency: 2}
+	srv := httptest.NewServer(storyrpc.Handler(newStoryService(p, &Cache{ExpirationDuration: time.Minute}, cfg)))
+	defer srv.Close()
+	c := &storyrpc.Client{URL: srv.URL}
+	ctx := context.Background()
+
+	resp, err := c.ListStories(ctx, &storyrpc.ListStoriesRequest{Limit: 2, Preferences: storyrpc.Preferences{HideJobs: true}})
+	if err != nil {
+		t.Fatalf("ListStories() received an error: %s", err)
+	}
+	if len(resp.Stories) != 2 || resp.Stories[0].Item.ID != 1 || resp.Stories[1].Item.ID != 3 || resp.Stories[1].Rank != 3 || resp.Stories[1].Host != "example.com" {
+		t.Errorf("ListStories() hiding jobs: want stories 1 and 3, got %+v", resp.Stories)
+	}
+	var e *storyrpc.Error
+	if _, err := c.ListStories(ctx, &storyrpc.ListStoriesRequest{Limit: 4}); !errors.As(err, &e) || e.Code != storyrpc.InvalidArgument {
+		t.Errorf("ListStories() of more stories than the front page has: want an invalid_argument error, got %v", err)
+	}
+
+	if itm, err := c.GetItem(ctx, &storyrpc.GetItemRequest{ID: 4}); err != nil || itm.Title != "Story 4" {
+		t.Errorf("GetItem(4): want story 4, got %+v, %v", itm, err)
+	}
+	p.items[5] = hn.Item{ID: 5, Deleted: true}
+	if _, err := c.GetItem(ctx, &storyrpc.GetItemRequest{ID: 5}); !errors.As(err, &e) || e.Code != storyrpc.NotFound {
+		t.Errorf("GetItem() of a deleted item: want a not_found error, got %v", err)
+	}
+}
+
+func TestStoryService_StreamUpdates(t *testing.T) {
+	p := newFakeProvider(3)
+	cache := &Cache{ExpirationDuration: time.Minute}
+	s := newStoryService(p, cache, config{NumStories: 3, Concurrency: 2})
+	s.poll = time.Millisecond
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var updates [][]int
+	err := s.StreamUpdates(ctx, &storyrpc.StreamUpdatesRequest{}, func(resp *storyrpc.ListStoriesResponse) error {
+		var ids []int
+		for _, story := range resp.Stories {
+			ids = append(ids, story.Item.ID)
+		}
+		updates = append(updates, ids)
+		if len(updates) == 1 {
+			// the polls until the front page changes send nothing
+			p.ids = []int{3, 1, 2}
+			cache.Purge("")
+		} else {
+			cancel()
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("StreamUpdates() received an error: %s", err)
+	}
+	if len(updates) != 2 || !equalIDs(updates[0], []int{1, 2, 3}) || !equalIDs(updates[1], []int{3, 1, 2}) {
+		t.Errorf("updates: want [1 2 3] then [3 1 2], got %v", updates)
+	}
+}
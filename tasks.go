@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/mmxmb/quiet_hn/history"
+)
+
+// tasks are the maintenance tasks which may be run on demand by the hooks
+// or on a schedule. poster is nil if no sinks are configured, and hist if
+// the history is disabled.
+type tasks struct {
+	client    StoryProvider
+	cache     *Cache
+	cfg       config
+	poster    *crossPoster
+	hist      *history.Store
+	retention retention
+}
+
+// errNoHistory is the error of the tasks needing the history when it is
+// disabled
+var errNoHistory = errors.New("the history is disabled")
+
+// taskActions are the tasks by name, which run with the given parameters
+// and return a summary of what they did
+var taskActions = map[string]func(t *tasks, ctx context.Context, params url.Values) (string, error){
+	// refresh fetches the front page for the default preferences again
+	"refresh": func(t *tasks, ctx context.Context, params url.Values) (string, error) {
+		f := newFilter(t.cfg, t.cfg.Defaults)
+		stories, err := fetchTopStories(ctx, t.client, t.cfg, f)
+		if err != nil {
+			return "", err
+		}
+		t.cache.Set(f.key(), stories)
+		return fmt.Sprintf("fetched %d stories", len(f.unmuted(stories, t.cfg.NumStories))), nil
+	},
+	// purge removes the stories cached for the key parameter, or all of them
+	"purge": func(t *tasks, ctx context.Context, params url.Values) (string, error) {
+		n := t.cache.Purge(params.Get("key"))
+		return fmt.Sprintf("purged %d lists", n), nil
+	},
+	// post runs a round of the cross-poster now
+	"post": func(t *tasks, ctx context.Context, params url.Values) (string, error) {
+		if t.poster == nil {
+			return "", errors.New("no sinks are configured")
+		}
+		f := newFilter(t.cfg, t.cfg.Defaults)
+		stories, err := cachedTopStories(ctx, t.client, t.cache, t.cfg, f)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("made %d posts", t.poster.post(ctx, stories, time.Now())), nil
+	},
+	// snapshot records the front page to the history now
+	"snapshot": func(t *tasks, ctx context.Context, params url.Values) (string, error) {
+		if t.hist == nil {
+			return "", errNoHistory
+		}
+		now := time.Now()
+		f := newFilter(t.cfg, t.cfg.Defaults)
+		stories, err := fetchTopStories(ctx, t.client, t.cfg, f)
+		if err != nil {
+			return "", err
+		}
+		t.cache.Set(f.key(), stories)
+		stories = f.unmuted(stories, t.cfg.NumStories)
+		if err := recordSnapshot(t.hist, stories, now); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("recorded %d stories", len(stories)), nil
+	},
+	// prune drops the snapshots beyond the retention, which the days and
+	// max parameters override, and compacts the history file
+	"prune": func(t *tasks, ctx context.Context, params url.Values) (string, error) {
+		if t.hist == nil {
+			return "", errNoHistory
+		}
+		ret := t.retention
+		for name, v := range map[string]*int{"days": &ret.Days, "max": &ret.MaxSnapshots} {
+			if s := params.Get(name); s != "" {
+				n, err := strconv.Atoi(s)
+				if err != nil || n < 0 {
+					return "", fmt.Errorf("bad %s parameter %q", name, s)
+				}
+				*v = n
+			}
+		}
+		var before time.Time
+		if ret.Days > 0 {
+			before = time.Now().AddDate(0, 0, -ret.Days)
+		}
+		pruned := t.hist.Prune(before, ret.MaxSnapshots)
+		compacted, err := t.hist.Compact()
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("pruned %d snapshots, compacted %d", pruned, compacted), nil
+	},
+}
@@ -0,0 +1,389 @@
+package main
+
+import (
+	"bytes"
+	"embed"
+	"errors"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mmxmb/quiet_hn/i18n"
+	"github.com/mmxmb/quiet_hn/sanitize"
+)
+
+// pageNames are the names of the page templates. Each page is rendered with
+// layout.gohtml, filling in its "content" template and optionally its
+// "title", "head", "style" and "footer" templates, or with print.gohtml for
+// the print variant of the page, which leaves out the footer.
+var pageNames = []string{"index", "item", "read", "user", "following", "best", "hiring", "error", "admin", "search", "domains"}
+
+//go:embed templates/*.gohtml
+var embeddedTemplates embed.FS
+
+//go:embed locales/*.json
+var embeddedLocales embed.FS
+
+// loadMessages returns the catalogs of the UI embedded in the binary. The
+// messages of the catalogs in overrideDir, if set, replace the embedded ones
+// with the same language and key, and its other catalogs add languages.
+func loadMessages(overrideDir, defaultLang string) (*i18n.Bundle, error) {
+	fsys, err := fs.Sub(embeddedLocales, "locales")
+	if err != nil {
+		return nil, err
+	}
+	if overrideDir == "" {
+		return i18n.LoadFS(defaultLang, fsys)
+	}
+	return i18n.LoadFS(defaultLang, fsys, os.DirFS(overrideDir))
+}
+
+// templateSet holds the parsed page templates
+type templateSet struct {
+	pages map[string]*template.Template
+	// printPages are the pages parsed with the print layout
+	printPages map[string]*template.Template
+	messages   *i18n.Bundle
+	// Minify strips the comments and indentation of the rendered pages
+	Minify bool
+}
+
+// loadTemplates parses the page templates embedded in the binary. Templates
+// in overrideDir, if set, replace the embedded ones with the same file name,
+// so operators can customize any of the pages (or the layout) without
+// touching the others.
+func loadTemplates(messages *i18n.Bundle, overrideDir string) (*templateSet, error) {
+	fsys, err := fs.Sub(embeddedTemplates, "templates")
+	if err != nil {
+		return nil, err
+	}
+	if overrideDir != "" {
+		fsys = overlayFS{upper: os.DirFS(overrideDir), lower: fsys}
+	}
+
+	ts := &templateSet{messages: messages}
+	if ts.pages, err = parsePages(fsys, messages, "layout.gohtml"); err != nil {
+		return nil, err
+	}
+	if ts.printPages, err = parsePages(fsys, messages, "print.gohtml"); err != nil {
+		return nil, err
+	}
+	return ts, nil
+}
+
+// parsePages parses the page templates of fsys with the layout of the file
+// layoutFile
+func parsePages(fsys fs.FS, messages *i18n.Bundle, layoutFile string) (map[string]*template.Template, error) {
+	layout, err := fs.ReadFile(fsys, layoutFile)
+	if err != nil {
+		return nil, err
+	}
+	pages := make(map[string]*template.Template, len(pageNames))
+	for _, name := range pageNames {
+		page, err := fs.ReadFile(fsys, name+".gohtml")
+		if err != nil {
+			return nil, err
+		}
+		// the layout is parsed first so that the pages can redefine its blocks
+		tpl := template.New(name).Funcs(templateFuncs(messages))
+		if _, err := tpl.New("layout").Parse(string(layout)); err != nil {
+			return nil, fmt.Errorf("parsing %s: %w", layoutFile, err)
+		}
+		if _, err := tpl.Parse(string(page)); err != nil {
+			return nil, fmt.Errorf("parsing %s.gohtml: %w", name, err)
+		}
+		pages[name] = tpl
+	}
+	return pages, nil
+}
+
+// print returns the set rendering the print variant of the pages of ts:
+// without their navigation, controls and footer, for printing or reading
+func (ts *templateSet) print() *templateSet {
+	return &templateSet{pages: ts.printPages, printPages: ts.printPages, messages: ts.messages, Minify: ts.Minify}
+}
+
+// renderBuffers are the buffers the pages are rendered into
+var renderBuffers = sync.Pool{New: func() interface{} { return new(bytes.Buffer) }}
+
+// maxPooledBuffer is the size past which a buffer is dropped rather than
+// pooled, so that a single huge page doesn't pin its memory forever
+const maxPooledBuffer = 1 << 20
+
+// releaseBuffer returns buf to renderBuffers
+func releaseBuffer(buf *bytes.Buffer) {
+	if buf.Cap() <= maxPooledBuffer {
+		buf.Reset()
+		renderBuffers.Put(buf)
+	}
+}
+
+// execute renders the page name with data to w, with status if it isn't
+// zero.
+//
+// Pages are rendered into a buffer before anything is written: holding the
+// page in memory costs a copy and delays the first byte, but a template
+// error can still be answered with a proper error status instead of a 200
+// with half a page, and the response gets a Content-Length, sparing clients
+// the chunked encoding.
+func (ts *templateSet) execute(w http.ResponseWriter, status int, name string, data interface{}) error {
+	buf := renderBuffers.Get().(*bytes.Buffer)
+	defer func() { releaseBuffer(buf) }()
+	if err := ts.pages[name].Execute(buf, data); err != nil {
+		return err
+	}
+	if ts.Minify {
+		min := renderBuffers.Get().(*bytes.Buffer)
+		minifyHTML(min, buf.Bytes())
+		releaseBuffer(buf)
+		buf = min
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+	if status != 0 {
+		w.WriteHeader(status)
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// render renders the page name with data to w. Nothing is written if it
+// fails, so the caller can respond with an error instead.
+func (ts *templateSet) render(w http.ResponseWriter, name string, data interface{}) error {
+	return ts.execute(w, 0, name, data)
+}
+
+// renderError responds to r with the error page for status. message is the
+// key of the message explaining the error, formatted with args.
+func (ts *templateSet) renderError(w http.ResponseWriter, r *http.Request, status int, message string, args ...interface{}) {
+	lang := languagePref(w, r, ts.messages)
+	data := errorTemplateData{
+		Lang:       lang,
+		Status:     status,
+		StatusText: http.StatusText(status),
+		Message:    ts.messages.Translate(lang, message, args...),
+	}
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if err := ts.execute(w, status, "error", data); err != nil {
+		// a broken error page mustn't hide the error
+		http.Error(w, data.Message, status)
+	}
+}
+
+type errorTemplateData struct {
+	Lang       string
+	Status     int
+	StatusText string
+	Message    string
+	Time       time.Duration
+}
+
+// overlayFS is a file system reading files from upper if they exist there and
+// from lower otherwise
+type overlayFS struct {
+	upper, lower fs.FS
+}
+
+func (o overlayFS) Open(name string) (fs.File, error) {
+	f, err := o.upper.Open(name)
+	if err == nil {
+		return f, nil
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	return o.lower.Open(name)
+}
+
+// templateFuncs returns the functions available to all templates. They are
+// part of the contract with users who override the templates, so their names
+// and arguments must not change:
+//
+//	t LANG KEY ARGS...     translates the message KEY to LANG
+//	tn LANG KEY N          translates the message KEY to LANG in the form matching N
+//	localtime UNIX TZ      formats the Unix time UNIX in the timezone TZ
+//	isotime UNIX           formats the Unix time UNIX for <time datetime="...">
+//	timeago UNIX LANG      describes how long ago UNIX was in LANG, eg "3 hours ago"
+//	comma N                formats N with thousands separators, eg "12,345"
+//	pluralize N ONE OTHER  returns "N ONE" if N is 1 and "N OTHER" otherwise
+//	truncate N S           shortens S to at most N characters, ending with "…"
+//	join LIST SEP          joins the strings of LIST with SEP, eg "go, rust"
+//	domain HOST            wraps the registrable domain of HOST in <b>, eg "blog.<b>example.com</b>"
+//	commentData C LANG TZ [VIEW]  the data of the "comment" template of item pages for the comment C,
+//	                       with VIEW the comments collapsed and searched on the page
+//	highlight QUERY HTML   wraps the occurrences of QUERY in the HTML of text in <mark>
+//
+// truncate takes the string last so it can be used in pipelines:
+// {{.Title | truncate 80}}.
+func templateFuncs(messages *i18n.Bundle) template.FuncMap {
+	return template.FuncMap{
+		"t":         messages.Translate,
+		"tn":        messages.Plural,
+		"localtime": localTime,
+		"isotime":   isoTime,
+		"timeago": func(unix int, lang string) string {
+			return timeAgo(messages, time.Unix(int64(unix), 0), time.Now(), lang)
+		},
+		"comma":     comma,
+		"pluralize": pluralize,
+		"truncate":  truncate,
+		"join":      strings.Join,
+		"domain":    highlightDomain,
+		"commentData": func(c *comment, lang, tz string, view ...threadView) commentTemplateData {
+			data := commentTemplateData{Comment: c, Lang: lang, TZ: tz}
+			if len(view) > 0 {
+				data.View = view[0]
+			}
+			return data
+		},
+		"highlight": func(query string, s template.HTML) template.HTML {
+			return template.HTML(highlightMatches(string(s), query))
+		},
+		"version": func() string { return version },
+		"text": func(s string) template.HTML {
+			return template.HTML(hnText.HTML(s))
+		},
+	}
+}
+
+// hnText sanitizes the HTML of texts and comments, pointing their links to
+// HN items and users to the pages of the site
+var hnText = &sanitize.Sanitizer{RewriteLink: localLink}
+
+// localLink returns the path of the page of the site showing the HN page u,
+// if any
+func localLink(u *url.URL) string {
+	if u.Host != "news.ycombinator.com" {
+		return ""
+	}
+	id := u.Query().Get("id")
+	switch {
+	case id == "":
+		return ""
+	case u.Path == "/item":
+		if _, err := strconv.Atoi(id); err == nil {
+			return "/item/" + id
+		}
+	case u.Path == "/user":
+		return "/user/" + url.PathEscape(id)
+	}
+	return ""
+}
+
+// timeAgo describes the time elapsed between t and now in lang, in the
+// largest unit that fits
+func timeAgo(messages *i18n.Bundle, t, now time.Time, lang string) string {
+	d := now.Sub(t)
+	if d < time.Minute {
+		return messages.Translate(lang, "just_now")
+	}
+	units := []struct {
+		key string
+		d   time.Duration
+	}{
+		{"years", 365 * 24 * time.Hour},
+		{"months", 30 * 24 * time.Hour},
+		{"days", 24 * time.Hour},
+		{"hours", time.Hour},
+		{"minutes", time.Minute},
+	}
+	for _, unit := range units {
+		if n := int(d / unit.d); n >= 1 {
+			return messages.Translate(lang, "ago", messages.Plural(lang, unit.key, n))
+		}
+	}
+	return messages.Translate(lang, "just_now")
+}
+
+func comma(n int) string {
+	s := strconv.Itoa(n)
+	sign := ""
+	if n < 0 {
+		sign, s = "-", s[1:]
+	}
+	var b strings.Builder
+	for i, c := range s {
+		if i > 0 && (len(s)-i)%3 == 0 {
+			b.WriteByte(',')
+		}
+		b.WriteRune(c)
+	}
+	return sign + b.String()
+}
+
+// truncate shortens s to at most n runes, ending it with an ellipsis if it
+// had to be cut
+func truncate(n int, s string) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	return string(runes[:n-1]) + "…"
+}
+
+func pluralize(n int, one, other string) string {
+	if n == 1 {
+		return fmt.Sprintf("%d %s", n, one)
+	}
+	return fmt.Sprintf("%d %s", n, other)
+}
+
+// highlightDomain returns host with its registrable domain in bold
+func highlightDomain(host string) template.HTML {
+	domain := registrableDomain(host)
+	prefix := strings.TrimSuffix(host, domain)
+	return template.HTML(template.HTMLEscapeString(prefix) + "<b>" + template.HTMLEscapeString(domain) + "</b>")
+}
+
+// registrableDomain guesses the part of host that was registered with a
+// registrar, eg "example.co.uk" for "blog.example.co.uk". Without a public
+// suffix list this is a heuristic: second level labels of two or three
+// letters under a country code ("co.uk", "com.au") are treated as public
+// suffixes.
+func registrableDomain(host string) string {
+	labels := strings.Split(host, ".")
+	n := 2
+	if len(labels) > 2 && len(labels[len(labels)-1]) == 2 && len(labels[len(labels)-2]) <= 3 {
+		n = 3
+	}
+	if len(labels) <= n {
+		return host
+	}
+	return strings.Join(labels[len(labels)-n:], ".")
+}
+
+// locations caches the timezones loaded by loadLocation, since
+// time.LoadLocation reads the timezone database on every call
+var locations sync.Map
+
+// loadLocation is time.LoadLocation with caching
+func loadLocation(name string) (*time.Location, error) {
+	if loc, ok := locations.Load(name); ok {
+		return loc.(*time.Location), nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, err
+	}
+	locations.Store(name, loc)
+	return loc, nil
+}
+
+func localTime(unix int, tz string) string {
+	loc, err := loadLocation(tz)
+	if err != nil {
+		loc = time.UTC
+	}
+	return time.Unix(int64(unix), 0).In(loc).Format("2006-01-02 15:04 MST")
+}
+
+func isoTime(unix int) string {
+	return time.Unix(int64(unix), 0).UTC().Format(time.RFC3339)
+}
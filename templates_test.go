@@ -0,0 +1,157 @@
+package main
+
+import (
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTimeAgo(t *testing.T) {
+	messages := testMessages(t)
+	now := time.Date(2020, 1, 10, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		t    time.Time
+		lang string
+		want string
+	}{
+		{now.Add(-30 * time.Second), "en", "just now"},
+		{now.Add(-1 * time.Minute), "en", "1 minute ago"},
+		{now.Add(-150 * time.Minute), "en", "2 hours ago"},
+		{now.Add(-49 * time.Hour), "de", "vor 2 Tagen"},
+		{now.Add(-400 * 24 * time.Hour), "en", "1 year ago"},
+	}
+	for _, tc := range tests {
+		if got := timeAgo(messages, tc.t, now, tc.lang); got != tc.want {
+			t.Errorf("timeAgo(%s): want %q, got %q", now.Sub(tc.t), tc.want, got)
+		}
+	}
+}
+
+func TestComma(t *testing.T) {
+	tests := map[int]string{0: "0", 999: "999", 1000: "1,000", 123456: "123,456", 1234567: "1,234,567", -1234: "-1,234"}
+	for n, want := range tests {
+		if got := comma(n); got != want {
+			t.Errorf("comma(%d): want %q, got %q", n, want, got)
+		}
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	if got := truncate(5, "short"); got != "short" {
+		t.Errorf("truncate(5, short): want %q, got %q", "short", got)
+	}
+	if got := truncate(4, "héllo"); got != "hél…" {
+		t.Errorf("truncate(4, héllo): want %q, got %q", "hél…", got)
+	}
+}
+
+func TestHighlightDomain(t *testing.T) {
+	tests := map[string]template.HTML{
+		"example.com":        "<b>example.com</b>",
+		"blog.example.com":   "blog.<b>example.com</b>",
+		"news.bbc.co.uk":     "news.<b>bbc.co.uk</b>",
+		"localhost":          "<b>localhost</b>",
+		"x<y>.example.com":   "x&lt;y&gt;.<b>example.com</b>",
+		"joe.blogspot.co.jp": "joe.<b>blogspot.co.jp</b>",
+	}
+	for host, want := range tests {
+		if got := highlightDomain(host); got != want {
+			t.Errorf("highlightDomain(%s): want %q, got %q", host, want, got)
+		}
+	}
+}
+
+func TestLoadTemplates_overrideDir(t *testing.T) {
+	dir := t.TempDir()
+	page := `{{template "layout" .}}{{define "content"}}<p>Custom error page: {{.Message}}</p>{{end}}`
+	if err := os.WriteFile(filepath.Join(dir, "error.gohtml"), []byte(page), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tpls, err := loadTemplates(testMessages(t), dir)
+	if err != nil {
+		t.Fatalf("loadTemplates() received an error: %s", err.Error())
+	}
+
+	rec := httptest.NewRecorder()
+	tpls.renderError(rec, httptest.NewRequest("GET", "/", nil), 404, "error.not_found")
+	if body := rec.Body.String(); !strings.Contains(body, "Custom error page: There is nothing here.") {
+		t.Errorf("the error page was not overridden: %s", body)
+	}
+
+	rec = httptest.NewRecorder()
+	if err := tpls.render(rec, "user", userTemplateData{Lang: "en"}); err != nil {
+		t.Errorf("rendering a page that isn't overridden received an error: %s", err.Error())
+	}
+}
+
+func TestTemplateSet_render_error(t *testing.T) {
+	dir := t.TempDir()
+	// index fails halfway, calling a method that doesn't exist
+	page := `{{template "layout" .}}{{define "content"}}<p>Before</p>{{.Missing}}{{end}}`
+	if err := os.WriteFile(filepath.Join(dir, "index.gohtml"), []byte(page), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tpls, err := loadTemplates(testMessages(t), dir)
+	if err != nil {
+		t.Fatalf("loadTemplates() received an error: %s", err.Error())
+	}
+
+	rec := httptest.NewRecorder()
+	if err := tpls.render(rec, "index", templateData{Lang: "en"}); err == nil {
+		t.Fatalf("render() of a broken template: want an error")
+	}
+	if rec.Body.Len() != 0 || rec.Header().Get("Content-Type") != "" {
+		t.Errorf("render() of a broken template wrote a partial page: %q", rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	if err := tpls.render(rec, "user", userTemplateData{Lang: "en"}); err != nil {
+		t.Fatalf("render() received an error: %s", err.Error())
+	}
+	if cl := rec.Header().Get("Content-Length"); cl != strconv.Itoa(rec.Body.Len()) {
+		t.Errorf("Content-Length: want %d, got %q", rec.Body.Len(), cl)
+	}
+}
+
+func TestTemplateSet_print(t *testing.T) {
+	p := newThreadProvider()
+	cfg := config{Messages: testMessages(t), Comments: commentsConfig{FetchTimeout: time.Second}}
+	tpls := testTemplates(t)
+	h := routed("/print/item/{id}", itemHandler(p, cfg, newCommentTrees(p, cfg), nil, tpls.print()))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/print/item/1", nil))
+	body := rec.Body.String()
+	if !strings.Contains(body, "Story 1") || !strings.Contains(body, "Reply") || !strings.Contains(body, "Georgia") {
+		t.Errorf("body: want the item in the print layout, got %s", body)
+	}
+	if strings.Contains(body, "gophercises") {
+		t.Errorf("body: want the print variant without the footer, got %s", body)
+	}
+}
+
+func TestLocalLink(t *testing.T) {
+	tests := map[string]string{
+		"https://news.ycombinator.com/item?id=8863":  "/item/8863",
+		"https://news.ycombinator.com/user?id=pg":    "/user/pg",
+		"https://news.ycombinator.com/item?id=x":     "",
+		"https://news.ycombinator.com/newest":        "",
+		"https://example.com/item?id=8863":           "",
+		"https://news.ycombinator.com/user?id=a%2Fb": "/user/a%2Fb",
+	}
+	for link, want := range tests {
+		u, err := url.Parse(link)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := localLink(u); got != want {
+			t.Errorf("localLink(%s) = %q, want %q", link, got, want)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/mmxmb/quiet_hn/history"
+)
+
+// trendWindows are the periods trends can be computed over
+var trendWindows = map[string]time.Duration{
+	"24h": 24 * time.Hour,
+	"7d":  7 * 24 * time.Hour,
+}
+
+// maxTrends is the number of terms returned by /api/trends
+const maxTrends = 20
+
+// stopWords are the words never considered trending. Besides the most common
+// English words, it has the words of the conventional title prefixes.
+var stopWords = map[string]bool{}
+
+func init() {
+	for _, w := range strings.Fields(`a about after all an and are as at be but by can do for from
+		has have how i in into is it its my new no not of on or our out over so than that the their
+		this to up vs was we what when where which who why will with without you your
+		ask show tell launch hn pdf video`) {
+		stopWords[w] = true
+	}
+}
+
+// trend is the number of stories on the front page with a term in their
+// title over a window, and over the window before it
+type trend struct {
+	Term     string `json:"term"`
+	Stories  int    `json:"stories"`
+	Previous int    `json:"previous"`
+}
+
+// titleTerms returns the distinct lower cased words of title that aren't
+// stop words or numbers
+func titleTerms(title string) []string {
+	seen := make(map[string]bool)
+	var terms []string
+	for _, w := range titleWords(title) {
+		if len(w) < 2 || stopWords[w] || seen[w] || strings.IndexFunc(w, unicode.IsLetter) < 0 {
+			continue
+		}
+		seen[w] = true
+		terms = append(terms, w)
+	}
+	return terms
+}
+
+// titleWords returns the lower cased words of title, keeping terms such as
+// "c++", "c#" and "node.js" whole
+func titleWords(title string) []string {
+	words := strings.FieldsFunc(strings.ToLower(title), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && !strings.ContainsRune("+#.-", r)
+	})
+	for i, w := range words {
+		words[i] = strings.Trim(w, ".-")
+	}
+	return words
+}
+
+// termCounts returns the number of distinct stories in snaps each term
+// appears in the title of
+func termCounts(snaps []history.Snapshot) map[string]int {
+	seen := make(map[int]bool)
+	counts := make(map[string]int)
+	for _, snap := range snaps {
+		for _, story := range snap.Stories {
+			if seen[story.ID] {
+				continue
+			}
+			seen[story.ID] = true
+			for _, term := range titleTerms(story.Title) {
+				counts[term]++
+			}
+		}
+	}
+	return counts
+}
+
+// trends returns the terms in the most stories over [now-window, now), with
+// terms that grew the most compared to the window before first among equals.
+// Terms in a single story aren't trends.
+func trends(hist *history.Store, now time.Time, window time.Duration) []trend {
+	current := termCounts(hist.Snapshots(now.Add(-window), now))
+	previous := termCounts(hist.Snapshots(now.Add(-2*window), now.Add(-window)))
+	ret := []trend{}
+	for term, n := range current {
+		if n < 2 {
+			continue
+		}
+		ret = append(ret, trend{Term: term, Stories: n, Previous: previous[term]})
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		a, b := ret[i], ret[j]
+		if a.Stories != b.Stories {
+			return a.Stories > b.Stories
+		}
+		if a.Stories-a.Previous != b.Stories-b.Previous {
+			return a.Stories-a.Previous > b.Stories-b.Previous
+		}
+		return a.Term < b.Term
+	})
+	if len(ret) > maxTrends {
+		ret = ret[:maxTrends]
+	}
+	return ret
+}
+
+// trendsHandler serves /api/trends?window=24h (or 7d), the terms trending on
+// the front page according to its history, as JSON.
+func trendsHandler(hist *history.Store) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := newQueryParams(r)
+		name := q.Enum("window", "24h", "24h", "7d")
+		if err := q.Err(); err != nil {
+			writeQueryError(w, err)
+			return
+		}
+		window := trendWindows[name]
+		writeJSON(w, struct {
+			Window string  `json:"window"`
+			Terms  []trend `json:"terms"`
+		}{name, trends(hist, time.Now(), window)})
+	})
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"crypto/tls"
+	"net/http"
+
+	"github.com/mmxmb/quiet_hn/hn"
+)
+
+// newUpstreamClient returns the HTTP client used to call the HN API. Every
+// front page fetch makes dozens of concurrent requests to the same host, so
+// up to maxIdlePerHost connections are kept open between refreshes instead
+// of the default 2, and the TLS sessions of up to tlsSessions connections
+// are cached to resume them with a shorter handshake (0 disables it).
+func newUpstreamClient(maxIdlePerHost, tlsSessions int) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConnsPerHost = maxIdlePerHost
+	if transport.MaxIdleConns < maxIdlePerHost {
+		transport.MaxIdleConns = maxIdlePerHost
+	}
+	if tlsSessions > 0 {
+		transport.TLSClientConfig = &tls.Config{ClientSessionCache: tls.NewLRUClientSessionCache(tlsSessions)}
+	}
+	return &http.Client{Transport: transport}
+}
+
+// upstreamStatsHandler serves /api/upstream/stats, the timings of the
+// requests to the HN API
+func upstreamStatsHandler(metrics *hn.Metrics) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, metrics.Stats())
+	})
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/mmxmb/quiet_hn/hn"
+	"github.com/mmxmb/quiet_hn/router"
+)
+
+// numUserSubmissions is the number of the most recent submissions of a user
+// looked at for the stories listed on their page. Most of them are usually
+// comments, which aren't listed.
+const numUserSubmissions = 30
+
+func userHandler(client StoryProvider, cfg config, tpls *templateSet) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		// /user/{name}, or /user?id= like on HN
+		username := router.Param(r, "name")
+		if username == "" {
+			username = r.URL.Query().Get("id")
+		}
+		if username == "" {
+			tpls.renderError(w, r, http.StatusBadRequest, "error.invalid_user")
+			return
+		}
+		user, err := client.GetUser(username)
+		if err != nil {
+			tpls.renderError(w, r, http.StatusInternalServerError, "error.load_user")
+			return
+		}
+		if user.ID == "" {
+			tpls.renderError(w, r, http.StatusNotFound, "error.not_found")
+			return
+		}
+
+		ids := user.Submitted
+		if len(ids) > numUserSubmissions {
+			ids = ids[:numUserSubmissions]
+		}
+		prefs, err := loadPreferences(w, r, cfg.Defaults)
+		if err != nil {
+			tpls.renderQueryError(w, r, err)
+			return
+		}
+		stories := getStories(r.Context(), ids, 0, client, cfg.Concurrency, newFilter(cfg, prefs))
+
+		data := userTemplateData{
+			User:    user,
+			Stories: stories,
+			Lang:    languagePref(w, r, cfg.Messages),
+			TZ:      prefs.Timezone,
+			Time:    time.Now().Sub(start),
+		}
+		err = tpls.render(w, "user", data)
+		if err != nil {
+			http.Error(w, "Failed to process the template", http.StatusInternalServerError)
+			return
+		}
+	})
+}
+
+type userTemplateData struct {
+	User    hn.User
+	Stories []item
+	Lang    string
+	TZ      string
+	Time    time.Duration
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"net/http"
+)
+
+// defaultContactURL is where the operators of the sites the server fetches
+// pages from can find out what it is
+const defaultContactURL = "https://github.com/mmxmb/quiet_hn"
+
+// defaultUserAgent returns the User-Agent of the requests of the server,
+// naming it, its version and contactURL, as crawlers conventionally do
+func defaultUserAgent(contactURL string) string {
+	ua := "quiet_hn/" + version
+	if contactURL != "" {
+		ua += " (+" + contactURL + ")"
+	}
+	return ua
+}
+
+// userAgentTransport sets the User-Agent of the requests it sends, unless
+// userAgent is empty
+type userAgentTransport struct {
+	http.RoundTripper
+	userAgent string
+}
+
+func (t userAgentTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	if t.userAgent == "" {
+		return t.RoundTripper.RoundTrip(r)
+	}
+	// a RoundTripper mustn't modify the request it is given
+	r = r.Clone(r.Context())
+	r.Header.Set("User-Agent", t.userAgent)
+	return t.RoundTripper.RoundTrip(r)
+}
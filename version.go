@@ -0,0 +1,58 @@
+package main
+
+import (
+	"net/http"
+	"runtime"
+	"runtime/debug"
+)
+
+// The version of the server and the commit and date it was built from, set
+// at build time with
+//
+//	go build -ldflags "-X main.version=1.2.0 -X main.commit=$(git rev-parse --short HEAD) -X main.buildDate=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+//
+// Without them, the version is the one of the module if it was installed with
+// go install, or "dev".
+var version, commit, buildDate string
+
+func init() {
+	if version != "" {
+		return
+	}
+	version = "dev"
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" && info.Main.Version != "(devel)" {
+		version = info.Main.Version
+	}
+}
+
+// buildInfo describes the build of the server
+type buildInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit,omitempty"`
+	BuildDate string `json:"build_date,omitempty"`
+	GoVersion string `json:"go_version"`
+}
+
+func currentBuild() buildInfo {
+	return buildInfo{Version: version, Commit: commit, BuildDate: buildDate, GoVersion: runtime.Version()}
+}
+
+// String describes the build in one line, for the logs
+func (b buildInfo) String() string {
+	s := "quiet_hn " + b.Version
+	if b.Commit != "" {
+		s += " (" + b.Commit + ")"
+	}
+	if b.BuildDate != "" {
+		s += " built " + b.BuildDate
+	}
+	return s + " with " + b.GoVersion
+}
+
+// versionHandler serves /version, the build of the server, so that the
+// deployments running different versions can be told apart
+func versionHandler() http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, currentBuild())
+	})
+}
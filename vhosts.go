@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// virtualHost overrides the settings of the site for one of the domains it
+// is served on, so one process can serve variants of it
+type virtualHost struct {
+	// NumStories replaces -num_stories if set
+	NumStories int `json:"num_stories"`
+	// Templates replaces -templates if set, eg to give the domain its own
+	// theme
+	Templates string `json:"templates"`
+}
+
+// loadVirtualHosts reads the domains in the JSON file at path, mapped to
+// their settings
+func loadVirtualHosts(path string) (map[string]virtualHost, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var hosts map[string]virtualHost
+	if err := json.Unmarshal(b, &hosts); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	if len(hosts) == 0 {
+		return nil, fmt.Errorf("%s lists no hosts", path)
+	}
+	normalized := make(map[string]virtualHost, len(hosts))
+	for host, vh := range hosts {
+		normalized[strings.ToLower(host)] = vh
+	}
+	return normalized, nil
+}
+
+// hostRouter dispatches requests to the site of their Host header. Requests
+// for other hosts are rejected, so that the site can't be served under
+// domains it doesn't know about, eg to poison caches with its links.
+type hostRouter map[string]http.Handler
+
+// newHostRouter returns a hostRouter serving the hosts with the site base,
+// with their settings. The hosts without settings share the routes of base,
+// the others get their own routes. They all share the cache of base, whose
+// keys include the number of stories, so that purges, refreshes and stats
+// cover every host.
+func newHostRouter(base *site, hosts map[string]virtualHost) (hostRouter, error) {
+	shared := base.routes()
+	hr := make(hostRouter, len(hosts))
+	for host, vh := range hosts {
+		if vh == (virtualHost{}) {
+			hr[host] = shared
+			continue
+		}
+		s := *base
+		if vh.NumStories > 0 {
+			s.cfg.NumStories = vh.NumStories
+		}
+		if vh.Templates != "" {
+			tpls, err := loadTemplates(base.cfg.Messages, vh.Templates)
+			if err != nil {
+				return nil, fmt.Errorf("host %s: %w", host, err)
+			}
+			tpls.Minify = base.tpls.Minify
+			s.tpls = tpls
+		}
+		hr[host] = s.routes()
+	}
+	return hr, nil
+}
+
+// warmHosts fetches the lists of the hosts showing a different number of
+// stories than cfg into cache, like warmCache does for the list of cfg
+func warmHosts(client StoryProvider, cache *Cache, cfg config, hosts map[string]virtualHost, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	warmed := map[int]bool{cfg.NumStories: true}
+	for host, vh := range hosts {
+		if vh.NumStories <= 0 || warmed[vh.NumStories] {
+			continue
+		}
+		warmed[vh.NumStories] = true
+		hostCfg := cfg
+		hostCfg.NumStories = vh.NumStories
+		if _, err := cachedTopStories(ctx, client, cache, hostCfg, newFilter(hostCfg, hostCfg.Defaults)); err != nil {
+			log.Printf("warming the cache of %s: %s", host, err)
+		}
+	}
+}
+
+func (hr hostRouter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	host := strings.ToLower(r.Host)
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	h, ok := hr[strings.TrimSuffix(host, ".")]
+	if !ok {
+		http.Error(w, "unknown host", http.StatusMisdirectedRequest)
+		return
+	}
+	h.ServeHTTP(w, r)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHostRouter(t *testing.T) {
+	base := &site{
+		client:      newFakeProvider(3),
+		cache:       &Cache{ExpirationDuration: time.Minute},
+		cfg:         config{Messages: testMessages(t), NumStories: 3, Concurrency: 2},
+		tpls:        testTemplates(t),
+		compression: func(h http.Handler) http.Handler { return h },
+		ready:       &readiness{},
+	}
+	hr, err := newHostRouter(base, map[string]virtualHost{
+		"quiet.example.com": {},
+		"short.example.com": {NumStories: 1},
+	})
+	if err != nil {
+		t.Fatalf("newHostRouter() received an error: %s", err.Error())
+	}
+
+	tests := []struct {
+		host    string
+		status  int
+		stories int
+	}{
+		{"quiet.example.com", http.StatusOK, 3},
+		{"Quiet.Example.com:8080", http.StatusOK, 3},
+		{"short.example.com", http.StatusOK, 1},
+		{"evil.example.com", http.StatusMisdirectedRequest, 0},
+	}
+	for _, tc := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Host = tc.host
+		rec := httptest.NewRecorder()
+		hr.ServeHTTP(rec, r)
+		if rec.Code != tc.status {
+			t.Errorf("%s: status code: want %d, got %d", tc.host, tc.status, rec.Code)
+			continue
+		}
+		if got := strings.Count(rec.Body.String(), "<li value="); tc.status == http.StatusOK && got != tc.stories {
+			t.Errorf("%s: want %d stories, got %d", tc.host, tc.stories, got)
+		}
+	}
+	// the lists of both hosts are in the shared cache, so purges reach them
+	if n := base.cache.Purge(""); n != 2 {
+		t.Errorf("Purge(): want the lists of both hosts purged, got %d", n)
+	}
+}
+
+func TestLoadVirtualHosts(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hosts.json")
+	if err := os.WriteFile(path, []byte(`{"Quiet.Example.com": {"num_stories": 10, "templates": "./themes/dark"}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	hosts, err := loadVirtualHosts(path)
+	if err != nil {
+		t.Fatalf("loadVirtualHosts() received an error: %s", err.Error())
+	}
+	if got := hosts["quiet.example.com"]; got != (virtualHost{NumStories: 10, Templates: "./themes/dark"}) {
+		t.Errorf("quiet.example.com: got %+v", got)
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/mmxmb/quiet_hn/history"
+)
+
+const lastVisitCookie = "last_visit"
+
+// lastVisit returns the time of the previous visit of the user making r, and
+// false if it is their first visit, then remembers the current visit.
+func lastVisit(w http.ResponseWriter, r *http.Request, now time.Time) (time.Time, bool) {
+	setPrefCookie(w, lastVisitCookie, strconv.FormatInt(now.Unix(), 10))
+	c, err := r.Cookie(lastVisitCookie)
+	if err != nil {
+		return time.Time{}, false
+	}
+	sec, err := strconv.ParseInt(c.Value, 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(sec, 0), true
+}
+
+// markNew sets New on the stories that first made it to the front page after
+// since. Nothing is marked if the history doesn't go back to since, as every
+// story would look new.
+func markNew(stories []item, hist *history.Store, since time.Time) {
+	if start, ok := hist.Start(); !ok || start.After(since) {
+		return
+	}
+	for i := range stories {
+		if seen, ok := hist.FirstSeen(stories[i].ID); ok && seen.After(since) {
+			stories[i].New = true
+		}
+	}
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mmxmb/quiet_hn/notify"
+)
+
+// watchedThread is an item whose new comments are notified to the sinks
+type watchedThread struct {
+	ID    int    `json:"id"`
+	Title string `json:"title,omitempty"`
+	// Comments is the number of comments last notified, or seen when the
+	// thread started being watched. It is -1 until the first poll.
+	Comments int `json:"comments"`
+	// Notified is when the new comments were last notified
+	Notified time.Time `json:"notified,omitempty"`
+}
+
+// watchList holds the threads watched from the admin dashboard, saved to
+// backend if set. The new comments of a thread are notified at most once per
+// cooldown, the ones arriving in between notified together after it.
+type watchList struct {
+	backend  watchBackend
+	sinks    []*postSink
+	cooldown time.Duration
+
+	mu      sync.Mutex
+	threads []watchedThread
+}
+
+// watchBackend is where the threads watched are kept
+type watchBackend interface {
+	Load() ([]watchedThread, error)
+	Save(threads []watchedThread) error
+}
+
+// fileWatched keeps the threads watched in a JSON file
+type fileWatched struct {
+	path string
+}
+
+// Load reads the threads of the file, none if it doesn't exist
+func (f fileWatched) Load() ([]watchedThread, error) {
+	data, err := ioutil.ReadFile(f.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var threads []watchedThread
+	if err := json.Unmarshal(data, &threads); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", f.path, err)
+	}
+	return threads, nil
+}
+
+// Save writes threads to the file, replacing it atomically
+func (f fileWatched) Save(threads []watchedThread) error {
+	data, err := json.Marshal(threads)
+	if err != nil {
+		return err
+	}
+	tmp := f.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, f.path)
+}
+
+// newWatchList returns the list of the threads saved to backend, if set,
+// notifying sinks of their new comments
+func newWatchList(backend watchBackend, sinks []*postSink, cooldown time.Duration) (*watchList, error) {
+	l := &watchList{backend: backend, sinks: sinks, cooldown: cooldown}
+	if err := l.reload(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// reload reads the threads from l.backend, which other replicas may have
+// changed since, if set
+func (l *watchList) reload() error {
+	if l.backend == nil {
+		return nil
+	}
+	threads, err := l.backend.Load()
+	if err != nil {
+		return err
+	}
+	l.mu.Lock()
+	l.threads = threads
+	l.mu.Unlock()
+	return nil
+}
+
+// Threads returns the threads watched, in the order they were added
+func (l *watchList) Threads() []watchedThread {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]watchedThread(nil), l.threads...)
+}
+
+// Watch starts watching the thread of the item id, if it isn't already, and
+// saves the threads
+func (l *watchList) Watch(id int) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, t := range l.threads {
+		if t.ID == id {
+			return nil
+		}
+	}
+	return l.save(append(append([]watchedThread(nil), l.threads...), watchedThread{ID: id, Comments: -1}))
+}
+
+// Unwatch stops watching the thread of the item id and saves the threads
+func (l *watchList) Unwatch(id int) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var threads []watchedThread
+	for _, t := range l.threads {
+		if t.ID != id {
+			threads = append(threads, t)
+		}
+	}
+	return l.save(threads)
+}
+
+// save makes threads the threads watched, saving them to l.backend, if set.
+// l.mu must be held.
+func (l *watchList) save(threads []watchedThread) error {
+	if l.backend != nil {
+		if err := l.backend.Save(threads); err != nil {
+			return err
+		}
+	}
+	l.threads = threads
+	return nil
+}
+
+// poll fetches the threads watched and notifies the sinks of the ones with
+// new comments past their cooldown, and returns how many were notified
+func (l *watchList) poll(ctx context.Context, client StoryProvider, concurrency int, now time.Time) (int, error) {
+	threads := l.Threads()
+	if len(threads) == 0 {
+		return 0, nil
+	}
+	ids := make([]int, len(threads))
+	for i, t := range threads {
+		ids[i] = t.ID
+	}
+	hnItems, err := client.GetItems(ctx, ids, concurrency)
+	if err != nil {
+		return 0, err
+	}
+	items := make(map[int]item, len(hnItems))
+	for _, hnItem := range hnItems {
+		items[hnItem.ID] = parseHNItem(hnItem)
+	}
+
+	updates := make(map[int]watchedThread)
+	n := 0
+	for _, t := range threads {
+		itm, ok := items[t.ID]
+		if !ok || !itm.Alive() {
+			continue
+		}
+		t.Title = itm.Title
+		switch {
+		case t.Comments < 0:
+			t.Comments = itm.Descendants
+		case itm.Descendants > t.Comments && now.Sub(t.Notified) >= l.cooldown:
+			if l.notify(ctx, itm, itm.Descendants-t.Comments) == 0 {
+				// retried in the next round
+				break
+			}
+			t.Comments, t.Notified = itm.Descendants, now
+			n++
+		}
+		updates[t.ID] = t
+	}
+
+	// the threads may have been watched or unwatched since
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	threads = append([]watchedThread(nil), l.threads...)
+	for i, t := range threads {
+		if u, ok := updates[t.ID]; ok {
+			threads[i] = u
+		}
+	}
+	return n, l.save(threads)
+}
+
+// notify sends the thread of itm, with the number of its new comments, to
+// the sinks and returns to how many
+func (l *watchList) notify(ctx context.Context, itm item, comments int) int {
+	story := notifyStory(itm)
+	n := 0
+	for _, sink := range l.sinks {
+		var text strings.Builder
+		fmt.Fprintf(&text, "[+%d comments] ", comments)
+		if err := sink.Template.Execute(&text, story); err != nil {
+			log.Printf("rendering the %s comment notification of item %d: %s", sink.Name, itm.ID, err)
+			continue
+		}
+		if err := sink.Notifier.Notify(ctx, notify.Message{Text: text.String(), Story: story}); err != nil {
+			log.Printf("notifying %s of the comments of item %d: %s", sink.Name, itm.ID, err)
+			continue
+		}
+		n++
+	}
+	return n
+}
+
+// runWatches polls the threads watched every interval until ctx is done
+func runWatches(ctx context.Context, client StoryProvider, cfg config, l *watchList, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := l.reload(); err != nil {
+			log.Printf("reloading the threads watched: %s", err)
+		}
+		if _, err := l.poll(ctx, client, cfg.Concurrency, time.Now()); err != nil && ctx.Err() == nil {
+			log.Printf("polling the threads watched: %s", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// watchAdminHandler serves POST /admin/watch, which watches the thread of
+// the item of the watch field or unwatches the one of unwatch, and
+// redirects back to the dashboard
+func watchAdminHandler(l *watchList) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !sameOrigin(r) {
+			http.Error(w, "cross-origin requests are forbidden", http.StatusForbidden)
+			return
+		}
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		op, field := l.Watch, "watch"
+		if r.PostForm.Get("unwatch") != "" {
+			op, field = l.Unwatch, "unwatch"
+		}
+		id, err := strconv.Atoi(strings.TrimSpace(r.PostForm.Get(field)))
+		if err != nil || id <= 0 {
+			http.Error(w, "invalid item ID", http.StatusBadRequest)
+			return
+		}
+		if err := op(id); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		http.Redirect(w, r, "/admin", http.StatusSeeOther)
+	})
+}
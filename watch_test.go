@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWatchList(t *testing.T) {
+	tpl, err := parsePostTemplate("test", "{{.Title}}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := &fakeNotifier{}
+	sinks := []*postSink{{Name: "test", Notifier: n, Template: tpl}}
+	backend := fileWatched{path: filepath.Join(t.TempDir(), "watched.json")}
+	l, err := newWatchList(backend, sinks, 30*time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := newFakeProvider(2)
+	comments := func(id, n int) {
+		itm := p.items[id]
+		itm.Descendants = n
+		p.items[id] = itm
+	}
+	comments(1, 3)
+
+	form := url.Values{"watch": {"1"}}
+	req := httptest.NewRequest(http.MethodPost, "/admin/watch", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	watchAdminHandler(l).ServeHTTP(rec, req)
+	if rec.Code != http.StatusSeeOther {
+		t.Fatalf("watching: want status %d, got %d", http.StatusSeeOther, rec.Code)
+	}
+
+	now := time.Now()
+	poll := func(at time.Time, want int) {
+		t.Helper()
+		if got, err := l.poll(context.Background(), p, 2, at); err != nil || got != want {
+			t.Errorf("poll(): want %d notifications, got %d (%v)", want, got, err)
+		}
+	}
+	// the comments made before the thread was watched aren't notified
+	poll(now, 0)
+	comments(1, 5)
+	poll(now.Add(time.Minute), 1)
+	if len(n.msgs) != 1 || n.msgs[0].Text != "[+2 comments] Story 1" {
+		t.Errorf("messages: want the 2 new comments of story 1, got %+v", n.msgs)
+	}
+	comments(1, 9)
+	poll(now.Add(2*time.Minute), 0)
+	poll(now.Add(time.Hour), 1)
+	if len(n.msgs) != 2 || n.msgs[1].Text != "[+4 comments] Story 1" {
+		t.Errorf("messages after the cooldown: want the 4 new comments of story 1, got %+v", n.msgs)
+	}
+
+	// the threads survive restarts
+	reloaded, err := newWatchList(backend, sinks, 30*time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if threads := reloaded.Threads(); len(threads) != 1 || threads[0].Comments != 9 || threads[0].Title != "Story 1" {
+		t.Errorf("reloaded threads: got %+v", threads)
+	}
+	if err := reloaded.Unwatch(1); err != nil {
+		t.Fatal(err)
+	}
+	if threads := reloaded.Threads(); len(threads) != 0 {
+		t.Errorf("threads after unwatching: want none, got %+v", threads)
+	}
+}